@@ -0,0 +1,147 @@
+package api
+
+import (
+	"fmt"
+	"mail-server/storage"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// maxAliasesPerDomain 每个邮箱最多添加的别名数量
+const maxAliasesPerDomain = 50
+
+// aliasPattern 别名的本地部分，* 匹配任意字符（单独的 * 等同于catch-all）
+var aliasPattern = regexp.MustCompile(`^[a-z0-9*]([a-z0-9._*-]{0,62}[a-z0-9*])?$`)
+
+// mailDomainFromRequest 读取路径中的邮箱域名，不存在时写入错误响应并返回 nil
+func (s *Server) mailDomainFromRequest(w http.ResponseWriter, r *http.Request) *storage.MailDomain {
+	userID := getUserIDFromRequest(r)
+
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return nil
+	}
+
+	domain, err := s.storage.GetMailDomain(userID, id)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return nil
+	}
+	if domain == nil {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "邮箱不存在"})
+		return nil
+	}
+	return domain
+}
+
+// getDomainAddresses 获取邮箱的主地址和别名
+func (s *Server) getDomainAddresses(w http.ResponseWriter, r *http.Request) {
+	domain := s.mailDomainFromRequest(w, r)
+	if domain == nil {
+		return
+	}
+
+	aliases, err := s.storage.GetAliases(domain.UserID, domain.ID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"email":       domain.Email,
+		"full_domain": domain.FullDomain,
+		"catch_all":   domain.CatchAll,
+		"addresses":   aliases,
+	})
+}
+
+// createDomainAddress 在邮箱子域名下添加别名，请求可以是 local_part 或完整的 email
+func (s *Server) createDomainAddress(w http.ResponseWriter, r *http.Request) {
+	domain := s.mailDomainFromRequest(w, r)
+	if domain == nil {
+		return
+	}
+
+	var req struct {
+		LocalPart string `json:"local_part"`
+		Email     string `json:"email"`
+	}
+	if err := parseJSON(r, &req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "无效的请求"})
+		return
+	}
+
+	host := strings.ToLower(domain.FullDomain)
+	local := strings.ToLower(strings.TrimSpace(req.LocalPart))
+	if email := strings.ToLower(strings.TrimSpace(req.Email)); email != "" {
+		at := strings.LastIndex(email, "@")
+		if at < 0 || email[at+1:] != host {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("地址必须在 %s 下", host)})
+			return
+		}
+		local = email[:at]
+	}
+	if !aliasPattern.MatchString(local) {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "无效的邮箱地址"})
+		return
+	}
+
+	email := local + "@" + host
+	if strings.EqualFold(email, domain.Email) {
+		respondJSON(w, http.StatusConflict, map[string]string{"error": "邮箱地址已存在"})
+		return
+	}
+	existing, err := s.storage.GetAliasByEmail(email)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	if existing != nil {
+		respondJSON(w, http.StatusConflict, map[string]string{"error": "邮箱地址已存在"})
+		return
+	}
+
+	aliases, err := s.storage.GetAliases(domain.UserID, domain.ID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	if len(aliases) >= maxAliasesPerDomain {
+		respondJSON(w, http.StatusForbidden, map[string]string{"error": fmt.Sprintf("每个邮箱最多添加%d个别名", maxAliasesPerDomain)})
+		return
+	}
+
+	alias := &storage.Alias{DomainID: domain.ID, UserID: domain.UserID, Email: email}
+	if err := s.storage.CreateAlias(alias); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, alias)
+}
+
+// deleteDomainAddress 删除邮箱的别名
+func (s *Server) deleteDomainAddress(w http.ResponseWriter, r *http.Request) {
+	domain := s.mailDomainFromRequest(w, r)
+	if domain == nil {
+		return
+	}
+
+	id, err := strconv.ParseInt(mux.Vars(r)["addressId"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.storage.DeleteAlias(domain.UserID, domain.ID, id); err != nil {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "success"})
+}
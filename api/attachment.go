@@ -0,0 +1,74 @@
+package api
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// getAttachments 获取邮件的附件列表
+func (s *Server) getAttachments(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromRequest(r)
+
+	vars := mux.Vars(r)
+	mailID, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	attachments, err := s.storage.GetAttachments(userID, mailID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{"attachments": attachments})
+}
+
+// downloadAttachment 下载附件，?inline=1 时图片可直接在页面中显示
+func (s *Server) downloadAttachment(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromRequest(r)
+
+	vars := mux.Vars(r)
+	mailID, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+	id, err := strconv.ParseInt(vars["attachmentId"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid attachment ID", http.StatusBadRequest)
+		return
+	}
+
+	attachment, err := s.storage.GetAttachment(userID, mailID, id)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	if attachment == nil {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "附件不存在"})
+		return
+	}
+
+	filename := attachment.Filename
+	if filename == "" {
+		filename = "attachment-" + strconv.FormatInt(attachment.ID, 10)
+	}
+
+	// 附件内容来自外部发件人，除图片外一律作为下载，避免HTML/SVG等内容在本站域名下执行脚本
+	disposition := "attachment"
+	if r.URL.Query().Get("inline") == "1" && strings.HasPrefix(attachment.ContentType, "image/") && attachment.ContentType != "image/svg+xml" {
+		disposition = "inline"
+	}
+
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": filename}))
+	w.Header().Set("Content-Length", strconv.Itoa(len(attachment.Data)))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write(attachment.Data)
+}
@@ -1,9 +1,8 @@
 package api
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
 	"log"
+	"mail-server/storage"
 	"net/http"
 	"strconv"
 	"strings"
@@ -47,12 +46,6 @@ type LoginResponse struct {
 	NeedSetPass bool   `json:"need_set_password"` // 是否需要设置密码
 }
 
-// hashPassword 对密码进行SHA256哈希
-func hashPassword(password string) string {
-	hash := sha256.Sum256([]byte(password))
-	return hex.EncodeToString(hash[:])
-}
-
 // getClientIP 获取客户端IP地址
 func getClientIP(r *http.Request) string {
 	// 检查X-Forwarded-For
@@ -167,7 +160,7 @@ func (s *Server) register(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 创建用户
-	hashedPassword := hashPassword(req.Password)
+	hashedPassword := storage.HashPassword(req.Password)
 	user, err := s.storage.CreateUser(req.Email, hashedPassword, clientIP)
 	if err != nil {
 		log.Printf("Failed to create user: %v", err)
@@ -315,7 +308,7 @@ func (s *Server) setPassword(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 更新密码
-	hashedPassword := hashPassword(req.Password)
+	hashedPassword := storage.HashPassword(req.Password)
 	err := s.storage.UpdateUserPassword(req.Email, hashedPassword)
 	if err != nil {
 		log.Printf("Failed to update password: %v", err)
@@ -353,7 +346,7 @@ func (s *Server) passwordLogin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 验证密码
-	hashedPassword := hashPassword(req.Password)
+	hashedPassword := storage.HashPassword(req.Password)
 	if user.Password != hashedPassword {
 		respondJSON(w, http.StatusUnauthorized, map[string]string{"error": "邮箱或密码错误"})
 		return
@@ -404,3 +397,33 @@ func (s *Server) authMiddleware(next http.HandlerFunc) http.HandlerFunc {
 		next(w, r)
 	}
 }
+
+// streamAuthMiddleware 推送接口的认证中间件，没有 Authorization 头时从 access_token 参数读取token
+func (s *Server) streamAuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	auth := s.authMiddleware(next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			if token := r.URL.Query().Get("access_token"); token != "" {
+				r.Header.Set("Authorization", "Bearer "+token)
+			}
+		}
+		auth(w, r)
+	}
+}
+
+// adminMiddleware 管理员权限中间件，包含登录认证
+func (s *Server) adminMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return s.authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		user, err := s.storage.GetUserByEmail(r.Header.Get("X-User-Email"))
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "获取用户信息失败"})
+			return
+		}
+		if user == nil || !user.IsAdmin {
+			respondJSON(w, http.StatusForbidden, map[string]string{"error": "需要管理员权限"})
+			return
+		}
+
+		next(w, r)
+	})
+}
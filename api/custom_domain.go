@@ -0,0 +1,261 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"mail-server/storage"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// maxCustomDomainsPerUser 每个用户最多添加的自有域名数量
+const maxCustomDomainsPerUser = 5
+
+// localPartPattern 自有域名下地址的本地部分，+ 用于标签，不能出现在地址中
+var localPartPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9._-]{0,62}[a-z0-9])?$`)
+
+// customDomainAvailable 检查自有域名服务是否可用，不可用时写入错误响应
+func (s *Server) customDomainAvailable(w http.ResponseWriter) bool {
+	if s.customDomains == nil {
+		respondJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "自有域名功能不可用"})
+		return false
+	}
+	return true
+}
+
+// getCustomDomains 获取自有域名列表
+func (s *Server) getCustomDomains(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromRequest(r)
+
+	domains, err := s.storage.GetCustomDomains(userID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{"domains": domains})
+}
+
+// createCustomDomain 添加自有域名，返回需要添加的验证TXT记录
+func (s *Server) createCustomDomain(w http.ResponseWriter, r *http.Request) {
+	if !s.customDomainAvailable(w) {
+		return
+	}
+	userID := getUserIDFromRequest(r)
+
+	var req struct {
+		Domain string `json:"domain"`
+	}
+	if err := parseJSON(r, &req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "无效的请求"})
+		return
+	}
+
+	domains, err := s.storage.GetCustomDomains(userID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	if len(domains) >= maxCustomDomainsPerUser {
+		respondJSON(w, http.StatusForbidden, map[string]string{"error": fmt.Sprintf("最多添加%d个自有域名", maxCustomDomainsPerUser)})
+		return
+	}
+
+	domain, err := s.customDomains.Add(userID, req.Domain)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, domain)
+}
+
+// customDomainFromRequest 读取路径中的自有域名，不存在时写入错误响应并返回 nil
+func (s *Server) customDomainFromRequest(w http.ResponseWriter, r *http.Request) *storage.CustomDomain {
+	userID := getUserIDFromRequest(r)
+
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return nil
+	}
+
+	domain, err := s.storage.GetCustomDomain(userID, id)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return nil
+	}
+	if domain == nil {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "域名不存在"})
+		return nil
+	}
+	return domain
+}
+
+// getCustomDomain 获取自有域名及最近一次DNS检查结果
+func (s *Server) getCustomDomain(w http.ResponseWriter, r *http.Request) {
+	domain := s.customDomainFromRequest(w, r)
+	if domain == nil {
+		return
+	}
+
+	respondJSON(w, http.StatusOK, domain)
+}
+
+// deleteCustomDomain 删除自有域名及其下的地址，已收到的邮件保留
+func (s *Server) deleteCustomDomain(w http.ResponseWriter, r *http.Request) {
+	if !s.customDomainAvailable(w) {
+		return
+	}
+	domain := s.customDomainFromRequest(w, r)
+	if domain == nil {
+		return
+	}
+
+	if err := s.customDomains.Remove(domain); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "success"})
+}
+
+// verifyCustomDomain 立即检查DNS记录，不用等待后台检查
+func (s *Server) verifyCustomDomain(w http.ResponseWriter, r *http.Request) {
+	if !s.customDomainAvailable(w) {
+		return
+	}
+	domain := s.customDomainFromRequest(w, r)
+	if domain == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), dnsCheckTimeout)
+	defer cancel()
+	if err := s.customDomains.Check(ctx, domain); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, domain)
+}
+
+// setCustomDomainCatchAll 开启或关闭自有域名的catch-all
+func (s *Server) setCustomDomainCatchAll(w http.ResponseWriter, r *http.Request) {
+	domain := s.customDomainFromRequest(w, r)
+	if domain == nil {
+		return
+	}
+
+	var req struct {
+		Enabled bool `json:"enabled"`
+	}
+	if err := parseJSON(r, &req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "无效的请求"})
+		return
+	}
+
+	if err := s.storage.SetCustomDomainCatchAll(domain.UserID, domain.ID, req.Enabled); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{"id": domain.ID, "catch_all": req.Enabled})
+}
+
+// getCustomDomainAddresses 获取自有域名下的地址
+func (s *Server) getCustomDomainAddresses(w http.ResponseWriter, r *http.Request) {
+	domain := s.customDomainFromRequest(w, r)
+	if domain == nil {
+		return
+	}
+
+	addrs, err := s.storage.GetCustomDomainAddresses(domain.UserID, domain.ID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{"addresses": addrs})
+}
+
+// createCustomDomainAddress 在已验证的自有域名下创建地址，请求可以是 local_part 或完整的 email
+func (s *Server) createCustomDomainAddress(w http.ResponseWriter, r *http.Request) {
+	domain := s.customDomainFromRequest(w, r)
+	if domain == nil {
+		return
+	}
+	if domain.Status != storage.CustomDomainVerified {
+		respondJSON(w, http.StatusConflict, map[string]string{"error": "域名尚未验证"})
+		return
+	}
+
+	var req struct {
+		LocalPart string `json:"local_part"`
+		Email     string `json:"email"`
+	}
+	if err := parseJSON(r, &req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "无效的请求"})
+		return
+	}
+
+	local := strings.ToLower(strings.TrimSpace(req.LocalPart))
+	if email := strings.ToLower(strings.TrimSpace(req.Email)); email != "" {
+		at := strings.LastIndex(email, "@")
+		if at < 0 || email[at+1:] != domain.Domain {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("地址必须在 %s 下", domain.Domain)})
+			return
+		}
+		local = email[:at]
+	}
+	if !localPartPattern.MatchString(local) {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "无效的邮箱地址"})
+		return
+	}
+
+	email := local + "@" + domain.Domain
+	addrs, err := s.storage.GetCustomDomainAddresses(domain.UserID, domain.ID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	for _, a := range addrs {
+		if a.Email == email {
+			respondJSON(w, http.StatusConflict, map[string]string{"error": "邮箱地址已存在"})
+			return
+		}
+	}
+
+	addr := &storage.CustomDomainAddress{DomainID: domain.ID, UserID: domain.UserID, Email: email}
+	if err := s.storage.CreateCustomDomainAddress(addr); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, addr)
+}
+
+// deleteCustomDomainAddress 删除自有域名下的地址
+func (s *Server) deleteCustomDomainAddress(w http.ResponseWriter, r *http.Request) {
+	domain := s.customDomainFromRequest(w, r)
+	if domain == nil {
+		return
+	}
+
+	id, err := strconv.ParseInt(mux.Vars(r)["addressId"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.storage.DeleteCustomDomainAddress(domain.UserID, domain.ID, id); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "success"})
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// getDKIMKeys 获取所有DKIM密钥
+func (s *Server) getDKIMKeys(w http.ResponseWriter, r *http.Request) {
+	if s.dkimService == nil {
+		respondJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "DKIM服务不可用"})
+		return
+	}
+
+	keys, err := s.dkimService.ListKeys()
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{"keys": keys})
+}
+
+// createDKIMKey 生成DKIM密钥并发布DNS记录
+func (s *Server) createDKIMKey(w http.ResponseWriter, r *http.Request) {
+	if s.dkimService == nil {
+		respondJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "DKIM服务不可用"})
+		return
+	}
+
+	var req struct {
+		Domain    string `json:"domain"`
+		Selector  string `json:"selector"`
+		Algorithm string `json:"algorithm"` // rsa-sha256（默认）或 ed25519-sha256
+	}
+	if err := parseJSON(r, &req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "无效的请求"})
+		return
+	}
+
+	key, err := s.dkimService.GenerateKey(req.Domain, req.Selector, req.Algorithm)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, key)
+}
+
+// deleteDKIMKey 删除DKIM密钥
+func (s *Server) deleteDKIMKey(w http.ResponseWriter, r *http.Request) {
+	if s.dkimService == nil {
+		respondJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "DKIM服务不可用"})
+		return
+	}
+
+	vars := mux.Vars(r)
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.dkimService.DeleteKey(id); err != nil {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "success"})
+}
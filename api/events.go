@@ -0,0 +1,214 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"mail-server/services"
+	"mail-server/storage"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// eventHeartbeat 心跳间隔，防止代理因空闲断开连接
+	eventHeartbeat = 25 * time.Second
+	// eventWriteTimeout WebSocket单次写入超时
+	eventWriteTimeout = 10 * time.Second
+	// eventReplayLimit 断线重连时最多补发的邮件数量，更早的邮件请通过列表接口获取
+	eventReplayLimit = 100
+)
+
+// upgrader WebSocket升级器，使用token认证而不是cookie，因此允许任意来源
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// subscribeEvents 订阅新邮件，并查出 lastEventID 之后错过的邮件
+// 先订阅再查询，两者之间到达的邮件可能重复，由调用方按ID去重
+func (s *Server) subscribeEvents(userID int64, address string, lastEventID int64) (*services.Subscription, []*services.MailEvent, error) {
+	sub := s.events.Subscribe(userID, address)
+	if lastEventID <= 0 {
+		return sub, nil, nil
+	}
+
+	mails, err := s.storage.ListMails(userID, &storage.MailFilter{To: address, AfterID: lastEventID, Limit: eventReplayLimit})
+	if err != nil {
+		s.events.Unsubscribe(sub)
+		return nil, nil, err
+	}
+	missed := make([]*services.MailEvent, 0, len(mails))
+	for _, mail := range mails {
+		missed = append(missed, services.NewMailEvent(userID, mail))
+	}
+	return sub, missed, nil
+}
+
+// lastEventID 读取续传位置，SSE使用 Last-Event-ID 头，WebSocket使用 last_event_id 参数
+func lastEventID(r *http.Request) int64 {
+	v := r.Header.Get("Last-Event-ID")
+	if v == "" {
+		v = r.URL.Query().Get("last_event_id")
+	}
+	id, _ := strconv.ParseInt(v, 10, 64)
+	return id
+}
+
+// streamEvents 通过SSE推送新邮件，?address= 只接收发到该地址的邮件
+func (s *Server) streamEvents(w http.ResponseWriter, r *http.Request) {
+	if s.events == nil {
+		respondJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "推送服务不可用"})
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "不支持流式响应"})
+		return
+	}
+
+	userID := getUserIDFromRequest(r)
+	last := lastEventID(r)
+	sub, missed, err := s.subscribeEvents(userID, r.URL.Query().Get("address"), last)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	defer s.events.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // 关闭nginx缓冲
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 5000\n\n")
+	flusher.Flush()
+
+	send := func(event *services.MailEvent) error {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+
+	for _, event := range missed {
+		if err := send(event); err != nil {
+			return
+		}
+		last = event.ID
+	}
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-sub.C:
+			if !ok {
+				// 处理过慢被断开，客户端会带着 Last-Event-ID 自动重连
+				return
+			}
+			if event.ID <= last {
+				continue
+			}
+			if err := send(event); err != nil {
+				return
+			}
+			last = event.ID
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// websocketEvents 通过WebSocket推送新邮件，每条消息是一个JSON事件
+func (s *Server) websocketEvents(w http.ResponseWriter, r *http.Request) {
+	if s.events == nil {
+		respondJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "推送服务不可用"})
+		return
+	}
+
+	userID := getUserIDFromRequest(r)
+	last := lastEventID(r)
+	sub, missed, err := s.subscribeEvents(userID, r.URL.Query().Get("address"), last)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	defer s.events.Unsubscribe(sub)
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("[Events] WebSocket升级失败: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	// 读取客户端消息以处理 pong 和关闭帧，超过两个心跳周期没有响应视为断开
+	closed := make(chan struct{})
+	conn.SetReadLimit(512)
+	conn.SetReadDeadline(time.Now().Add(2 * eventHeartbeat))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * eventHeartbeat))
+	})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	send := func(event *services.MailEvent) error {
+		conn.SetWriteDeadline(time.Now().Add(eventWriteTimeout))
+		return conn.WriteJSON(event)
+	}
+
+	for _, event := range missed {
+		if err := send(event); err != nil {
+			return
+		}
+		last = event.ID
+	}
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case event, ok := <-sub.C:
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too slow, reconnect with last_event_id"),
+					time.Now().Add(eventWriteTimeout))
+				return
+			}
+			if event.ID <= last {
+				continue
+			}
+			if err := send(event); err != nil {
+				return
+			}
+			last = event.ID
+		case <-heartbeat.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(eventWriteTimeout)); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// mailFlagsRequest 修改邮件状态的请求，未提供的字段保持不变
+type mailFlagsRequest struct {
+	IDs     []int64 `json:"ids"` // 仅批量接口使用
+	Read    *bool   `json:"read"`
+	Starred *bool   `json:"starred"`
+	Trashed *bool   `json:"trashed"` // true 移入回收站，false 从回收站恢复
+}
+
+// applyMailFlags 对一组邮件应用状态修改，返回受影响的邮件数量
+func (s *Server) applyMailFlags(userID int64, req *mailFlagsRequest) (int64, error) {
+	updated, err := s.storage.UpdateMailFlags(userID, req.IDs, req.Read, req.Starred)
+	if err != nil {
+		return 0, err
+	}
+	if req.Trashed != nil {
+		var n int64
+		if *req.Trashed {
+			n, err = s.storage.TrashMails(userID, req.IDs)
+		} else {
+			n, err = s.storage.RestoreMails(userID, req.IDs)
+		}
+		if err != nil {
+			return 0, err
+		}
+		if n > updated {
+			updated = n
+		}
+	}
+	return updated, nil
+}
+
+// updateMail 修改单封邮件的已读、星标和回收站状态
+func (s *Server) updateMail(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromRequest(r)
+
+	vars := mux.Vars(r)
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	var req mailFlagsRequest
+	if err := parseJSON(r, &req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "无效的请求"})
+		return
+	}
+	req.IDs = []int64{id}
+
+	if _, err := s.storage.GetMailByID(userID, id); err != nil {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "邮件不存在"})
+		return
+	}
+	if _, err := s.applyMailFlags(userID, &req); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	mail, err := s.storage.GetMailByID(userID, id)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	respondJSON(w, http.StatusOK, mail)
+}
+
+// deleteMail 删除邮件：先移入回收站，已在回收站或带 ?permanent=true 时永久删除
+func (s *Server) deleteMail(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromRequest(r)
+
+	vars := mux.Vars(r)
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	mail, err := s.storage.GetMailByID(userID, id)
+	if err != nil {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "邮件不存在"})
+		return
+	}
+
+	permanent := mail.DeletedAt != nil || r.URL.Query().Get("permanent") == "true"
+	if permanent {
+		_, err = s.storage.DeleteMails(userID, []int64{id})
+	} else {
+		_, err = s.storage.TrashMails(userID, []int64{id})
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{"message": "success", "permanent": permanent})
+}
+
+// bulkUpdateMails 批量修改邮件状态
+func (s *Server) bulkUpdateMails(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromRequest(r)
+
+	var req mailFlagsRequest
+	if err := parseJSON(r, &req); err != nil || len(req.IDs) == 0 {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "请提供邮件ID列表"})
+		return
+	}
+
+	updated, err := s.applyMailFlags(userID, &req)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{"updated": updated})
+}
+
+// bulkDeleteMails 批量删除邮件，permanent 为 true 时永久删除，否则移入回收站
+func (s *Server) bulkDeleteMails(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromRequest(r)
+
+	var req struct {
+		IDs       []int64 `json:"ids"`
+		Permanent bool    `json:"permanent"`
+	}
+	if err := parseJSON(r, &req); err != nil || len(req.IDs) == 0 {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "请提供邮件ID列表"})
+		return
+	}
+
+	var deleted int64
+	var err error
+	if req.Permanent {
+		deleted, err = s.storage.DeleteMails(userID, req.IDs)
+	} else {
+		deleted, err = s.storage.TrashMails(userID, req.IDs)
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{"deleted": deleted, "permanent": req.Permanent})
+}
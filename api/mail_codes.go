@@ -0,0 +1,38 @@
+package api
+
+import (
+	"mail-server/services"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// getMailCodes 获取邮件中识别出的验证码和验证链接，旧邮件没有识别结果时现场识别
+func (s *Server) getMailCodes(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromRequest(r)
+
+	vars := mux.Vars(r)
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	mail, err := s.storage.GetMailByID(userID, id)
+	if err != nil {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	}
+
+	codes := mail.MailCodes
+	if len(codes.Codes) == 0 && len(codes.Links) == 0 {
+		codes = services.ExtractCodes(mail.Subject, mail.Body, mail.HTML)
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"id":    mail.ID,
+		"codes": codes.Codes,
+		"links": codes.Links,
+	})
+}
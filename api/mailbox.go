@@ -0,0 +1,131 @@
+package api
+
+import (
+	"fmt"
+	"mail-server/services"
+	"mail-server/storage"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// defaultWaitTimeout 等待新邮件的默认超时时间
+	defaultWaitTimeout = 30 * time.Second
+	// maxWaitTimeout 等待新邮件的最长超时时间，超过的按此值处理
+	maxWaitTimeout = 120 * time.Second
+	// waitPollInterval 推送服务不可用时轮询数据库的间隔
+	waitPollInterval = 2 * time.Second
+)
+
+// mailboxOwner 检查邮箱地址是否属于当前用户，包括catch-all和自有域名下的地址
+func (s *Server) mailboxOwner(userID int64, email string) (bool, error) {
+	owner, err := s.storage.GetMailboxOwner(email)
+	if err != nil {
+		return false, err
+	}
+	return owner != 0 && owner == userID, nil
+}
+
+// waitForMail 阻塞等待发到指定邮箱的新邮件，返回 since 之后第一封符合条件的邮件，超时返回204
+// 等待 user@domain 时也匹配发到 user+tag@domain 的邮件，等待 user+tag@domain 时只匹配该地址
+// 参数：subject/from 子串匹配，since 起始时间（默认为请求时间），timeout 秒数（默认30，最长120）
+func (s *Server) waitForMail(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromRequest(r)
+	email := strings.ToLower(strings.TrimSpace(mux.Vars(r)["email"]))
+	q := r.URL.Query()
+
+	owned, err := s.mailboxOwner(userID, email)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	if !owned {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "邮箱不存在"})
+		return
+	}
+
+	since := time.Now()
+	if v := q.Get("since"); v != "" {
+		if since, err = parseTimeParam(v); err != nil {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("无效的 since: %v", err)})
+			return
+		}
+	}
+
+	timeout := defaultWaitTimeout
+	if v := q.Get("timeout"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds < 0 {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "无效的 timeout"})
+			return
+		}
+		timeout = time.Duration(seconds) * time.Second
+	}
+	if timeout > maxWaitTimeout {
+		timeout = maxWaitTimeout
+	}
+
+	// 按接收时间正序取 since 之后的第一封，received_at 以本地时区的文本保存
+	filter := &storage.MailFilter{
+		From:    strings.TrimSpace(q.Get("from")),
+		Subject: strings.TrimSpace(q.Get("subject")),
+		After:   &storage.MailCursor{ReceivedAt: since.Local()},
+		Limit:   1,
+	}
+	// 与收信时一样去掉 +tag 匹配邮箱，地址本身带 +tag 时只匹配该地址
+	if _, tag := storage.SplitAddressTag(email); tag != "" {
+		filter.To = email
+	} else {
+		filter.Mailbox = email
+	}
+
+	// 先订阅再查询，避免查询之后、开始等待之前到达的邮件被漏掉；推送服务不可用时定期轮询
+	var sub *services.Subscription
+	var events <-chan *services.MailEvent
+	var poll <-chan time.Time
+	if s.events != nil {
+		sub = s.events.Subscribe(userID, email)
+		defer func() { s.events.Unsubscribe(sub) }()
+		events = sub.C
+	} else {
+		ticker := time.NewTicker(waitPollInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for {
+		mails, err := s.storage.ListMails(userID, filter)
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		if len(mails) > 0 {
+			respondJSON(w, http.StatusOK, mails[0])
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			// 客户端已断开
+			return
+		case <-deadline.C:
+			// 超时不是错误，客户端可以直接发起下一次等待
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case _, ok := <-events:
+			if !ok {
+				// 处理过慢被断开，重新订阅后再查一次
+				sub = s.events.Subscribe(userID, email)
+				events = sub.C
+			}
+		case <-poll:
+		}
+	}
+}
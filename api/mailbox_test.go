@@ -0,0 +1,94 @@
+package api
+
+import (
+	"encoding/json"
+	"mail-server/services"
+	"mail-server/storage"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// newWaitTestServer 创建只带存储和推送服务的API服务器，用户拥有邮箱 box@abc.test.local
+func newWaitTestServer(t *testing.T) (*Server, *storage.User) {
+	t.Helper()
+	store, err := storage.NewSQLiteStorage(filepath.Join(t.TempDir(), "mails.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteStorage: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	user, err := store.CreateUser("user@example.org", storage.HashPassword("secret"), "127.0.0.1")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if err := store.CreateMailDomain(user.ID, "abc", "abc.test.local", "rec-1", nil, "box@abc.test.local", nil); err != nil {
+		t.Fatalf("CreateMailDomain: %v", err)
+	}
+	return &Server{storage: store, events: services.NewEventBus()}, user
+}
+
+// waitRequest 以该用户身份调用等待接口
+func waitRequest(s *Server, userID int64, email, query string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/api/mailboxes/"+email+"/wait?"+query, nil)
+	req.Header.Set("X-User-ID", strconv.FormatInt(userID, 10))
+	req = mux.SetURLVars(req, map[string]string{"email": email})
+	rec := httptest.NewRecorder()
+	s.waitForMail(rec, req)
+	return rec
+}
+
+func TestWaitForMailTimeoutReturnsNoContent(t *testing.T) {
+	s, user := newWaitTestServer(t)
+
+	rec := waitRequest(s, user.ID, "box@abc.test.local", "timeout=0")
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	if rec.Body.Len() != 0 {
+		t.Errorf("body = %q, want empty", rec.Body.String())
+	}
+}
+
+func TestWaitForMailMatchesTaggedAddress(t *testing.T) {
+	s, user := newWaitTestServer(t)
+
+	// 等待开始后才收到发到 +tag 地址的邮件
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		id, err := s.storage.SaveMailCopy(user.ID, "github@example.com", []string{"box+signup@abc.test.local"}, "verify", "code 123456", "", storage.MailHeaders{}, 0, storage.AuthResult{})
+		if err != nil {
+			t.Errorf("SaveMailCopy: %v", err)
+			return
+		}
+		mail, err := s.storage.GetMailByID(user.ID, id)
+		if err != nil {
+			t.Errorf("GetMailByID: %v", err)
+			return
+		}
+		s.events.Publish(services.NewMailEvent(user.ID, mail))
+	}()
+
+	rec := waitRequest(s, user.ID, "box@abc.test.local", "timeout=5")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d (%s), want 200", rec.Code, rec.Body.String())
+	}
+	var mail storage.Mail
+	if err := json.Unmarshal(rec.Body.Bytes(), &mail); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if mail.Subject != "verify" {
+		t.Errorf("subject = %q, want verify", mail.Subject)
+	}
+
+	// 指定 +tag 时只匹配该地址
+	rec = waitRequest(s, user.ID, "box+other@abc.test.local", "timeout=0&since="+time.Now().UTC().Add(-time.Minute).Format(time.RFC3339))
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("box+other status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+}
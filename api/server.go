@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -9,17 +10,31 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 )
 
+// 邮箱有效期范围（秒）
+const (
+	minMailboxTTL = 60
+	maxMailboxTTL = 365 * 86400
+)
+
+// dnsCheckTimeout 检查DNS记录时等待查询的时间
+const dnsCheckTimeout = 10 * time.Second
+
 // Server HTTP API服务器
 type Server struct {
-	storage     storage.Storage
-	dnsService  *services.MailDNSService
-	emailSender *services.EmailSender
-	router      *mux.Router
-	port        int
+	storage       storage.Storage
+	dnsService    *services.MailDNSService
+	emailSender   *services.EmailSender
+	dkimService   *services.DKIMService
+	events        *services.EventBus
+	webhooks      *services.WebhookDispatcher
+	customDomains *services.CustomDomainService // 为nil时自有域名相关接口返回503
+	router        *mux.Router
+	port          int
 }
 
 // getUserIDFromRequest 从请求中获取用户ID
@@ -31,13 +46,17 @@ func getUserIDFromRequest(r *http.Request) int64 {
 }
 
 // NewServer 创建新的API服务器
-func NewServer(storage storage.Storage, dnsService *services.MailDNSService, emailSender *services.EmailSender, port int) *Server {
+func NewServer(storage storage.Storage, dnsService *services.MailDNSService, emailSender *services.EmailSender, dkimService *services.DKIMService, events *services.EventBus, webhooks *services.WebhookDispatcher, customDomains *services.CustomDomainService, port int) *Server {
 	s := &Server{
-		storage:     storage,
-		dnsService:  dnsService,
-		emailSender: emailSender,
-		router:      mux.NewRouter(),
-		port:        port,
+		storage:       storage,
+		dnsService:    dnsService,
+		emailSender:   emailSender,
+		dkimService:   dkimService,
+		events:        events,
+		webhooks:      webhooks,
+		customDomains: customDomains,
+		router:        mux.NewRouter(),
+		port:          port,
 	}
 	s.setupRoutes()
 	return s
@@ -58,13 +77,58 @@ func (s *Server) setupRoutes() {
 
 	// API路由 - 需要认证
 	s.router.HandleFunc("/api/mails", s.authMiddleware(s.getMails)).Methods("GET", "OPTIONS")
+	s.router.HandleFunc("/api/mails", s.authMiddleware(s.bulkUpdateMails)).Methods("PATCH", "OPTIONS")
+	s.router.HandleFunc("/api/mails", s.authMiddleware(s.bulkDeleteMails)).Methods("DELETE", "OPTIONS")
+	s.router.HandleFunc("/api/mails/search", s.authMiddleware(s.searchMails)).Methods("GET", "OPTIONS")
 	s.router.HandleFunc("/api/mails/{id}", s.authMiddleware(s.getMailByID)).Methods("GET", "OPTIONS")
+	s.router.HandleFunc("/api/mails/{id}", s.authMiddleware(s.updateMail)).Methods("PATCH", "OPTIONS")
+	s.router.HandleFunc("/api/mails/{id}", s.authMiddleware(s.deleteMail)).Methods("DELETE", "OPTIONS")
+	s.router.HandleFunc("/api/mails/{id}/codes", s.authMiddleware(s.getMailCodes)).Methods("GET", "OPTIONS")
+	s.router.HandleFunc("/api/mails/{id}/attachments", s.authMiddleware(s.getAttachments)).Methods("GET", "OPTIONS")
+	s.router.HandleFunc("/api/mails/{id}/attachments/{attachmentId}", s.authMiddleware(s.downloadAttachment)).Methods("GET", "OPTIONS")
 	s.router.HandleFunc("/api/stats", s.authMiddleware(s.getStats)).Methods("GET", "OPTIONS")
 
+	// 新邮件推送 - 需要认证（浏览器的 EventSource/WebSocket 无法设置请求头，支持 ?access_token=）
+	s.router.HandleFunc("/api/events", s.streamAuthMiddleware(s.streamEvents)).Methods("GET", "OPTIONS")
+	s.router.HandleFunc("/api/events/ws", s.streamAuthMiddleware(s.websocketEvents)).Methods("GET", "OPTIONS")
+
+	// 长轮询等待新邮件（供自动化测试使用） - 需要认证
+	s.router.HandleFunc("/api/mailboxes/{email}/wait", s.authMiddleware(s.waitForMail)).Methods("GET", "OPTIONS")
+
+	// Webhook - 需要认证
+	s.router.HandleFunc("/api/webhooks", s.authMiddleware(s.getWebhooks)).Methods("GET", "OPTIONS")
+	s.router.HandleFunc("/api/webhooks", s.authMiddleware(s.createWebhook)).Methods("POST", "OPTIONS")
+	s.router.HandleFunc("/api/webhooks/{id}", s.authMiddleware(s.updateWebhook)).Methods("PUT", "OPTIONS")
+	s.router.HandleFunc("/api/webhooks/{id}", s.authMiddleware(s.deleteWebhook)).Methods("DELETE", "OPTIONS")
+	s.router.HandleFunc("/api/webhooks/{id}/deliveries", s.authMiddleware(s.getWebhookDeliveries)).Methods("GET", "OPTIONS")
+	s.router.HandleFunc("/api/webhooks/{id}/test", s.authMiddleware(s.testWebhook)).Methods("POST", "OPTIONS")
+
 	// DNS管理API - 需要认证
 	s.router.HandleFunc("/api/domains", s.authMiddleware(s.getDomains)).Methods("GET", "OPTIONS")
 	s.router.HandleFunc("/api/domains", s.authMiddleware(s.createDomain)).Methods("POST", "OPTIONS")
 	s.router.HandleFunc("/api/domains/{id}", s.authMiddleware(s.deleteDomain)).Methods("DELETE", "OPTIONS")
+	s.router.HandleFunc("/api/domains/{id}/catch-all", s.authMiddleware(s.setDomainCatchAll)).Methods("PUT", "OPTIONS")
+	s.router.HandleFunc("/api/domains/{id}/ttl", s.authMiddleware(s.setDomainTTL)).Methods("PUT", "OPTIONS")
+	s.router.HandleFunc("/api/domains/{id}/dns-check", s.authMiddleware(s.checkDomainDNS)).Methods("GET", "OPTIONS")
+	s.router.HandleFunc("/api/domains/{id}/addresses", s.authMiddleware(s.getDomainAddresses)).Methods("GET", "OPTIONS")
+	s.router.HandleFunc("/api/domains/{id}/addresses", s.authMiddleware(s.createDomainAddress)).Methods("POST", "OPTIONS")
+	s.router.HandleFunc("/api/domains/{id}/addresses/{addressId}", s.authMiddleware(s.deleteDomainAddress)).Methods("DELETE", "OPTIONS")
+
+	// 自有域名API - 需要认证
+	s.router.HandleFunc("/api/custom-domains", s.authMiddleware(s.getCustomDomains)).Methods("GET", "OPTIONS")
+	s.router.HandleFunc("/api/custom-domains", s.authMiddleware(s.createCustomDomain)).Methods("POST", "OPTIONS")
+	s.router.HandleFunc("/api/custom-domains/{id}", s.authMiddleware(s.getCustomDomain)).Methods("GET", "OPTIONS")
+	s.router.HandleFunc("/api/custom-domains/{id}", s.authMiddleware(s.deleteCustomDomain)).Methods("DELETE", "OPTIONS")
+	s.router.HandleFunc("/api/custom-domains/{id}/verify", s.authMiddleware(s.verifyCustomDomain)).Methods("POST", "OPTIONS")
+	s.router.HandleFunc("/api/custom-domains/{id}/catch-all", s.authMiddleware(s.setCustomDomainCatchAll)).Methods("PUT", "OPTIONS")
+	s.router.HandleFunc("/api/custom-domains/{id}/addresses", s.authMiddleware(s.getCustomDomainAddresses)).Methods("GET", "OPTIONS")
+	s.router.HandleFunc("/api/custom-domains/{id}/addresses", s.authMiddleware(s.createCustomDomainAddress)).Methods("POST", "OPTIONS")
+	s.router.HandleFunc("/api/custom-domains/{id}/addresses/{addressId}", s.authMiddleware(s.deleteCustomDomainAddress)).Methods("DELETE", "OPTIONS")
+
+	// DKIM密钥管理API - 需要管理员权限
+	s.router.HandleFunc("/api/dkim/keys", s.adminMiddleware(s.getDKIMKeys)).Methods("GET", "OPTIONS")
+	s.router.HandleFunc("/api/dkim/keys", s.adminMiddleware(s.createDKIMKey)).Methods("POST", "OPTIONS")
+	s.router.HandleFunc("/api/dkim/keys/{id}", s.adminMiddleware(s.deleteDKIMKey)).Methods("DELETE", "OPTIONS")
 
 	// 邮件发送API - 需要认证
 	s.router.HandleFunc("/api/send-email", s.authMiddleware(s.sendEmail)).Methods("POST", "OPTIONS")
@@ -80,31 +144,26 @@ func (s *Server) Start() error {
 	return http.ListenAndServe(addr, s.router)
 }
 
-// getMails 获取邮件列表
+// getMails 获取邮件列表，支持按邮箱、发件人、时间、已读和附件筛选，以及游标分页
 func (s *Server) getMails(w http.ResponseWriter, r *http.Request) {
 	// 获取当前用户ID
 	userID := getUserIDFromRequest(r)
 
-	// 解析查询参数
-	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
-	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
-
-	if limit <= 0 {
-		limit = 20
-	}
-	if limit > 100 {
-		limit = 100
+	filter, err := parseMailFilter(r)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
 	}
 
 	// 获取用户的邮件
-	mails, err := s.storage.GetMails(userID, limit, offset)
+	mails, err := s.storage.ListMails(userID, filter)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// 获取用户邮件总数
-	total, err := s.storage.GetMailCount(userID)
+	// 符合筛选条件的邮件总数
+	total, err := s.storage.CountMails(userID, filter)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -113,15 +172,150 @@ func (s *Server) getMails(w http.ResponseWriter, r *http.Request) {
 	// 构建响应
 	response := map[string]interface{}{
 		"total":  total,
-		"limit":  limit,
-		"offset": offset,
+		"limit":  filter.Limit,
+		"offset": filter.Offset,
 		"mails":  mails,
 	}
 
+	// next_cursor 用于继续向前翻页（before），newest_cursor 用于轮询新邮件（after）
+	if len(mails) > 0 {
+		if filter.After != nil {
+			response["newest_cursor"] = storage.CursorOf(mails[len(mails)-1]).String()
+		} else {
+			response["newest_cursor"] = storage.CursorOf(mails[0]).String()
+			if len(mails) == filter.Limit {
+				response["next_cursor"] = storage.CursorOf(mails[len(mails)-1]).String()
+			}
+		}
+	} else if filter.After != nil {
+		response["newest_cursor"] = r.URL.Query().Get("after")
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
+// parseMailFilter 解析邮件列表的查询参数
+func parseMailFilter(r *http.Request) (*storage.MailFilter, error) {
+	q := r.URL.Query()
+	filter := &storage.MailFilter{
+		To:      strings.TrimSpace(q.Get("to")),
+		Tag:     strings.TrimSpace(q.Get("tag")),
+		From:    strings.TrimSpace(q.Get("from")),
+		Subject: strings.TrimSpace(q.Get("subject")),
+	}
+
+	filter.Limit, _ = strconv.Atoi(q.Get("limit"))
+	filter.Offset, _ = strconv.Atoi(q.Get("offset"))
+	if filter.Limit <= 0 {
+		filter.Limit = 20
+	}
+	if filter.Limit > 100 {
+		filter.Limit = 100
+	}
+	if filter.Offset < 0 {
+		filter.Offset = 0
+	}
+
+	if v := q.Get("domain_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("无效的 domain_id")
+		}
+		filter.DomainID = id
+	}
+
+	var err error
+	if filter.Since, err = parseTimeParam(q.Get("since")); err != nil {
+		return nil, fmt.Errorf("无效的 since: %v", err)
+	}
+	if filter.Until, err = parseTimeParam(q.Get("until")); err != nil {
+		return nil, fmt.Errorf("无效的 until: %v", err)
+	}
+	if filter.Read, err = parseBoolParam(q.Get("read")); err != nil {
+		return nil, fmt.Errorf("无效的 read: %v", err)
+	}
+	if filter.HasAttachment, err = parseBoolParam(q.Get("has_attachment")); err != nil {
+		return nil, fmt.Errorf("无效的 has_attachment: %v", err)
+	}
+	if filter.Starred, err = parseBoolParam(q.Get("starred")); err != nil {
+		return nil, fmt.Errorf("无效的 starred: %v", err)
+	}
+	filter.Trash = q.Get("trash") == "true"
+
+	if v := q.Get("before"); v != "" {
+		if filter.Before, err = storage.ParseMailCursor(v); err != nil {
+			return nil, fmt.Errorf("无效的 before 游标")
+		}
+	}
+	if v := q.Get("after"); v != "" {
+		if filter.After, err = storage.ParseMailCursor(v); err != nil {
+			return nil, fmt.Errorf("无效的 after 游标")
+		}
+	}
+	if filter.Before != nil && filter.After != nil {
+		return nil, fmt.Errorf("before 和 after 不能同时使用")
+	}
+
+	return filter, nil
+}
+
+// parseTimeParam 解析时间参数，支持 RFC3339 和 2006-01-02（按服务器本地时区）
+func parseTimeParam(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", v, time.Local)
+}
+
+// parseBoolParam 解析可选的布尔参数，为空时返回 nil
+func parseBoolParam(v string) (*bool, error) {
+	if v == "" {
+		return nil, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// searchMails 全文搜索邮件
+func (s *Server) searchMails(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromRequest(r)
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "搜索内容不能为空"})
+		return
+	}
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	if limit <= 0 {
+		limit = 20
+	}
+	if limit > 100 {
+		limit = 100
+	}
+
+	mails, err := s.storage.SearchMails(userID, query, limit, offset)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"query":  query,
+		"limit":  limit,
+		"offset": offset,
+		"mails":  mails,
+	})
+}
+
 // getMailByID 根据ID获取邮件
 func (s *Server) getMailByID(w http.ResponseWriter, r *http.Request) {
 	// 获取当前用户ID
@@ -155,8 +349,23 @@ func (s *Server) getStats(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 每个收件地址的未读数
+	unreadByAddress, err := s.storage.GetUnreadCounts(userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	read := false
+	unread, err := s.storage.CountMails(userID, &storage.MailFilter{Read: &read})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	response := map[string]interface{}{
-		"total_mails": total,
+		"total_mails":       total,
+		"unread_mails":      unread,
+		"unread_by_address": unreadByAddress,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -167,7 +376,7 @@ func (s *Server) getStats(w http.ResponseWriter, r *http.Request) {
 func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 
 		if r.Method == "OPTIONS" {
@@ -219,6 +428,7 @@ func (s *Server) createDomain(w http.ResponseWriter, r *http.Request) {
 
 	var req struct {
 		Email string `json:"email"`
+		TTL   int64  `json:"ttl"` // 有效期（秒），0表示永久
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -231,6 +441,12 @@ func (s *Server) createDomain(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	expiresAt, err := mailboxExpiry(req.TTL)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
 	// 检查用户是否达到创建限制（非管理员）
 	if userEmail != "admin@admin.com" {
 		user, err := s.storage.GetUserByEmail(userEmail)
@@ -263,7 +479,7 @@ func (s *Server) createDomain(w http.ResponseWriter, r *http.Request) {
 		fullDomain := fmt.Sprintf("%s.mail.example.com", subdomain)
 
 		// 直接保存到数据库
-		err := s.storage.CreateMailDomain(userID, subdomain, fullDomain, subdomain, req.Email)
+		err := s.storage.CreateMailDomain(userID, subdomain, fullDomain, subdomain, nil, req.Email, expiresAt)
 		if err != nil {
 			response := map[string]string{"error": err.Error()}
 			w.Header().Set("Content-Type", "application/json")
@@ -277,6 +493,7 @@ func (s *Server) createDomain(w http.ResponseWriter, r *http.Request) {
 			FullDomain: fullDomain,
 			RecordID:   subdomain,
 			Email:      req.Email,
+			ExpiresAt:  expiresAt,
 		}
 
 		// 增加用户域名计数
@@ -287,7 +504,7 @@ func (s *Server) createDomain(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	domain, err := s.dnsService.CreateMailDomain(userID, req.Email)
+	domain, err := s.dnsService.CreateMailDomain(userID, req.Email, expiresAt)
 	if err != nil {
 		response := map[string]string{"error": err.Error()}
 		w.Header().Set("Content-Type", "application/json")
@@ -339,6 +556,113 @@ func (s *Server) deleteDomain(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]string{"message": "success"})
 }
 
+// setDomainCatchAll 开启或关闭邮箱域名的catch-all
+func (s *Server) setDomainCatchAll(w http.ResponseWriter, r *http.Request) {
+	// 获取当前用户ID
+	userID := getUserIDFromRequest(r)
+
+	vars := mux.Vars(r)
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Enabled bool `json:"enabled"`
+	}
+	if err := parseJSON(r, &req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "无效的请求"})
+		return
+	}
+
+	err = s.storage.SetMailDomainCatchAll(userID, id, req.Enabled)
+	if err != nil {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{"id": id, "catch_all": req.Enabled})
+}
+
+// setDomainTTL 修改邮箱的有效期，从现在开始计算，ttl 为0表示永久
+func (s *Server) setDomainTTL(w http.ResponseWriter, r *http.Request) {
+	// 获取当前用户ID
+	userID := getUserIDFromRequest(r)
+
+	vars := mux.Vars(r)
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		TTL int64 `json:"ttl"`
+	}
+	if err := parseJSON(r, &req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "无效的请求"})
+		return
+	}
+
+	expiresAt, err := mailboxExpiry(req.TTL)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	if err := s.storage.SetMailDomainExpiry(userID, id, expiresAt); err != nil {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{"id": id, "expires_at": expiresAt})
+}
+
+// checkDomainDNS 查询邮箱子域名实际发布的MX、SPF、DMARC等记录，报告缺失或不一致的记录
+func (s *Server) checkDomainDNS(w http.ResponseWriter, r *http.Request) {
+	domain := s.mailDomainFromRequest(w, r)
+	if domain == nil {
+		return
+	}
+	if s.dnsService == nil || !s.dnsService.ManagesDNS() {
+		respondJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "DNS服务不可用"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), dnsCheckTimeout)
+	defer cancel()
+	checks, err := s.dnsService.CheckRecords(ctx, domain)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	ok := true
+	for _, check := range checks {
+		if check.Status != storage.DNSCheckOK {
+			ok = false
+		}
+	}
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"domain": domain.FullDomain,
+		"ok":     ok,
+		"checks": checks,
+	})
+}
+
+// mailboxExpiry 根据有效期（秒）计算过期时间，0表示永久
+func mailboxExpiry(ttl int64) (*time.Time, error) {
+	if ttl == 0 {
+		return nil, nil
+	}
+	if ttl < minMailboxTTL || ttl > maxMailboxTTL {
+		return nil, fmt.Errorf("有效期必须在%d秒到%d天之间", minMailboxTTL, maxMailboxTTL/86400)
+	}
+	expiresAt := time.Now().Add(time.Duration(ttl) * time.Second)
+	return &expiresAt, nil
+}
+
 // sendEmail 发送邮件
 func (s *Server) sendEmail(w http.ResponseWriter, r *http.Request) {
 	if s.emailSender == nil {
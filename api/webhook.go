@@ -0,0 +1,242 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mail-server/services"
+	"mail-server/storage"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// maxWebhooksPerUser 每个用户最多创建的Webhook数量
+const maxWebhooksPerUser = 10
+
+// webhookEvents 可以订阅的事件
+var webhookEvents = map[string]bool{
+	storage.WebhookEventMailReceived: true,
+}
+
+// webhookRequest 创建和修改Webhook的请求
+type webhookRequest struct {
+	URL      string   `json:"url"`
+	Secret   string   `json:"secret"`    // 为空时自动生成（修改时保持不变）
+	DomainID int64    `json:"domain_id"` // 0表示所有邮箱
+	Events   []string `json:"events"`    // 为空表示全部事件
+	Active   *bool    `json:"active"`    // 默认启用
+}
+
+// applyWebhookRequest 校验请求并写入Webhook
+func (s *Server) applyWebhookRequest(userID int64, req *webhookRequest, hook *storage.Webhook) error {
+	u, err := services.ValidateWebhookURL(req.URL)
+	if err != nil {
+		return err
+	}
+
+	for _, event := range req.Events {
+		if !webhookEvents[event] {
+			return fmt.Errorf("不支持的事件: %s", event)
+		}
+	}
+
+	if req.DomainID != 0 {
+		domains, err := s.storage.GetMailDomains(userID)
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, d := range domains {
+			if d.ID == req.DomainID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("邮箱不存在")
+		}
+	}
+
+	hook.URL = u.String()
+	hook.DomainID = req.DomainID
+	hook.Events = req.Events
+	if hook.Events == nil {
+		hook.Events = []string{}
+	}
+	if req.Secret != "" {
+		hook.Secret = req.Secret
+	}
+	if hook.Secret == "" {
+		secret := make([]byte, 24)
+		if _, err := rand.Read(secret); err != nil {
+			return fmt.Errorf("生成密钥失败: %v", err)
+		}
+		hook.Secret = hex.EncodeToString(secret)
+	}
+	if req.Active != nil {
+		hook.Active = *req.Active
+	}
+	return nil
+}
+
+// getWebhooks 获取Webhook列表
+func (s *Server) getWebhooks(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromRequest(r)
+
+	hooks, err := s.storage.GetWebhooks(userID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{"webhooks": hooks})
+}
+
+// createWebhook 创建Webhook
+func (s *Server) createWebhook(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromRequest(r)
+
+	var req webhookRequest
+	if err := parseJSON(r, &req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "无效的请求"})
+		return
+	}
+
+	hooks, err := s.storage.GetWebhooks(userID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	if len(hooks) >= maxWebhooksPerUser {
+		respondJSON(w, http.StatusForbidden, map[string]string{"error": fmt.Sprintf("最多创建%d个Webhook", maxWebhooksPerUser)})
+		return
+	}
+
+	hook := &storage.Webhook{UserID: userID, Active: true}
+	if err := s.applyWebhookRequest(userID, &req, hook); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if err := s.storage.CreateWebhook(hook); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, hook)
+}
+
+// webhookFromRequest 读取路径中的Webhook，不存在时写入错误响应并返回 nil
+func (s *Server) webhookFromRequest(w http.ResponseWriter, r *http.Request) *storage.Webhook {
+	userID := getUserIDFromRequest(r)
+
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return nil
+	}
+
+	hook, err := s.storage.GetWebhook(userID, id)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return nil
+	}
+	if hook == nil {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "Webhook不存在"})
+		return nil
+	}
+	return hook
+}
+
+// updateWebhook 修改Webhook
+func (s *Server) updateWebhook(w http.ResponseWriter, r *http.Request) {
+	hook := s.webhookFromRequest(w, r)
+	if hook == nil {
+		return
+	}
+
+	var req webhookRequest
+	if err := parseJSON(r, &req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "无效的请求"})
+		return
+	}
+	if err := s.applyWebhookRequest(hook.UserID, &req, hook); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if err := s.storage.UpdateWebhook(hook); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, hook)
+}
+
+// deleteWebhook 删除Webhook及其投递日志
+func (s *Server) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	hook := s.webhookFromRequest(w, r)
+	if hook == nil {
+		return
+	}
+
+	if err := s.storage.DeleteWebhook(hook.UserID, hook.ID); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "success"})
+}
+
+// getWebhookDeliveries 获取Webhook的投递日志
+func (s *Server) getWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	hook := s.webhookFromRequest(w, r)
+	if hook == nil {
+		return
+	}
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	if limit <= 0 {
+		limit = 20
+	}
+	if limit > 100 {
+		limit = 100
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	deliveries, err := s.storage.GetWebhookDeliveries(hook.UserID, hook.ID, limit, offset)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"limit":      limit,
+		"offset":     offset,
+		"deliveries": deliveries,
+	})
+}
+
+// testWebhook 立即发送一次测试事件，返回投递结果
+func (s *Server) testWebhook(w http.ResponseWriter, r *http.Request) {
+	if s.webhooks == nil {
+		respondJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "Webhook服务不可用"})
+		return
+	}
+
+	hook := s.webhookFromRequest(w, r)
+	if hook == nil {
+		return
+	}
+
+	delivery, err := s.webhooks.TestFire(hook)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, delivery)
+}
@@ -85,7 +85,7 @@ echo "正在编译..."
 echo "下载依赖..."
 go mod download
 echo "开始编译程序..."
-CGO_ENABLED=1 go build -v -o ../mail-server
+CGO_ENABLED=1 go build -v -tags sqlite_fts5 -o ../mail-server
 BUILD_STATUS=$?
 if [ $BUILD_STATUS -eq 0 ]; then
     echo "✓ 编译成功"
@@ -5,8 +5,19 @@ go 1.23.12
 require (
 	github.com/golang-jwt/jwt/v5 v5.3.0
 	github.com/gorilla/mux v1.8.1
+	github.com/gorilla/websocket v1.5.3
 	github.com/mattn/go-sqlite3 v1.14.32
+	github.com/miekg/dns v1.1.68
 	github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common v1.1.54
 	github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/dnspod v1.1.50
+	golang.org/x/net v0.40.0
+	golang.org/x/text v0.25.0
 	gopkg.in/yaml.v3 v3.0.1
 )
+
+require (
+	golang.org/x/mod v0.24.0 // indirect
+	golang.org/x/sync v0.14.0 // indirect
+	golang.org/x/sys v0.33.0 // indirect
+	golang.org/x/tools v0.33.0 // indirect
+)
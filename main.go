@@ -1,15 +1,21 @@
 package main
 
 import (
+	"crypto/tls"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"mail-server/api"
 	"mail-server/services"
 	"mail-server/smtp"
 	"mail-server/storage"
+	"net"
+	"net/mail"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -23,6 +29,22 @@ type Config struct {
 	PublicIP         string `yaml:"public_ip"`
 	TencentSecretID  string `yaml:"tencent_secret_id"`
 	TencentSecretKey string `yaml:"tencent_secret_key"`
+	// DNS服务商配置
+	DNSProvider            string `yaml:"dns_provider"` // dnspod（默认）、cloudflare、route53、rfc2136
+	CloudflareAPIToken     string `yaml:"cloudflare_api_token"`
+	CloudflareZoneID       string `yaml:"cloudflare_zone_id"` // 为空时按域名查询
+	Route53AccessKeyID     string `yaml:"route53_access_key_id"`
+	Route53SecretAccessKey string `yaml:"route53_secret_access_key"`
+	Route53HostedZoneID    string `yaml:"route53_hosted_zone_id"` // 为空时按域名查询
+	Route53Endpoint        string `yaml:"route53_endpoint"`       // Route53兼容接口地址，为空时使用AWS
+	Route53Region          string `yaml:"route53_region"`
+	RFC2136Server          string `yaml:"rfc2136_server"`         // 主DNS服务器 host:port
+	RFC2136KeyName         string `yaml:"rfc2136_tsig_key_name"`  // TSIG密钥名，为空时不签名
+	RFC2136Secret          string `yaml:"rfc2136_tsig_secret"`    // TSIG密钥（base64）
+	RFC2136Algorithm       string `yaml:"rfc2136_tsig_algorithm"` // hmac-sha256（默认）、hmac-sha512、hmac-sha1
+	// 邮箱子域名的SPF、DMARC、TLS-RPT记录
+	DMARCRua  string `yaml:"dmarc_rua"`   // DMARC汇总报告地址，为空时不设置 rua
+	TLSRPTRua string `yaml:"tls_rpt_rua"` // TLS-RPT报告地址，为空时不发布
 	// 邮件发送配置
 	EmailSMTPHost   string `yaml:"email_smtp_host"`
 	EmailSMTPPort   int    `yaml:"email_smtp_port"`
@@ -30,46 +52,252 @@ type Config struct {
 	EmailPassword   string `yaml:"email_password"`
 	EmailSenderName string `yaml:"email_sender_name"`
 	// 邮件转发配置
-	ForwardEnabled bool `yaml:"forward_enabled"`
+	ForwardEnabled     bool `yaml:"forward_enabled"`
+	QueueWorkers       int  `yaml:"queue_workers"`         // 外发队列投递worker数量
+	QueueRetryInterval int  `yaml:"queue_retry_interval"`  // 首次重试间隔（秒），之后指数增长
+	QueueMaxRetryHours int  `yaml:"queue_max_retry_hours"` // 最长重试时间（小时），超过后退信
+	// 邮件大小上限（字节）
+	MaxMessageSize int64 `yaml:"max_message_size"`
+	// TLS配置
+	TLSCertFile    string `yaml:"tls_cert_file"`   // 证书文件路径（PEM），为空则不启用TLS
+	TLSKeyFile     string `yaml:"tls_key_file"`    // 私钥文件路径（PEM）
+	SubmissionPort int    `yaml:"submission_port"` // 邮件提交端口（STARTTLS）
+	SMTPSPort      int    `yaml:"smtps_port"`      // 隐式TLS端口，0表示不启用
+	RequireTLS     bool   `yaml:"require_tls"`     // 提交端口是否要求先启用TLS
+	// 回收站邮件保留天数，超过后永久删除
+	TrashRetentionDays int `yaml:"trash_retention_days"`
+	// Webhook投递配置
+	WebhookWorkers       int `yaml:"webhook_workers"`        // Webhook投递worker数量
+	WebhookRetryInterval int `yaml:"webhook_retry_interval"` // 首次重试间隔（秒），之后指数增长
+	WebhookMaxAttempts   int `yaml:"webhook_max_attempts"`   // 最多尝试次数，超过后标记为失败
 }
 
 // MailHandler 邮件处理器
 type MailHandler struct {
-	storage storage.Storage
+	storage  storage.Storage
+	events   *services.EventBus          // 新邮件通知，为nil时不推送
+	webhooks *services.WebhookDispatcher // Webhook推送，为nil时不推送
 }
 
-func (h *MailHandler) HandleMail(msg *smtp.MailMessage) error {
+// HandleMail 按收件人所属用户分别保存邮件，原始数据只保存一份
+func (h *MailHandler) HandleMail(msg *smtp.MailMessage) ([]smtp.DeliveryResult, error) {
 	log.Printf("Received mail from %s to %v with subject: %s", msg.From, msg.To, msg.Subject)
 
-	// 根据收件人邮箱地址找到创建者
-	var userID int64 = 0
-	if len(msg.To) > 0 {
-		// 直接查找这个邮箱是谁创建的
-		recipientEmail := msg.To[0]
-		domain, err := h.storage.GetMailDomainByEmail(recipientEmail)
+	results := make([]smtp.DeliveryResult, 0, len(msg.To))
+	owners := make(map[int64][]string)
+	tags := make(map[int64][]string)
+	var ownerOrder []int64
+
+	// 每个收件人单独查找归属用户，user+tag 形式的地址投递到 user 并记录 tag
+	for _, recipientEmail := range msg.To {
+		recipient, err := h.storage.ResolveRecipient(recipientEmail)
 		if err != nil {
 			log.Printf("Warning: Failed to find mail domain for %s: %v", recipientEmail, err)
-		} else if domain != nil {
-			// 直接从域名记录中获取 user_id
-			userID = domain.UserID
-			log.Printf("[Mail] 邮件归属用户ID: %d (邮箱: %s)", userID, recipientEmail)
-		} else {
+			results = append(results, smtp.DeliveryResult{Recipient: recipientEmail, Err: err, Temporary: true})
+			continue
+		}
+		if recipient == nil {
 			log.Printf("Warning: 邮箱 %s 未在系统中创建", recipientEmail)
+			results = append(results, smtp.DeliveryResult{Recipient: recipientEmail, Err: fmt.Errorf("mailbox %s not found", recipientEmail)})
+			continue
+		}
+
+		userID := recipient.UserID
+		log.Printf("[Mail] 邮件归属用户ID: %d (邮箱: %s)", userID, recipientEmail)
+		if _, ok := owners[userID]; !ok {
+			ownerOrder = append(ownerOrder, userID)
+		}
+		owners[userID] = append(owners[userID], recipientEmail)
+		if recipient.Tag != "" {
+			tags[userID] = append(tags[userID], recipient.Tag)
+		}
+	}
+
+	if len(ownerOrder) == 0 {
+		return results, nil
+	}
+
+	rawID, err := h.storage.SaveRawMessage(msg.RawData)
+	if err != nil {
+		log.Printf("Error: 保存原始邮件失败: %v", err)
+		return nil, err
+	}
+
+	// 入站验证结果（提交端口和本地退信没有）
+	var auth storage.AuthResult
+	if msg.Auth != nil {
+		auth = storage.AuthResult{SPF: msg.Auth.SPF, DKIM: msg.Auth.DKIMResult(), DMARC: msg.Auth.DMARC}
+	}
+
+	headers := storage.MailHeaders{
+		HeaderTo: storageAddresses(msg.HeaderTo),
+		Cc:       storageAddresses(msg.Cc),
+	}
+	if msg.HeaderFrom != nil {
+		headers.HeaderFrom = storage.Address{Name: msg.HeaderFrom.Name, Address: msg.HeaderFrom.Address}
+	}
+
+	// 识别验证码和验证链接，每份邮件记录保存相同的结果
+	codes := services.ExtractCodes(msg.Subject, msg.Body, msg.HTML)
+	if len(codes.Codes) > 0 || len(codes.Links) > 0 {
+		log.Printf("[Mail] 识别到验证码 %v，链接 %d 个", codes.Codes, len(codes.Links))
+	}
+
+	// 每个用户保存一份邮件记录
+	for _, userID := range ownerOrder {
+		recipients := owners[userID]
+		// 邮件记录、附件、验证码和tag在同一事务中保存，失败时不会留下残缺的邮件，发件方重试也不会产生重复邮件
+		mailID, err := h.storage.DeliverMailCopy(&storage.MailCopy{
+			UserID:      userID,
+			From:        msg.From,
+			To:          recipients,
+			Subject:     msg.Subject,
+			Body:        msg.Body,
+			HTML:        msg.HTML,
+			Headers:     headers,
+			RawID:       rawID,
+			Auth:        auth,
+			Attachments: storageAttachments(msg.Attachments),
+			Codes:       codes,
+			Tags:        tags[userID],
+		})
+		if err != nil {
+			log.Printf("Error: 保存邮件失败 (userID: %d): %v", userID, err)
+		} else {
+			log.Printf("✓ 邮件已保存 (userID: %d, from: %s, to: %v, attachments: %d)", userID, msg.From, recipients, len(msg.Attachments))
+			h.publish(userID, mailID)
+		}
+		for _, recipient := range recipients {
+			results = append(results, smtp.DeliveryResult{Recipient: recipient, Err: err, Temporary: err != nil})
 		}
 	}
 
-	// 如果找不到用户，使用默认userID=0（公共邮件）
-	if userID == 0 {
-		log.Printf("Warning: 邮件保存为公共邮件 (userID=0)，需要先在系统中创建该邮箱")
+	return results, nil
+}
+
+// publish 通知在线客户端和Webhook有新邮件
+func (h *MailHandler) publish(userID, mailID int64) {
+	if h.events == nil && h.webhooks == nil {
+		return
+	}
+	mail, err := h.storage.GetMailByID(userID, mailID)
+	if err != nil {
+		log.Printf("Warning: 读取新邮件失败，未推送通知: %v", err)
+		return
+	}
+	if h.events != nil {
+		h.events.Publish(services.NewMailEvent(userID, mail))
+	}
+	if h.webhooks != nil {
+		if err := h.webhooks.MailReceived(userID, mail); err != nil {
+			log.Printf("Warning: 创建Webhook投递失败 (userID: %d, mail: %d): %v", userID, mailID, err)
+		}
+	}
+}
+
+// storageAttachments 转换为存储层的附件记录，每份邮件记录各自一组附件行，内容由存储层共享
+func storageAttachments(attachments []*smtp.Attachment) []*storage.Attachment {
+	result := make([]*storage.Attachment, 0, len(attachments))
+	for _, a := range attachments {
+		result = append(result, &storage.Attachment{
+			Filename:    a.Filename,
+			ContentType: a.ContentType,
+			ContentID:   a.ContentID,
+			Inline:      a.Inline,
+			Data:        a.Data,
+		})
+	}
+	return result
+}
+
+// storageAddresses 转换为存储层的地址列表
+func storageAddresses(addrs []*mail.Address) []storage.Address {
+	result := make([]storage.Address, 0, len(addrs))
+	for _, a := range addrs {
+		result = append(result, storage.Address{Name: a.Name, Address: a.Address})
+	}
+	return result
+}
+
+// IsLocalDomain 已验证的自有域名由本服务器接收
+func (h *MailHandler) IsLocalDomain(domain string) bool {
+	custom, err := h.storage.GetCustomDomainByName(domain)
+	if err != nil {
+		log.Printf("Warning: 查询自有域名失败 (%s): %v", domain, err)
+		return false
+	}
+	return custom != nil
+}
+
+// ValidateRecipient 在 RCPT TO 阶段检查收件人是否存在
+func (h *MailHandler) ValidateRecipient(email string) (bool, error) {
+	// postmaster 由 ResolveRecipient 投递给管理员，没有管理员时拒收
+	userID, err := h.storage.GetMailboxOwner(email)
+	if err != nil {
+		return false, err
+	}
+	return userID != 0, nil
+}
+
+// isOwnSMTPHost 判断SMTP主机是否为本服务器（主域名或 mail. 子域名）
+func isOwnSMTPHost(host, domain string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	domain = strings.ToLower(domain)
+	return host == "localhost" || host == domain || host == "mail."+domain
+}
+
+// SMTPAuthenticator 基于用户表的SMTP认证
+type SMTPAuthenticator struct {
+	storage      storage.Storage
+	domain       string // 系统主域名
+	systemSender string // 系统发件人（email_sender），以该账户登录时可以使用该地址发信
+}
+
+// Authenticate 使用登录邮箱和密码认证
+func (a *SMTPAuthenticator) Authenticate(username, password string) (*smtp.AuthUser, error) {
+	user, err := a.storage.GetUserByEmail(username)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || !user.CheckPassword(password) {
+		return nil, nil
 	}
+	return &smtp.AuthUser{ID: user.ID, Email: user.Email, IsAdmin: user.IsAdmin}, nil
+}
 
-	err := h.storage.SaveMail(userID, msg.From, msg.To, msg.Subject, msg.Body, msg.RawData)
+// CanSendAs 发件人必须是该用户创建的邮箱、别名或其已验证自有域名下的地址，管理员可以使用主域名下的地址
+// 系统发件人账户可以使用配置的 email_sender 地址发送验证码等系统邮件
+func (a *SMTPAuthenticator) CanSendAs(user *smtp.AuthUser, from string) (bool, error) {
+	if from == "" {
+		return false, nil
+	}
+	if a.systemSender != "" && strings.EqualFold(from, a.systemSender) && strings.EqualFold(user.Email, a.systemSender) {
+		return true, nil
+	}
+	domain, err := a.storage.GetMailDomainByEmail(from)
+	if err != nil {
+		return false, err
+	}
+	if domain != nil {
+		return domain.UserID == user.ID, nil
+	}
+	alias, err := a.storage.GetAliasByEmail(from)
 	if err != nil {
-		log.Printf("Error: 保存邮件失败: %v", err)
-		return err
+		return false, err
 	}
-	log.Printf("✓ 邮件已保存 (userID: %d, from: %s, to: %v)", userID, msg.From, msg.To)
-	return nil
+	if alias != nil && !alias.Wildcard {
+		return alias.UserID == user.ID, nil
+	}
+	if at := strings.LastIndex(from, "@"); at >= 0 {
+		custom, err := a.storage.GetCustomDomainByName(from[at+1:])
+		if err != nil {
+			return false, err
+		}
+		if custom != nil && custom.Status == storage.CustomDomainVerified {
+			return custom.UserID == user.ID, nil
+		}
+	}
+	return user.IsAdmin && strings.HasSuffix(strings.ToLower(from), "@"+strings.ToLower(a.domain)), nil
 }
 
 func main() {
@@ -86,10 +314,24 @@ func main() {
 		EmailSMTPHost:   "mail.xxx.com",  // 自己的SMTP服务器
 		EmailSMTPPort:   587,             // 使用587端口进行邮件提交
 		EmailSender:     "admin@xxx.com", // 发件人邮箱
-		EmailPassword:   "",              // 本地服务器无需密码
+		EmailPassword:   "",              // 提交端口需要使用账户密码进行SMTP认证
 		EmailSenderName: "邮箱服务",          // 发件人名称
 		// 邮件转发配置
-		ForwardEnabled: false, // 暂时关闭邮件转发避免超时
+		ForwardEnabled:     false, // 暂时关闭邮件转发避免超时
+		QueueWorkers:       4,     // 外发队列worker数量
+		QueueRetryInterval: 300,   // 首次重试间隔5分钟
+		QueueMaxRetryHours: 120,   // 最长重试5天
+		// 邮件大小上限
+		MaxMessageSize: smtp.DefaultMaxMessageSize,
+		// TLS配置
+		SubmissionPort: 587, // 邮件提交端口
+		SMTPSPort:      465, // 隐式TLS端口（配置证书后生效）
+		// 回收站
+		TrashRetentionDays: 30,
+		// Webhook
+		WebhookWorkers:       2,  // Webhook投递worker数量
+		WebhookRetryInterval: 30, // 首次重试间隔30秒
+		WebhookMaxAttempts:   8,  // 最多尝试8次
 	}
 
 	// 尝试读取配置文件
@@ -111,6 +353,10 @@ func main() {
 		log.Printf("To customize settings, copy config.example.yaml to config.yaml")
 	}
 
+	if config.MaxMessageSize <= 0 {
+		config.MaxMessageSize = smtp.DefaultMaxMessageSize
+	}
+
 	// 初始化存储
 	store, err := storage.NewSQLiteStorage(config.DatabasePath)
 	if err != nil {
@@ -119,18 +365,34 @@ func main() {
 	defer store.Close()
 
 	// 初始化DNS服务
-	mailDNSService, err := services.NewMailDNSService(
-		config.Domain,
-		config.PublicIP,
-		config.TencentSecretID,
-		config.TencentSecretKey,
-		store,
-	)
+	dnsProvider, err := services.NewDNSProvider(&services.DNSProviderConfig{
+		Provider:               config.DNSProvider,
+		Zone:                   config.Domain,
+		PublicIP:               config.PublicIP,
+		TencentSecretID:        config.TencentSecretID,
+		TencentSecretKey:       config.TencentSecretKey,
+		CloudflareAPIToken:     config.CloudflareAPIToken,
+		CloudflareZoneID:       config.CloudflareZoneID,
+		Route53AccessKeyID:     config.Route53AccessKeyID,
+		Route53SecretAccessKey: config.Route53SecretAccessKey,
+		Route53HostedZoneID:    config.Route53HostedZoneID,
+		Route53Endpoint:        config.Route53Endpoint,
+		Route53Region:          config.Route53Region,
+		RFC2136Server:          config.RFC2136Server,
+		RFC2136KeyName:         config.RFC2136KeyName,
+		RFC2136Secret:          config.RFC2136Secret,
+		RFC2136Algorithm:       config.RFC2136Algorithm,
+	})
 	if err != nil {
-		log.Printf("Error: Failed to initialize DNS service: %v", err)
+		log.Printf("Error: Failed to initialize DNS provider: %v", err)
 		log.Printf("DNS management features will be disabled")
-		mailDNSService = nil
+		dnsProvider = nil
 	}
+	mailDNSService := services.NewMailDNSService(dnsProvider, store, net.DefaultResolver, services.MailRecordConfig{
+		PublicIP:  config.PublicIP,
+		DMARCRua:  config.DMARCRua,
+		TLSRPTRua: config.TLSRPTRua,
+	})
 
 	// 初始化邮件发送服务
 	emailSender := services.NewEmailSender(
@@ -140,14 +402,100 @@ func main() {
 		config.EmailSenderName, // 发件人名称
 		config.EmailPassword,
 	)
+	if isOwnSMTPHost(config.EmailSMTPHost, config.Domain) && config.EmailSMTPPort == config.SubmissionPort {
+		// 系统邮件通过本机的提交端口发送，走回环地址保证可以进行AUTH
+		emailSender.UseLoopback()
+	}
 	log.Printf("Email sender initialized: %s", config.EmailSender)
+	if config.EmailSender != "" && config.EmailPassword == "" {
+		// 提交端口要求AUTH，没有密码时验证码等系统邮件会被拒绝
+		log.Printf("Error: email_password is not configured, SMTP submission requires AUTH and system mail from %s will be rejected", config.EmailSender)
+		log.Printf("Set email_password in config.yaml to the login password of %s", config.EmailSender)
+	}
+
+	// 初始化DKIM签名（密钥通过 /api/dkim/keys 生成）
+	dkimService := services.NewDKIMService(store, mailDNSService)
+	emailSender.SetSigner(dkimService)
 
 	// 创建邮件处理器
-	handler := &MailHandler{storage: store}
+	eventBus := services.NewEventBus()
+	webhookDispatcher := services.NewWebhookDispatcher(
+		store,
+		config.WebhookWorkers,
+		time.Duration(config.WebhookRetryInterval)*time.Second,
+		config.WebhookMaxAttempts,
+	)
+	if err := webhookDispatcher.Start(); err != nil {
+		log.Fatalf("Failed to start webhook dispatcher: %v", err)
+	}
+	defer webhookDispatcher.Stop()
+	handler := &MailHandler{storage: store, events: eventBus, webhooks: webhookDispatcher}
+	authenticator := &SMTPAuthenticator{storage: store, domain: config.Domain, systemSender: config.EmailSender}
 
-	// 启动SMTP服务器（25端口接收邮件）
+	// 启动外发队列（外部收件人的邮件先入库，再由后台投递）
 	smtpDomain := "mail." + config.Domain
-	smtpServer := smtp.NewServer(smtpDomain, config.SMTPPort, handler, config.ForwardEnabled)
+	var deliveryQueue *services.DeliveryQueue
+	if config.ForwardEnabled {
+		forwarder := smtp.NewMailForwarder(config.Domain)
+		forwarder.Hostname = smtpDomain
+		forwarder.Signer = dkimService
+		deliveryQueue = services.NewDeliveryQueue(
+			store,
+			forwarder,
+			handler,
+			smtpDomain,
+			config.Domain,
+			config.QueueWorkers,
+			time.Duration(config.QueueRetryInterval)*time.Second,
+			time.Duration(config.QueueMaxRetryHours)*time.Hour,
+		)
+		if err := deliveryQueue.Start(); err != nil {
+			log.Fatalf("Failed to start delivery queue: %v", err)
+		}
+		defer deliveryQueue.Stop()
+	}
+
+	// 定期清理回收站
+	trashPurger := services.NewTrashPurger(store, time.Duration(config.TrashRetentionDays)*24*time.Hour)
+	trashPurger.Start()
+	defer trashPurger.Stop()
+
+	// 定期删除过期的邮箱
+	domainReaper := services.NewDomainReaper(store, mailDNSService)
+	domainReaper.Start()
+	defer domainReaper.Stop()
+
+	// 定期清理DNS服务商中没有对应邮箱的MX记录
+	if mailDNSService.ManagesDNS() {
+		dnsReconciler := services.NewDNSReconciler(mailDNSService)
+		dnsReconciler.Start()
+		defer dnsReconciler.Stop()
+	}
+
+	// 定期检查自有域名的DNS记录
+	customDomainService := services.NewCustomDomainService(store, net.DefaultResolver, dkimService, config.Domain, config.PublicIP)
+	customDomainService.Start()
+	defer customDomainService.Stop()
+
+	// 加载TLS证书（文件更新后自动重新加载）
+	var tlsConfig *tls.Config
+	if config.TLSCertFile != "" && config.TLSKeyFile != "" {
+		certReloader, err := smtp.NewCertReloader(config.TLSCertFile, config.TLSKeyFile)
+		if err != nil {
+			log.Printf("Error: Failed to load TLS certificate: %v", err)
+			log.Printf("STARTTLS and implicit TLS will be disabled")
+		} else {
+			tlsConfig = certReloader.TLSConfig()
+		}
+	}
+
+	// 启动SMTP服务器（25端口接收邮件）
+	smtpServer := smtp.NewServer(smtpDomain, config.SMTPPort, handler)
+	smtpServer.TLSConfig = tlsConfig
+	smtpServer.Verifier = smtp.NewVerifier(smtpDomain, net.DefaultResolver)
+	smtpServer.Recipients = handler
+	smtpServer.IsLocalDomain = handler.IsLocalDomain
+	smtpServer.MaxMessageSize = config.MaxMessageSize
 	go func() {
 		if err := smtpServer.Start(); err != nil {
 			log.Fatalf("SMTP server error: %v", err)
@@ -155,15 +503,45 @@ func main() {
 	}()
 
 	// 启动SMTP提交服务器（587端口用于邮件提交）
-	smtpSubmitServer := smtp.NewServer(smtpDomain, 587, handler, config.ForwardEnabled)
+	smtpSubmitServer := smtp.NewServer(smtpDomain, config.SubmissionPort, handler)
+	smtpSubmitServer.TLSConfig = tlsConfig
+	smtpSubmitServer.Recipients = handler
+	smtpSubmitServer.IsLocalDomain = handler.IsLocalDomain
+	smtpSubmitServer.MaxMessageSize = config.MaxMessageSize
+	smtpSubmitServer.RequireTLS = config.RequireTLS && tlsConfig != nil
+	smtpSubmitServer.Auth = authenticator
+	if deliveryQueue != nil {
+		smtpSubmitServer.Queue = deliveryQueue
+	}
+	smtpSubmitServer.Submission = true
 	go func() {
 		if err := smtpSubmitServer.Start(); err != nil {
 			log.Printf("SMTP submit server error: %v", err)
 		}
 	}()
 
+	// 启动隐式TLS提交服务器（465端口）
+	if tlsConfig != nil && config.SMTPSPort > 0 {
+		smtpsServer := smtp.NewServer(smtpDomain, config.SMTPSPort, handler)
+		smtpsServer.TLSConfig = tlsConfig
+		smtpsServer.Recipients = handler
+		smtpsServer.IsLocalDomain = handler.IsLocalDomain
+		smtpsServer.MaxMessageSize = config.MaxMessageSize
+		smtpsServer.ImplicitTLS = true
+		smtpsServer.Auth = authenticator
+		if deliveryQueue != nil {
+			smtpsServer.Queue = deliveryQueue
+		}
+		smtpsServer.Submission = true
+		go func() {
+			if err := smtpsServer.Start(); err != nil {
+				log.Printf("SMTPS server error: %v", err)
+			}
+		}()
+	}
+
 	// 启动HTTP API服务器
-	apiServer := api.NewServer(store, mailDNSService, emailSender, config.HTTPPort)
+	apiServer := api.NewServer(store, mailDNSService, emailSender, dkimService, eventBus, webhookDispatcher, customDomainService, config.HTTPPort)
 	go func() {
 		if err := apiServer.Start(); err != nil {
 			log.Fatalf("HTTP API server error: %v", err)
@@ -172,16 +550,33 @@ func main() {
 
 	log.Printf("Mail server started successfully!")
 	log.Printf("SMTP Server (接收邮件): %s:%d", smtpDomain, config.SMTPPort)
-	log.Printf("SMTP Submit Server (邮件提交): %s:587", smtpDomain)
+	log.Printf("SMTP Submit Server (邮件提交): %s:%d", smtpDomain, config.SubmissionPort)
+	if tlsConfig != nil && config.SMTPSPort > 0 {
+		log.Printf("SMTPS Server (隐式TLS): %s:%d", smtpDomain, config.SMTPSPort)
+	}
 	log.Printf("HTTP API Server: http://localhost:%d", config.HTTPPort)
 	log.Printf("Web Management: http://localhost:%d/", config.HTTPPort)
 	log.Printf("API Endpoints:")
 	log.Printf("  - GET  /api/mails?limit=20&offset=0  - 获取邮件列表")
+	log.Printf("  - GET  /api/mails/search?q=          - 搜索邮件")
 	log.Printf("  - GET  /api/mails/{id}               - 获取单个邮件")
+	log.Printf("  - PATCH /api/mails/{id}              - 标记已读/星标")
+	log.Printf("  - DELETE /api/mails/{id}             - 删除邮件（移入回收站）")
+	log.Printf("  - GET  /api/mails/{id}/codes         - 获取验证码和验证链接")
+	log.Printf("  - GET  /api/mails/{id}/attachments   - 获取邮件附件")
 	log.Printf("  - GET  /api/stats                    - 获取统计信息")
+	log.Printf("  - GET  /api/events                   - 新邮件推送（SSE）")
+	log.Printf("  - GET  /api/events/ws                - 新邮件推送（WebSocket）")
+	log.Printf("  - GET  /api/mailboxes/{email}/wait   - 等待新邮件（长轮询）")
+	log.Printf("  - GET  /api/webhooks                 - 获取Webhook列表")
+	log.Printf("  - POST /api/webhooks/{id}/test       - 发送测试事件")
 	log.Printf("  - GET  /api/domains                  - 获取邮箱域名列表")
 	log.Printf("  - POST /api/domains                  - 创建邮箱域名")
 	log.Printf("  - DELETE /api/domains/{id}           - 删除邮箱域名")
+	log.Printf("  - PUT  /api/domains/{id}/catch-all   - 设置catch-all")
+	log.Printf("  - PUT  /api/domains/{id}/ttl         - 设置邮箱有效期")
+	log.Printf("  - GET  /api/dkim/keys                - 获取DKIM密钥（管理员）")
+	log.Printf("  - POST /api/dkim/keys                - 生成DKIM密钥（管理员）")
 
 	// 等待中断信号
 	sigChan := make(chan os.Signal, 1)
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"mail-server/smtp"
+	"mail-server/storage"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCanSendAsSystemSender(t *testing.T) {
+	store, err := storage.NewSQLiteStorage(filepath.Join(t.TempDir(), "mails.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteStorage: %v", err)
+	}
+	defer store.Close()
+
+	system, err := store.CreateUser("admin@example.org", storage.HashPassword("secret"), "127.0.0.1")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	other, err := store.CreateUser("other@example.org", storage.HashPassword("secret"), "127.0.0.1")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	auth := &SMTPAuthenticator{storage: store, domain: "example.org", systemSender: "admin@example.org"}
+	tests := []struct {
+		user *smtp.AuthUser
+		from string
+		want bool
+	}{
+		// 系统发件人不是管理员，也可以使用配置的地址
+		{&smtp.AuthUser{ID: system.ID, Email: system.Email}, "admin@example.org", true},
+		{&smtp.AuthUser{ID: system.ID, Email: system.Email}, "Admin@Example.org", true},
+		{&smtp.AuthUser{ID: system.ID, Email: system.Email}, "noreply@example.org", false},
+		// 其他账户不能冒用系统发件人
+		{&smtp.AuthUser{ID: other.ID, Email: other.Email}, "admin@example.org", false},
+	}
+	for _, tt := range tests {
+		got, err := auth.CanSendAs(tt.user, tt.from)
+		if err != nil {
+			t.Fatalf("CanSendAs(%s, %s): %v", tt.user.Email, tt.from, err)
+		}
+		if got != tt.want {
+			t.Errorf("CanSendAs(%s, %s) = %v, want %v", tt.user.Email, tt.from, got, tt.want)
+		}
+	}
+}
+
+func TestIsLocalDomainOnlyVerifiedCustomDomains(t *testing.T) {
+	store, err := storage.NewSQLiteStorage(filepath.Join(t.TempDir(), "mails.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteStorage: %v", err)
+	}
+	defer store.Close()
+
+	d := &storage.CustomDomain{UserID: 1, Domain: "custom.example", VerificationToken: "token", Checks: []*storage.DNSCheck{}}
+	if err := store.CreateCustomDomain(d); err != nil {
+		t.Fatalf("CreateCustomDomain: %v", err)
+	}
+	handler := &MailHandler{storage: store}
+	if handler.IsLocalDomain("custom.example") {
+		t.Errorf("pending custom domain treated as local")
+	}
+
+	now := time.Now()
+	d.Status = storage.CustomDomainVerified
+	d.VerifiedAt = &now
+	d.CheckedAt = &now
+	if err := store.UpdateCustomDomainChecks(d); err != nil {
+		t.Fatalf("UpdateCustomDomainChecks: %v", err)
+	}
+	if !handler.IsLocalDomain("Custom.Example") {
+		t.Errorf("verified custom domain not treated as local")
+	}
+	if handler.IsLocalDomain("other.example") {
+		t.Errorf("unknown domain treated as local")
+	}
+}
@@ -0,0 +1,270 @@
+package services
+
+import (
+	"mail-server/storage"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	// maxExtractedCodes 最多返回的验证码数量，按可信度排序
+	maxExtractedCodes = 5
+	// maxExtractedLinks 最多返回的验证链接数量
+	maxExtractedLinks = 5
+	// codeWindowAfter 关键词之后多少字节内的候选视为验证码（中文一个字3字节）
+	codeWindowAfter = 120
+	// codeWindowBefore 关键词之前多少字节内的候选视为验证码，如 "123456 is your code"
+	codeWindowBefore = 60
+)
+
+var (
+	// codeKeywordPattern 验证码附近常见的提示词
+	codeKeywordPattern = regexp.MustCompile(`(?i)验证码|校验码|动态码|确认码|激活码|安全码|登录码|识别码|动态密码|验证代码|\b(?:verification|verify|code|otp|passcode|pin|one[- ]time|confirmation|2fa|two[- ]factor|authentication|security)\b`)
+	// codeCandidatePattern 候选验证码：4-10位字母数字，或 123-456 / 123 456 形式，再由 looksLikeCode 过滤
+	codeCandidatePattern = regexp.MustCompile(`\b(?:\d{3}[- ]\d{3}|[A-Za-z0-9]{4,10})\b`)
+	// urlPattern 纯文本中的链接
+	urlPattern = regexp.MustCompile(`https?://[^\s<>"'()（）\[\]【】，。；]+`)
+	// emailPattern 邮件地址，识别验证码前去掉以免误判
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+	// linkURLPattern 链接地址中表示验证、登录的词
+	linkURLPattern = regexp.MustCompile(`(?i)verif|confirm|activat|validat|magic|login|log-in|signin|sign-in|sign_in|auth|token|reset|password|invite|onboard|register|signup|sign-up|otp`)
+	// linkTextPattern 链接文字或所在行中表示验证、登录的词
+	linkTextPattern = regexp.MustCompile(`(?i)验证|确认|激活|登录|登陆|重置|注册|verify|confirm|activate|validate|sign in|log in|login|reset|get started|magic link`)
+	// linkExcludePattern 退订、隐私政策和静态资源链接
+	linkExcludePattern = regexp.MustCompile(`(?i)unsubscribe|退订|privacy|preferences|\.(?:png|jpe?g|gif|svg|css|js)(?:$|\?)`)
+)
+
+// mailLink 邮件中的链接及其文字
+type mailLink struct {
+	url  string
+	text string
+}
+
+// ExtractCodes 从邮件主题和正文中识别验证码和验证/登录链接，没有纯文本正文时从HTML中提取文字
+func ExtractCodes(subject, text, htmlBody string) storage.MailCodes {
+	var links []mailLink
+	if htmlBody != "" {
+		var htmlText string
+		htmlText, links = htmlContent(htmlBody)
+		if strings.TrimSpace(text) == "" {
+			text = htmlText
+		}
+	}
+	links = append(links, textLinks(text)...)
+
+	return storage.MailCodes{
+		Codes: findCodes(subject + "\n" + text),
+		Links: findLinks(links),
+	}
+}
+
+// htmlContent 提取HTML中可见的文字和所有链接
+func htmlContent(body string) (string, []mailLink) {
+	var sb strings.Builder
+	var links []mailLink
+	var anchor *mailLink
+	var anchorText strings.Builder
+	skip := 0
+
+	z := html.NewTokenizer(strings.NewReader(body))
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			return sb.String(), links
+		case html.StartTagToken, html.EndTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			tag := string(name)
+			switch tag {
+			case "script", "style", "head", "title":
+				if tt == html.StartTagToken {
+					skip++
+				} else if tt == html.EndTagToken && skip > 0 {
+					skip--
+				}
+				continue
+			case "a":
+				if tt == html.EndTagToken {
+					if anchor != nil {
+						anchor.text = strings.TrimSpace(anchorText.String())
+						links = append(links, *anchor)
+						anchor = nil
+					}
+					continue
+				}
+				for hasAttr {
+					var key, val []byte
+					key, val, hasAttr = z.TagAttr()
+					if string(key) == "href" {
+						anchor = &mailLink{url: strings.TrimSpace(string(val))}
+						anchorText.Reset()
+					}
+				}
+			case "br", "p", "div", "tr", "li", "h1", "h2", "h3", "h4", "h5", "h6", "table", "td":
+				sb.WriteString("\n")
+			}
+		case html.TextToken:
+			if skip > 0 {
+				continue
+			}
+			t := string(z.Text())
+			sb.WriteString(t)
+			if anchor != nil {
+				anchorText.WriteString(t)
+			}
+		}
+	}
+}
+
+// textLinks 提取纯文本中的链接，链接文字取所在的行
+func textLinks(text string) []mailLink {
+	var links []mailLink
+	for _, line := range strings.Split(text, "\n") {
+		for _, u := range urlPattern.FindAllString(line, -1) {
+			links = append(links, mailLink{url: strings.TrimRight(u, ".,;:!?"), text: line})
+		}
+	}
+	return links
+}
+
+// findLinks 筛选出验证、登录类链接，按出现顺序去重
+func findLinks(links []mailLink) []string {
+	result := []string{}
+	seen := make(map[string]bool)
+	for _, l := range links {
+		u, err := url.Parse(l.url)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			continue
+		}
+		if seen[l.url] || linkExcludePattern.MatchString(l.url) {
+			continue
+		}
+		if !linkURLPattern.MatchString(u.Path+"?"+u.RawQuery) && !linkTextPattern.MatchString(l.text) {
+			continue
+		}
+		seen[l.url] = true
+		result = append(result, l.url)
+		if len(result) == maxExtractedLinks {
+			break
+		}
+	}
+	return result
+}
+
+// codeCandidate 候选验证码及其与最近关键词的距离
+type codeCandidate struct {
+	code     string
+	distance int
+}
+
+// findCodes 查找关键词附近的验证码，距离关键词越近越靠前
+func findCodes(text string) []string {
+	// 链接和邮件地址中的字母数字不是验证码
+	text = urlPattern.ReplaceAllString(text, " ")
+	text = emailPattern.ReplaceAllString(text, " ")
+
+	keywords := codeKeywordPattern.FindAllStringIndex(text, -1)
+	if len(keywords) == 0 {
+		return []string{}
+	}
+
+	var candidates []codeCandidate
+	for _, loc := range codeCandidatePattern.FindAllStringIndex(text, -1) {
+		code := text[loc[0]:loc[1]]
+		if !looksLikeCode(text, code, loc[0], loc[1]) {
+			continue
+		}
+
+		distance := -1
+		for _, kw := range keywords {
+			d := -1
+			switch {
+			case loc[0] >= kw[1] && loc[0]-kw[1] <= codeWindowAfter:
+				d = loc[0] - kw[1]
+			case loc[1] <= kw[0] && kw[0]-loc[1] <= codeWindowBefore:
+				d = kw[0] - loc[1]
+			}
+			if d >= 0 && (distance < 0 || d < distance) {
+				distance = d
+			}
+		}
+		if distance < 0 {
+			continue
+		}
+		candidates = append(candidates, codeCandidate{code: strings.NewReplacer("-", "", " ", "").Replace(code), distance: distance})
+	}
+
+	// 有其他候选时去掉像年份的数字，如页脚的 © 2024
+	hasOther := false
+	for _, c := range candidates {
+		if !isYear(c.code) {
+			hasOther = true
+			break
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].distance < candidates[j].distance })
+
+	codes := []string{}
+	seen := make(map[string]bool)
+	for _, c := range candidates {
+		if seen[c.code] || (hasOther && isYear(c.code)) {
+			continue
+		}
+		seen[c.code] = true
+		codes = append(codes, c.code)
+		if len(codes) == maxExtractedCodes {
+			break
+		}
+	}
+	return codes
+}
+
+// looksLikeCode 排除金额、百分比、电话号码和普通单词：纯数字4-8位，字母数字混合必须同时包含数字和字母
+func looksLikeCode(text, code string, start, end int) bool {
+	digits, letters := 0, 0
+	for _, c := range code {
+		switch {
+		case c >= '0' && c <= '9':
+			digits++
+		case (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+			letters++
+		}
+	}
+	if digits == 0 || (letters == 0 && digits > 8) {
+		return false
+	}
+	// 字母数字混合的验证码通常是大写，小写混合的多是普通词语或标识符，如 utf8、h264
+	if letters > 0 && (letters < 2 || strings.ToUpper(code) != code) {
+		return false
+	}
+
+	if start > 0 && strings.ContainsAny(text[start-1:start], "$#+./") {
+		return false
+	}
+	if strings.HasSuffix(text[:start], "￥") || strings.HasSuffix(text[:start], "¥") {
+		return false
+	}
+	if end < len(text) && strings.ContainsAny(text[end:end+1], "%./") && !isSentenceEnd(text, end) {
+		return false
+	}
+	return true
+}
+
+// isSentenceEnd 判断 end 处的句点是否是句子结尾（后面是空白或文本结束），而不是小数点或版本号
+func isSentenceEnd(text string, end int) bool {
+	if text[end] != '.' {
+		return false
+	}
+	return end+1 == len(text) || strings.ContainsAny(text[end+1:end+2], " \t\r\n")
+}
+
+// isYear 判断是否是 1900-2099 之间的年份
+func isYear(code string) bool {
+	return len(code) == 4 && (strings.HasPrefix(code, "19") || strings.HasPrefix(code, "20")) &&
+		strings.Trim(code, "0123456789") == ""
+}
@@ -0,0 +1,289 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"mail-server/smtp"
+	"mail-server/storage"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// customDomainCheckInterval 后台检查间隔，待验证的域名每次都检查
+	customDomainCheckInterval = time.Minute
+	// customDomainRecheckInterval 已验证域名重新检查MX、SPF、DKIM、DMARC记录的间隔
+	customDomainRecheckInterval = time.Hour
+	// customDomainVerifyWindow 超过该时间仍未验证的域名被删除
+	customDomainVerifyWindow = 7 * 24 * time.Hour
+	// customDomainLookupTimeout 检查一个域名所有记录的超时
+	customDomainLookupTimeout = 15 * time.Second
+	// customDomainVerifyLabel 验证TXT记录所在的子域名
+	customDomainVerifyLabel = "_mail-verify"
+	// customDomainDKIMSelector 为自有域名生成DKIM密钥时使用的选择器
+	customDomainDKIMSelector = "mail"
+)
+
+// domainNamePattern 域名格式：至少两级，每级1-63个字母、数字或连字符
+var domainNamePattern = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,63}$`)
+
+// CustomDomainService 管理用户自有域名：TXT记录验证所有权，验证后定期检查MX、SPF、DKIM、DMARC记录
+type CustomDomainService struct {
+	storage  storage.Storage
+	checker  *DNSChecker
+	dkim     *DKIMService // 为nil时不生成DKIM密钥
+	zone     string       // 系统主域名，自有域名不能是它或它的子域名
+	mailHost string       // MX记录需要指向的主机
+	publicIP string       // 为空时SPF只要求 mx 机制
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewCustomDomainService 创建自有域名服务，MX记录需要指向 mail.<zone>
+func NewCustomDomainService(store storage.Storage, resolver smtp.Resolver, dkim *DKIMService, zone, publicIP string) *CustomDomainService {
+	zone = strings.TrimSuffix(strings.ToLower(zone), ".")
+	return &CustomDomainService{
+		storage:  store,
+		checker:  NewDNSChecker(resolver, "mail."+zone, publicIP),
+		dkim:     dkim,
+		zone:     zone,
+		mailHost: "mail." + zone,
+		publicIP: publicIP,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start 启动后台检查
+func (c *CustomDomainService) Start() {
+	go c.run()
+	log.Printf("[CustomDomain] 自有域名DNS检查已启动 (间隔: %v)", customDomainCheckInterval)
+}
+
+// Stop 停止后台检查
+func (c *CustomDomainService) Stop() {
+	close(c.stop)
+	<-c.done
+}
+
+// Add 为用户添加自有域名并生成验证令牌，域名需要验证后才能收信
+func (c *CustomDomainService) Add(userID int64, name string) (*storage.CustomDomain, error) {
+	name = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+	if !domainNamePattern.MatchString(name) || len(name) > 253 {
+		return nil, fmt.Errorf("无效的域名: %s", name)
+	}
+	if name == c.zone || strings.HasSuffix(name, "."+c.zone) {
+		return nil, fmt.Errorf("不能添加系统域名 %s 及其子域名", c.zone)
+	}
+
+	// 其他用户未验证的申请不占用域名，先完成验证的一方获得域名
+	existing, err := c.storage.GetCustomDomainByName(name)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, fmt.Errorf("域名 %s 已被添加", name)
+	}
+	owned, err := c.storage.GetCustomDomains(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range owned {
+		if d.Domain == name {
+			return nil, fmt.Errorf("域名 %s 已被添加", name)
+		}
+	}
+
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil, fmt.Errorf("生成验证令牌失败: %v", err)
+	}
+
+	domain := &storage.CustomDomain{
+		UserID:            userID,
+		Domain:            name,
+		VerificationToken: hex.EncodeToString(token),
+		Status:            storage.CustomDomainPending,
+	}
+	domain.Checks = c.requiredRecords(domain)
+	if err := c.storage.CreateCustomDomain(domain); err != nil {
+		return nil, err
+	}
+
+	log.Printf("[CustomDomain] 用户 %d 添加域名 %s，等待验证", userID, name)
+	return domain, nil
+}
+
+// Remove 删除自有域名、其下的地址和为它生成的DKIM密钥
+func (c *CustomDomainService) Remove(domain *storage.CustomDomain) error {
+	if c.dkim != nil && domain.Status == storage.CustomDomainVerified {
+		keys, err := c.storage.GetDKIMKeys()
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if key.Domain == domain.Domain {
+				if err := c.dkim.DeleteKey(key.ID); err != nil {
+					log.Printf("[CustomDomain] 删除 %s 的DKIM密钥失败: %v", domain.Domain, err)
+				}
+			}
+		}
+	}
+
+	if err := c.storage.DeleteCustomDomain(domain.UserID, domain.ID); err != nil {
+		return err
+	}
+	log.Printf("[CustomDomain] 已删除域名 %s (userID: %d)", domain.Domain, domain.UserID)
+	return nil
+}
+
+// Check 查询域名的DNS记录并保存结果，待验证的域名查到验证记录后标记为已验证并生成DKIM密钥
+func (c *CustomDomainService) Check(ctx context.Context, domain *storage.CustomDomain) error {
+	ctx, cancel := context.WithTimeout(ctx, customDomainLookupTimeout)
+	defer cancel()
+
+	if domain.Status == storage.CustomDomainPending {
+		verification := c.verificationRecord(domain)
+		c.checker.Check(ctx, verification)
+		if verification.Status == storage.DNSCheckOK {
+			now := time.Now()
+			domain.Status = storage.CustomDomainVerified
+			domain.VerifiedAt = &now
+			log.Printf("[CustomDomain] 域名 %s 验证成功 (userID: %d)", domain.Domain, domain.UserID)
+			c.ensureDKIMKey(domain.Domain)
+		}
+	}
+
+	checks := c.requiredRecords(domain)
+	for _, check := range checks {
+		c.checker.Check(ctx, check)
+	}
+
+	now := time.Now()
+	domain.Checks = checks
+	domain.CheckedAt = &now
+	return c.storage.UpdateCustomDomainChecks(domain)
+}
+
+// run 按固定间隔检查
+func (c *CustomDomainService) run() {
+	defer close(c.done)
+
+	ticker := time.NewTicker(customDomainCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		c.checkAll()
+		select {
+		case <-ticker.C:
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// checkAll 检查所有待验证的域名，删除验证超时的域名，并重新检查到期的已验证域名
+func (c *CustomDomainService) checkAll() {
+	pending, err := c.storage.GetCustomDomainsByStatus(storage.CustomDomainPending)
+	if err != nil {
+		log.Printf("[CustomDomain] 查询待验证域名失败: %v", err)
+		return
+	}
+	for _, domain := range pending {
+		if time.Since(domain.CreatedAt) > customDomainVerifyWindow {
+			log.Printf("[CustomDomain] 域名 %s 超过 %v 未验证，已删除", domain.Domain, customDomainVerifyWindow)
+			if err := c.Remove(domain); err != nil {
+				log.Printf("[CustomDomain] 删除域名 %s 失败: %v", domain.Domain, err)
+			}
+			continue
+		}
+		if err := c.Check(context.Background(), domain); err != nil {
+			log.Printf("[CustomDomain] 检查域名 %s 失败: %v", domain.Domain, err)
+		}
+	}
+
+	verified, err := c.storage.GetCustomDomainsByStatus(storage.CustomDomainVerified)
+	if err != nil {
+		log.Printf("[CustomDomain] 查询已验证域名失败: %v", err)
+		return
+	}
+	for _, domain := range verified {
+		if domain.CheckedAt != nil && time.Since(*domain.CheckedAt) < customDomainRecheckInterval {
+			continue
+		}
+		if err := c.Check(context.Background(), domain); err != nil {
+			log.Printf("[CustomDomain] 检查域名 %s 失败: %v", domain.Domain, err)
+		}
+	}
+}
+
+// ensureDKIMKey 域名没有签名密钥时生成一个，DNS记录由用户手动添加
+func (c *CustomDomainService) ensureDKIMKey(domain string) {
+	if c.dkim == nil {
+		return
+	}
+	keys, err := c.storage.GetActiveDKIMKeys(domain)
+	if err != nil {
+		log.Printf("[CustomDomain] 查询 %s 的DKIM密钥失败: %v", domain, err)
+		return
+	}
+	if len(keys) > 0 {
+		return
+	}
+	if _, err := c.dkim.GenerateKey(domain, customDomainDKIMSelector, "rsa-sha256"); err != nil {
+		log.Printf("[CustomDomain] 为 %s 生成DKIM密钥失败: %v", domain, err)
+	}
+}
+
+// verificationRecord 验证所有权需要添加的TXT记录
+func (c *CustomDomainService) verificationRecord(domain *storage.CustomDomain) *storage.DNSCheck {
+	return &storage.DNSCheck{
+		Purpose: "verification",
+		Type:    "TXT",
+		Name:    customDomainVerifyLabel + "." + domain.Domain,
+		Value:   "mail-verify=" + domain.VerificationToken,
+		Status:  storage.DNSCheckUnchecked,
+		Found:   []string{},
+	}
+}
+
+// requiredRecords 域名需要配置的所有记录，DKIM记录在验证通过、生成密钥之后才有
+func (c *CustomDomainService) requiredRecords(domain *storage.CustomDomain) []*storage.DNSCheck {
+	spf := "v=spf1 mx ~all"
+	if c.publicIP != "" {
+		spf = fmt.Sprintf("v=spf1 mx ip4:%s ~all", c.publicIP)
+	}
+
+	checks := []*storage.DNSCheck{
+		c.verificationRecord(domain),
+		{Purpose: "mx", Type: "MX", Name: domain.Domain, Value: c.mailHost, Priority: defaultMXPriority},
+		{Purpose: "spf", Type: "TXT", Name: domain.Domain, Value: spf},
+	}
+
+	if domain.Status == storage.CustomDomainVerified {
+		keys, err := c.storage.GetActiveDKIMKeys(domain.Domain)
+		if err != nil {
+			log.Printf("[CustomDomain] 查询 %s 的DKIM密钥失败: %v", domain.Domain, err)
+		}
+		for _, key := range keys {
+			checks = append(checks, &storage.DNSCheck{
+				Purpose: "dkim",
+				Type:    "TXT",
+				Name:    key.Selector + "._domainkey." + domain.Domain,
+				Value:   key.DNSRecord,
+			})
+		}
+	}
+
+	checks = append(checks, &storage.DNSCheck{Purpose: "dmarc", Type: "TXT", Name: "_dmarc." + domain.Domain, Value: "v=DMARC1; p=none"})
+
+	for _, check := range checks {
+		check.Status = storage.DNSCheckUnchecked
+		check.Found = []string{}
+	}
+	return checks
+}
@@ -0,0 +1,240 @@
+package services
+
+import (
+	"log"
+	"mail-server/smtp"
+	"mail-server/storage"
+	"net/mail"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// queuePollInterval 没有新任务时轮询数据库的间隔
+	queuePollInterval = 30 * time.Second
+	// queueMaxBackoff 重试间隔上限
+	queueMaxBackoff = 6 * time.Hour
+)
+
+// DeliveryQueue 持久化外发队列：接收邮件后先入库，由后台worker投递，失败按指数退避重试，永久失败时给发件人退信
+type DeliveryQueue struct {
+	storage      storage.Storage
+	forwarder    *smtp.MailForwarder
+	localHandler smtp.MailHandler // 退信投递到本地邮箱
+	hostname     string           // 退信中的 Reporting-MTA
+	localDomain  string
+	workers      int
+	retryBase    time.Duration
+	maxAge       time.Duration
+	jobs         chan *storage.OutboundJob
+	notify       chan struct{}
+	stop         chan struct{}
+	wg           sync.WaitGroup
+}
+
+// NewDeliveryQueue 创建外发队列
+func NewDeliveryQueue(store storage.Storage, forwarder *smtp.MailForwarder, localHandler smtp.MailHandler, hostname, localDomain string, workers int, retryBase, maxAge time.Duration) *DeliveryQueue {
+	if workers <= 0 {
+		workers = 4
+	}
+	if retryBase <= 0 {
+		retryBase = 5 * time.Minute
+	}
+	if maxAge <= 0 {
+		maxAge = 5 * 24 * time.Hour
+	}
+
+	return &DeliveryQueue{
+		storage:      store,
+		forwarder:    forwarder,
+		localHandler: localHandler,
+		hostname:     hostname,
+		localDomain:  localDomain,
+		workers:      workers,
+		retryBase:    retryBase,
+		maxAge:       maxAge,
+		jobs:         make(chan *storage.OutboundJob),
+		notify:       make(chan struct{}, 1),
+		stop:         make(chan struct{}),
+	}
+}
+
+// Start 启动调度和投递worker
+func (q *DeliveryQueue) Start() error {
+	// 上次退出时正在投递的任务重新排队
+	if err := q.storage.ResetOutboundJobs(); err != nil {
+		return err
+	}
+
+	q.wg.Add(1)
+	go q.dispatch()
+	for i := 0; i < q.workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+
+	log.Printf("[Queue] 外发队列已启动 (workers: %d)", q.workers)
+	return nil
+}
+
+// Stop 停止队列，等待正在进行的投递完成
+func (q *DeliveryQueue) Stop() {
+	close(q.stop)
+	q.wg.Wait()
+}
+
+// Enqueue 将邮件加入队列，持久化成功即返回
+func (q *DeliveryQueue) Enqueue(from string, to []string, rawData string) error {
+	if err := q.storage.EnqueueOutbound(from, to, rawData); err != nil {
+		return err
+	}
+	log.Printf("[Queue] 邮件已入队: %s -> %v", from, to)
+
+	// 唤醒调度器立即处理
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// dispatch 从数据库取出到期任务分发给worker
+func (q *DeliveryQueue) dispatch() {
+	defer q.wg.Done()
+	defer close(q.jobs)
+
+	for {
+		jobs, err := q.storage.ClaimOutboundJobs(q.workers * 2)
+		if err != nil {
+			log.Printf("[Queue] 读取队列失败: %v", err)
+		}
+
+		for _, job := range jobs {
+			select {
+			case q.jobs <- job:
+			case <-q.stop:
+				return
+			}
+		}
+
+		if len(jobs) > 0 {
+			continue
+		}
+
+		select {
+		case <-q.notify:
+		case <-time.After(queuePollInterval):
+		case <-q.stop:
+			return
+		}
+	}
+}
+
+// work 投递worker
+func (q *DeliveryQueue) work() {
+	defer q.wg.Done()
+
+	for job := range q.jobs {
+		q.deliver(job)
+	}
+}
+
+// deliver 投递单个任务并根据结果更新状态
+func (q *DeliveryQueue) deliver(job *storage.OutboundJob) {
+	attempt := job.Attempts + 1
+	log.Printf("[Queue] 投递 #%d: %s -> %s (第%d次)", job.ID, job.From, job.To, attempt)
+
+	err := q.forwarder.Forward(job.From, job.To, job.RawData)
+	if err == nil {
+		if err := q.storage.CompleteOutboundJob(job.ID); err != nil {
+			log.Printf("[Queue] 更新任务状态失败 #%d: %v", job.ID, err)
+		}
+		log.Printf("[Queue] ✓ 投递成功 #%d: %s", job.ID, job.To)
+		return
+	}
+
+	if smtp.IsPermanentError(err) || time.Since(job.CreatedAt) >= q.maxAge {
+		log.Printf("[Queue] ✗ 投递永久失败 #%d: %s: %v", job.ID, job.To, err)
+		if err := q.storage.FailOutboundJob(job.ID, err.Error()); err != nil {
+			log.Printf("[Queue] 更新任务状态失败 #%d: %v", job.ID, err)
+		}
+		q.bounce(job, err)
+		return
+	}
+
+	next := time.Now().Add(q.backoff(attempt))
+	log.Printf("[Queue] 投递失败 #%d: %v，将于 %s 重试", job.ID, err, next.Format("2006-01-02 15:04:05"))
+	if err := q.storage.RetryOutboundJob(job.ID, next, err.Error()); err != nil {
+		log.Printf("[Queue] 更新任务状态失败 #%d: %v", job.ID, err)
+	}
+}
+
+// backoff 第n次失败后的等待时间：retryBase * 2^(n-1)，最长 queueMaxBackoff
+func (q *DeliveryQueue) backoff(attempt int) time.Duration {
+	delay := q.retryBase
+	for i := 1; i < attempt && delay < queueMaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > queueMaxBackoff {
+		delay = queueMaxBackoff
+	}
+	return delay
+}
+
+// bounce 给原发件人发送退信
+func (q *DeliveryQueue) bounce(job *storage.OutboundJob, reason error) {
+	// 退信本身投递失败时不再退信，避免循环
+	if job.From == "" {
+		log.Printf("[Queue] 退信 #%d 投递失败，丢弃", job.ID)
+		return
+	}
+
+	raw := smtp.NewBounceMessage(&smtp.Bounce{
+		ReportingMTA: q.hostname,
+		Sender:       job.From,
+		Recipient:    job.To,
+		Reason:       reason,
+		ArrivalDate:  job.CreatedAt,
+		Original:     job.RawData,
+	})
+
+	if q.isLocal(job.From) && q.localHandler != nil {
+		msg := &smtp.MailMessage{
+			From:       "",
+			To:         []string{job.From},
+			Subject:    "Undelivered Mail Returned to Sender",
+			RawData:    raw,
+			ReceivedAt: time.Now(),
+		}
+		if parsed, err := mail.ReadMessage(strings.NewReader(raw)); err == nil {
+			if content, err := smtp.ParseMessage(parsed); err == nil {
+				msg.HeaderFrom = content.From
+				msg.HeaderTo = content.To
+				msg.Body = content.Text
+				msg.Attachments = content.Attachments
+			}
+		}
+		if _, err := q.localHandler.HandleMail(msg); err != nil {
+			log.Printf("[Queue] 本地退信投递失败: %v", err)
+			return
+		}
+		log.Printf("[Queue] 退信已投递到本地邮箱: %s", job.From)
+		return
+	}
+
+	if err := q.Enqueue("", []string{job.From}, raw); err != nil {
+		log.Printf("[Queue] 退信入队失败: %v", err)
+	}
+}
+
+// isLocal 检查地址是否属于本地域名
+func (q *DeliveryQueue) isLocal(email string) bool {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := strings.ToLower(email[at+1:])
+	localDomain := strings.ToLower(q.localDomain)
+	return domain == localDomain || strings.HasSuffix(domain, "."+localDomain)
+}
@@ -0,0 +1,232 @@
+package services
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"mail-server/smtp"
+	"mail-server/storage"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// dkimRSABits RSA密钥长度
+const dkimRSABits = 2048
+
+// selectorPattern DKIM选择器格式（一个或多个DNS标签）
+var selectorPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?(\.[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?)*$`)
+
+// DKIMService 管理DKIM密钥并对外发邮件签名
+type DKIMService struct {
+	storage    storage.Storage
+	dnsService DNSProvider             // 为空时不自动发布DNS记录
+	keys       map[int64]crypto.Signer // 已解析的私钥缓存
+	mu         sync.RWMutex
+}
+
+// NewDKIMService 创建DKIM服务，mailDNS 为空或未配置DNS服务商时需要手动添加TXT记录
+func NewDKIMService(store storage.Storage, mailDNS *MailDNSService) *DKIMService {
+	service := &DKIMService{
+		storage: store,
+		keys:    make(map[int64]crypto.Signer),
+	}
+	if mailDNS != nil {
+		service.dnsService = mailDNS.provider
+	}
+	return service
+}
+
+// GenerateKey 为域名生成新的签名密钥并发布 selector._domainkey TXT记录，algorithm 为 rsa-sha256 或 ed25519-sha256
+func (d *DKIMService) GenerateKey(domain, selector, algorithm string) (*storage.DKIMKey, error) {
+	domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+	selector = strings.ToLower(strings.TrimSpace(selector))
+	if domain == "" {
+		return nil, fmt.Errorf("域名不能为空")
+	}
+	if !selectorPattern.MatchString(selector) {
+		return nil, fmt.Errorf("无效的选择器: %s", selector)
+	}
+
+	var signer crypto.Signer
+	switch algorithm {
+	case "", "rsa-sha256":
+		algorithm = "rsa-sha256"
+		key, err := rsa.GenerateKey(rand.Reader, dkimRSABits)
+		if err != nil {
+			return nil, fmt.Errorf("生成RSA密钥失败: %v", err)
+		}
+		signer = key
+	case "ed25519-sha256":
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("生成Ed25519密钥失败: %v", err)
+		}
+		signer = key
+	default:
+		return nil, fmt.Errorf("不支持的签名算法: %s", algorithm)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		return nil, fmt.Errorf("编码私钥失败: %v", err)
+	}
+	record, err := smtp.DKIMRecord(signer)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &storage.DKIMKey{
+		Domain:     domain,
+		Selector:   selector,
+		Algorithm:  algorithm,
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		DNSRecord:  record,
+	}
+	if err := d.storage.CreateDKIMKey(key); err != nil {
+		return nil, fmt.Errorf("保存DKIM密钥失败: %v", err)
+	}
+
+	d.mu.Lock()
+	d.keys[key.ID] = signer
+	d.mu.Unlock()
+
+	log.Printf("[DKIM] 已生成密钥: %s._domainkey.%s (%s)", selector, domain, algorithm)
+
+	if err := d.publish(key); err != nil {
+		log.Printf("[DKIM] 发布DNS记录失败，请手动添加: %v", err)
+	}
+	return key, nil
+}
+
+// publish 通过DNS服务商发布密钥的TXT记录
+func (d *DKIMService) publish(key *storage.DKIMKey) error {
+	if d.dnsService == nil {
+		return fmt.Errorf("DNS服务不可用")
+	}
+
+	name, ok := relativeName(d.dnsService.Zone(), key.Selector+"._domainkey."+key.Domain)
+	if !ok {
+		return fmt.Errorf("域名 %s 不在DNS服务商管理的域名下", key.Domain)
+	}
+
+	recordID, err := d.dnsService.CreateRecord(&ZoneRecord{Name: name, Type: "TXT", Value: key.DNSRecord})
+	if err != nil {
+		return err
+	}
+	key.RecordID = recordID
+	return d.storage.SetDKIMKeyRecordID(key.ID, recordID)
+}
+
+// ListKeys 获取所有DKIM密钥
+func (d *DKIMService) ListKeys() ([]*storage.DKIMKey, error) {
+	return d.storage.GetDKIMKeys()
+}
+
+// DeleteKey 删除密钥及其DNS记录
+func (d *DKIMService) DeleteKey(id int64) error {
+	key, err := d.storage.GetDKIMKeyByID(id)
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		return fmt.Errorf("DKIM密钥不存在")
+	}
+
+	if key.RecordID != "" && d.dnsService != nil {
+		if err := d.dnsService.DeleteRecord(key.RecordID); err != nil {
+			log.Printf("[DKIM] 删除DNS记录失败: %v", err)
+		}
+	}
+
+	if err := d.storage.DeleteDKIMKey(id); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	delete(d.keys, id)
+	d.mu.Unlock()
+	return nil
+}
+
+// Sign 按邮件头 From 的域名查找密钥并签名，子域名没有密钥时使用上级域名的密钥；没有可用密钥时原样返回
+func (d *DKIMService) Sign(rawData string) (string, error) {
+	domain := smtp.HeaderFromDomain(rawData)
+	if domain == "" {
+		return rawData, nil
+	}
+
+	keys, err := d.findKeys(domain)
+	if err != nil {
+		return "", err
+	}
+	// 已经签过名的邮件（如经过本机提交端口后再投递）不再重复签名
+	if len(keys) == 0 || smtp.HasDKIMSignature(rawData, keys[0].Domain) {
+		return rawData, nil
+	}
+
+	signed := rawData
+	for _, key := range keys {
+		signer, err := d.signer(key)
+		if err != nil {
+			return "", err
+		}
+		dkim := &smtp.DKIMSigner{Domain: key.Domain, Selector: key.Selector, Key: signer}
+		if signed, err = dkim.Sign(signed); err != nil {
+			return "", err
+		}
+	}
+	return signed, nil
+}
+
+// findKeys 从邮件域名开始逐级向上查找签名密钥（DMARC 宽松对齐允许使用上级域名签名）
+func (d *DKIMService) findKeys(domain string) ([]*storage.DKIMKey, error) {
+	for {
+		keys, err := d.storage.GetActiveDKIMKeys(domain)
+		if err != nil {
+			return nil, err
+		}
+		if len(keys) > 0 {
+			return keys, nil
+		}
+
+		dot := strings.IndexByte(domain, '.')
+		if dot < 0 || !strings.Contains(domain[dot+1:], ".") {
+			return nil, nil
+		}
+		domain = domain[dot+1:]
+	}
+}
+
+// signer 解析并缓存私钥
+func (d *DKIMService) signer(key *storage.DKIMKey) (crypto.Signer, error) {
+	d.mu.RLock()
+	signer, ok := d.keys[key.ID]
+	d.mu.RUnlock()
+	if ok {
+		return signer, nil
+	}
+
+	block, _ := pem.Decode([]byte(key.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("DKIM密钥 %d 格式错误", key.ID)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("解析DKIM密钥失败: %v", err)
+	}
+	signer, ok = parsed.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("不支持的DKIM密钥类型 %T", parsed)
+	}
+
+	d.mu.Lock()
+	d.keys[key.ID] = signer
+	d.mu.Unlock()
+	return signer, nil
+}
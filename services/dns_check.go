@@ -0,0 +1,164 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mail-server/smtp"
+	"mail-server/storage"
+	"net"
+	"strings"
+)
+
+// txtVersions 各类TXT记录的版本标记，同名的其他TXT记录不参与比较
+var txtVersions = map[string]string{
+	"spf":     "v=spf1",
+	"dmarc":   "v=DMARC1",
+	"tls-rpt": "v=TLSRPTv1",
+}
+
+// DNSChecker 通过公共DNS查询记录，与需要配置的值比较
+type DNSChecker struct {
+	resolver smtp.Resolver
+	mailHost string // MX和SPF a 机制指向的主机
+	publicIP string // 不为空时SPF必须包含 ip4:<publicIP>
+}
+
+// NewDNSChecker 创建DNS记录检查器
+func NewDNSChecker(resolver smtp.Resolver, mailHost, publicIP string) *DNSChecker {
+	return &DNSChecker{
+		resolver: resolver,
+		mailHost: mailHost,
+		publicIP: publicIP,
+	}
+}
+
+// Check 查询一条记录并写入检查结果
+func (c *DNSChecker) Check(ctx context.Context, check *storage.DNSCheck) {
+	check.Found = []string{}
+	check.Error = ""
+
+	switch check.Type {
+	case "MX":
+		mxs, err := c.resolver.LookupMX(ctx, check.Name)
+		if !c.lookupOK(check, err) {
+			return
+		}
+		check.Status = storage.DNSCheckMismatch
+		for _, mx := range mxs {
+			host := strings.TrimSuffix(mx.Host, ".")
+			check.Found = append(check.Found, fmt.Sprintf("%d %s", mx.Pref, host))
+			if strings.EqualFold(host, check.Value) {
+				check.Status = storage.DNSCheckOK
+			}
+		}
+		if len(mxs) == 0 {
+			check.Status = storage.DNSCheckMissing
+		}
+		return
+	case "A":
+		addrs, err := c.resolver.LookupIPAddr(ctx, check.Name)
+		if !c.lookupOK(check, err) {
+			return
+		}
+		check.Status = storage.DNSCheckMismatch
+		for _, addr := range addrs {
+			check.Found = append(check.Found, addr.IP.String())
+			if addr.IP.String() == check.Value {
+				check.Status = storage.DNSCheckOK
+			}
+		}
+		if len(addrs) == 0 {
+			check.Status = storage.DNSCheckMissing
+		}
+		return
+	}
+
+	txts, err := c.resolver.LookupTXT(ctx, check.Name)
+	if !c.lookupOK(check, err) {
+		return
+	}
+	if version, ok := txtVersions[check.Purpose]; ok {
+		txts = filterPrefix(txts, version)
+	}
+	check.Found = append(check.Found, txts...)
+	if len(txts) == 0 {
+		check.Status = storage.DNSCheckMissing
+		return
+	}
+
+	check.Status = storage.DNSCheckMismatch
+	for _, txt := range txts {
+		if c.matchTXT(check, txt) {
+			check.Status = storage.DNSCheckOK
+			return
+		}
+	}
+}
+
+// lookupOK 处理查询错误，没有记录时标记为 missing，其他错误标记为 error
+func (c *DNSChecker) lookupOK(check *storage.DNSCheck, err error) bool {
+	if err == nil {
+		return true
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		check.Status = storage.DNSCheckMissing
+		return false
+	}
+	check.Status = storage.DNSCheckError
+	check.Error = err.Error()
+	return false
+}
+
+// matchTXT 判断查到的TXT记录是否满足要求
+func (c *DNSChecker) matchTXT(check *storage.DNSCheck, txt string) bool {
+	switch check.Purpose {
+	case "spf":
+		// 配置了公网IP时必须包含 ip4:<公网IP>（mx 指向的主机可能有多个地址，不能代替），
+		// 否则包含 mx 或指向本机的 a 机制即可，其他机制不影响
+		for _, field := range strings.Fields(strings.ToLower(txt))[1:] {
+			field = strings.TrimPrefix(field, "+")
+			if c.publicIP != "" {
+				if field == "ip4:"+c.publicIP || field == "ip4:"+c.publicIP+"/32" {
+					return true
+				}
+			} else if field == "mx" || field == "a:"+c.mailHost {
+				return true
+			}
+		}
+		return false
+	case "dkim":
+		return recordTag(txt, "p") != "" && recordTag(txt, "p") == recordTag(check.Value, "p")
+	case "dmarc", "tls-rpt":
+		// 已有的策略不要求修改，只在要求了报告地址时检查是否包含
+		rua := recordTag(check.Value, "rua")
+		return rua == "" || strings.Contains(strings.ToLower(recordTag(txt, "rua")), strings.ToLower(rua))
+	default:
+		return strings.TrimSpace(txt) == check.Value
+	}
+}
+
+// filterPrefix 筛选以指定版本标记开头的TXT记录（不区分大小写）
+func filterPrefix(txts []string, prefix string) []string {
+	result := []string{}
+	for _, txt := range txts {
+		txt = strings.TrimSpace(txt)
+		if len(txt) >= len(prefix) && strings.EqualFold(txt[:len(prefix)], prefix) &&
+			(len(txt) == len(prefix) || txt[len(prefix)] == ' ' || txt[len(prefix)] == ';') {
+			result = append(result, txt)
+		}
+	}
+	return result
+}
+
+// recordTag 读取 tag=value 形式记录中某个标签的值，去掉其中的空白
+func recordTag(record, tag string) string {
+	for _, part := range strings.Split(record, ";") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) == 2 && strings.TrimSpace(kv[0]) == tag {
+			return strings.Join(strings.Fields(kv[1]), "")
+		}
+	}
+	return ""
+}
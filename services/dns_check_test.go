@@ -0,0 +1,31 @@
+package services
+
+import (
+	"mail-server/storage"
+	"testing"
+)
+
+func TestMatchSPF(t *testing.T) {
+	tests := []struct {
+		publicIP string
+		txt      string
+		want     bool
+	}{
+		{"", "v=spf1 mx ~all", true},
+		{"", "v=spf1 a:mail.example.com -all", true},
+		{"", "v=spf1 include:_spf.elsewhere.net ~all", false},
+		{"192.0.2.10", "v=spf1 mx ip4:192.0.2.10 ~all", true},
+		{"192.0.2.10", "v=spf1 +ip4:192.0.2.10/32 -all", true},
+		{"192.0.2.10", "v=spf1 mx ~all", false},
+		{"192.0.2.10", "v=spf1 a:mail.example.com ~all", false},
+		{"192.0.2.10", "v=spf1 ip4:192.0.2.11 ~all", false},
+	}
+
+	for _, tt := range tests {
+		c := NewDNSChecker(nil, "mail.example.com", tt.publicIP)
+		check := &storage.DNSCheck{Purpose: "spf", Type: "TXT"}
+		if got := c.matchTXT(check, tt.txt); got != tt.want {
+			t.Errorf("publicIP=%q matchTXT(%q) = %v, want %v", tt.publicIP, tt.txt, got, tt.want)
+		}
+	}
+}
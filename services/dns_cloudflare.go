@@ -0,0 +1,232 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// cloudflareAPI Cloudflare API地址
+	cloudflareAPI = "https://api.cloudflare.com/client/v4"
+	// cloudflarePageSize 查询记录时每页数量
+	cloudflarePageSize = 100
+)
+
+// CloudflareProvider 通过Cloudflare API v4管理DNS记录
+type CloudflareProvider struct {
+	zone   string
+	token  string
+	zoneID string // 为空时第一次请求前按主域名查询
+	client *http.Client
+	mu     sync.Mutex
+}
+
+// cloudflareRecord Cloudflare的DNS记录
+type cloudflareRecord struct {
+	ID         string `json:"id,omitempty"`
+	Type       string `json:"type"`
+	Name       string `json:"name"`
+	Content    string `json:"content"`
+	TTL        int    `json:"ttl"`
+	Priority   *int   `json:"priority,omitempty"`
+	ModifiedOn string `json:"modified_on,omitempty"`
+}
+
+// cloudflareResponse Cloudflare API响应
+type cloudflareResponse struct {
+	Success bool `json:"success"`
+	Errors  []struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+	Result     json.RawMessage `json:"result"`
+	ResultInfo struct {
+		Page       int `json:"page"`
+		TotalPages int `json:"total_pages"`
+	} `json:"result_info"`
+}
+
+// NewCloudflareProvider 创建Cloudflare DNS服务商，zoneID 为空时按主域名查询
+func NewCloudflareProvider(zone, token, zoneID string) *CloudflareProvider {
+	return &CloudflareProvider{
+		zone:   zone,
+		token:  token,
+		zoneID: zoneID,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Zone 返回管理的主域名
+func (p *CloudflareProvider) Zone() string {
+	return p.zone
+}
+
+// CreateRecord 创建记录，返回Cloudflare记录ID
+func (p *CloudflareProvider) CreateRecord(record *ZoneRecord) (string, error) {
+	if err := checkRecord(record); err != nil {
+		return "", err
+	}
+	zoneID, err := p.getZoneID()
+	if err != nil {
+		return "", err
+	}
+
+	body := &cloudflareRecord{
+		Type:    record.Type,
+		Name:    absoluteName(p.zone, record.Name),
+		Content: record.Value,
+		TTL:     record.TTL,
+	}
+	if record.Type == "MX" {
+		body.Priority = &record.Priority
+	}
+
+	var created cloudflareRecord
+	if _, err := p.request(http.MethodPost, "/zones/"+zoneID+"/dns_records", body, &created); err != nil {
+		return "", fmt.Errorf("创建%s记录失败: %v", record.Type, err)
+	}
+
+	log.Printf("%s记录创建成功: %s (RecordID: %s)", record.Type, body.Name, created.ID)
+	return created.ID, nil
+}
+
+// DeleteRecord 根据记录ID删除记录
+func (p *CloudflareProvider) DeleteRecord(recordID string) error {
+	zoneID, err := p.getZoneID()
+	if err != nil {
+		return err
+	}
+
+	if _, err := p.request(http.MethodDelete, "/zones/"+zoneID+"/dns_records/"+url.PathEscape(recordID), nil, nil); err != nil {
+		return fmt.Errorf("删除DNS记录失败: %v", err)
+	}
+
+	log.Printf("DNS记录删除成功 (RecordID: %s)", recordID)
+	return nil
+}
+
+// GetRecords 分页查询记录，name 和 recordType 为空时不过滤
+func (p *CloudflareProvider) GetRecords(name, recordType string) ([]*ZoneRecord, error) {
+	zoneID, err := p.getZoneID()
+	if err != nil {
+		return nil, err
+	}
+
+	var records []*ZoneRecord
+	for page := 1; ; page++ {
+		query := url.Values{}
+		query.Set("page", fmt.Sprintf("%d", page))
+		query.Set("per_page", fmt.Sprintf("%d", cloudflarePageSize))
+		if name != "" {
+			query.Set("name", absoluteName(p.zone, name))
+		}
+		if recordType != "" {
+			query.Set("type", strings.ToUpper(recordType))
+		}
+
+		var items []cloudflareRecord
+		resp, err := p.request(http.MethodGet, "/zones/"+zoneID+"/dns_records?"+query.Encode(), nil, &items)
+		if err != nil {
+			return nil, fmt.Errorf("查询DNS记录失败: %v", err)
+		}
+
+		for _, item := range items {
+			rel, ok := relativeName(p.zone, item.Name)
+			if !ok {
+				continue
+			}
+			record := &ZoneRecord{
+				RecordID: item.ID,
+				Name:     rel,
+				Type:     item.Type,
+				Value:    strings.TrimSuffix(item.Content, "."),
+				TTL:      item.TTL,
+			}
+			if item.Priority != nil {
+				record.Priority = *item.Priority
+			}
+			record.UpdatedOn, _ = time.Parse(time.RFC3339Nano, item.ModifiedOn)
+			if matchRecord(record, name, recordType) {
+				records = append(records, record)
+			}
+		}
+
+		if page >= resp.ResultInfo.TotalPages {
+			return records, nil
+		}
+	}
+}
+
+// getZoneID 返回主域名的Zone ID，未配置时查询一次后缓存
+func (p *CloudflareProvider) getZoneID() (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.zoneID != "" {
+		return p.zoneID, nil
+	}
+
+	var zones []struct {
+		ID string `json:"id"`
+	}
+	if _, err := p.request(http.MethodGet, "/zones?name="+url.QueryEscape(p.zone), nil, &zones); err != nil {
+		return "", fmt.Errorf("查询Cloudflare Zone失败: %v", err)
+	}
+	if len(zones) == 0 {
+		return "", fmt.Errorf("Cloudflare账号中没有域名 %s", p.zone)
+	}
+
+	p.zoneID = zones[0].ID
+	return p.zoneID, nil
+}
+
+// request 发送API请求，result 不为nil时解析响应中的 result 字段
+func (p *CloudflareProvider) request(method, path string, body, result interface{}) (*cloudflareResponse, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, cloudflareAPI+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var cfResp cloudflareResponse
+	if err := json.NewDecoder(resp.Body).Decode(&cfResp); err != nil {
+		return nil, fmt.Errorf("HTTP %d: 无法解析响应: %v", resp.StatusCode, err)
+	}
+	if !cfResp.Success {
+		if len(cfResp.Errors) > 0 {
+			return nil, fmt.Errorf("Cloudflare API错误 %d: %s", cfResp.Errors[0].Code, cfResp.Errors[0].Message)
+		}
+		return nil, fmt.Errorf("Cloudflare API错误: HTTP %d", resp.StatusCode)
+	}
+
+	if result != nil && len(cfResp.Result) > 0 {
+		if err := json.Unmarshal(cfResp.Result, result); err != nil {
+			return nil, fmt.Errorf("无法解析响应: %v", err)
+		}
+	}
+	return &cfResp, nil
+}
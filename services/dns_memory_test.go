@@ -0,0 +1,79 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// memoryDNSProvider 内存中的DNS服务商，不发出任何请求，用于测试
+type memoryDNSProvider struct {
+	zone    string
+	records map[string]*ZoneRecord
+	nextID  int64
+	mu      sync.Mutex
+}
+
+// newMemoryDNSProvider 创建内存DNS服务商
+func newMemoryDNSProvider(zone string) *memoryDNSProvider {
+	return &memoryDNSProvider{
+		zone:    zone,
+		records: make(map[string]*ZoneRecord),
+	}
+}
+
+// Zone 返回管理的主域名
+func (p *memoryDNSProvider) Zone() string {
+	return p.zone
+}
+
+// CreateRecord 保存记录，返回自增的记录ID
+func (p *memoryDNSProvider) CreateRecord(record *ZoneRecord) (string, error) {
+	if err := checkRecord(record); err != nil {
+		return "", err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.nextID++
+	saved := *record
+	saved.RecordID = strconv.FormatInt(p.nextID, 10)
+	saved.UpdatedOn = time.Now()
+	p.records[saved.RecordID] = &saved
+	return saved.RecordID, nil
+}
+
+// DeleteRecord 删除记录
+func (p *memoryDNSProvider) DeleteRecord(recordID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.records[recordID]; !ok {
+		return fmt.Errorf("记录不存在: %s", recordID)
+	}
+	delete(p.records, recordID)
+	return nil
+}
+
+// GetRecords 按创建顺序返回符合条件的记录副本
+func (p *memoryDNSProvider) GetRecords(name, recordType string) ([]*ZoneRecord, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var records []*ZoneRecord
+	for _, record := range p.records {
+		if matchRecord(record, name, recordType) {
+			r := *record
+			records = append(records, &r)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		a, _ := strconv.ParseInt(records[i].RecordID, 10, 64)
+		b, _ := strconv.ParseInt(records[j].RecordID, 10, 64)
+		return a < b
+	})
+	return records, nil
+}
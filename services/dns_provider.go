@@ -0,0 +1,207 @@
+package services
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultRecordTTL 新建记录默认TTL（秒）
+	defaultRecordTTL = 600
+	// defaultMXPriority MX记录默认优先级
+	defaultMXPriority = 10
+)
+
+// supportedRecordTypes DNSProvider 支持的记录类型
+var supportedRecordTypes = map[string]bool{
+	"A":     true,
+	"MX":    true,
+	"TXT":   true,
+	"CNAME": true,
+}
+
+// ZoneRecord 主域名下的一条解析记录
+type ZoneRecord struct {
+	RecordID  string
+	Name      string // 主机记录，如 abc123 或 @
+	Type      string // A、MX、TXT、CNAME
+	Value     string // 不含末尾的点，MX记录不含优先级
+	TTL       int
+	Priority  int       // MX优先级
+	UpdatedOn time.Time // 最后修改时间，服务商不提供时为零值
+}
+
+// DNSProvider DNS服务商接口，记录名均为相对主域名的主机记录（主域名本身为 @）
+type DNSProvider interface {
+	// Zone 返回管理的主域名
+	Zone() string
+	// CreateRecord 创建记录，返回服务商的记录ID
+	CreateRecord(record *ZoneRecord) (string, error)
+	// DeleteRecord 根据 CreateRecord 或 GetRecords 返回的记录ID删除记录
+	DeleteRecord(recordID string) error
+	// GetRecords 查询记录，name 和 recordType 为空时不过滤
+	GetRecords(name, recordType string) ([]*ZoneRecord, error)
+}
+
+// DNSProviderConfig DNS服务商配置
+type DNSProviderConfig struct {
+	Provider string // dnspod（默认）、cloudflare、route53、rfc2136
+	Zone     string // 主域名
+	PublicIP string
+	// DNSPod
+	TencentSecretID  string
+	TencentSecretKey string
+	// Cloudflare
+	CloudflareAPIToken string
+	CloudflareZoneID   string // 为空时按主域名查询
+	// Route53 及兼容接口
+	Route53AccessKeyID     string
+	Route53SecretAccessKey string
+	Route53HostedZoneID    string // 为空时按主域名查询
+	Route53Endpoint        string // 为空时使用 AWS 官方地址
+	Route53Region          string
+	// RFC 2136 动态更新
+	RFC2136Server    string // host:port
+	RFC2136KeyName   string // TSIG密钥名，为空时不签名
+	RFC2136Secret    string // TSIG密钥（base64）
+	RFC2136Algorithm string // 默认 hmac-sha256
+}
+
+// NewDNSProvider 根据配置创建DNS服务商，关键配置为空时返回 nil（不管理DNS）
+func NewDNSProvider(cfg *DNSProviderConfig) (DNSProvider, error) {
+	if cfg.Zone == "" {
+		return nil, nil
+	}
+
+	switch strings.ToLower(cfg.Provider) {
+	case "", "dnspod":
+		if cfg.PublicIP == "" || cfg.TencentSecretID == "" || cfg.TencentSecretKey == "" {
+			return nil, nil
+		}
+		service, err := NewDNSPodServiceWithCredentials(cfg.Zone, cfg.PublicIP, cfg.TencentSecretID, cfg.TencentSecretKey)
+		if err != nil {
+			return nil, err
+		}
+		return service, nil
+	case "cloudflare":
+		if cfg.CloudflareAPIToken == "" {
+			return nil, nil
+		}
+		return NewCloudflareProvider(cfg.Zone, cfg.CloudflareAPIToken, cfg.CloudflareZoneID), nil
+	case "route53":
+		if cfg.Route53AccessKeyID == "" || cfg.Route53SecretAccessKey == "" {
+			return nil, nil
+		}
+		return NewRoute53Provider(cfg.Zone, cfg.Route53AccessKeyID, cfg.Route53SecretAccessKey, cfg.Route53HostedZoneID, cfg.Route53Endpoint, cfg.Route53Region), nil
+	case "rfc2136":
+		if cfg.RFC2136Server == "" {
+			return nil, nil
+		}
+		provider, err := NewRFC2136Provider(cfg.Zone, cfg.RFC2136Server, cfg.RFC2136KeyName, cfg.RFC2136Secret, cfg.RFC2136Algorithm)
+		if err != nil {
+			return nil, err
+		}
+		return provider, nil
+	default:
+		return nil, fmt.Errorf("不支持的DNS服务商: %s", cfg.Provider)
+	}
+}
+
+// checkRecord 校验记录类型并补全默认TTL和MX优先级
+func checkRecord(record *ZoneRecord) error {
+	record.Type = strings.ToUpper(record.Type)
+	if !supportedRecordTypes[record.Type] {
+		return fmt.Errorf("不支持的记录类型: %s", record.Type)
+	}
+	record.Name = strings.ToLower(record.Name)
+	if record.Name == "" {
+		record.Name = "@"
+	}
+	record.Value = strings.TrimSuffix(record.Value, ".")
+	if record.TTL <= 0 {
+		record.TTL = defaultRecordTTL
+	}
+	if record.Type == "MX" && record.Priority <= 0 {
+		record.Priority = defaultMXPriority
+	}
+	return nil
+}
+
+// matchRecord 判断记录是否符合 GetRecords 的过滤条件
+func matchRecord(record *ZoneRecord, name, recordType string) bool {
+	if !supportedRecordTypes[record.Type] {
+		return false
+	}
+	if name != "" && !strings.EqualFold(record.Name, name) {
+		return false
+	}
+	return recordType == "" || strings.EqualFold(record.Type, recordType)
+}
+
+// relativeName 将完整域名转换为相对主域名的主机记录，不属于主域名时返回 false
+func relativeName(zone, fqdn string) (string, bool) {
+	fqdn = strings.TrimSuffix(strings.ToLower(fqdn), ".")
+	zone = strings.TrimSuffix(strings.ToLower(zone), ".")
+	if fqdn == zone {
+		return "@", true
+	}
+	if !strings.HasSuffix(fqdn, "."+zone) {
+		return "", false
+	}
+	return strings.TrimSuffix(fqdn, "."+zone), true
+}
+
+// absoluteName 将主机记录转换为完整域名（不含末尾的点）
+func absoluteName(zone, name string) string {
+	if name == "" || name == "@" {
+		return zone
+	}
+	return name + "." + zone
+}
+
+// recordKey 没有记录ID的服务商用记录内容作为ID：主机记录|类型|优先级|值
+func recordKey(record *ZoneRecord) string {
+	return strings.Join([]string{record.Name, record.Type, strconv.Itoa(record.Priority), record.Value}, "|")
+}
+
+// parseRecordKey 解析 recordKey 生成的记录ID
+func parseRecordKey(recordID string) (*ZoneRecord, error) {
+	parts := strings.SplitN(recordID, "|", 4)
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("无效的记录ID: %s", recordID)
+	}
+	priority, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("无效的记录ID: %s", recordID)
+	}
+	return &ZoneRecord{RecordID: recordID, Name: parts[0], Type: parts[1], Priority: priority, Value: parts[3]}, nil
+}
+
+// randomLabel 生成由小写字母和数字组成的随机DNS标签
+func randomLabel(length int) (string, error) {
+	const charset = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+	b := make([]byte, length)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(charset))))
+		if err != nil {
+			return "", fmt.Errorf("生成随机数失败: %v", err)
+		}
+		b[i] = charset[n.Int64()]
+	}
+	return string(b), nil
+}
+
+// splitTXT 将TXT记录值按255字节拆分为多个字符串（DKIM公钥通常超过255字节）
+func splitTXT(value string) []string {
+	var chunks []string
+	for len(value) > 255 {
+		chunks = append(chunks, value[:255])
+		value = value[255:]
+	}
+	return append(chunks, value)
+}
@@ -0,0 +1,70 @@
+package services
+
+import (
+	"log"
+	"time"
+)
+
+const (
+	// dnsReconcileInterval DNS记录对账间隔
+	dnsReconcileInterval = time.Hour
+	// dnsReconcileMinAge 最近更新过的记录不参与对账，留给正在创建的邮箱写入数据库
+	dnsReconcileMinAge = 10 * time.Minute
+)
+
+// DNSReconciler 定期对比DNS服务商的记录和数据库中的邮箱域名，删除没有对应邮箱的残留记录并补发缺少的记录
+type DNSReconciler struct {
+	dnsService *MailDNSService
+	stop       chan struct{}
+	done       chan struct{}
+}
+
+// NewDNSReconciler 创建DNS记录对账任务
+func NewDNSReconciler(dnsService *MailDNSService) *DNSReconciler {
+	return &DNSReconciler{
+		dnsService: dnsService,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+// Start 启动后台对账
+func (r *DNSReconciler) Start() {
+	go r.run()
+	log.Printf("[DNS] 记录自动对账已启动 (间隔: %v)", dnsReconcileInterval)
+}
+
+// Stop 停止后台对账
+func (r *DNSReconciler) Stop() {
+	close(r.stop)
+	<-r.done
+}
+
+// run 启动时对账一次，之后按固定间隔对账
+func (r *DNSReconciler) run() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(dnsReconcileInterval)
+	defer ticker.Stop()
+
+	for {
+		r.reconcile()
+		select {
+		case <-ticker.C:
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// reconcile 执行一次对账
+func (r *DNSReconciler) reconcile() {
+	deleted, created, err := r.dnsService.ReconcileRecords(dnsReconcileMinAge)
+	if err != nil {
+		log.Printf("[DNS] 对账失败: %v", err)
+		return
+	}
+	if deleted > 0 || created > 0 {
+		log.Printf("[DNS] 已清理 %d 条残留记录，补发 %d 条缺少的记录", deleted, created)
+	}
+}
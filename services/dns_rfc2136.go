@@ -0,0 +1,215 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// rfc2136Timeout 单次更新或区域传送的超时
+const rfc2136Timeout = 30 * time.Second
+
+// rfc2136Algorithms 支持的TSIG算法
+var rfc2136Algorithms = map[string]string{
+	"hmac-sha1":   dns.HmacSHA1,
+	"hmac-sha256": dns.HmacSHA256,
+	"hmac-sha512": dns.HmacSHA512,
+}
+
+// RFC2136Provider 通过DNS动态更新（RFC 2136）管理记录，请求使用TSIG签名，查询记录使用区域传送（AXFR）
+// 动态更新没有记录ID，记录ID使用 recordKey 生成
+type RFC2136Provider struct {
+	zone      string
+	server    string
+	keyName   string // TSIG密钥名（FQDN），为空时不签名
+	secret    string
+	algorithm string
+}
+
+// NewRFC2136Provider 创建RFC 2136 DNS服务商，server 为主DNS服务器地址（host:port，端口默认53）
+func NewRFC2136Provider(zone, server, keyName, secret, algorithm string) (*RFC2136Provider, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+
+	p := &RFC2136Provider{
+		zone:   zone,
+		server: server,
+	}
+	if keyName != "" {
+		if secret == "" {
+			return nil, fmt.Errorf("RFC 2136 TSIG密钥不能为空")
+		}
+		if algorithm == "" {
+			algorithm = "hmac-sha256"
+		}
+		alg, ok := rfc2136Algorithms[strings.ToLower(algorithm)]
+		if !ok {
+			return nil, fmt.Errorf("不支持的TSIG算法: %s", algorithm)
+		}
+		p.keyName = dns.Fqdn(keyName)
+		p.secret = secret
+		p.algorithm = alg
+	}
+	return p, nil
+}
+
+// Zone 返回管理的主域名
+func (p *RFC2136Provider) Zone() string {
+	return p.zone
+}
+
+// CreateRecord 通过动态更新添加记录，返回记录ID
+func (p *RFC2136Provider) CreateRecord(record *ZoneRecord) (string, error) {
+	if err := checkRecord(record); err != nil {
+		return "", err
+	}
+	rr, err := p.toRR(record)
+	if err != nil {
+		return "", err
+	}
+
+	m := new(dns.Msg)
+	m.SetUpdate(dns.Fqdn(p.zone))
+	m.Insert([]dns.RR{rr})
+	if err := p.update(m); err != nil {
+		return "", fmt.Errorf("创建%s记录失败: %v", record.Type, err)
+	}
+
+	recordID := recordKey(record)
+	log.Printf("%s记录创建成功: %s (RecordID: %s)", record.Type, absoluteName(p.zone, record.Name), recordID)
+	return recordID, nil
+}
+
+// DeleteRecord 通过动态更新删除记录
+func (p *RFC2136Provider) DeleteRecord(recordID string) error {
+	record, err := parseRecordKey(recordID)
+	if err != nil {
+		return err
+	}
+	rr, err := p.toRR(record)
+	if err != nil {
+		return err
+	}
+
+	m := new(dns.Msg)
+	m.SetUpdate(dns.Fqdn(p.zone))
+	m.Remove([]dns.RR{rr})
+	if err := p.update(m); err != nil {
+		return fmt.Errorf("删除DNS记录失败: %v", err)
+	}
+
+	log.Printf("DNS记录删除成功 (RecordID: %s)", recordID)
+	return nil
+}
+
+// GetRecords 通过区域传送读取全部记录后过滤，动态更新不提供修改时间
+func (p *RFC2136Provider) GetRecords(name, recordType string) ([]*ZoneRecord, error) {
+	m := new(dns.Msg)
+	m.SetAxfr(dns.Fqdn(p.zone))
+	t := &dns.Transfer{DialTimeout: rfc2136Timeout, ReadTimeout: rfc2136Timeout}
+	if p.keyName != "" {
+		m.SetTsig(p.keyName, p.algorithm, 300, time.Now().Unix())
+		t.TsigSecret = map[string]string{p.keyName: p.secret}
+	}
+
+	envelopes, err := t.In(m, p.server)
+	if err != nil {
+		return nil, fmt.Errorf("区域传送失败: %v", err)
+	}
+
+	var records []*ZoneRecord
+	for env := range envelopes {
+		if env.Error != nil {
+			return nil, fmt.Errorf("区域传送失败: %v", env.Error)
+		}
+		for _, rr := range env.RR {
+			record := p.fromRR(rr)
+			if record != nil && matchRecord(record, name, recordType) {
+				records = append(records, record)
+			}
+		}
+	}
+	return records, nil
+}
+
+// update 发送动态更新请求并检查响应码
+func (p *RFC2136Provider) update(m *dns.Msg) error {
+	c := &dns.Client{Net: "tcp", Timeout: rfc2136Timeout}
+	if p.keyName != "" {
+		m.SetTsig(p.keyName, p.algorithm, 300, time.Now().Unix())
+		c.TsigSecret = map[string]string{p.keyName: p.secret}
+	}
+
+	resp, _, err := c.Exchange(m, p.server)
+	if err != nil {
+		return err
+	}
+	if resp.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("DNS服务器返回 %s", dns.RcodeToString[resp.Rcode])
+	}
+	return nil
+}
+
+// toRR 将记录转换为DNS资源记录
+func (p *RFC2136Provider) toRR(record *ZoneRecord) (dns.RR, error) {
+	hdr := dns.RR_Header{
+		Name:  dns.Fqdn(absoluteName(p.zone, record.Name)),
+		Class: dns.ClassINET,
+		Ttl:   uint32(record.TTL),
+	}
+
+	switch record.Type {
+	case "A":
+		ip := net.ParseIP(record.Value).To4()
+		if ip == nil {
+			return nil, fmt.Errorf("无效的IPv4地址: %s", record.Value)
+		}
+		hdr.Rrtype = dns.TypeA
+		return &dns.A{Hdr: hdr, A: ip}, nil
+	case "MX":
+		hdr.Rrtype = dns.TypeMX
+		return &dns.MX{Hdr: hdr, Preference: uint16(record.Priority), Mx: dns.Fqdn(record.Value)}, nil
+	case "TXT":
+		hdr.Rrtype = dns.TypeTXT
+		return &dns.TXT{Hdr: hdr, Txt: splitTXT(record.Value)}, nil
+	case "CNAME":
+		hdr.Rrtype = dns.TypeCNAME
+		return &dns.CNAME{Hdr: hdr, Target: dns.Fqdn(record.Value)}, nil
+	default:
+		return nil, fmt.Errorf("不支持的记录类型: %s", record.Type)
+	}
+}
+
+// fromRR 将DNS资源记录转换为记录，不支持的类型返回 nil
+func (p *RFC2136Provider) fromRR(rr dns.RR) *ZoneRecord {
+	name, ok := relativeName(p.zone, rr.Header().Name)
+	if !ok {
+		return nil
+	}
+
+	record := &ZoneRecord{Name: name, TTL: int(rr.Header().Ttl)}
+	switch v := rr.(type) {
+	case *dns.A:
+		record.Type = "A"
+		record.Value = v.A.String()
+	case *dns.MX:
+		record.Type = "MX"
+		record.Priority = int(v.Preference)
+		record.Value = strings.TrimSuffix(v.Mx, ".")
+	case *dns.TXT:
+		record.Type = "TXT"
+		record.Value = strings.Join(v.Txt, "")
+	case *dns.CNAME:
+		record.Type = "CNAME"
+		record.Value = strings.TrimSuffix(v.Target, ".")
+	default:
+		return nil
+	}
+	record.RecordID = recordKey(record)
+	return record
+}
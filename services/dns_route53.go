@@ -0,0 +1,506 @@
+package services
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// route53DefaultEndpoint AWS Route53 API地址
+	route53DefaultEndpoint = "https://route53.amazonaws.com"
+	// route53DefaultRegion Route53签名使用的区域
+	route53DefaultRegion = "us-east-1"
+	// route53APIVersion Route53 API版本
+	route53APIVersion = "2013-04-01"
+	// route53XMLNS Route53请求的XML命名空间
+	route53XMLNS = "https://route53.amazonaws.com/doc/2013-04-01/"
+	// route53PageSize 查询记录时每页数量
+	route53PageSize = 300
+)
+
+// Route53Provider 通过Route53 API（或兼容接口）管理DNS记录，请求使用 AWS Signature V4 签名
+// Route53按"名称+类型"保存记录集，没有单条记录的ID，记录ID使用 recordKey 生成
+type Route53Provider struct {
+	zone         string
+	accessKey    string
+	secretKey    string
+	hostedZoneID string // 为空时第一次请求前按主域名查询
+	endpoint     string
+	region       string
+	client       *http.Client
+	mu           sync.Mutex
+}
+
+// route53RecordSet Route53记录集
+type route53RecordSet struct {
+	Name            string   `xml:"Name"`
+	Type            string   `xml:"Type"`
+	TTL             int      `xml:"TTL"`
+	ResourceRecords []string `xml:"ResourceRecords>ResourceRecord>Value"`
+}
+
+// route53Change 记录集变更
+type route53Change struct {
+	Action    string            `xml:"Action"`
+	RecordSet *route53RecordSet `xml:"ResourceRecordSet"`
+}
+
+// route53ChangeRequest ChangeResourceRecordSets 请求
+type route53ChangeRequest struct {
+	XMLName xml.Name        `xml:"ChangeResourceRecordSetsRequest"`
+	XMLNS   string          `xml:"xmlns,attr"`
+	Changes []route53Change `xml:"ChangeBatch>Changes>Change"`
+}
+
+// route53ListResponse ListResourceRecordSets 响应
+type route53ListResponse struct {
+	RecordSets     []route53RecordSet `xml:"ResourceRecordSets>ResourceRecordSet"`
+	IsTruncated    bool               `xml:"IsTruncated"`
+	NextRecordName string             `xml:"NextRecordName"`
+	NextRecordType string             `xml:"NextRecordType"`
+}
+
+// route53ErrorResponse Route53错误响应，InvalidChangeBatch 的详情在 Messages 中
+type route53ErrorResponse struct {
+	Code     string   `xml:"Error>Code"`
+	Message  string   `xml:"Error>Message"`
+	Messages []string `xml:"Messages>Message"`
+}
+
+// NewRoute53Provider 创建Route53 DNS服务商，hostedZoneID 为空时按主域名查询，endpoint 为空时使用AWS官方地址
+func NewRoute53Provider(zone, accessKey, secretKey, hostedZoneID, endpoint, region string) *Route53Provider {
+	if endpoint == "" {
+		endpoint = route53DefaultEndpoint
+	}
+	if region == "" {
+		region = route53DefaultRegion
+	}
+	return &Route53Provider{
+		zone:         zone,
+		accessKey:    accessKey,
+		secretKey:    secretKey,
+		hostedZoneID: strings.TrimPrefix(hostedZoneID, "/hostedzone/"),
+		endpoint:     strings.TrimSuffix(endpoint, "/"),
+		region:       region,
+		client:       &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Zone 返回管理的主域名
+func (p *Route53Provider) Zone() string {
+	return p.zone
+}
+
+// CreateRecord 把记录值加入对应的记录集，返回记录ID
+func (p *Route53Provider) CreateRecord(record *ZoneRecord) (string, error) {
+	if err := checkRecord(record); err != nil {
+		return "", err
+	}
+	value := route53Value(record)
+
+	existing, err := p.getRecordSet(record.Name, record.Type)
+	if err != nil {
+		return "", fmt.Errorf("创建%s记录失败: %v", record.Type, err)
+	}
+
+	// 删除旧记录集和创建新记录集在同一批次中原子执行，旧记录集被并发修改时整批失败
+	var changes []route53Change
+	updated := &route53RecordSet{Name: absoluteName(p.zone, record.Name) + ".", Type: record.Type, TTL: record.TTL}
+	if existing != nil {
+		for _, v := range existing.ResourceRecords {
+			if v == value {
+				return recordKey(record), nil
+			}
+		}
+		changes = append(changes, route53Change{Action: "DELETE", RecordSet: existing})
+		updated.TTL = existing.TTL
+		updated.ResourceRecords = append(updated.ResourceRecords, existing.ResourceRecords...)
+	}
+	updated.ResourceRecords = append(updated.ResourceRecords, value)
+	changes = append(changes, route53Change{Action: "CREATE", RecordSet: updated})
+
+	if err := p.change(changes); err != nil {
+		return "", fmt.Errorf("创建%s记录失败: %v", record.Type, err)
+	}
+
+	recordID := recordKey(record)
+	log.Printf("%s记录创建成功: %s (RecordID: %s)", record.Type, absoluteName(p.zone, record.Name), recordID)
+	return recordID, nil
+}
+
+// DeleteRecord 从记录集中删除记录值，记录集为空时删除整个记录集
+func (p *Route53Provider) DeleteRecord(recordID string) error {
+	record, err := parseRecordKey(recordID)
+	if err != nil {
+		return err
+	}
+	value := route53Value(record)
+
+	existing, err := p.getRecordSet(record.Name, record.Type)
+	if err != nil {
+		return fmt.Errorf("删除DNS记录失败: %v", err)
+	}
+
+	var remaining []string
+	found := false
+	if existing != nil {
+		for _, v := range existing.ResourceRecords {
+			if v == value {
+				found = true
+				continue
+			}
+			remaining = append(remaining, v)
+		}
+	}
+	if !found {
+		return fmt.Errorf("删除DNS记录失败: 记录不存在")
+	}
+
+	changes := []route53Change{{Action: "DELETE", RecordSet: existing}}
+	if len(remaining) > 0 {
+		changes = append(changes, route53Change{Action: "CREATE", RecordSet: &route53RecordSet{
+			Name:            existing.Name,
+			Type:            existing.Type,
+			TTL:             existing.TTL,
+			ResourceRecords: remaining,
+		}})
+	}
+	if err := p.change(changes); err != nil {
+		return fmt.Errorf("删除DNS记录失败: %v", err)
+	}
+
+	log.Printf("DNS记录删除成功 (RecordID: %s)", recordID)
+	return nil
+}
+
+// GetRecords 查询记录，记录集中的每个值作为一条记录返回，Route53不提供修改时间
+func (p *Route53Provider) GetRecords(name, recordType string) ([]*ZoneRecord, error) {
+	var records []*ZoneRecord
+	err := p.listRecordSets(name, recordType, func(set *route53RecordSet) bool {
+		rel, ok := relativeName(p.zone, set.Name)
+		if !ok {
+			return true
+		}
+		// 指定名称时列表从该名称开始，遇到其他名称说明已经查完
+		if name != "" && !strings.EqualFold(rel, name) {
+			return false
+		}
+		for _, v := range set.ResourceRecords {
+			record := parseRoute53Value(set.Type, v)
+			record.Name = rel
+			record.TTL = set.TTL
+			record.RecordID = recordKey(record)
+			if matchRecord(record, name, recordType) {
+				records = append(records, record)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("查询DNS记录失败: %v", err)
+	}
+	return records, nil
+}
+
+// getRecordSet 查询指定名称和类型的记录集，不存在时返回 nil
+func (p *Route53Provider) getRecordSet(name, recordType string) (*route53RecordSet, error) {
+	var found *route53RecordSet
+	err := p.listRecordSets(name, recordType, func(set *route53RecordSet) bool {
+		rel, ok := relativeName(p.zone, set.Name)
+		if ok && strings.EqualFold(rel, name) && set.Type == recordType {
+			s := *set
+			found = &s
+		}
+		return false
+	})
+	return found, err
+}
+
+// listRecordSets 分页遍历记录集，name 不为空时从该名称开始，fn 返回 false 时停止
+func (p *Route53Provider) listRecordSets(name, recordType string, fn func(set *route53RecordSet) bool) error {
+	zoneID, err := p.getHostedZoneID()
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{}
+	query.Set("maxitems", strconv.Itoa(route53PageSize))
+	if name != "" {
+		query.Set("name", absoluteName(p.zone, name)+".")
+		// Route53只允许在指定名称时按类型过滤
+		if recordType != "" {
+			query.Set("type", strings.ToUpper(recordType))
+		}
+	}
+
+	for {
+		var resp route53ListResponse
+		if err := p.request(http.MethodGet, "/hostedzone/"+zoneID+"/rrset", query, nil, &resp); err != nil {
+			return err
+		}
+		for i := range resp.RecordSets {
+			if !fn(&resp.RecordSets[i]) {
+				return nil
+			}
+		}
+		if !resp.IsTruncated {
+			return nil
+		}
+		query.Set("name", resp.NextRecordName)
+		query.Set("type", resp.NextRecordType)
+	}
+}
+
+// change 提交一批记录集变更
+func (p *Route53Provider) change(changes []route53Change) error {
+	zoneID, err := p.getHostedZoneID()
+	if err != nil {
+		return err
+	}
+
+	body := &route53ChangeRequest{XMLNS: route53XMLNS, Changes: changes}
+	return p.request(http.MethodPost, "/hostedzone/"+zoneID+"/rrset", nil, body, nil)
+}
+
+// getHostedZoneID 返回主域名的Hosted Zone ID，未配置时查询一次后缓存
+func (p *Route53Provider) getHostedZoneID() (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.hostedZoneID != "" {
+		return p.hostedZoneID, nil
+	}
+
+	var resp struct {
+		HostedZones []struct {
+			ID   string `xml:"Id"`
+			Name string `xml:"Name"`
+		} `xml:"HostedZones>HostedZone"`
+	}
+	query := url.Values{}
+	query.Set("dnsname", p.zone+".")
+	query.Set("maxitems", "1")
+	if err := p.request(http.MethodGet, "/hostedzonesbyname", query, nil, &resp); err != nil {
+		return "", fmt.Errorf("查询Route53 Hosted Zone失败: %v", err)
+	}
+	if len(resp.HostedZones) == 0 || !strings.EqualFold(strings.TrimSuffix(resp.HostedZones[0].Name, "."), p.zone) {
+		return "", fmt.Errorf("Route53账号中没有域名 %s", p.zone)
+	}
+
+	p.hostedZoneID = strings.TrimPrefix(resp.HostedZones[0].ID, "/hostedzone/")
+	return p.hostedZoneID, nil
+}
+
+// request 发送签名后的API请求，body 和 result 不为nil时按XML编解码
+func (p *Route53Provider) request(method, path string, query url.Values, body, result interface{}) error {
+	var payload []byte
+	if body != nil {
+		data, err := xml.Marshal(body)
+		if err != nil {
+			return err
+		}
+		payload = append([]byte(xml.Header), data...)
+	}
+
+	u := p.endpoint + "/" + route53APIVersion + path
+	if len(query) > 0 {
+		u += "?" + canonicalQuery(query)
+	}
+	req, err := http.NewRequest(method, u, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/xml")
+	}
+	p.sign(req, payload, time.Now().UTC())
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var errResp route53ErrorResponse
+		if xml.Unmarshal(data, &errResp) == nil {
+			if len(errResp.Messages) > 0 {
+				return fmt.Errorf("Route53 API错误: %s", strings.Join(errResp.Messages, "; "))
+			}
+			if errResp.Code != "" {
+				return fmt.Errorf("Route53 API错误 %s: %s", errResp.Code, errResp.Message)
+			}
+		}
+		return fmt.Errorf("Route53 API错误: HTTP %d", resp.StatusCode)
+	}
+
+	if result != nil {
+		if err := xml.Unmarshal(data, result); err != nil {
+			return fmt.Errorf("无法解析响应: %v", err)
+		}
+	}
+	return nil
+}
+
+// sign 使用 AWS Signature Version 4 签名请求
+func (p *Route53Provider) sign(req *http.Request, payload []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(payload)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": payloadHash,
+		"x-amz-date":           amzDate,
+	}
+	if ct := req.Header.Get("Content-Type"); ct != "" {
+		headers["content-type"] = ct
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + p.region + "/route53/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+p.secretKey), date)
+	key = hmacSHA256(key, p.region)
+	key = hmacSHA256(key, "route53")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		p.accessKey, scope, signedHeaders, signature))
+}
+
+// canonicalQuery 按 SigV4 规则编码查询参数：按名称排序，空格编码为 %20
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, sigv4Escape(k)+"="+sigv4Escape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// sigv4Escape 按RFC 3986编码，只保留字母、数字和 -_.~
+func sigv4Escape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+// sha256Hex 计算SHA-256的十六进制
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// hmacSHA256 计算HMAC-SHA256
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// route53Value 将记录转换为Route53记录值：MX带优先级，域名带末尾的点，TXT加引号
+func route53Value(record *ZoneRecord) string {
+	switch record.Type {
+	case "MX":
+		return fmt.Sprintf("%d %s.", record.Priority, record.Value)
+	case "CNAME":
+		return record.Value + "."
+	case "TXT":
+		var quoted []string
+		for _, chunk := range splitTXT(record.Value) {
+			chunk = strings.ReplaceAll(chunk, `\`, `\\`)
+			quoted = append(quoted, `"`+strings.ReplaceAll(chunk, `"`, `\"`)+`"`)
+		}
+		return strings.Join(quoted, " ")
+	default:
+		return record.Value
+	}
+}
+
+// parseRoute53Value 解析Route53记录值，是 route53Value 的逆操作
+func parseRoute53Value(recordType, value string) *ZoneRecord {
+	record := &ZoneRecord{Type: recordType}
+	switch recordType {
+	case "MX":
+		if fields := strings.Fields(value); len(fields) == 2 {
+			record.Priority, _ = strconv.Atoi(fields[0])
+			value = fields[1]
+		}
+		record.Value = strings.TrimSuffix(value, ".")
+	case "CNAME":
+		record.Value = strings.TrimSuffix(value, ".")
+	case "TXT":
+		record.Value = unquoteTXT(value)
+	default:
+		record.Value = value
+	}
+	return record
+}
+
+// unquoteTXT 将 "a" "b" 形式的TXT记录值拼接为一个字符串
+func unquoteTXT(value string) string {
+	var sb strings.Builder
+	inQuote := false
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == '\\' && inQuote && i+1 < len(value):
+			i++
+			sb.WriteByte(value[i])
+		case c == '"':
+			inQuote = !inQuote
+		case inQuote:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
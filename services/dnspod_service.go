@@ -1,13 +1,12 @@
 package services
 
 import (
-	"crypto/rand"
 	"fmt"
 	"log"
-	"math/big"
 	"os"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
 	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/errors"
@@ -105,22 +104,13 @@ func (d *DNSPodService) GenerateSubdomain() (string, error) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
-	const charset = "abcdefghijklmnopqrstuvwxyz0123456789"
-	const length = 8
-
 	for attempts := 0; attempts < 100; attempts++ {
 		// 生成随机字符串
-		b := make([]byte, length)
-		for i := range b {
-			n, err := rand.Int(rand.Reader, big.NewInt(int64(len(charset))))
-			if err != nil {
-				return "", fmt.Errorf("生成随机数失败: %v", err)
-			}
-			b[i] = charset[n.Int64()]
+		subdomain, err := randomLabel(8)
+		if err != nil {
+			return "", err
 		}
 
-		subdomain := string(b)
-
 		// 检查是否已存在
 		if _, exists := d.subdomainMap[subdomain]; !exists {
 			return subdomain, nil
@@ -222,6 +212,134 @@ func (d *DNSPodService) DeleteDNSRecord(port int) error {
 	return nil
 }
 
+// Zone 返回管理的主域名
+func (d *DNSPodService) Zone() string {
+	return d.domain
+}
+
+// CreateRecord 在主域名下创建记录，返回DNSPod记录ID
+func (d *DNSPodService) CreateRecord(record *ZoneRecord) (string, error) {
+	if err := checkRecord(record); err != nil {
+		return "", err
+	}
+
+	request := dnspod.NewCreateRecordRequest()
+	request.Domain = common.StringPtr(d.domain)
+	request.RecordType = common.StringPtr(record.Type)
+	request.RecordLine = common.StringPtr("默认")
+	// MX记录的Value只需要域名，不需要优先级和点号
+	request.Value = common.StringPtr(record.Value)
+	request.SubDomain = common.StringPtr(record.Name)
+	request.TTL = common.Uint64Ptr(uint64(record.TTL))
+	request.Status = common.StringPtr("ENABLE")
+	if record.Type == "MX" {
+		// 优先级单独设置在MX字段
+		request.MX = common.Uint64Ptr(uint64(record.Priority))
+	}
+
+	response, err := d.client.CreateRecord(request)
+	if err != nil {
+		if sdkErr, ok := err.(*errors.TencentCloudSDKError); ok {
+			return "", fmt.Errorf("DNSPod API错误: %s", sdkErr.Message)
+		}
+		return "", fmt.Errorf("创建%s记录失败: %v", record.Type, err)
+	}
+
+	recordID := fmt.Sprintf("%d", *response.Response.RecordId)
+	log.Printf("%s记录创建成功: %s (RecordID: %s)", record.Type, absoluteName(d.domain, record.Name), recordID)
+	return recordID, nil
+}
+
+// DeleteRecord 根据记录ID删除主域名下的DNS记录
+func (d *DNSPodService) DeleteRecord(recordID string) error {
+	request := dnspod.NewDeleteRecordRequest()
+	request.Domain = common.StringPtr(d.domain)
+	request.RecordId = common.Uint64Ptr(parseUint64(recordID))
+
+	_, err := d.client.DeleteRecord(request)
+	if err != nil {
+		if sdkErr, ok := err.(*errors.TencentCloudSDKError); ok {
+			return fmt.Errorf("DNSPod API错误: %s", sdkErr.Message)
+		}
+		return fmt.Errorf("删除DNS记录失败: %v", err)
+	}
+
+	log.Printf("DNS记录删除成功 (RecordID: %s)", recordID)
+	return nil
+}
+
+// recordListPageSize DescribeRecordList 每页数量
+const recordListPageSize = 1000
+
+// GetRecords 分页查询主域名下的解析记录，name 和 recordType 为空时不过滤
+func (d *DNSPodService) GetRecords(name, recordType string) ([]*ZoneRecord, error) {
+	var records []*ZoneRecord
+	for offset := uint64(0); ; offset += recordListPageSize {
+		request := dnspod.NewDescribeRecordListRequest()
+		request.Domain = common.StringPtr(d.domain)
+		if name != "" {
+			request.Subdomain = common.StringPtr(name)
+		}
+		if recordType != "" {
+			request.RecordType = common.StringPtr(recordType)
+		}
+		request.Offset = common.Uint64Ptr(offset)
+		request.Limit = common.Uint64Ptr(recordListPageSize)
+
+		response, err := d.client.DescribeRecordList(request)
+		if err != nil {
+			if sdkErr, ok := err.(*errors.TencentCloudSDKError); ok {
+				// 没有匹配的记录时DNSPod返回错误而不是空列表
+				if sdkErr.Code == "ResourceNotFound.NoDataOfRecord" {
+					return records, nil
+				}
+				return nil, fmt.Errorf("DNSPod API错误: %s", sdkErr.Message)
+			}
+			return nil, fmt.Errorf("查询DNS记录失败: %v", err)
+		}
+
+		for _, item := range response.Response.RecordList {
+			if item.RecordId == nil {
+				continue
+			}
+			record := &ZoneRecord{
+				RecordID: fmt.Sprintf("%d", *item.RecordId),
+				Name:     stringValue(item.Name),
+				Type:     stringValue(item.Type),
+				Value:    strings.TrimSuffix(stringValue(item.Value), "."),
+			}
+			if item.TTL != nil {
+				record.TTL = int(*item.TTL)
+			}
+			if item.MX != nil {
+				record.Priority = int(*item.MX)
+			}
+			// DNSPod返回的是北京时间
+			if item.UpdatedOn != nil {
+				record.UpdatedOn, _ = time.ParseInLocation("2006-01-02 15:04:05", *item.UpdatedOn, dnspodLocation)
+			}
+			if matchRecord(record, name, recordType) {
+				records = append(records, record)
+			}
+		}
+
+		if len(response.Response.RecordList) < recordListPageSize {
+			return records, nil
+		}
+	}
+}
+
+// dnspodLocation DNSPod接口返回时间使用的时区
+var dnspodLocation = time.FixedZone("CST", 8*3600)
+
+// stringValue 读取SDK返回的字符串指针
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
 // GetPortByDomain 根据域名获取端口
 func (d *DNSPodService) GetPortByDomain(domain string) (int, bool) {
 	d.mu.RLock()
@@ -0,0 +1,96 @@
+package services
+
+import (
+	"log"
+	"mail-server/storage"
+	"time"
+)
+
+// domainReapInterval 检查过期邮箱的间隔
+const domainReapInterval = time.Minute
+
+// DomainReaper 定期删除已过期的邮箱域名，包括DNS记录和收到的邮件
+type DomainReaper struct {
+	storage    storage.Storage
+	dnsService *MailDNSService // 为nil时只删除数据库记录
+	stop       chan struct{}
+	done       chan struct{}
+}
+
+// NewDomainReaper 创建过期邮箱清理任务
+func NewDomainReaper(store storage.Storage, dnsService *MailDNSService) *DomainReaper {
+	return &DomainReaper{
+		storage:    store,
+		dnsService: dnsService,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+// Start 启动后台清理
+func (r *DomainReaper) Start() {
+	go r.run()
+	log.Printf("[Reaper] 过期邮箱自动清理已启动 (间隔: %v)", domainReapInterval)
+}
+
+// Stop 停止后台清理
+func (r *DomainReaper) Stop() {
+	close(r.stop)
+	<-r.done
+}
+
+// run 启动时清理一次，之后按固定间隔清理
+func (r *DomainReaper) run() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(domainReapInterval)
+	defer ticker.Stop()
+
+	for {
+		r.reap()
+		select {
+		case <-ticker.C:
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// reap 删除所有已过期的邮箱
+func (r *DomainReaper) reap() {
+	domains, err := r.storage.GetExpiredMailDomains(time.Now())
+	if err != nil {
+		log.Printf("[Reaper] 查询过期邮箱失败: %v", err)
+		return
+	}
+
+	for _, domain := range domains {
+		if err := r.reapDomain(domain); err != nil {
+			log.Printf("[Reaper] 删除过期邮箱 %s 失败: %v", domain.Email, err)
+		}
+	}
+}
+
+// reapDomain 删除一个过期邮箱：先删邮件，再删DNS记录和域名记录，最后释放用户的邮箱配额
+func (r *DomainReaper) reapDomain(domain *storage.MailDomain) error {
+	deleted, err := r.storage.DeleteMailDomainMails(domain)
+	if err != nil {
+		return err
+	}
+
+	if r.dnsService != nil {
+		err = r.dnsService.DeleteMailDomain(domain.UserID, domain.ID)
+	} else {
+		err = r.storage.DeleteMailDomain(domain.UserID, domain.ID)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := r.storage.DecrementDomainCount(domain.UserID); err != nil {
+		log.Printf("[Reaper] 更新用户邮箱数量失败 (userID: %d): %v", domain.UserID, err)
+	}
+
+	log.Printf("[Reaper] 已删除过期邮箱 %s (userID: %d, 邮件: %d)", domain.Email, domain.UserID, deleted)
+	return nil
+}
@@ -5,8 +5,10 @@ import (
 	"crypto/tls"
 	"fmt"
 	"html/template"
+	mailsmtp "mail-server/smtp"
+	"net"
 	"net/smtp"
-	"strings"
+	"strconv"
 )
 
 // EmailSender 邮件发送服务
@@ -16,6 +18,8 @@ type EmailSender struct {
 	senderEmail string
 	senderName  string
 	password    string
+	dialHost    string                 // 非空时连接该地址而不是 smtpHost
+	signer      mailsmtp.MessageSigner // DKIM签名，为空时不签名
 }
 
 // NewEmailSender 创建邮件发送服务
@@ -29,6 +33,29 @@ func NewEmailSender(smtpHost string, smtpPort int, senderEmail, senderName, pass
 	}
 }
 
+// UseLoopback SMTP服务器就是本机时通过回环地址连接，明文连接上也可以进行认证
+func (e *EmailSender) UseLoopback() {
+	e.dialHost = "127.0.0.1"
+}
+
+// SetSigner 设置发送前使用的DKIM签名
+func (e *EmailSender) SetSigner(signer mailsmtp.MessageSigner) {
+	e.signer = signer
+}
+
+// sign 对邮件进行DKIM签名，失败时返回原邮件
+func (e *EmailSender) sign(message string) string {
+	if e.signer == nil {
+		return message
+	}
+	signed, err := e.signer.Sign(message)
+	if err != nil {
+		fmt.Printf("[EmailSender] DKIM签名失败: %v\n", err)
+		return message
+	}
+	return signed
+}
+
 // SendVerifyCode 发送验证码邮件
 func (e *EmailSender) SendVerifyCode(to, code string) error {
 	subject := "您的邮箱服务验证码"
@@ -58,79 +85,10 @@ func (e *EmailSender) SendTextEmail(to, subject, textBody string) error {
 		message += fmt.Sprintf("%s: %s\r\n", k, v)
 	}
 	message += "\r\n" + textBody
+	message = e.sign(message)
 
-	// 连接SMTP服务器并发送邮件
-	addr := fmt.Sprintf("%s:%d", e.smtpHost, e.smtpPort)
-	fmt.Printf("[EmailSender] 正在发送文本邮件到 %s，使用SMTP服务器: %s\n", to, addr)
-
-	// 创建客户端连接
-	client, err := smtp.Dial(addr)
-	if err != nil {
-		return fmt.Errorf("连接SMTP服务器失败: %v", err)
-	}
-	defer client.Close()
-
-	// 发送HELO
-	if err = client.Hello("localhost"); err != nil {
-		return fmt.Errorf("HELO失败: %v", err)
-	}
-
-	// 对于587端口，尝试启动TLS（但本地服务器可能不支持）
-	if e.smtpPort == 587 {
-		// 检查是否是本地服务器，如果是则跳过TLS
-		isLocal := e.smtpHost == "localhost" ||
-			e.smtpHost == "127.0.0.1" ||
-			strings.HasPrefix(e.smtpHost, "mail.") ||
-			strings.HasSuffix(e.smtpHost, ".local") ||
-			strings.HasSuffix(e.smtpHost, ".lan")
-
-		if !isLocal {
-			tlsConfig := &tls.Config{
-				ServerName:         e.smtpHost,
-				InsecureSkipVerify: true, // 对于自签名证书
-			}
-			if err = client.StartTLS(tlsConfig); err != nil {
-				fmt.Printf("[EmailSender] STARTTLS失败: %v\n", err)
-				return fmt.Errorf("STARTTLS失败: %v", err)
-			}
-			fmt.Printf("[EmailSender] ✓ TLS已启动\n")
-		} else {
-			fmt.Printf("[EmailSender] 检测到本地服务器，跳过TLS\n")
-		}
-	}
-
-	// 如果有密码，进行认证
-	if e.password != "" {
-		auth := smtp.PlainAuth("", e.senderEmail, e.password, e.smtpHost)
-		if err = client.Auth(auth); err != nil {
-			return fmt.Errorf("SMTP认证失败: %v", err)
-		}
-	}
-
-	// 设置发件人
-	if err = client.Mail(e.senderEmail); err != nil {
-		return fmt.Errorf("设置发件人失败: %v", err)
-	}
-
-	// 设置收件人
-	if err = client.Rcpt(to); err != nil {
-		return fmt.Errorf("设置收件人失败: %v", err)
-	}
-
-	// 发送邮件内容
-	wc, err := client.Data()
-	if err != nil {
-		return fmt.Errorf("获取数据写入器失败: %v", err)
-	}
-	defer wc.Close()
-
-	_, err = fmt.Fprintf(wc, "%s", message)
-	if err != nil {
-		return fmt.Errorf("写入邮件内容失败: %v", err)
-	}
-
-	fmt.Printf("[EmailSender] ✓ 邮件发送成功！\n")
-	return nil
+	fmt.Printf("[EmailSender] 正在发送文本邮件到 %s，使用SMTP服务器: %s\n", to, e.addr())
+	return e.deliver(to, message)
 }
 
 // sendHTML 发送HTML邮件
@@ -149,12 +107,24 @@ func (e *EmailSender) sendHTML(to, subject, htmlBody string) error {
 		message += fmt.Sprintf("%s: %s\r\n", k, v)
 	}
 	message += "\r\n" + htmlBody
+	message = e.sign(message)
 
-	// 连接SMTP服务器并发送邮件
-	addr := fmt.Sprintf("%s:%d", e.smtpHost, e.smtpPort)
-	fmt.Printf("[EmailSender] 正在发送邮件到 %s，使用SMTP服务器: %s\n", to, addr)
+	fmt.Printf("[EmailSender] 正在发送邮件到 %s，使用SMTP服务器: %s\n", to, e.addr())
+	return e.deliver(to, message)
+}
 
-	// 创建客户端连接
+// addr 实际连接的SMTP地址
+func (e *EmailSender) addr() string {
+	host := e.smtpHost
+	if e.dialHost != "" {
+		host = e.dialHost
+	}
+	return net.JoinHostPort(host, strconv.Itoa(e.smtpPort))
+}
+
+// deliver 连接SMTP服务器，完成STARTTLS和认证后发送邮件
+func (e *EmailSender) deliver(to, message string) error {
+	addr := e.addr()
 	client, err := smtp.Dial(addr)
 	if err != nil {
 		return fmt.Errorf("连接SMTP服务器失败: %v", err)
@@ -166,33 +136,23 @@ func (e *EmailSender) sendHTML(to, subject, htmlBody string) error {
 		return fmt.Errorf("HELO失败: %v", err)
 	}
 
-	// 对于587端口，尝试启动TLS（但本地服务器可能不支持）
-	if e.smtpPort == 587 {
-		// 检查是否是本地服务器，如果是则跳过TLS
-		isLocal := e.smtpHost == "localhost" ||
-			e.smtpHost == "127.0.0.1" ||
-			strings.HasPrefix(e.smtpHost, "mail.") ||
-			strings.HasSuffix(e.smtpHost, ".local") ||
-			strings.HasSuffix(e.smtpHost, ".lan")
-
-		if !isLocal {
-			tlsConfig := &tls.Config{
-				ServerName:         e.smtpHost,
-				InsecureSkipVerify: true, // 对于自签名证书
-			}
-			if err = client.StartTLS(tlsConfig); err != nil {
-				fmt.Printf("[EmailSender] STARTTLS失败: %v\n", err)
-				return fmt.Errorf("STARTTLS失败: %v", err)
-			}
-			fmt.Printf("[EmailSender] ✓ TLS已启动\n")
-		} else {
-			fmt.Printf("[EmailSender] 检测到本地服务器，跳过TLS\n")
+	// 服务器支持时总是启动TLS，否则 PlainAuth 拒绝在非本机的明文连接上发送密码
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		tlsConfig := &tls.Config{
+			ServerName:         e.smtpHost,
+			InsecureSkipVerify: true, // 对于自签名证书
+		}
+		if err = client.StartTLS(tlsConfig); err != nil {
+			fmt.Printf("[EmailSender] STARTTLS失败: %v\n", err)
+			return fmt.Errorf("STARTTLS失败: %v", err)
 		}
+		fmt.Printf("[EmailSender] ✓ TLS已启动\n")
 	}
 
 	// 如果有密码，进行认证
 	if e.password != "" {
-		auth := smtp.PlainAuth("", e.senderEmail, e.password, e.smtpHost)
+		host, _, _ := net.SplitHostPort(addr)
+		auth := smtp.PlainAuth("", e.senderEmail, e.password, host)
 		if err = client.Auth(auth); err != nil {
 			return fmt.Errorf("SMTP认证失败: %v", err)
 		}
@@ -213,12 +173,14 @@ func (e *EmailSender) sendHTML(to, subject, htmlBody string) error {
 	if err != nil {
 		return fmt.Errorf("获取数据写入器失败: %v", err)
 	}
-	defer wc.Close()
-
-	_, err = fmt.Fprintf(wc, "%s", message)
-	if err != nil {
+	if _, err = fmt.Fprintf(wc, "%s", message); err != nil {
+		wc.Close()
 		return fmt.Errorf("写入邮件内容失败: %v", err)
 	}
+	if err = wc.Close(); err != nil {
+		return fmt.Errorf("邮件提交失败: %v", err)
+	}
+	client.Quit()
 
 	fmt.Printf("[EmailSender] ✓ 邮件发送成功！\n")
 	return nil
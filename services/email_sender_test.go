@@ -0,0 +1,73 @@
+package services
+
+import (
+	mailsmtp "mail-server/smtp"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// submissionAuth 测试用的认证后端，只有系统发件人账户
+type submissionAuth struct {
+	email, password string
+}
+
+func (a *submissionAuth) Authenticate(username, password string) (*mailsmtp.AuthUser, error) {
+	if username != a.email || password != a.password {
+		return nil, nil
+	}
+	return &mailsmtp.AuthUser{ID: 1, Email: a.email}, nil
+}
+
+func (a *submissionAuth) CanSendAs(user *mailsmtp.AuthUser, from string) (bool, error) {
+	return strings.EqualFold(from, user.Email), nil
+}
+
+// collectingHandler 记录投递到本地的邮件
+type collectingHandler struct {
+	mu   sync.Mutex
+	msgs []*mailsmtp.MailMessage
+}
+
+func (h *collectingHandler) HandleMail(msg *mailsmtp.MailMessage) ([]mailsmtp.DeliveryResult, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.msgs = append(h.msgs, msg)
+	results := make([]mailsmtp.DeliveryResult, 0, len(msg.To))
+	for _, rcpt := range msg.To {
+		results = append(results, mailsmtp.DeliveryResult{Recipient: rcpt})
+	}
+	return results, nil
+}
+
+func TestEmailSenderAuthenticatesOverLoopback(t *testing.T) {
+	handler := &collectingHandler{}
+	srv := mailsmtp.NewServer("mail.example.org", 0, handler)
+	srv.Submission = true
+	srv.Auth = &submissionAuth{email: "admin@example.org", password: "secret"}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer listener.Close()
+	go srv.Serve(listener)
+
+	// 默认配置中的主机名是 mail.<域名>，没有TLS时只能通过回环地址认证
+	sender := NewEmailSender("mail.example.org", listener.Addr().(*net.TCPAddr).Port, "admin@example.org", "邮箱服务", "secret")
+	sender.UseLoopback()
+
+	if err := sender.SendTextEmail("user@example.org", "验证码", "123456"); err != nil {
+		t.Fatalf("SendTextEmail: %v", err)
+	}
+
+	handler.mu.Lock()
+	defer handler.mu.Unlock()
+	if len(handler.msgs) != 1 {
+		t.Fatalf("delivered %d messages, want 1", len(handler.msgs))
+	}
+	if msg := handler.msgs[0]; msg.From != "admin@example.org" || len(msg.To) != 1 || msg.To[0] != "user@example.org" {
+		t.Errorf("envelope = %s -> %v", msg.From, msg.To)
+	}
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"mail-server/storage"
+	"strings"
+	"sync"
+	"time"
+)
+
+// subscriberBuffer 每个订阅者的事件缓冲，写满时断开订阅，客户端通过 Last-Event-ID 重连补齐
+const subscriberBuffer = 64
+
+// MailEvent 新邮件通知，ID 即邮件ID（单调递增，可用于断线续传）
+type MailEvent struct {
+	ID         int64           `json:"id"`
+	Type       string          `json:"type"` // 目前只有 mail
+	UserID     int64           `json:"-"`
+	From       string          `json:"from"`
+	HeaderFrom storage.Address `json:"header_from"`
+	To         []string        `json:"to"`
+	Subject    string          `json:"subject"`
+	ReceivedAt time.Time       `json:"received_at"`
+}
+
+// NewMailEvent 根据邮件记录生成通知
+func NewMailEvent(userID int64, mail *storage.Mail) *MailEvent {
+	return &MailEvent{
+		ID:         mail.ID,
+		Type:       "mail",
+		UserID:     userID,
+		From:       mail.From,
+		HeaderFrom: mail.HeaderFrom,
+		To:         mail.Recipients(),
+		Subject:    mail.Subject,
+		ReceivedAt: mail.ReceivedAt,
+	}
+}
+
+// matches 判断事件是否属于订阅的用户和地址
+func (e *MailEvent) matches(userID int64, address string) bool {
+	if e.UserID != userID {
+		return false
+	}
+	if address == "" {
+		return true
+	}
+	for _, to := range e.To {
+		// 订阅 user@domain 时也接收发到 user+tag@domain 的邮件
+		base, _ := storage.SplitAddressTag(to)
+		if strings.EqualFold(to, address) || strings.EqualFold(base, address) {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscription 一个事件订阅，C 被关闭表示订阅已结束（取消或处理过慢）
+type Subscription struct {
+	C       <-chan *MailEvent
+	ch      chan *MailEvent
+	userID  int64
+	address string
+}
+
+// EventBus 进程内事件总线，收到新邮件时通知在线的客户端
+type EventBus struct {
+	mu          sync.Mutex
+	subscribers map[*Subscription]struct{}
+}
+
+// NewEventBus 创建事件总线
+func NewEventBus() *EventBus {
+	return &EventBus{subscribers: make(map[*Subscription]struct{})}
+}
+
+// Subscribe 订阅用户的新邮件，address 不为空时只接收发到该地址的邮件
+func (b *EventBus) Subscribe(userID int64, address string) *Subscription {
+	ch := make(chan *MailEvent, subscriberBuffer)
+	sub := &Subscription{C: ch, ch: ch, userID: userID, address: strings.ToLower(address)}
+
+	b.mu.Lock()
+	b.subscribers[sub] = struct{}{}
+	b.mu.Unlock()
+	return sub
+}
+
+// Unsubscribe 取消订阅，可重复调用
+func (b *EventBus) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subscribers[sub]; ok {
+		delete(b.subscribers, sub)
+		close(sub.ch)
+	}
+}
+
+// Publish 发布事件，不阻塞：缓冲已满的订阅者会被断开
+func (b *EventBus) Publish(event *MailEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subscribers {
+		if !event.matches(sub.userID, sub.address) {
+			continue
+		}
+		select {
+		case sub.ch <- event:
+		default:
+			delete(b.subscribers, sub)
+			close(sub.ch)
+		}
+	}
+}
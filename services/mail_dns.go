@@ -1,45 +1,67 @@
 package services
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"mail-server/smtp"
 	"mail-server/storage"
+	"regexp"
+	"slices"
 	"strings"
-
-	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
-	dnspod "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/dnspod/v20210323"
+	"sync"
+	"time"
 )
 
+// legacyMTASTSPattern 早期版本发布的 _mta-sts 记录，策略只能通过HTTP获取，对账时删除
+var legacyMTASTSPattern = regexp.MustCompile(`^v=STSv1; id=[0-9a-f]{16}$`)
+
+// MailRecordConfig 创建邮箱子域名时和MX记录一起发布的记录
+type MailRecordConfig struct {
+	PublicIP  string // SPF记录中的 ip4，为空时只使用 mx 机制
+	DMARCRua  string // DMARC汇总报告地址，为空时不设置 rua
+	TLSRPTRua string // TLS-RPT报告地址，为空时不发布TLS-RPT记录
+}
+
 // MailDNSService 邮箱DNS管理服务
 type MailDNSService struct {
-	dnsService *DNSPodService
-	storage    storage.Storage
+	provider DNSProvider // 为空时不管理DNS，只生成虚拟域名
+	storage  storage.Storage
+	checker  *DNSChecker
+	records  MailRecordConfig
+	orphans  map[string]time.Time // 服务商不提供修改时间时，记录对账中第一次发现孤立记录的时间
+	mu       sync.Mutex           // 创建、删除邮箱和对账互斥，对账看到的DNS记录和数据库一致
 }
 
-// NewMailDNSService 创建邮箱DNS服务
-func NewMailDNSService(domain, publicIP, secretId, secretKey string, storage storage.Storage) (*MailDNSService, error) {
-	// 如果关键配置为空，创建一个简化的DNS服务（不提供DNS管理功能）
-	if domain == "" || publicIP == "" || secretId == "" || secretKey == "" {
+// NewMailDNSService 创建邮箱DNS服务，provider 为空时创建一个简化的DNS服务（不提供DNS管理功能）
+// resolver 用于检查已发布的记录
+func NewMailDNSService(provider DNSProvider, storage storage.Storage, resolver smtp.Resolver, records MailRecordConfig) *MailDNSService {
+	if provider == nil {
 		log.Printf("Warning: DNS configuration incomplete, creating simplified DNS service")
-		return &MailDNSService{
-			dnsService: nil, // 不提供DNS管理功能
-			storage:    storage,
-		}, nil
 	}
 
-	dnsService, err := NewDNSPodServiceWithCredentials(domain, publicIP, secretId, secretKey)
-	if err != nil {
-		return nil, err
+	m := &MailDNSService{
+		provider: provider,
+		storage:  storage,
+		records:  records,
+		orphans:  make(map[string]time.Time),
+	}
+	if provider != nil {
+		m.checker = NewDNSChecker(resolver, m.mailExchange(), records.PublicIP)
 	}
+	return m
+}
 
-	return &MailDNSService{
-		dnsService: dnsService,
-		storage:    storage,
-	}, nil
+// ManagesDNS 是否配置了DNS服务商，未配置时只生成虚拟域名
+func (m *MailDNSService) ManagesDNS() bool {
+	return m.provider != nil
 }
 
-// CreateMailDomain 为邮箱创建域名解析
-func (m *MailDNSService) CreateMailDomain(userID int64, email string) (*storage.MailDomain, error) {
+// CreateMailDomain 为邮箱创建域名解析，expiresAt 为空表示永久
+func (m *MailDNSService) CreateMailDomain(userID int64, email string, expiresAt *time.Time) (*storage.MailDomain, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	// 检查邮箱是否已经存在域名
 	existing, err := m.storage.GetMailDomainByEmail(email)
 	if err != nil {
@@ -49,9 +71,10 @@ func (m *MailDNSService) CreateMailDomain(userID int64, email string) (*storage.
 		return existing, nil
 	}
 
-	var subdomain, fullDomain string
+	var subdomain, fullDomain, recordID string
+	var extraRecordIDs []string
 
-	if m.dnsService == nil {
+	if m.provider == nil {
 		// DNS服务不可用时，生成一个虚拟的子域名
 		log.Printf("DNS service not available, creating virtual domain for email: %s", email)
 		// 使用邮箱前缀作为子域名
@@ -63,94 +86,400 @@ func (m *MailDNSService) CreateMailDomain(userID int64, email string) (*storage.
 		}
 		// 使用默认域名
 		fullDomain = fmt.Sprintf("%s.mail.example.com", subdomain)
+		recordID = subdomain
 	} else {
 		// 生成子域名
-		subdomain, err = m.dnsService.GenerateSubdomain()
+		subdomain, err = m.generateSubdomain()
 		if err != nil {
 			return nil, fmt.Errorf("生成子域名失败: %v", err)
 		}
 
-		// 创建MX记录和A记录
-		err = m.createMailRecords(subdomain, email)
+		// 创建DNS记录，保存服务商返回的记录ID用于删除
+		fullDomain = fmt.Sprintf("%s.%s", subdomain, m.provider.Zone())
+		recordID, extraRecordIDs, err = m.createMailRecords(subdomain, fullDomain)
 		if err != nil {
 			return nil, fmt.Errorf("创建DNS记录失败: %v", err)
 		}
-
-		fullDomain = fmt.Sprintf("%s.%s", subdomain, m.dnsService.domain)
 	}
 
 	// 保存到数据库
-	err = m.storage.CreateMailDomain(userID, subdomain, fullDomain, subdomain, email)
+	err = m.storage.CreateMailDomain(userID, subdomain, fullDomain, recordID, extraRecordIDs, email, expiresAt)
 	if err != nil {
-		// 如果保存失败，尝试清理DNS记录
-		if m.dnsService != nil {
-			m.deleteMailRecords(subdomain)
+		// 如果保存失败，清理刚创建的DNS记录
+		if m.provider != nil {
+			if derr := m.deleteMailRecords(subdomain, recordID, extraRecordIDs); derr != nil {
+				log.Printf("清理子域名 %s 的DNS记录失败: %v", subdomain, derr)
+			}
 		}
 		return nil, fmt.Errorf("保存邮箱域名失败: %v", err)
 	}
 
 	domain := &storage.MailDomain{
-		Subdomain:  subdomain,
-		FullDomain: fullDomain,
-		RecordID:   subdomain,
-		Email:      email,
+		Subdomain:      subdomain,
+		FullDomain:     fullDomain,
+		RecordID:       recordID,
+		ExtraRecordIDs: extraRecordIDs,
+		Email:          email,
+		ExpiresAt:      expiresAt,
 	}
 
 	log.Printf("邮箱域名创建成功: %s -> %s", email, fullDomain)
 	return domain, nil
 }
 
-// createMailRecords 创建邮箱相关的DNS记录
-func (m *MailDNSService) createMailRecords(subdomain, email string) error {
+// createMailRecords 创建邮箱子域名的DNS记录，返回MX记录ID和其他记录的ID
+// MX记录创建失败时返回错误，其他记录创建失败只记录日志，可以通过 CheckRecords 查看
+func (m *MailDNSService) createMailRecords(subdomain, fullDomain string) (string, []string, error) {
 	// 创建MX记录指向主域名的mail子域名
-	err := m.createMXRecord(subdomain)
+	recordID, err := m.createMXRecord(subdomain)
 	if err != nil {
-		return fmt.Errorf("创建MX记录失败: %v", err)
+		return "", nil, fmt.Errorf("创建MX记录失败: %v", err)
 	}
+	log.Printf("为子域名 %s 创建MX记录成功 (RecordID: %s)", subdomain, recordID)
 
-	log.Printf("为子域名 %s 创建MX记录成功", subdomain)
-	return nil
+	extraRecordIDs := []string{}
+	for _, record := range m.requiredRecords(fullDomain) {
+		if record.Type == "MX" {
+			continue
+		}
+		id, err := m.createRecord(record)
+		if err != nil {
+			log.Printf("Warning: 为子域名 %s 创建%s记录失败: %v", subdomain, strings.ToUpper(record.Purpose), err)
+			continue
+		}
+		extraRecordIDs = append(extraRecordIDs, id)
+	}
+	return recordID, extraRecordIDs, nil
+}
+
+// createRecord 在服务商创建 requiredRecords 中的一条记录，返回记录ID
+func (m *MailDNSService) createRecord(record *storage.DNSCheck) (string, error) {
+	name, _ := relativeName(m.provider.Zone(), record.Name)
+	return m.provider.CreateRecord(&ZoneRecord{
+		Name:  name,
+		Type:  record.Type,
+		Value: record.Value,
+		TTL:   defaultRecordTTL,
+	})
+}
+
+// requiredRecords 邮箱子域名需要的DNS记录，名称为完整域名
+func (m *MailDNSService) requiredRecords(fullDomain string) []*storage.DNSCheck {
+	spf := "v=spf1 mx ~all"
+	if m.records.PublicIP != "" {
+		spf = fmt.Sprintf("v=spf1 mx ip4:%s ~all", m.records.PublicIP)
+	}
+	dmarc := "v=DMARC1; p=none"
+	if m.records.DMARCRua != "" {
+		dmarc += "; rua=" + reportURI(m.records.DMARCRua)
+	}
+
+	// 不发布MTA-STS：策略必须通过证书覆盖 mta-sts.<子域名> 的HTTPS获取，服务无法为随机子域名提供
+	records := []*storage.DNSCheck{
+		{Purpose: "mx", Type: "MX", Name: fullDomain, Value: m.mailExchange(), Priority: defaultMXPriority},
+		{Purpose: "spf", Type: "TXT", Name: fullDomain, Value: spf},
+		{Purpose: "dmarc", Type: "TXT", Name: "_dmarc." + fullDomain, Value: dmarc},
+	}
+	if m.records.TLSRPTRua != "" {
+		records = append(records, &storage.DNSCheck{Purpose: "tls-rpt", Type: "TXT", Name: "_smtp._tls." + fullDomain, Value: "v=TLSRPTv1; rua=" + reportURI(m.records.TLSRPTRua)})
+	}
+
+	for _, record := range records {
+		record.Status = storage.DNSCheckUnchecked
+		record.Found = []string{}
+	}
+	return records
+}
+
+// CheckRecords 查询邮箱子域名实际发布的记录，与需要的记录比较
+func (m *MailDNSService) CheckRecords(ctx context.Context, domain *storage.MailDomain) ([]*storage.DNSCheck, error) {
+	if m.provider == nil {
+		return nil, fmt.Errorf("DNS服务不可用")
+	}
+
+	checks := m.requiredRecords(domain.FullDomain)
+	for _, check := range checks {
+		m.checker.Check(ctx, check)
+	}
+	return checks, nil
+}
+
+// reportURI 报告地址没有协议时按邮箱地址处理
+func reportURI(addr string) string {
+	if strings.Contains(addr, ":") {
+		return addr
+	}
+	return "mailto:" + addr
+}
+
+// mailExchange 邮箱MX记录指向的主机
+func (m *MailDNSService) mailExchange() string {
+	return fmt.Sprintf("mail.%s", m.provider.Zone())
 }
 
-// createMXRecord 创建MX记录
-func (m *MailDNSService) createMXRecord(subdomain string) error {
-	// 使用DNSPod API创建MX记录
+// generateSubdomain 生成数据库中不存在的随机子域名
+func (m *MailDNSService) generateSubdomain() (string, error) {
+	for attempts := 0; attempts < 100; attempts++ {
+		subdomain, err := randomLabel(8)
+		if err != nil {
+			return "", err
+		}
+
+		existing, err := m.storage.GetMailDomainsByDomain(fmt.Sprintf("%s.%s", subdomain, m.provider.Zone()))
+		if err != nil {
+			return "", err
+		}
+		if len(existing) == 0 {
+			return subdomain, nil
+		}
+	}
+
+	return "", fmt.Errorf("生成唯一子域名失败，尝试次数过多")
+}
+
+// createMXRecord 创建MX记录，返回记录ID
+func (m *MailDNSService) createMXRecord(subdomain string) (string, error) {
 	// MX记录指向 mail.主域名
-	request := dnspod.NewCreateRecordRequest()
-	request.Domain = common.StringPtr(m.dnsService.domain)
-	request.RecordType = common.StringPtr("MX")
-	request.RecordLine = common.StringPtr("默认")
-	// MX记录的Value只需要域名，不需要优先级和点号
-	request.Value = common.StringPtr(fmt.Sprintf("mail.%s", m.dnsService.domain))
-	request.SubDomain = common.StringPtr(subdomain)
-	request.TTL = common.Uint64Ptr(600)
-	request.Status = common.StringPtr("ENABLE")
-	// 优先级单独设置在MX字段
-	request.MX = common.Uint64Ptr(10)
-
-	_, err := m.dnsService.client.CreateRecord(request)
-	return err
-}
-
-// deleteMailRecords 删除邮箱相关的DNS记录
-func (m *MailDNSService) deleteMailRecords(subdomain string) error {
-	// 这里需要实现删除逻辑，暂时简化
-	log.Printf("尝试删除子域名 %s 的DNS记录", subdomain)
+	return m.provider.CreateRecord(&ZoneRecord{
+		Name:     subdomain,
+		Type:     "MX",
+		Value:    m.mailExchange(),
+		TTL:      defaultRecordTTL,
+		Priority: defaultMXPriority,
+	})
+}
+
+// deleteMailRecords 删除邮箱相关的DNS记录，其他记录删除失败只记录日志
+// 早期版本的 record_id 保存的是子域名而不是记录ID，这种情况按子域名查出MX记录再删除
+func (m *MailDNSService) deleteMailRecords(subdomain, recordID string, extraRecordIDs []string) error {
+	for _, id := range extraRecordIDs {
+		if err := m.provider.DeleteRecord(id); err != nil {
+			log.Printf("删除子域名 %s 的DNS记录失败 (RecordID: %s): %v", subdomain, id, err)
+		}
+	}
+
+	if recordID != "" && recordID != subdomain {
+		return m.provider.DeleteRecord(recordID)
+	}
+
+	records, err := m.provider.GetRecords(subdomain, "MX")
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		if !strings.EqualFold(record.Value, m.mailExchange()) {
+			continue
+		}
+		if err := m.provider.DeleteRecord(record.RecordID); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// DeleteMailDomain 删除邮箱域名
+// DeleteMailDomain 删除邮箱域名及其DNS记录
+// DNS记录删除失败时仍然删除数据库记录，残留的记录由 DNSReconciler 清理
 func (m *MailDNSService) DeleteMailDomain(userID int64, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	domain, err := m.storage.GetMailDomain(userID, id)
+	if err != nil {
+		return fmt.Errorf("查询邮箱域名失败: %v", err)
+	}
+
+	if domain != nil && m.provider != nil {
+		if err := m.deleteMailRecords(domain.Subdomain, domain.RecordID, domain.ExtraRecordIDs); err != nil {
+			log.Printf("删除子域名 %s 的DNS记录失败: %v", domain.Subdomain, err)
+		}
+	}
+
 	// 从数据库删除
-	err := m.storage.DeleteMailDomain(userID, id)
+	err = m.storage.DeleteMailDomain(userID, id)
 	if err != nil {
 		return fmt.Errorf("删除邮箱域名失败: %v", err)
 	}
-
-	// TODO: 删除对应的DNS记录
 	return nil
 }
 
+// ReconcileRecords 对比服务商的记录和数据库中的邮箱域名，返回删除和补发的记录数量
+//   - 删除没有对应邮箱的子域名记录（MX、SPF、DMARC、TLS-RPT），minAge 内更新过的记录跳过；
+//     服务商不提供修改时间时，记录需要在间隔 minAge 以上的两次对账中都是孤立的才会删除
+//   - 删除早期版本发布的MTA-STS记录，以及本服务创建、但与当前配置（如 dmarc_rua）不一致的记录
+//   - 为已有邮箱补发缺少的SPF、DMARC、TLS-RPT记录（早期版本只创建了MX记录）
+//
+// 只处理单级子域名下的记录；没有对应邮箱的记录与生成的内容一致才删除，
+// 有对应邮箱的记录ID保存在邮箱记录中才会替换，手动添加或修改的记录不受影响
+func (m *MailDNSService) ReconcileRecords(minAge time.Duration) (int, int, error) {
+	if m.provider == nil {
+		return 0, 0, nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	records, err := m.provider.GetRecords("", "")
+	if err != nil {
+		return 0, 0, err
+	}
+
+	domains, err := m.storage.GetAllMailDomains()
+	if err != nil {
+		return 0, 0, err
+	}
+	known := make(map[string]*storage.MailDomain, len(domains))
+	for _, d := range domains {
+		known[strings.ToLower(d.Subdomain)] = d
+	}
+
+	now := time.Now()
+	orphans := make(map[string]time.Time)
+	present := make(map[string]bool) // 子域名|用途
+	removed := make(map[string]bool) // 已删除的记录ID
+	changed := make(map[*storage.MailDomain]bool)
+	deleted := 0
+	for _, record := range records {
+		label, purpose, current := m.classifyRecord(record)
+		if label == "" {
+			continue
+		}
+		domain := known[label]
+		legacy := purpose == "mta-sts" || purpose == "mta-sts-host"
+
+		switch {
+		case legacy:
+		case domain != nil:
+			if current || !slices.Contains(domain.ExtraRecordIDs, record.RecordID) {
+				present[label+"|"+purpose] = true
+				continue
+			}
+			// 本服务创建、内容已过期的记录，删除后按当前配置补发
+		case !current:
+			continue
+		default:
+			updatedOn := record.UpdatedOn
+			if updatedOn.IsZero() {
+				firstSeen, ok := m.orphans[record.RecordID]
+				if !ok {
+					firstSeen = now
+				}
+				orphans[record.RecordID] = firstSeen
+				updatedOn = firstSeen
+			}
+			if now.Sub(updatedOn) < minAge {
+				continue
+			}
+		}
+
+		if err := m.provider.DeleteRecord(record.RecordID); err != nil {
+			log.Printf("删除%s记录 %s.%s 失败: %v", strings.ToUpper(purpose), record.Name, m.provider.Zone(), err)
+			continue
+		}
+		log.Printf("已删除%s记录 %s.%s (RecordID: %s)", strings.ToUpper(purpose), record.Name, m.provider.Zone(), record.RecordID)
+		delete(orphans, record.RecordID)
+		removed[record.RecordID] = true
+		if domain != nil {
+			changed[domain] = true
+		}
+		deleted++
+	}
+	m.orphans = orphans
+
+	created := 0
+	for _, domain := range domains {
+		label := strings.ToLower(domain.Subdomain)
+		for _, record := range m.requiredRecords(domain.FullDomain) {
+			if record.Type == "MX" || present[label+"|"+record.Purpose] {
+				continue
+			}
+			id, err := m.createRecord(record)
+			if err != nil {
+				log.Printf("为子域名 %s 补发%s记录失败: %v", domain.Subdomain, strings.ToUpper(record.Purpose), err)
+				continue
+			}
+			log.Printf("已为子域名 %s 补发%s记录 (RecordID: %s)", domain.Subdomain, strings.ToUpper(record.Purpose), id)
+			domain.ExtraRecordIDs = append(domain.ExtraRecordIDs, id)
+			changed[domain] = true
+			created++
+		}
+	}
+
+	for domain := range changed {
+		ids := []string{}
+		for _, id := range domain.ExtraRecordIDs {
+			if !removed[id] {
+				ids = append(ids, id)
+			}
+		}
+		if err := m.storage.SetMailDomainExtraRecords(domain.ID, ids); err != nil {
+			log.Printf("保存子域名 %s 的记录ID失败: %v", domain.Subdomain, err)
+		}
+	}
+	return deleted, created, nil
+}
+
+// classifyRecord 按名称和类型判断记录是否属于某个邮箱子域名，返回子域名、用途和内容是否与当前配置一致
+// 不属于邮箱子域名时返回空字符串
+func (m *MailDNSService) classifyRecord(record *ZoneRecord) (string, string, bool) {
+	name := strings.ToLower(record.Name)
+	value := strings.TrimSpace(record.Value)
+
+	var label, purpose string
+	switch record.Type {
+	case "MX":
+		if !strings.EqualFold(value, m.mailExchange()) {
+			return "", "", false
+		}
+		label, purpose = name, "mx"
+	case "A":
+		// 早期版本为MTA-STS策略主机发布的记录
+		var ok bool
+		label, ok = strings.CutPrefix(name, "mta-sts.")
+		if !ok || m.records.PublicIP == "" || value != m.records.PublicIP {
+			return "", "", false
+		}
+		purpose = "mta-sts-host"
+	case "TXT":
+		var ok bool
+		if label, ok = strings.CutPrefix(name, "_mta-sts."); ok {
+			if !legacyMTASTSPattern.MatchString(value) {
+				return "", "", false
+			}
+			purpose = "mta-sts"
+		} else if label, ok = strings.CutPrefix(name, "_dmarc."); ok {
+			purpose = "dmarc"
+		} else if label, ok = strings.CutPrefix(name, "_smtp._tls."); ok {
+			purpose = "tls-rpt"
+		} else {
+			label, purpose = name, "spf"
+		}
+		if version, ok := txtVersions[purpose]; ok && len(filterPrefix([]string{value}, version)) == 0 {
+			return "", "", false
+		}
+	default:
+		return "", "", false
+	}
+
+	label = mailLabel(label)
+	if label == "" {
+		return "", "", false
+	}
+	for _, required := range m.requiredRecords(absoluteName(m.provider.Zone(), label)) {
+		if required.Purpose == purpose && strings.EqualFold(required.Value, value) {
+			return label, purpose, true
+		}
+	}
+	return label, purpose, false
+}
+
+// mailLabel 邮箱子域名都是主域名下的单级子域名，主域名本身和 mail 主机除外，不是时返回空字符串
+func mailLabel(label string) string {
+	if label == "" || label == "@" || label == "mail" || strings.Contains(label, ".") {
+		return ""
+	}
+	return label
+}
+
 // GetMailDomains 获取所有邮箱域名
 func (m *MailDNSService) GetMailDomains(userID int64) ([]*storage.MailDomain, error) {
 	return m.storage.GetMailDomains(userID)
@@ -0,0 +1,246 @@
+package services
+
+import (
+	"mail-server/storage"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestStorage 在临时目录中创建数据库
+func newTestStorage(t *testing.T) *storage.SQLiteStorage {
+	t.Helper()
+	store, err := storage.NewSQLiteStorage(filepath.Join(t.TempDir(), "mails.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteStorage: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// newTestMailDNS 创建使用内存DNS服务商的邮箱DNS服务
+func newTestMailDNS(t *testing.T, records MailRecordConfig) (*MailDNSService, *memoryDNSProvider, *storage.SQLiteStorage) {
+	t.Helper()
+	store := newTestStorage(t)
+	provider := newMemoryDNSProvider("example.com")
+	return NewMailDNSService(provider, store, nil, records), provider, store
+}
+
+// recordNames 列出服务商中的记录，格式为 类型 名称
+func recordNames(t *testing.T, provider *memoryDNSProvider) []string {
+	t.Helper()
+	records, err := provider.GetRecords("", "")
+	if err != nil {
+		t.Fatalf("GetRecords: %v", err)
+	}
+	names := make([]string, 0, len(records))
+	for _, r := range records {
+		names = append(names, r.Type+" "+r.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestCreateMailDomainPublishesRecords(t *testing.T) {
+	m, provider, _ := newTestMailDNS(t, MailRecordConfig{PublicIP: "192.0.2.10", DMARCRua: "dmarc@example.com", TLSRPTRua: "tls@example.com"})
+
+	domain, err := m.CreateMailDomain(1, "user@example.org", nil)
+	if err != nil {
+		t.Fatalf("CreateMailDomain: %v", err)
+	}
+	if domain.FullDomain != domain.Subdomain+".example.com" {
+		t.Errorf("full domain = %q", domain.FullDomain)
+	}
+
+	sub := domain.Subdomain
+	want := []string{"MX " + sub, "TXT " + sub, "TXT _dmarc." + sub, "TXT _smtp._tls." + sub}
+	sort.Strings(want)
+	if got := recordNames(t, provider); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("records = %v, want %v", got, want)
+	}
+	if len(domain.ExtraRecordIDs) != 3 {
+		t.Errorf("extra record IDs = %v, want 3", domain.ExtraRecordIDs)
+	}
+
+	mx, _ := provider.GetRecords(sub, "MX")
+	if len(mx) != 1 || mx[0].Value != "mail.example.com" || mx[0].RecordID != domain.RecordID {
+		t.Errorf("MX record = %+v", mx)
+	}
+	spf, _ := provider.GetRecords(sub, "TXT")
+	if len(spf) != 1 || spf[0].Value != "v=spf1 mx ip4:192.0.2.10 ~all" {
+		t.Errorf("SPF record = %+v", spf)
+	}
+
+	// 同一邮箱再次创建返回已有的域名
+	again, err := m.CreateMailDomain(1, "user@example.org", nil)
+	if err != nil || again.FullDomain != domain.FullDomain {
+		t.Errorf("second CreateMailDomain = %v, %v", again, err)
+	}
+}
+
+func TestDeleteMailDomainRemovesRecords(t *testing.T) {
+	m, provider, store := newTestMailDNS(t, MailRecordConfig{PublicIP: "192.0.2.10"})
+
+	if _, err := m.CreateMailDomain(1, "user@example.org", nil); err != nil {
+		t.Fatalf("CreateMailDomain: %v", err)
+	}
+	domain, err := store.GetMailDomainByEmail("user@example.org")
+	if err != nil || domain == nil {
+		t.Fatalf("GetMailDomainByEmail: %v, %v", domain, err)
+	}
+
+	if err := m.DeleteMailDomain(1, domain.ID); err != nil {
+		t.Fatalf("DeleteMailDomain: %v", err)
+	}
+	if got := recordNames(t, provider); len(got) != 0 {
+		t.Errorf("records left after delete: %v", got)
+	}
+}
+
+func TestReconcileRecordsDeletesOrphans(t *testing.T) {
+	m, provider, _ := newTestMailDNS(t, MailRecordConfig{PublicIP: "192.0.2.10"})
+
+	domain, err := m.CreateMailDomain(1, "user@example.org", nil)
+	if err != nil {
+		t.Fatalf("CreateMailDomain: %v", err)
+	}
+	// 已删除邮箱残留的记录
+	provider.CreateRecord(&ZoneRecord{Name: "orphan", Type: "MX", Value: "mail.example.com", Priority: defaultMXPriority})
+	provider.CreateRecord(&ZoneRecord{Name: "orphan", Type: "TXT", Value: "v=spf1 mx ip4:192.0.2.10 ~all"})
+	provider.CreateRecord(&ZoneRecord{Name: "_dmarc.orphan", Type: "TXT", Value: "v=DMARC1; p=none"})
+	// 不由本服务管理的记录
+	provider.CreateRecord(&ZoneRecord{Name: "other", Type: "MX", Value: "mx.elsewhere.net", Priority: 10})
+	provider.CreateRecord(&ZoneRecord{Name: "mail", Type: "MX", Value: "mail.example.com", Priority: 10})
+	provider.CreateRecord(&ZoneRecord{Name: "www", Type: "TXT", Value: "v=spf1 include:_spf.elsewhere.net ~all"})
+	provider.CreateRecord(&ZoneRecord{Name: "_dmarc.www", Type: "TXT", Value: "v=DMARC1; p=reject"})
+
+	// 刚更新过的记录不删除
+	deleted, created, err := m.ReconcileRecords(time.Hour)
+	if err != nil || deleted != 0 || created != 0 {
+		t.Fatalf("ReconcileRecords(1h) = %d, %d, %v; want 0, 0", deleted, created, err)
+	}
+
+	deleted, created, err = m.ReconcileRecords(0)
+	if err != nil || deleted != 3 || created != 0 {
+		t.Fatalf("ReconcileRecords(0) = %d, %d, %v; want 3, 0", deleted, created, err)
+	}
+	sub := domain.Subdomain
+	want := []string{"MX " + sub, "MX mail", "MX other", "TXT " + sub, "TXT _dmarc." + sub, "TXT _dmarc.www", "TXT www"}
+	sort.Strings(want)
+	if got := recordNames(t, provider); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("records = %v, want %v", got, want)
+	}
+}
+
+func TestReconcileRecordsBackfillsMissingRecords(t *testing.T) {
+	m, provider, store := newTestMailDNS(t, MailRecordConfig{TLSRPTRua: "tls@example.com"})
+
+	// 早期版本只创建了MX记录
+	mxID, err := provider.CreateRecord(&ZoneRecord{Name: "legacy01", Type: "MX", Value: "mail.example.com", Priority: defaultMXPriority})
+	if err != nil {
+		t.Fatalf("CreateRecord: %v", err)
+	}
+	if err := store.CreateMailDomain(1, "legacy01", "legacy01.example.com", mxID, nil, "old@example.org", nil); err != nil {
+		t.Fatalf("CreateMailDomain: %v", err)
+	}
+
+	deleted, created, err := m.ReconcileRecords(time.Hour)
+	if err != nil || deleted != 0 || created != 3 {
+		t.Fatalf("ReconcileRecords = %d, %d, %v; want 0, 3", deleted, created, err)
+	}
+	want := []string{"MX legacy01", "TXT _dmarc.legacy01", "TXT _smtp._tls.legacy01", "TXT legacy01"}
+	if got := recordNames(t, provider); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("records = %v, want %v", got, want)
+	}
+
+	domain, err := store.GetMailDomainByEmail("old@example.org")
+	if err != nil || domain == nil {
+		t.Fatalf("GetMailDomainByEmail: %v, %v", domain, err)
+	}
+	if len(domain.ExtraRecordIDs) != 3 {
+		t.Errorf("extra record IDs = %v, want 3", domain.ExtraRecordIDs)
+	}
+
+	// 补发的记录随邮箱一起删除
+	if err := m.DeleteMailDomain(1, domain.ID); err != nil {
+		t.Fatalf("DeleteMailDomain: %v", err)
+	}
+	if got := recordNames(t, provider); len(got) != 0 {
+		t.Errorf("records left after delete: %v", got)
+	}
+
+	// 再次对账没有变化
+	if deleted, created, err := m.ReconcileRecords(0); err != nil || deleted != 0 || created != 0 {
+		t.Errorf("second ReconcileRecords = %d, %d, %v; want 0, 0", deleted, created, err)
+	}
+}
+
+func TestReconcileRecordsRemovesLegacyMTASTS(t *testing.T) {
+	m, provider, store := newTestMailDNS(t, MailRecordConfig{PublicIP: "192.0.2.10"})
+
+	if _, err := m.CreateMailDomain(1, "user@example.org", nil); err != nil {
+		t.Fatalf("CreateMailDomain: %v", err)
+	}
+	domain, _ := store.GetMailDomainByEmail("user@example.org")
+	sub := domain.Subdomain
+
+	// 早期版本为邮箱发布的MTA-STS记录
+	stsID, _ := provider.CreateRecord(&ZoneRecord{Name: "_mta-sts." + sub, Type: "TXT", Value: "v=STSv1; id=0123456789abcdef"})
+	hostID, _ := provider.CreateRecord(&ZoneRecord{Name: "mta-sts." + sub, Type: "A", Value: "192.0.2.10"})
+	if err := store.SetMailDomainExtraRecords(domain.ID, append(domain.ExtraRecordIDs, stsID, hostID)); err != nil {
+		t.Fatalf("SetMailDomainExtraRecords: %v", err)
+	}
+	// 手动配置的MTA-STS不受影响
+	provider.CreateRecord(&ZoneRecord{Name: "_mta-sts.www", Type: "TXT", Value: "v=STSv1; id=20240101"})
+
+	deleted, created, err := m.ReconcileRecords(time.Hour)
+	if err != nil || deleted != 2 || created != 0 {
+		t.Fatalf("ReconcileRecords = %d, %d, %v; want 2, 0", deleted, created, err)
+	}
+	want := []string{"MX " + sub, "TXT " + sub, "TXT _dmarc." + sub, "TXT _mta-sts.www"}
+	sort.Strings(want)
+	if got := recordNames(t, provider); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("records = %v, want %v", got, want)
+	}
+
+	domain, _ = store.GetMailDomainByEmail("user@example.org")
+	for _, id := range domain.ExtraRecordIDs {
+		if id == stsID || id == hostID {
+			t.Errorf("deleted record %s still in extra record IDs %v", id, domain.ExtraRecordIDs)
+		}
+	}
+}
+
+func TestReconcileRecordsReplacesStaleRecords(t *testing.T) {
+	old, provider, store := newTestMailDNS(t, MailRecordConfig{DMARCRua: "old@example.com"})
+
+	if _, err := old.CreateMailDomain(1, "user@example.org", nil); err != nil {
+		t.Fatalf("CreateMailDomain: %v", err)
+	}
+	domain, _ := store.GetMailDomainByEmail("user@example.org")
+
+	// 用户自己修改过DMARC的邮箱（记录ID不在邮箱记录中）
+	mxID, _ := provider.CreateRecord(&ZoneRecord{Name: "manual01", Type: "MX", Value: "mail.example.com", Priority: defaultMXPriority})
+	if err := store.CreateMailDomain(1, "manual01", "manual01.example.com", mxID, nil, "manual@example.org", nil); err != nil {
+		t.Fatalf("CreateMailDomain: %v", err)
+	}
+	provider.CreateRecord(&ZoneRecord{Name: "_dmarc.manual01", Type: "TXT", Value: "v=DMARC1; p=reject"})
+
+	// 修改 dmarc_rua 后重启
+	m := NewMailDNSService(provider, store, nil, MailRecordConfig{DMARCRua: "new@example.com"})
+	deleted, created, err := m.ReconcileRecords(time.Hour)
+	if err != nil || deleted != 1 || created != 2 {
+		t.Fatalf("ReconcileRecords = %d, %d, %v; want 1, 2 (replace DMARC, add SPF for manual01)", deleted, created, err)
+	}
+
+	records, _ := provider.GetRecords("_dmarc."+domain.Subdomain, "TXT")
+	if len(records) != 1 || records[0].Value != "v=DMARC1; p=none; rua=mailto:new@example.com" {
+		t.Errorf("DMARC records = %+v", records)
+	}
+	records, _ = provider.GetRecords("_dmarc.manual01", "TXT")
+	if len(records) != 1 || records[0].Value != "v=DMARC1; p=reject" {
+		t.Errorf("manual DMARC records = %+v", records)
+	}
+}
@@ -0,0 +1,72 @@
+package services
+
+import (
+	"log"
+	"mail-server/storage"
+	"time"
+)
+
+// trashPurgeInterval 检查回收站的间隔
+const trashPurgeInterval = time.Hour
+
+// TrashPurger 定期永久删除在回收站中超过保留期的邮件
+type TrashPurger struct {
+	storage   storage.Storage
+	retention time.Duration
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// NewTrashPurger 创建回收站清理任务，retention 为邮件在回收站中的保留时间
+func NewTrashPurger(store storage.Storage, retention time.Duration) *TrashPurger {
+	if retention <= 0 {
+		retention = 30 * 24 * time.Hour
+	}
+	return &TrashPurger{
+		storage:   store,
+		retention: retention,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Start 启动后台清理
+func (p *TrashPurger) Start() {
+	go p.run()
+	log.Printf("[Trash] 回收站自动清理已启动 (保留: %v)", p.retention)
+}
+
+// Stop 停止后台清理
+func (p *TrashPurger) Stop() {
+	close(p.stop)
+	<-p.done
+}
+
+// run 启动时清理一次，之后按固定间隔清理
+func (p *TrashPurger) run() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(trashPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		p.purge()
+		select {
+		case <-ticker.C:
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// purge 删除过期的回收站邮件
+func (p *TrashPurger) purge() {
+	deleted, err := p.storage.PurgeTrash(time.Now().Add(-p.retention))
+	if err != nil {
+		log.Printf("[Trash] 清理回收站失败: %v", err)
+		return
+	}
+	if deleted > 0 {
+		log.Printf("[Trash] 已永久删除 %d 封回收站邮件", deleted)
+	}
+}
@@ -0,0 +1,310 @@
+package services
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mail-server/storage"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// webhookTimeout 单次请求超时
+	webhookTimeout = 15 * time.Second
+	// webhookErrorBodyLimit 失败时记录的响应内容长度
+	webhookErrorBodyLimit = 512
+)
+
+// WebhookPayload Webhook请求体
+type WebhookPayload struct {
+	Event     string      `json:"event"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// WebhookMail mail.received 事件中的邮件内容
+type WebhookMail struct {
+	ID         int64     `json:"id"`
+	From       string    `json:"from"` // 信封发件人
+	To         []string  `json:"to"`   // 信封收件人（属于该用户的地址）
+	Subject    string    `json:"subject"`
+	Text       string    `json:"text"`
+	HTML       string    `json:"html"`
+	ReceivedAt time.Time `json:"received_at"`
+	storage.MailHeaders
+	storage.MailCodes
+	storage.AuthResult
+	Spoofed     bool                  `json:"spoofed"`
+	Attachments []*storage.Attachment `json:"attachments"` // 只有元数据，内容通过附件下载接口获取
+}
+
+// WebhookDispatcher Webhook投递队列：推送内容先入库，由后台worker投递，失败按指数退避重试
+type WebhookDispatcher struct {
+	storage     storage.Storage
+	client      *http.Client
+	workers     int
+	retryBase   time.Duration
+	maxAttempts int
+	jobs        chan *storage.WebhookDelivery
+	notify      chan struct{}
+	stop        chan struct{}
+	wg          sync.WaitGroup
+}
+
+// NewWebhookDispatcher 创建Webhook投递队列
+func NewWebhookDispatcher(store storage.Storage, workers int, retryBase time.Duration, maxAttempts int) *WebhookDispatcher {
+	if workers <= 0 {
+		workers = 2
+	}
+	if retryBase <= 0 {
+		retryBase = 30 * time.Second
+	}
+	if maxAttempts <= 0 {
+		maxAttempts = 8
+	}
+
+	return &WebhookDispatcher{
+		storage:     store,
+		client:      newWebhookClient(),
+		workers:     workers,
+		retryBase:   retryBase,
+		maxAttempts: maxAttempts,
+		jobs:        make(chan *storage.WebhookDelivery),
+		notify:      make(chan struct{}, 1),
+		stop:        make(chan struct{}),
+	}
+}
+
+// Start 启动调度和投递worker
+func (d *WebhookDispatcher) Start() error {
+	// 上次退出时正在投递的记录重新排队
+	if err := d.storage.ResetWebhookDeliveries(); err != nil {
+		return err
+	}
+
+	d.wg.Add(1)
+	go d.dispatch()
+	for i := 0; i < d.workers; i++ {
+		d.wg.Add(1)
+		go d.work()
+	}
+
+	log.Printf("[Webhook] 投递队列已启动 (workers: %d)", d.workers)
+	return nil
+}
+
+// Stop 停止队列，等待正在进行的投递完成
+func (d *WebhookDispatcher) Stop() {
+	close(d.stop)
+	d.wg.Wait()
+}
+
+// MailReceived 为订阅了新邮件事件的Webhook创建投递任务
+func (d *WebhookDispatcher) MailReceived(userID int64, mail *storage.Mail) error {
+	recipients := mail.Recipients()
+	hooks, err := d.storage.GetMailWebhooks(userID, recipients, storage.WebhookEventMailReceived)
+	if err != nil || len(hooks) == 0 {
+		return err
+	}
+
+	attachments, err := d.storage.GetAttachments(userID, mail.ID)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(&WebhookPayload{
+		Event:     storage.WebhookEventMailReceived,
+		CreatedAt: time.Now(),
+		Data: &WebhookMail{
+			ID:          mail.ID,
+			From:        mail.From,
+			To:          recipients,
+			Subject:     mail.Subject,
+			Text:        mail.Body,
+			HTML:        mail.HTML,
+			ReceivedAt:  mail.ReceivedAt,
+			MailHeaders: mail.MailHeaders,
+			MailCodes:   mail.MailCodes,
+			AuthResult:  mail.AuthResult,
+			Spoofed:     mail.Spoofed,
+			Attachments: attachments,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook payload: %v", err)
+	}
+
+	for _, hook := range hooks {
+		delivery := &storage.WebhookDelivery{WebhookID: hook.ID, Event: storage.WebhookEventMailReceived, Payload: payload}
+		if err := d.storage.CreateWebhookDelivery(delivery); err != nil {
+			return err
+		}
+		log.Printf("[Webhook] 投递已入队 #%d: 邮件 %d -> %s", delivery.ID, mail.ID, hook.URL)
+	}
+
+	// 唤醒调度器立即处理
+	select {
+	case d.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// TestFire 立即向Webhook发送一次测试事件并返回投递结果，失败不重试
+func (d *WebhookDispatcher) TestFire(hook *storage.Webhook) (*storage.WebhookDelivery, error) {
+	payload, err := json.Marshal(&WebhookPayload{
+		Event:     storage.WebhookEventTest,
+		CreatedAt: time.Now(),
+		Data:      map[string]interface{}{"webhook_id": hook.ID, "message": "This is a test event"},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal webhook payload: %v", err)
+	}
+
+	delivery := &storage.WebhookDelivery{
+		WebhookID: hook.ID,
+		Event:     storage.WebhookEventTest,
+		Payload:   payload,
+		Status:    storage.WebhookDelivering,
+		URL:       hook.URL,
+		Secret:    hook.Secret,
+	}
+	if err := d.storage.CreateWebhookDelivery(delivery); err != nil {
+		return nil, err
+	}
+
+	d.deliver(delivery, false)
+	return d.storage.GetWebhookDelivery(delivery.ID)
+}
+
+// dispatch 从数据库取出到期任务分发给worker
+func (d *WebhookDispatcher) dispatch() {
+	defer d.wg.Done()
+	defer close(d.jobs)
+
+	for {
+		deliveries, err := d.storage.ClaimWebhookDeliveries(d.workers * 2)
+		if err != nil {
+			log.Printf("[Webhook] 读取投递队列失败: %v", err)
+		}
+
+		for _, delivery := range deliveries {
+			select {
+			case d.jobs <- delivery:
+			case <-d.stop:
+				return
+			}
+		}
+
+		if len(deliveries) > 0 {
+			continue
+		}
+
+		select {
+		case <-d.notify:
+		case <-time.After(queuePollInterval):
+		case <-d.stop:
+			return
+		}
+	}
+}
+
+// work 投递worker
+func (d *WebhookDispatcher) work() {
+	defer d.wg.Done()
+
+	for delivery := range d.jobs {
+		d.deliver(delivery, true)
+	}
+}
+
+// deliver 发送一次请求并记录结果，retry 为 true 时失败按退避时间重新排队
+func (d *WebhookDispatcher) deliver(delivery *storage.WebhookDelivery, retry bool) {
+	attempt := delivery.Attempts + 1
+	status, err := d.send(delivery)
+	if err == nil {
+		if err := d.storage.FinishWebhookDelivery(delivery.ID, storage.WebhookDelivered, status, "", time.Now()); err != nil {
+			log.Printf("[Webhook] 更新投递状态失败 #%d: %v", delivery.ID, err)
+		}
+		log.Printf("[Webhook] ✓ 投递成功 #%d: %s", delivery.ID, delivery.URL)
+		return
+	}
+
+	// 410 表示接收方已删除该地址，不再重试
+	if !retry || attempt >= d.maxAttempts || status == http.StatusGone {
+		log.Printf("[Webhook] ✗ 投递失败 #%d: %s: %v", delivery.ID, delivery.URL, err)
+		if err := d.storage.FinishWebhookDelivery(delivery.ID, storage.WebhookFailed, status, err.Error(), time.Now()); err != nil {
+			log.Printf("[Webhook] 更新投递状态失败 #%d: %v", delivery.ID, err)
+		}
+		return
+	}
+
+	next := time.Now().Add(d.backoff(attempt))
+	log.Printf("[Webhook] 投递失败 #%d: %v，将于 %s 重试", delivery.ID, err, next.Format("2006-01-02 15:04:05"))
+	if err := d.storage.FinishWebhookDelivery(delivery.ID, storage.WebhookPending, status, err.Error(), next); err != nil {
+		log.Printf("[Webhook] 更新投递状态失败 #%d: %v", delivery.ID, err)
+	}
+}
+
+// send 发送签名后的请求，返回HTTP状态码，非2xx视为失败
+func (d *WebhookDispatcher) send(delivery *storage.WebhookDelivery) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequest(http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "mail-server-webhook/1.0")
+	req.Header.Set("X-Webhook-Event", delivery.Event)
+	req.Header.Set("X-Webhook-Delivery", strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+SignWebhook(delivery.Secret, timestamp, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, webhookErrorBodyLimit))
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg := strings.TrimSpace(string(body))
+		if msg == "" {
+			return resp.StatusCode, fmt.Errorf("HTTP %d", resp.StatusCode)
+		}
+		return resp.StatusCode, fmt.Errorf("HTTP %d: %s", resp.StatusCode, msg)
+	}
+	return resp.StatusCode, nil
+}
+
+// backoff 第n次失败后的等待时间：retryBase * 2^(n-1)，最长 queueMaxBackoff
+func (d *WebhookDispatcher) backoff(attempt int) time.Duration {
+	delay := d.retryBase
+	for i := 1; i < attempt && delay < queueMaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > queueMaxBackoff {
+		delay = queueMaxBackoff
+	}
+	return delay
+}
+
+// SignWebhook 计算Webhook签名：HMAC-SHA256(secret, timestamp + "." + body) 的十六进制，接收方用同样方法校验
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
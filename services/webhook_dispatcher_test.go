@@ -0,0 +1,157 @@
+package services
+
+import (
+	"crypto/hmac"
+	"io"
+	"mail-server/storage"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookReceiver 测试用的Webhook接收方，按签名规则校验请求
+type webhookReceiver struct {
+	secret string
+	mu     sync.Mutex
+	bodies []string
+}
+
+func (rcv *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	timestamp := r.Header.Get("X-Webhook-Timestamp")
+	want := "sha256=" + SignWebhook(rcv.secret, timestamp, body)
+	if timestamp == "" || !hmac.Equal([]byte(r.Header.Get("X-Webhook-Signature")), []byte(want)) {
+		http.Error(w, "bad signature", http.StatusUnauthorized)
+		return
+	}
+
+	rcv.mu.Lock()
+	rcv.bodies = append(rcv.bodies, string(body))
+	rcv.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// newTestDispatcher 创建使用临时数据库的投递队列和一个Webhook
+func newTestDispatcher(t *testing.T, url, secret string) (*WebhookDispatcher, *storage.Webhook) {
+	t.Helper()
+	store := newTestStorage(t)
+	user, err := store.CreateUser("owner@example.org", "password", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	hook := &storage.Webhook{UserID: user.ID, URL: url, Secret: secret, Events: []string{}, Active: true}
+	if err := store.CreateWebhook(hook); err != nil {
+		t.Fatalf("CreateWebhook: %v", err)
+	}
+	return NewWebhookDispatcher(store, 1, time.Second, 3), hook
+}
+
+func TestTestFireSignsRequest(t *testing.T) {
+	rcv := &webhookReceiver{secret: "s3cret"}
+	server := httptest.NewServer(rcv)
+	defer server.Close()
+
+	d, hook := newTestDispatcher(t, server.URL, "s3cret")
+	// 接收方在回环地址上，测试中换成不检查地址的客户端
+	d.client = server.Client()
+
+	delivery, err := d.TestFire(hook)
+	if err != nil {
+		t.Fatalf("TestFire: %v", err)
+	}
+	if delivery.Status != storage.WebhookDelivered || delivery.ResponseStatus != http.StatusNoContent {
+		t.Fatalf("delivery = %s (HTTP %d, %q), want delivered", delivery.Status, delivery.ResponseStatus, delivery.LastError)
+	}
+	if len(rcv.bodies) != 1 || !strings.Contains(rcv.bodies[0], `"event":"`+storage.WebhookEventTest+`"`) {
+		t.Errorf("receiver got %q", rcv.bodies)
+	}
+}
+
+func TestTestFireWrongSecretFails(t *testing.T) {
+	server := httptest.NewServer(&webhookReceiver{secret: "expected"})
+	defer server.Close()
+
+	d, hook := newTestDispatcher(t, server.URL, "other")
+	d.client = server.Client()
+
+	delivery, err := d.TestFire(hook)
+	if err != nil {
+		t.Fatalf("TestFire: %v", err)
+	}
+	if delivery.Status != storage.WebhookFailed || delivery.ResponseStatus != http.StatusUnauthorized {
+		t.Errorf("delivery = %s (HTTP %d), want failed with 401", delivery.Status, delivery.ResponseStatus)
+	}
+}
+
+func TestWebhookClientRefusesPrivateAddress(t *testing.T) {
+	rcv := &webhookReceiver{secret: "s3cret"}
+	server := httptest.NewServer(rcv)
+	defer server.Close()
+
+	// 默认客户端在连接时检查地址，即使地址已经保存也无法访问回环地址
+	d, hook := newTestDispatcher(t, server.URL, "s3cret")
+
+	delivery, err := d.TestFire(hook)
+	if err != nil {
+		t.Fatalf("TestFire: %v", err)
+	}
+	if delivery.Status != storage.WebhookFailed || !strings.Contains(delivery.LastError, "not a public address") {
+		t.Errorf("delivery = %s (%q), want refused", delivery.Status, delivery.LastError)
+	}
+	if len(rcv.bodies) != 0 {
+		t.Errorf("private receiver was reached")
+	}
+}
+
+func TestValidateWebhookURL(t *testing.T) {
+	tests := []struct {
+		url string
+		ok  bool
+	}{
+		{"https://93.184.216.34/hook", true},
+		{"http://[2606:4700::1111]:8080/hook", true},
+		{"ftp://93.184.216.34/", false},
+		{"https:///path", false},
+		{"http://127.0.0.1/", false},
+		{"http://localhost:8080/", false},
+		{"http://[::1]/", false},
+		{"http://0.0.0.0/", false},
+		{"http://10.1.2.3/", false},
+		{"http://172.16.0.1/", false},
+		{"http://192.168.1.1/", false},
+		{"http://169.254.169.254/latest/meta-data/", false},
+		{"http://[fe80::1]/", false},
+		{"http://[fd00::1]/", false},
+		{"http://100.64.0.1/", false},
+		{"http://[::ffff:127.0.0.1]/", false},
+	}
+
+	for _, tt := range tests {
+		_, err := ValidateWebhookURL(tt.url)
+		if (err == nil) != tt.ok {
+			t.Errorf("ValidateWebhookURL(%q) = %v, want ok=%v", tt.url, err, tt.ok)
+		}
+	}
+}
+
+func TestIsPublicIP(t *testing.T) {
+	for _, ip := range []string{"8.8.8.8", "2001:4860:4860::8888"} {
+		if !isPublicIP(net.ParseIP(ip)) {
+			t.Errorf("isPublicIP(%s) = false", ip)
+		}
+	}
+	for _, ip := range []string{"127.0.0.53", "::", "224.0.0.1", "198.18.0.1", "64:ff9b::a00:1"} {
+		if isPublicIP(net.ParseIP(ip)) {
+			t.Errorf("isPublicIP(%s) = true", ip)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package smtp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// maxAuthFailures 单个连接允许的认证失败次数
+const maxAuthFailures = 3
+
+// AuthUser 已通过SMTP认证的用户
+type AuthUser struct {
+	ID      int64
+	Email   string
+	IsAdmin bool
+}
+
+// Authenticator SMTP认证后端
+type Authenticator interface {
+	// Authenticate 校验用户名和密码，凭据错误时返回 nil, nil
+	Authenticate(username, password string) (*AuthUser, error)
+	// CanSendAs 检查用户是否可以使用该地址作为信封发件人
+	CanSendAs(user *AuthUser, from string) (bool, error)
+}
+
+// authAvailable 当前连接是否可以进行认证
+func (s *smtpSession) authAvailable() bool {
+	if s.server.Auth == nil {
+		return false
+	}
+	// 要求TLS时，不在明文连接上通告和接受AUTH
+	return s.tls || !s.server.RequireTLS
+}
+
+// handleAuth 处理 AUTH 命令（RFC 4954），返回false表示关闭连接
+func (s *smtpSession) handleAuth(arg string) bool {
+	if s.server.Auth == nil {
+		s.writeLine("502 5.5.1 Command not implemented")
+		return true
+	}
+	if s.state == stateConnected {
+		s.writeLine("503 5.5.1 Send EHLO first")
+		return true
+	}
+	if s.authUser != nil {
+		s.writeLine("503 5.5.1 Already authenticated")
+		return true
+	}
+	if s.state != stateGreeted {
+		s.writeLine("503 5.5.1 AUTH not permitted during a mail transaction")
+		return true
+	}
+	if !s.authAvailable() {
+		s.writeLine("538 5.7.11 Encryption required for requested authentication mechanism")
+		return true
+	}
+
+	parts := strings.Fields(arg)
+	if len(parts) == 0 || len(parts) > 2 {
+		s.writeLine("501 5.5.2 Syntax: AUTH mechanism [initial-response]")
+		return true
+	}
+	mechanism := strings.ToUpper(parts[0])
+	var initial string
+	if len(parts) == 2 {
+		initial = parts[1]
+	}
+
+	var username, password string
+	var ok bool
+	switch mechanism {
+	case "PLAIN":
+		username, password, ok = s.authPlain(initial)
+	case "LOGIN":
+		username, password, ok = s.authLogin(initial)
+	default:
+		s.writeLine("504 5.5.4 Unrecognized authentication type")
+		return true
+	}
+	if !ok {
+		return true
+	}
+
+	user, err := s.server.Auth.Authenticate(username, password)
+	if err != nil {
+		log.Printf("[SMTP] 认证出错 (%s): %v", username, err)
+		s.writeLine("454 4.7.0 Temporary authentication failure")
+		return true
+	}
+	if user == nil {
+		s.authFailures++
+		log.Printf("[SMTP] 认证失败: %s (%s)", username, s.conn.RemoteAddr())
+		s.writeLine("535 5.7.8 Authentication credentials invalid")
+		if s.authFailures >= maxAuthFailures {
+			s.writeLine("421 4.7.0 Too many authentication failures, closing connection")
+			return false
+		}
+		return true
+	}
+
+	s.authUser = user
+	log.Printf("[SMTP] ✓ 用户认证成功: %s", user.Email)
+	s.writeLine("235 2.7.0 Authentication successful")
+	return true
+}
+
+// authPlain 处理 PLAIN 机制（RFC 4616）：[authzid] NUL authcid NUL passwd
+func (s *smtpSession) authPlain(initial string) (string, string, bool) {
+	response, ok := s.authResponse(initial, "")
+	if !ok {
+		return "", "", false
+	}
+
+	fields := bytes.Split(response, []byte{0})
+	if len(fields) != 3 {
+		s.writeLine("501 5.5.2 Invalid PLAIN response")
+		return "", "", false
+	}
+	authzid, username, password := string(fields[0]), string(fields[1]), string(fields[2])
+	if authzid != "" && authzid != username {
+		s.writeLine("535 5.7.8 Authorization identity must match authentication identity")
+		return "", "", false
+	}
+	return username, password, true
+}
+
+// authLogin 处理 LOGIN 机制：依次询问用户名和密码
+func (s *smtpSession) authLogin(initial string) (string, string, bool) {
+	username, ok := s.authResponse(initial, "VXNlcm5hbWU6") // "Username:"
+	if !ok {
+		return "", "", false
+	}
+	password, ok := s.authResponse("", "UGFzc3dvcmQ6") // "Password:"
+	if !ok {
+		return "", "", false
+	}
+	return string(username), string(password), true
+}
+
+// authResponse 解码客户端响应，initial 为空时发送 334 质询并读取一行
+func (s *smtpSession) authResponse(initial, challenge string) ([]byte, bool) {
+	line := initial
+	if line == "" {
+		s.writeLine(strings.TrimSpace(fmt.Sprintf("334 %s", challenge)))
+		if err := s.flush(); err != nil {
+			return nil, false
+		}
+		var err error
+		line, err = s.readLine(maxCommandLength)
+		if err != nil {
+			s.writeLine("501 5.5.2 Invalid authentication response")
+			return nil, false
+		}
+	}
+
+	if line == "*" {
+		s.writeLine("501 5.7.0 Authentication cancelled")
+		return nil, false
+	}
+	// "=" 表示空的初始响应
+	if line == "=" {
+		return []byte{}, true
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(line)
+	if err != nil {
+		s.writeLine("501 5.5.2 Invalid base64 data")
+		return nil, false
+	}
+	return decoded, true
+}
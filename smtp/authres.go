@@ -0,0 +1,225 @@
+package smtp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// 认证结果（RFC 8601 2.7）
+const (
+	ResultNone      = "none"
+	ResultPass      = "pass"
+	ResultFail      = "fail"
+	ResultSoftFail  = "softfail"
+	ResultNeutral   = "neutral"
+	ResultTempError = "temperror"
+	ResultPermError = "permerror"
+)
+
+// verifyTimeout 单封邮件SPF/DKIM/DMARC检查的总超时
+const verifyTimeout = 20 * time.Second
+
+// AuthResults 入站邮件的发件人认证结果
+type AuthResults struct {
+	SPF         string       // SPF结果
+	SPFDomain   string       // SPF检查的域名（信封发件人域名，空发件人时为HELO）
+	SPFReason   string       // SPF说明
+	DKIM        []DKIMResult // 每个签名的验证结果
+	DMARC       string       // DMARC结果
+	DMARCPolicy string       // 发件域名的DMARC策略 none/quarantine/reject
+	FromDomain  string       // 邮件头 From 的域名
+}
+
+// DKIMResult 所有签名中最好的结果，没有签名时为 none
+func (r *AuthResults) DKIMResult() string {
+	if len(r.DKIM) == 0 {
+		return ResultNone
+	}
+	for _, d := range r.DKIM {
+		if d.Result == ResultPass {
+			return ResultPass
+		}
+	}
+	return r.DKIM[0].Result
+}
+
+// Verifier 入站邮件的 SPF、DKIM 和 DMARC 检查
+type Verifier struct {
+	Hostname string   // Authentication-Results 中的 authserv-id
+	Resolver Resolver // DNS查询
+}
+
+// NewVerifier 创建发件人验证器
+func NewVerifier(hostname string, resolver Resolver) *Verifier {
+	return &Verifier{
+		Hostname: hostname,
+		Resolver: resolver,
+	}
+}
+
+// Verify 对邮件执行SPF、DKIM和DMARC检查
+func (v *Verifier) Verify(ip net.IP, helo, mailFrom, rawData string) *AuthResults {
+	ctx, cancel := context.WithTimeout(context.Background(), verifyTimeout)
+	defer cancel()
+
+	results := &AuthResults{FromDomain: HeaderFromDomain(rawData)}
+
+	results.SPFDomain = extractDomain(mailFrom)
+	if results.SPFDomain == "" {
+		results.SPFDomain = strings.ToLower(helo)
+	}
+	results.SPF, results.SPFReason = CheckSPF(ctx, v.Resolver, ip, mailFrom, helo)
+	results.DKIM = VerifyDKIM(ctx, v.Resolver, rawData)
+	results.DMARC, results.DMARCPolicy = v.checkDMARC(ctx, results)
+	return results
+}
+
+// checkDMARC 检查SPF或DKIM是否通过且与邮件头 From 域名对齐（RFC 7489）
+func (v *Verifier) checkDMARC(ctx context.Context, r *AuthResults) (string, string) {
+	if r.FromDomain == "" {
+		return ResultPermError, ""
+	}
+
+	record, org, err := v.lookupDMARC(ctx, r.FromDomain)
+	if err != nil {
+		return ResultTempError, ""
+	}
+	if record == nil {
+		return ResultNone, ""
+	}
+
+	policy := record["p"]
+	if sp, ok := record["sp"]; ok && org {
+		policy = sp
+	}
+
+	if r.SPF == ResultPass && domainsAligned(r.SPFDomain, r.FromDomain, record["aspf"]) {
+		return ResultPass, policy
+	}
+	for _, d := range r.DKIM {
+		if d.Result == ResultPass && domainsAligned(d.Domain, r.FromDomain, record["adkim"]) {
+			return ResultPass, policy
+		}
+	}
+	return ResultFail, policy
+}
+
+// lookupDMARC 查询 _dmarc 记录，发件域名没有记录时查询组织域名，org 表示记录来自组织域名
+func (v *Verifier) lookupDMARC(ctx context.Context, domain string) (map[string]string, bool, error) {
+	record, err := v.lookupDMARCRecord(ctx, domain)
+	if err != nil || record != nil {
+		return record, false, err
+	}
+
+	orgDomain := organizationalDomain(domain)
+	if orgDomain == domain {
+		return nil, false, nil
+	}
+	record, err = v.lookupDMARCRecord(ctx, orgDomain)
+	return record, true, err
+}
+
+// lookupDMARCRecord 查询单个域名的 v=DMARC1 记录，不存在时返回 nil
+func (v *Verifier) lookupDMARCRecord(ctx context.Context, domain string) (map[string]string, error) {
+	txts, err := v.Resolver.LookupTXT(ctx, "_dmarc."+domain)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	for _, txt := range txts {
+		if !strings.HasPrefix(strings.ToUpper(strings.TrimSpace(txt)), "V=DMARC1") {
+			continue
+		}
+		tags, err := parseTagList(txt)
+		if err != nil {
+			continue
+		}
+		// p 必须是有效的策略
+		switch strings.ToLower(tags["p"]) {
+		case "none", "quarantine", "reject":
+			tags["p"] = strings.ToLower(tags["p"])
+			return tags, nil
+		}
+	}
+	return nil, nil
+}
+
+// domainsAligned 判断认证域名与 From 域名是否对齐，mode 为 s 时要求完全相同，否则组织域名相同即可
+func domainsAligned(authDomain, fromDomain, mode string) bool {
+	authDomain = strings.ToLower(authDomain)
+	fromDomain = strings.ToLower(fromDomain)
+	if authDomain == "" {
+		return false
+	}
+	if strings.EqualFold(mode, "s") {
+		return authDomain == fromDomain
+	}
+	return organizationalDomain(authDomain) == organizationalDomain(fromDomain)
+}
+
+// organizationalDomain 根据公共后缀列表计算组织域名，如 a.b.example.com.cn -> example.com.cn
+func organizationalDomain(domain string) string {
+	org, err := publicsuffix.EffectiveTLDPlusOne(strings.ToLower(domain))
+	if err != nil {
+		return strings.ToLower(domain)
+	}
+	return org
+}
+
+// Header 生成 Authentication-Results 头（RFC 8601），以CRLF结尾
+func (v *Verifier) Header(r *AuthResults, mailFrom, helo string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Authentication-Results: %s;\r\n", v.Hostname)
+
+	if mailFrom != "" {
+		fmt.Fprintf(&b, "\tspf=%s smtp.mailfrom=%s;\r\n", r.SPF, mailFrom)
+	} else {
+		fmt.Fprintf(&b, "\tspf=%s smtp.helo=%s;\r\n", r.SPF, helo)
+	}
+
+	if len(r.DKIM) == 0 {
+		b.WriteString("\tdkim=none;\r\n")
+	}
+	for _, d := range r.DKIM {
+		if d.Reason != "" {
+			fmt.Fprintf(&b, "\tdkim=%s (%s) header.d=%s header.s=%s;\r\n", d.Result, d.Reason, d.Domain, d.Selector)
+		} else {
+			fmt.Fprintf(&b, "\tdkim=%s header.d=%s header.s=%s;\r\n", d.Result, d.Domain, d.Selector)
+		}
+	}
+
+	if r.DMARCPolicy != "" {
+		fmt.Fprintf(&b, "\tdmarc=%s (p=%s) header.from=%s\r\n", r.DMARC, r.DMARCPolicy, r.FromDomain)
+	} else {
+		fmt.Fprintf(&b, "\tdmarc=%s header.from=%s\r\n", r.DMARC, r.FromDomain)
+	}
+	return b.String()
+}
+
+// Stamp 去掉邮件中伪造的本机 Authentication-Results 头，并在最前面加上本次检查结果
+func (v *Verifier) Stamp(rawData string, r *AuthResults, mailFrom, helo string) string {
+	header, body := splitMessage(toCRLF(rawData))
+
+	var kept strings.Builder
+	for _, field := range parseHeaderFields(header) {
+		name, value, _ := strings.Cut(field, ":")
+		if strings.EqualFold(strings.TrimSpace(name), "Authentication-Results") {
+			authservID, _, _ := strings.Cut(strings.TrimSpace(value), ";")
+			if strings.EqualFold(strings.TrimSpace(authservID), v.Hostname) {
+				continue
+			}
+		}
+		kept.WriteString(field)
+		kept.WriteString("\r\n")
+	}
+
+	return v.Header(r, mailFrom, helo) + kept.String() + "\r\n" + body
+}
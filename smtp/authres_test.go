@@ -0,0 +1,282 @@
+package smtp
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"net"
+	"strings"
+	"testing"
+)
+
+// rfc8463Message RFC 8463 附录A中使用 ed25519-sha256 签名的示例邮件
+const rfc8463Message = "DKIM-Signature: v=1; a=ed25519-sha256; c=relaxed/relaxed;\r\n" +
+	" d=football.example.com; i=@football.example.com;\r\n" +
+	" q=dns/txt; s=brisbane; t=1528637909; h=from : to :\r\n" +
+	" subject : date : message-id : from : subject : date;\r\n" +
+	" bh=2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8=;\r\n" +
+	" b=/gCrinpcQOoIfuHNQIbq4pgh9kyIK3AQUdt9OdqQehSwhEIug4D11Bus\r\n" +
+	" Fa3bT3FY5OsU7ZbnKELq+eXdp1Q1Dw==\r\n" +
+	"From: Joe SixPack <joe@football.example.com>\r\n" +
+	"To: Suzie Q <suzie@shopping.example.net>\r\n" +
+	"Subject: Is dinner ready?\r\n" +
+	"Date: Fri, 11 Jul 2003 21:00:37 -0700 (PDT)\r\n" +
+	"Message-ID: <20030712040037.46341.5F8J@football.example.com>\r\n" +
+	"\r\n" +
+	"Hi.\r\n" +
+	"\r\n" +
+	"We lost the game.  Are you hungry yet?\r\n" +
+	"\r\n" +
+	"Joe.\r\n"
+
+// rfc8463Key RFC 8463 附录A中 brisbane._domainkey.football.example.com 的公钥记录
+const rfc8463Key = "v=DKIM1; k=ed25519; p=11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo="
+
+func TestCheckSPF(t *testing.T) {
+	resolver := newFakeResolver()
+	resolver.txt["pass.example"] = []string{"v=spf1 ip4:192.0.2.0/24 -all"}
+	resolver.txt["softfail.example"] = []string{"v=spf1 ip4:198.51.100.1 ~all"}
+	resolver.txt["neutral.example"] = []string{"v=spf1 ?all"}
+	resolver.txt["default.example"] = []string{"v=spf1 ip4:198.51.100.1"}
+	resolver.txt["ip6.example"] = []string{"v=spf1 ip6:2001:db8::/32 -all"}
+	resolver.txt["a.example"] = []string{"v=spf1 a/24 -all"}
+	resolver.ip["a.example"] = ipAddrs("192.0.2.10")
+	resolver.txt["mx.example"] = []string{"v=spf1 mx -all"}
+	resolver.mx["mx.example"] = []*net.MX{{Host: "mail.mx.example.", Pref: 10}}
+	resolver.ip["mail.mx.example"] = ipAddrs("192.0.2.1")
+	resolver.txt["include.example"] = []string{"v=spf1 include:pass.example -all"}
+	resolver.txt["redirect.example"] = []string{"v=spf1 redirect=pass.example"}
+	resolver.txt["badredirect.example"] = []string{"v=spf1 redirect=missing.example"}
+	resolver.txt["exists.example"] = []string{"v=spf1 exists:%{ir}.allow.exists.example -all"}
+	resolver.ip["1.2.0.192.allow.exists.example"] = ipAddrs("127.0.0.2")
+	resolver.txt["multiple.example"] = []string{"v=spf1 -all", "v=spf1 +all"}
+	resolver.txt["unknown.example"] = []string{"v=spf1 foo:bar -all"}
+	resolver.txt["other-txt.example"] = []string{"google-site-verification=abc"}
+	resolver.fail["temp.example"] = true
+
+	// 每个 include 都消耗一次DNS查询，链条超过10次时为 permerror
+	for i := 0; i < 12; i++ {
+		resolver.txt[chainName(i)] = []string{"v=spf1 include:" + chainName(i+1) + " -all"}
+	}
+	resolver.txt[chainName(12)] = []string{"v=spf1 +all"}
+
+	tests := []struct {
+		ip     string
+		sender string
+		helo   string
+		want   string
+	}{
+		{"192.0.2.1", "a@pass.example", "", ResultPass},
+		{"203.0.113.1", "a@pass.example", "", ResultFail},
+		{"203.0.113.1", "a@softfail.example", "", ResultSoftFail},
+		{"203.0.113.1", "a@neutral.example", "", ResultNeutral},
+		{"203.0.113.1", "a@default.example", "", ResultNeutral},
+		{"2001:db8::1", "a@ip6.example", "", ResultPass},
+		{"192.0.2.200", "a@a.example", "", ResultPass},
+		{"198.51.100.1", "a@a.example", "", ResultFail},
+		{"192.0.2.1", "a@mx.example", "", ResultPass},
+		{"192.0.2.2", "a@mx.example", "", ResultFail},
+		{"192.0.2.1", "a@include.example", "", ResultPass},
+		{"203.0.113.1", "a@include.example", "", ResultFail},
+		{"192.0.2.1", "a@redirect.example", "", ResultPass},
+		{"192.0.2.1", "a@badredirect.example", "", ResultPermError},
+		{"192.0.2.1", "a@exists.example", "", ResultPass},
+		{"192.0.2.2", "a@exists.example", "", ResultFail},
+		{"192.0.2.1", "a@multiple.example", "", ResultPermError},
+		{"192.0.2.1", "a@unknown.example", "", ResultPermError},
+		{"192.0.2.1", "a@other-txt.example", "", ResultNone},
+		{"192.0.2.1", "a@nxdomain.example", "", ResultNone},
+		{"192.0.2.1", "a@temp.example", "", ResultTempError},
+		{"192.0.2.1", "a@" + chainName(0), "", ResultPermError},
+		// 空发件人（退信）检查 HELO 域名
+		{"192.0.2.1", "", "pass.example", ResultPass},
+		{"203.0.113.1", "", "pass.example", ResultFail},
+	}
+
+	for _, tt := range tests {
+		got, reason := CheckSPF(context.Background(), resolver, net.ParseIP(tt.ip), tt.sender, tt.helo)
+		if got != tt.want {
+			t.Errorf("CheckSPF(%s, %q, %q) = %s (%s), want %s", tt.ip, tt.sender, tt.helo, got, reason, tt.want)
+		}
+	}
+}
+
+func chainName(i int) string {
+	return "chain" + strings.Repeat("x", i) + ".example"
+}
+
+func TestVerifyDKIMRFC8463Vector(t *testing.T) {
+	resolver := newFakeResolver()
+	resolver.txt["brisbane._domainkey.football.example.com"] = []string{rfc8463Key}
+
+	results := VerifyDKIM(context.Background(), resolver, rfc8463Message)
+	if len(results) != 1 || results[0].Result != ResultPass {
+		t.Fatalf("VerifyDKIM = %+v, want a single pass", results)
+	}
+	if results[0].Domain != "football.example.com" || results[0].Selector != "brisbane" {
+		t.Errorf("domain/selector = %s/%s", results[0].Domain, results[0].Selector)
+	}
+
+	tampered := strings.Replace(rfc8463Message, "Are you hungry yet?", "Are you hungry now?", 1)
+	results = VerifyDKIM(context.Background(), resolver, tampered)
+	if len(results) != 1 || results[0].Result != ResultFail {
+		t.Errorf("tampered body: VerifyDKIM = %+v, want fail", results)
+	}
+
+	tampered = strings.Replace(rfc8463Message, "Subject: Is dinner ready?", "Subject: Is lunch ready?", 1)
+	results = VerifyDKIM(context.Background(), resolver, tampered)
+	if len(results) != 1 || results[0].Result != ResultFail {
+		t.Errorf("tampered header: VerifyDKIM = %+v, want fail", results)
+	}
+}
+
+func TestVerifyDKIMSignedRoundTrip(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate RSA key: %v", err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate ed25519 key: %v", err)
+	}
+
+	message := "From: Alice <alice@sender.example>\nTo: bob@test.local\nSubject: hello\n\nline one  \nline two\n\n\n"
+	for _, signer := range []*DKIMSigner{
+		{Domain: "sender.example", Selector: "rsa", Key: rsaKey},
+		{Domain: "sender.example", Selector: "ed", Key: edKey},
+	} {
+		record, err := DKIMRecord(signer.Key)
+		if err != nil {
+			t.Fatalf("DKIMRecord: %v", err)
+		}
+		resolver := newFakeResolver()
+		resolver.txt[signer.Selector+"._domainkey.sender.example"] = []string{record}
+
+		signed, err := signer.Sign(message)
+		if err != nil {
+			t.Fatalf("Sign: %v", err)
+		}
+		if results := VerifyDKIM(context.Background(), resolver, signed); len(results) != 1 || results[0].Result != ResultPass {
+			t.Errorf("%s: VerifyDKIM = %+v, want pass", signer.Selector, results)
+		}
+
+		// 密钥被撤销（p= 为空）
+		resolver.txt[signer.Selector+"._domainkey.sender.example"] = []string{"v=DKIM1; p="}
+		if results := VerifyDKIM(context.Background(), resolver, signed); len(results) != 1 || results[0].Result != ResultFail {
+			t.Errorf("%s: revoked key: VerifyDKIM = %+v, want fail", signer.Selector, results)
+		}
+
+		// 没有公钥记录
+		delete(resolver.txt, signer.Selector+"._domainkey.sender.example")
+		if results := VerifyDKIM(context.Background(), resolver, signed); len(results) != 1 || results[0].Result != ResultPermError {
+			t.Errorf("%s: missing key: VerifyDKIM = %+v, want permerror", signer.Selector, results)
+		}
+	}
+
+	if results := VerifyDKIM(context.Background(), newFakeResolver(), message); len(results) != 0 {
+		t.Errorf("unsigned message: VerifyDKIM = %+v, want none", results)
+	}
+}
+
+func TestVerifierDMARC(t *testing.T) {
+	resolver := newFakeResolver()
+	resolver.txt["brisbane._domainkey.football.example.com"] = []string{rfc8463Key}
+	resolver.txt["_dmarc.example.com"] = []string{"v=DMARC1; p=reject; sp=quarantine"}
+	resolver.txt["spf.example.com"] = []string{"v=spf1 ip4:192.0.2.1 -all"}
+	resolver.txt["strict.example.org"] = []string{"v=spf1 ip4:192.0.2.1 -all"}
+	resolver.txt["_dmarc.strict.example.org"] = []string{"v=DMARC1; p=reject; aspf=s"}
+	resolver.txt["mail.strict.example.org"] = []string{"v=spf1 ip4:192.0.2.1 -all"}
+	resolver.txt["nodmarc.example.net"] = []string{"v=spf1 ip4:192.0.2.1 -all"}
+
+	v := NewVerifier("mail.test.local", resolver)
+	ip := net.ParseIP("192.0.2.1")
+
+	tests := []struct {
+		name       string
+		mailFrom   string
+		raw        string
+		wantDMARC  string
+		wantPolicy string
+	}{
+		{
+			// DKIM d=football.example.com 与 From 的组织域名 example.com 宽松对齐，使用组织域名的 sp
+			name:       "relaxed DKIM alignment",
+			mailFrom:   "bounce@elsewhere.example",
+			raw:        rfc8463Message,
+			wantDMARC:  ResultPass,
+			wantPolicy: "quarantine",
+		},
+		{
+			name:       "relaxed SPF alignment",
+			mailFrom:   "bounce@spf.example.com",
+			raw:        "From: a@example.com\r\nSubject: hi\r\n\r\nbody\r\n",
+			wantDMARC:  ResultPass,
+			wantPolicy: "reject",
+		},
+		{
+			name:       "SPF pass for unrelated domain",
+			mailFrom:   "bounce@nodmarc.example.net",
+			raw:        "From: ceo@example.com\r\nSubject: hi\r\n\r\nbody\r\n",
+			wantDMARC:  ResultFail,
+			wantPolicy: "reject",
+		},
+		{
+			name:       "strict SPF alignment",
+			mailFrom:   "bounce@mail.strict.example.org",
+			raw:        "From: a@strict.example.org\r\nSubject: hi\r\n\r\nbody\r\n",
+			wantDMARC:  ResultFail,
+			wantPolicy: "reject",
+		},
+		{
+			name:      "no DMARC record",
+			mailFrom:  "a@nodmarc.example.net",
+			raw:       "From: a@nodmarc.example.net\r\nSubject: hi\r\n\r\nbody\r\n",
+			wantDMARC: ResultNone,
+		},
+		{
+			name:      "missing From header",
+			mailFrom:  "a@nodmarc.example.net",
+			raw:       "Subject: hi\r\n\r\nbody\r\n",
+			wantDMARC: ResultPermError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := v.Verify(ip, "client.example", tt.mailFrom, tt.raw)
+			if r.DMARC != tt.wantDMARC || r.DMARCPolicy != tt.wantPolicy {
+				t.Errorf("dmarc = %s (p=%s), want %s (p=%s); spf=%s dkim=%s",
+					r.DMARC, r.DMARCPolicy, tt.wantDMARC, tt.wantPolicy, r.SPF, r.DKIMResult())
+			}
+		})
+	}
+}
+
+func TestVerifierStamp(t *testing.T) {
+	v := NewVerifier("mail.test.local", newFakeResolver())
+	raw := "Authentication-Results: mail.test.local; spf=pass smtp.mailfrom=forged@example.com\r\n" +
+		"Authentication-Results: other.example; spf=fail\r\n" +
+		"From: a@example.com\r\n" +
+		"Subject: hi\r\n" +
+		"\r\n" +
+		"body\r\n"
+	r := &AuthResults{SPF: ResultFail, DMARC: ResultFail, DMARCPolicy: "reject", FromDomain: "example.com"}
+
+	stamped := v.Stamp(raw, r, "bounce@example.com", "client.example")
+	if !strings.HasPrefix(stamped, "Authentication-Results: mail.test.local;\r\n\tspf=fail smtp.mailfrom=bounce@example.com;\r\n") {
+		t.Errorf("stamped message does not start with our header:\n%s", stamped)
+	}
+	if strings.Contains(stamped, "forged@example.com") {
+		t.Errorf("forged Authentication-Results header was kept:\n%s", stamped)
+	}
+	if !strings.Contains(stamped, "Authentication-Results: other.example; spf=fail\r\n") {
+		t.Errorf("third-party Authentication-Results header was removed:\n%s", stamped)
+	}
+	if !strings.Contains(stamped, "\tdkim=none;\r\n\tdmarc=fail (p=reject) header.from=example.com\r\n") {
+		t.Errorf("unexpected DKIM/DMARC results:\n%s", stamped)
+	}
+	if !strings.HasSuffix(stamped, "\r\n\r\nbody\r\n") {
+		t.Errorf("body changed:\n%s", stamped)
+	}
+}
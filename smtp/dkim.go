@@ -0,0 +1,272 @@
+package smtp
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// dkimSignedHeaders 参与DKIM签名的邮件头（存在时才签名）
+var dkimSignedHeaders = []string{
+	"From", "Reply-To", "Subject", "Date", "To", "Cc",
+	"Message-ID", "In-Reply-To", "References",
+	"MIME-Version", "Content-Type", "Content-Transfer-Encoding",
+}
+
+// MessageSigner 外发邮件签名接口，返回签名后的完整邮件
+type MessageSigner interface {
+	Sign(rawData string) (string, error)
+}
+
+// DKIMSigner 使用指定域名和选择器对邮件进行DKIM签名（RFC 6376），支持 rsa-sha256 和 ed25519-sha256（RFC 8463）
+type DKIMSigner struct {
+	Domain   string
+	Selector string
+	Key      crypto.Signer // *rsa.PrivateKey 或 ed25519.PrivateKey
+}
+
+// DKIMAlgorithm 根据私钥类型返回签名算法名
+func DKIMAlgorithm(key crypto.Signer) (string, error) {
+	switch key.(type) {
+	case *rsa.PrivateKey:
+		return "rsa-sha256", nil
+	case ed25519.PrivateKey:
+		return "ed25519-sha256", nil
+	default:
+		return "", fmt.Errorf("unsupported DKIM key type %T", key)
+	}
+}
+
+// Sign 使用 relaxed/relaxed 规范化生成 DKIM-Signature 头并加在邮件最前面，换行统一为CRLF
+func (d *DKIMSigner) Sign(rawData string) (string, error) {
+	algorithm, err := DKIMAlgorithm(d.Key)
+	if err != nil {
+		return "", err
+	}
+
+	message := toCRLF(rawData)
+	header, body := splitMessage(message)
+	fields := parseHeaderFields(header)
+
+	bodyHash := sha256.Sum256([]byte(canonicalBodyRelaxed(body)))
+
+	// 按RFC 6376 5.4.2，同名头部从下往上依次选取
+	var signed []string
+	used := make(map[string]int)
+	var data strings.Builder
+	for _, name := range dkimSignedHeaders {
+		key := strings.ToLower(name)
+		for {
+			field, ok := lastHeaderField(fields, key, used[key])
+			if !ok {
+				break
+			}
+			used[key]++
+			signed = append(signed, key)
+			data.WriteString(canonicalHeaderRelaxed(field))
+			data.WriteString("\r\n")
+		}
+	}
+	if used["from"] == 0 {
+		return "", fmt.Errorf("message has no From header")
+	}
+
+	value := fmt.Sprintf("v=1; a=%s; c=relaxed/relaxed; d=%s; s=%s;\r\n\tt=%d; h=%s;\r\n\tbh=%s;\r\n\tb=",
+		algorithm, d.Domain, d.Selector, time.Now().Unix(), strings.Join(signed, ":"),
+		base64.StdEncoding.EncodeToString(bodyHash[:]))
+
+	// 签名数据最后是 b= 为空的 DKIM-Signature 头本身，不带结尾CRLF
+	data.WriteString(canonicalHeaderRelaxed("DKIM-Signature: " + value))
+
+	signature, err := dkimSign(d.Key, []byte(data.String()))
+	if err != nil {
+		return "", fmt.Errorf("failed to sign message: %w", err)
+	}
+
+	return "DKIM-Signature: " + value + foldBase64(base64.StdEncoding.EncodeToString(signature)) + "\r\n" + message, nil
+}
+
+// dkimSign 对签名数据做 SHA-256 摘要后签名；ed25519-sha256 对摘要本身做 PureEdDSA 签名
+func dkimSign(key crypto.Signer, data []byte) ([]byte, error) {
+	digest := sha256.Sum256(data)
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		return ed25519.Sign(k, digest[:]), nil
+	default:
+		return key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+}
+
+// foldBase64 将较长的签名值折行，避免邮件头单行过长
+func foldBase64(s string) string {
+	const width = 72
+	var b strings.Builder
+	for len(s) > width {
+		b.WriteString(s[:width])
+		b.WriteString("\r\n\t")
+		s = s[width:]
+	}
+	b.WriteString(s)
+	return b.String()
+}
+
+// toCRLF 将换行统一为CRLF
+func toCRLF(s string) string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	return strings.ReplaceAll(s, "\n", "\r\n")
+}
+
+// splitMessage 拆分邮件头和邮件体（输入为CRLF换行），返回的头部包含结尾CRLF
+func splitMessage(message string) (string, string) {
+	if strings.HasPrefix(message, "\r\n") {
+		return "", message[2:]
+	}
+	if i := strings.Index(message, "\r\n\r\n"); i >= 0 {
+		return message[:i+2], message[i+4:]
+	}
+	return message, ""
+}
+
+// parseHeaderFields 将邮件头拆分为字段，折行保留在字段内
+func parseHeaderFields(header string) []string {
+	var fields []string
+	for _, line := range strings.SplitAfter(header, "\r\n") {
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			fields[len(fields)-1] += line
+			continue
+		}
+		fields = append(fields, line)
+	}
+	for i, f := range fields {
+		fields[i] = strings.TrimSuffix(f, "\r\n")
+	}
+	return fields
+}
+
+// lastHeaderField 返回名为 name 的倒数第 skip+1 个字段
+func lastHeaderField(fields []string, name string, skip int) (string, bool) {
+	for i := len(fields) - 1; i >= 0; i-- {
+		fieldName, _, ok := strings.Cut(fields[i], ":")
+		if !ok || strings.ToLower(strings.TrimSpace(fieldName)) != name {
+			continue
+		}
+		if skip == 0 {
+			return fields[i], true
+		}
+		skip--
+	}
+	return "", false
+}
+
+// canonicalHeaderRelaxed relaxed 头部规范化：名称小写、展开折行、连续空白压缩为一个空格、去掉冒号两侧空白
+func canonicalHeaderRelaxed(field string) string {
+	name, value, _ := strings.Cut(field, ":")
+	name = strings.ToLower(strings.TrimRight(name, " \t"))
+	value = strings.ReplaceAll(value, "\r\n", "")
+	value = strings.Join(strings.FieldsFunc(value, isWSP), " ")
+	return name + ":" + value
+}
+
+// canonicalBodyRelaxed relaxed 邮件体规范化：压缩行内空白、去掉行尾空白和结尾空行
+func canonicalBodyRelaxed(body string) string {
+	lines := strings.Split(body, "\r\n")
+	for i, line := range lines {
+		line = strings.TrimRight(line, " \t")
+		var b strings.Builder
+		space := false
+		for j := 0; j < len(line); j++ {
+			if isWSP(rune(line[j])) {
+				space = true
+				continue
+			}
+			if space {
+				b.WriteByte(' ')
+				space = false
+			}
+			b.WriteByte(line[j])
+		}
+		lines[i] = b.String()
+	}
+
+	end := len(lines)
+	for end > 0 && lines[end-1] == "" {
+		end--
+	}
+	if end == 0 {
+		return ""
+	}
+	return strings.Join(lines[:end], "\r\n") + "\r\n"
+}
+
+// isWSP 空格或制表符
+func isWSP(r rune) bool {
+	return r == ' ' || r == '\t'
+}
+
+// DKIMRecord 生成发布在 selector._domainkey 下的TXT记录值
+func DKIMRecord(key crypto.Signer) (string, error) {
+	switch k := key.Public().(type) {
+	case *rsa.PublicKey:
+		der, err := x509.MarshalPKIXPublicKey(k)
+		if err != nil {
+			return "", err
+		}
+		return "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der), nil
+	case ed25519.PublicKey:
+		return "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(k), nil
+	default:
+		return "", fmt.Errorf("unsupported DKIM key type %T", k)
+	}
+}
+
+// dkimHeaderDomain 从 DKIM-Signature 头中取出 d= 的值
+func dkimHeaderDomain(field string) string {
+	_, value, _ := strings.Cut(field, ":")
+	for _, tag := range strings.Split(value, ";") {
+		k, v, ok := strings.Cut(tag, "=")
+		if ok && strings.TrimSpace(k) == "d" {
+			return strings.ToLower(strings.Join(strings.Fields(v), ""))
+		}
+	}
+	return ""
+}
+
+// HasDKIMSignature 检查邮件是否已带有指定域名的DKIM签名，避免重复签名
+func HasDKIMSignature(rawData, domain string) bool {
+	header, _ := splitMessage(toCRLF(rawData))
+	for _, field := range parseHeaderFields(header) {
+		name, _, _ := strings.Cut(field, ":")
+		if strings.EqualFold(strings.TrimSpace(name), "DKIM-Signature") && dkimHeaderDomain(field) == strings.ToLower(domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// HeaderFromDomain 取出邮件头 From 地址的域名
+func HeaderFromDomain(rawData string) string {
+	header, _ := splitMessage(toCRLF(rawData))
+	field, ok := lastHeaderField(parseHeaderFields(header), "from", 0)
+	if !ok {
+		return ""
+	}
+	_, value, _ := strings.Cut(field, ":")
+	value = strings.ReplaceAll(value, "\r\n", "")
+	if i := strings.LastIndex(value, "<"); i >= 0 {
+		value = value[i+1:]
+		if j := strings.IndexByte(value, '>'); j >= 0 {
+			value = value[:j]
+		}
+	}
+	return extractDomain(strings.TrimSpace(value))
+}
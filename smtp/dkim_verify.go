@@ -0,0 +1,282 @@
+package smtp
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dkimMaxSignatures 每封邮件最多验证的签名数量，防止恶意邮件消耗资源
+const dkimMaxSignatures = 5
+
+// bTagPattern 匹配 DKIM-Signature 中 b= 标签的值（不含 bh=）
+var bTagPattern = regexp.MustCompile(`([;:]\s*b\s*=)[^;]*`)
+
+// DKIMResult 单个DKIM签名的验证结果
+type DKIMResult struct {
+	Result   string // pass/fail/neutral/temperror/permerror
+	Domain   string // 签名域名 d=
+	Selector string // 选择器 s=
+	Reason   string // 失败原因
+}
+
+// VerifyDKIM 验证邮件中的所有DKIM签名（RFC 6376），没有签名时返回空列表
+func VerifyDKIM(ctx context.Context, resolver Resolver, rawData string) []DKIMResult {
+	message := toCRLF(rawData)
+	header, body := splitMessage(message)
+	fields := parseHeaderFields(header)
+
+	var results []DKIMResult
+	for _, field := range fields {
+		name, _, _ := strings.Cut(field, ":")
+		if !strings.EqualFold(strings.TrimSpace(name), "DKIM-Signature") {
+			continue
+		}
+		if len(results) >= dkimMaxSignatures {
+			break
+		}
+		results = append(results, verifySignature(ctx, resolver, field, fields, body))
+	}
+	return results
+}
+
+// verifySignature 验证单个 DKIM-Signature 头
+func verifySignature(ctx context.Context, resolver Resolver, sigField string, fields []string, body string) DKIMResult {
+	_, value, _ := strings.Cut(sigField, ":")
+	tags, err := parseTagList(value)
+	if err != nil {
+		return DKIMResult{Result: ResultPermError, Reason: err.Error()}
+	}
+
+	result := DKIMResult{Domain: strings.ToLower(tags["d"]), Selector: tags["s"]}
+	fail := func(res, format string, args ...interface{}) DKIMResult {
+		result.Result = res
+		result.Reason = fmt.Sprintf(format, args...)
+		return result
+	}
+
+	for _, required := range []string{"v", "a", "b", "bh", "d", "h", "s"} {
+		if _, ok := tags[required]; !ok {
+			return fail(ResultPermError, "missing %s= tag", required)
+		}
+	}
+	if tags["v"] != "1" {
+		return fail(ResultPermError, "unsupported version %s", tags["v"])
+	}
+
+	var signedHeaders []string
+	for _, h := range strings.Split(tags["h"], ":") {
+		signedHeaders = append(signedHeaders, strings.ToLower(strings.TrimSpace(h)))
+	}
+	hasFrom := false
+	for _, h := range signedHeaders {
+		if h == "from" {
+			hasFrom = true
+		}
+	}
+	if !hasFrom {
+		return fail(ResultPermError, "From header not signed")
+	}
+
+	if x, ok := tags["x"]; ok {
+		expires, err := strconv.ParseInt(x, 10, 64)
+		if err == nil && time.Now().Unix() > expires {
+			return fail(ResultFail, "signature expired")
+		}
+	}
+
+	headerCanon, bodyCanon := "simple", "simple"
+	if c, ok := tags["c"]; ok {
+		h, b, hasBody := strings.Cut(strings.ToLower(c), "/")
+		headerCanon = h
+		if hasBody {
+			bodyCanon = b
+		}
+	}
+	if (headerCanon != "simple" && headerCanon != "relaxed") || (bodyCanon != "simple" && bodyCanon != "relaxed") {
+		return fail(ResultPermError, "unsupported canonicalization %s", tags["c"])
+	}
+
+	algorithm := strings.ToLower(tags["a"])
+	if algorithm != "rsa-sha256" && algorithm != "ed25519-sha256" {
+		// rsa-sha1 已不安全（RFC 8301）
+		return fail(ResultPermError, "unsupported algorithm %s", algorithm)
+	}
+
+	// 校验邮件体哈希
+	canonicalBody := canonicalBodySimple(body)
+	if bodyCanon == "relaxed" {
+		canonicalBody = canonicalBodyRelaxed(body)
+	}
+	if l, ok := tags["l"]; ok {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 0 {
+			return fail(ResultPermError, "invalid l= tag")
+		}
+		if n < len(canonicalBody) {
+			canonicalBody = canonicalBody[:n]
+		}
+	}
+	bodyHash := sha256.Sum256([]byte(canonicalBody))
+	expected, err := base64.StdEncoding.DecodeString(tags["bh"])
+	if err != nil {
+		return fail(ResultPermError, "invalid bh= tag")
+	}
+	if !bytes.Equal(bodyHash[:], expected) {
+		return fail(ResultFail, "body hash mismatch")
+	}
+
+	// 按 h= 顺序拼接签名的邮件头，同名头部从下往上选取
+	canonHeader := canonicalHeaderSimple
+	if headerCanon == "relaxed" {
+		canonHeader = canonicalHeaderRelaxed
+	}
+	var data strings.Builder
+	used := make(map[string]int)
+	for _, name := range signedHeaders {
+		field, ok := lastHeaderField(fields, name, used[name])
+		if !ok {
+			continue
+		}
+		used[name]++
+		data.WriteString(canonHeader(field))
+		data.WriteString("\r\n")
+	}
+	data.WriteString(canonHeader(bTagPattern.ReplaceAllString(sigField, "$1")))
+
+	signature, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		return fail(ResultPermError, "invalid b= tag")
+	}
+
+	key, res, err := lookupDKIMKey(ctx, resolver, result.Selector, result.Domain)
+	if err != nil {
+		return fail(res, "%v", err)
+	}
+
+	digest := sha256.Sum256([]byte(data.String()))
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if algorithm != "rsa-sha256" {
+			return fail(ResultPermError, "key type does not match algorithm")
+		}
+		if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], signature); err != nil {
+			return fail(ResultFail, "signature verification failed")
+		}
+	case ed25519.PublicKey:
+		if algorithm != "ed25519-sha256" {
+			return fail(ResultPermError, "key type does not match algorithm")
+		}
+		if !ed25519.Verify(k, digest[:], signature) {
+			return fail(ResultFail, "signature verification failed")
+		}
+	}
+
+	result.Result = ResultPass
+	return result
+}
+
+// lookupDKIMKey 查询 selector._domainkey.domain 的公钥，失败时同时返回对应的结果
+func lookupDKIMKey(ctx context.Context, resolver Resolver, selector, domain string) (crypto.PublicKey, string, error) {
+	name := selector + "._domainkey." + domain
+	txts, err := resolver.LookupTXT(ctx, name)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, ResultPermError, fmt.Errorf("no key for signature at %s", name)
+		}
+		return nil, ResultTempError, fmt.Errorf("key lookup failed: %v", err)
+	}
+
+	for _, txt := range txts {
+		tags, err := parseTagList(txt)
+		if err != nil {
+			continue
+		}
+		if v, ok := tags["v"]; ok && v != "DKIM1" {
+			continue
+		}
+		p, ok := tags["p"]
+		if !ok {
+			continue
+		}
+		if p == "" {
+			return nil, ResultFail, fmt.Errorf("key revoked")
+		}
+		der, err := base64.StdEncoding.DecodeString(p)
+		if err != nil {
+			return nil, ResultPermError, fmt.Errorf("invalid key encoding")
+		}
+
+		switch strings.ToLower(tags["k"]) {
+		case "", "rsa":
+			if pub, err := x509.ParsePKIXPublicKey(der); err == nil {
+				if rsaKey, ok := pub.(*rsa.PublicKey); ok {
+					return rsaKey, "", nil
+				}
+			}
+			// 部分域名发布的是 PKCS#1 格式
+			if rsaKey, err := x509.ParsePKCS1PublicKey(der); err == nil {
+				return rsaKey, "", nil
+			}
+			return nil, ResultPermError, fmt.Errorf("invalid RSA key")
+		case "ed25519":
+			if len(der) != ed25519.PublicKeySize {
+				return nil, ResultPermError, fmt.Errorf("invalid ed25519 key")
+			}
+			return ed25519.PublicKey(der), "", nil
+		default:
+			return nil, ResultPermError, fmt.Errorf("unsupported key type %s", tags["k"])
+		}
+	}
+	return nil, ResultPermError, fmt.Errorf("no valid key record at %s", name)
+}
+
+// parseTagList 解析 "tag=value; tag=value" 格式，值中的空白会被去除
+func parseTagList(s string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, part := range strings.Split(s, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("malformed tag %q", part)
+		}
+		name = strings.TrimSpace(name)
+		if _, dup := tags[name]; dup {
+			return nil, fmt.Errorf("duplicate tag %s", name)
+		}
+		tags[name] = strings.Join(strings.Fields(value), "")
+	}
+	return tags, nil
+}
+
+// canonicalHeaderSimple simple 头部规范化：保持原样
+func canonicalHeaderSimple(field string) string {
+	return field
+}
+
+// canonicalBodySimple simple 邮件体规范化：去掉结尾空行，空邮件体视为一个CRLF
+func canonicalBodySimple(body string) string {
+	for strings.HasSuffix(body, "\r\n\r\n") {
+		body = strings.TrimSuffix(body, "\r\n")
+	}
+	if body == "" || body == "\r\n" {
+		return "\r\n"
+	}
+	if !strings.HasSuffix(body, "\r\n") {
+		body += "\r\n"
+	}
+	return body
+}
@@ -0,0 +1,114 @@
+package smtp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/textproto"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// enhancedCodePattern 匹配响应文本开头的增强状态码（RFC 3463）
+var enhancedCodePattern = regexp.MustCompile(`^[245]\.\d{1,3}\.\d{1,3}`)
+
+// Bounce 退信（DSN）所需信息
+type Bounce struct {
+	ReportingMTA string    // 生成退信的主机名，如 mail.example.com
+	Sender       string    // 原邮件的信封发件人，即退信收件人
+	Recipient    string    // 投递失败的收件人
+	Reason       error     // 最后一次投递错误
+	ArrivalDate  time.Time // 原邮件进入队列的时间
+	Original     string    // 原始邮件数据（只附带邮件头）
+}
+
+// NewBounceMessage 生成 RFC 3464 格式的退信邮件
+func NewBounceMessage(b *Bounce) string {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	status, diagnostic := dsnStatus(b.Reason)
+
+	// 第一部分：人类可读的说明
+	textHeader := textproto.MIMEHeader{}
+	textHeader.Set("Content-Type", "text/plain; charset=UTF-8")
+	textHeader.Set("Content-Transfer-Encoding", "8bit")
+	part, _ := writer.CreatePart(textHeader)
+	fmt.Fprintf(part, "这是来自 %s 的邮件投递系统的自动通知。\r\n\r\n", b.ReportingMTA)
+	fmt.Fprintf(part, "很抱歉，您的邮件无法投递到以下收件人：\r\n\r\n    <%s>: %s\r\n\r\n", b.Recipient, diagnostic)
+	fmt.Fprintf(part, "This is the mail system at host %s.\r\n\r\n", b.ReportingMTA)
+	fmt.Fprintf(part, "Your message could not be delivered to one or more recipients.\r\n\r\n    <%s>: %s\r\n", b.Recipient, diagnostic)
+
+	// 第二部分：机器可读的投递状态
+	statusHeader := textproto.MIMEHeader{}
+	statusHeader.Set("Content-Type", "message/delivery-status")
+	part, _ = writer.CreatePart(statusHeader)
+	fmt.Fprintf(part, "Reporting-MTA: dns; %s\r\n", b.ReportingMTA)
+	fmt.Fprintf(part, "Arrival-Date: %s\r\n", b.ArrivalDate.Format(time.RFC1123Z))
+	fmt.Fprintf(part, "\r\n")
+	fmt.Fprintf(part, "Final-Recipient: rfc822; %s\r\n", b.Recipient)
+	fmt.Fprintf(part, "Action: failed\r\n")
+	fmt.Fprintf(part, "Status: %s\r\n", status)
+	fmt.Fprintf(part, "Diagnostic-Code: smtp; %s\r\n", diagnostic)
+
+	// 第三部分：原邮件头
+	headersHeader := textproto.MIMEHeader{}
+	headersHeader.Set("Content-Type", "text/rfc822-headers")
+	part, _ = writer.CreatePart(headersHeader)
+	part.Write([]byte(originalHeaders(b.Original)))
+
+	writer.Close()
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: Mail Delivery System <MAILER-DAEMON@%s>\r\n", b.ReportingMTA)
+	fmt.Fprintf(&msg, "To: <%s>\r\n", b.Sender)
+	fmt.Fprintf(&msg, "Subject: Undelivered Mail Returned to Sender\r\n")
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Message-ID: <%s@%s>\r\n", randomID(), b.ReportingMTA)
+	fmt.Fprintf(&msg, "Auto-Submitted: auto-replied\r\n")
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/report; report-type=delivery-status; boundary=\"%s\"\r\n", writer.Boundary())
+	fmt.Fprintf(&msg, "\r\n")
+	msg.Write(body.Bytes())
+	return msg.String()
+}
+
+// dsnStatus 从投递错误中提取增强状态码和诊断信息
+func dsnStatus(err error) (string, string) {
+	if err == nil {
+		return "5.0.0", "unknown error"
+	}
+
+	var tpErr *textproto.Error
+	if errors.As(err, &tpErr) {
+		diagnostic := fmt.Sprintf("%d %s", tpErr.Code, tpErr.Msg)
+		if code := enhancedCodePattern.FindString(tpErr.Msg); code != "" {
+			return code, diagnostic
+		}
+		return fmt.Sprintf("%d.0.0", tpErr.Code/100), diagnostic
+	}
+
+	return "5.0.0", strings.ReplaceAll(err.Error(), "\n", " ")
+}
+
+// originalHeaders 截取原邮件的头部
+func originalHeaders(raw string) string {
+	if i := strings.Index(raw, "\r\n\r\n"); i >= 0 {
+		return raw[:i+2]
+	}
+	if i := strings.Index(raw, "\n\n"); i >= 0 {
+		return raw[:i+1]
+	}
+	return raw
+}
+
+// randomID 生成随机标识，用于 Message-ID
+func randomID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
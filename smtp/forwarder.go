@@ -1,100 +1,89 @@
 package smtp
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"net/smtp"
+	"net/textproto"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
 
-// MailForwarder 邮件转发器
-type MailForwarder struct {
-	localDomain string
-}
+const (
+	// dnsTimeout 单次DNS查询超时
+	dnsTimeout = 15 * time.Second
+	// dialTimeout 连接目标服务器超时
+	dialTimeout = 30 * time.Second
+	// deliveryTimeout 单次SMTP会话的总超时
+	deliveryTimeout = 10 * time.Minute
+)
 
-// 邮箱服务商端口配置
-var providerPorts = map[string][]int{
-	"qq.com":      {25, 587, 465},
-	"foxmail.com": {25, 587, 465},
-	"163.com":     {25, 587, 465},
-	"126.com":     {25, 587, 465},
-	"sina.com":    {25, 587, 465},
-	"sohu.com":    {25, 587},
-	"gmail.com":   {587, 465},
-	"outlook.com": {587, 25},
-	"live.com":    {587, 25},
-	"hotmail.com": {587, 25},
-	"yahoo.com":   {587, 465},
-	"icloud.com":  {587, 25},
-	"mail.ru":     {25, 587, 465},
-	"yandex.com":  {25, 587, 465},
-	// 默认端口（如果服务商不在列表中）
-	"default": {25, 587, 465, 2525},
+// Resolver 投递和发件人验证使用的DNS查询接口，*net.Resolver 即满足该接口，测试时可替换为假DNS
+type Resolver interface {
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+	LookupTXT(ctx context.Context, name string) ([]string, error)
 }
 
-// NewMailForwarder 创建邮件转发器
-func NewMailForwarder(localDomain string) *MailForwarder {
-	return &MailForwarder{
-		localDomain: localDomain,
-	}
+// MailForwarder 邮件转发器，按MX记录直接投递到收件人域名的邮件服务器
+type MailForwarder struct {
+	localDomain string
+	Hostname    string        // EHLO 使用的主机名
+	Resolver    Resolver      // DNS查询
+	Port        int           // 目标服务器端口，MTA之间固定为25
+	TLSConfig   *tls.Config   // 机会性STARTTLS使用的配置，为空时使用默认配置
+	Signer      MessageSigner // DKIM签名，为空时不签名
 }
 
-// getPortsForDomain 根据域名获取推荐的SMTP端口
-func (f *MailForwarder) getPortsForDomain(domain string) []int {
-	// 检查是否有匹配的邮箱服务商
-	for provider, ports := range providerPorts {
-		if strings.Contains(strings.ToLower(domain), provider) {
-			log.Printf("[Forwarder] 检测到邮箱服务商: %s, 使用端口: %v", provider, ports)
-			return ports
-		}
-	}
-
-	// 如果没有匹配，使用默认端口
-	log.Printf("[Forwarder] 未知邮箱服务商: %s, 使用默认端口: %v", domain, providerPorts["default"])
-	return providerPorts["default"]
+// permanentError 无需重试的投递错误
+type permanentError struct {
+	err error
 }
 
-// checkPortAvailability 检查端口是否可用
-func (f *MailForwarder) checkPortAvailability(host string, port int, timeout time.Duration) bool {
-	addr := fmt.Sprintf("%s:%d", host, port)
+func (e *permanentError) Error() string { return e.err.Error() }
 
-	conn, err := net.DialTimeout("tcp", addr, timeout)
-	if err != nil {
-		log.Printf("[Forwarder] 端口 %d 不可用: %v", port, err)
-		return false
-	}
-	conn.Close()
-
-	log.Printf("[Forwarder] ✓ 端口 %d 可用", port)
-	return true
-}
+func (e *permanentError) Unwrap() error { return e.err }
 
-// findAvailablePorts 找到可用的端口
-func (f *MailForwarder) findAvailablePorts(host string, ports []int) []int {
-	var availablePorts []int
-
-	for _, port := range ports {
-		if f.checkPortAvailability(host, port, 2*time.Second) {
-			availablePorts = append(availablePorts, port)
-		}
+// IsPermanentError 判断投递错误是否为永久性错误（5xx响应或地址无效），永久错误不再重试
+func IsPermanentError(err error) bool {
+	var pErr *permanentError
+	if errors.As(err, &pErr) {
+		return true
 	}
+	var tpErr *textproto.Error
+	return errors.As(err, &tpErr) && tpErr.Code >= 500
+}
 
-	if len(availablePorts) == 0 {
-		log.Printf("[Forwarder] ⚠️  所有端口都不可用")
-		return []int{25} // 至少尝试25端口
+// NewMailForwarder 创建邮件转发器
+func NewMailForwarder(localDomain string) *MailForwarder {
+	return &MailForwarder{
+		localDomain: localDomain,
+		Hostname:    localDomain,
+		Resolver:    net.DefaultResolver,
+		Port:        25,
 	}
-
-	log.Printf("[Forwarder] ✓ 找到可用端口: %v", availablePorts)
-	return availablePorts
 }
 
 // Forward 转发邮件到外部邮箱服务器
 func (f *MailForwarder) Forward(from string, to string, rawData string) error {
 	// 检查是否是本地域名
 	if f.isLocalDomain(to) {
-		return fmt.Errorf("cannot forward to local domain: %s", to)
+		return &permanentError{fmt.Errorf("cannot forward to local domain: %s", to)}
+	}
+
+	if f.Signer != nil {
+		signed, err := f.Signer.Sign(rawData)
+		if err != nil {
+			log.Printf("[Forwarder] DKIM签名失败，发送未签名邮件: %v", err)
+		} else {
+			rawData = signed
+		}
 	}
 
 	// 使用直接转发
@@ -106,240 +95,187 @@ func (f *MailForwarder) sendDirect(from string, to string, rawData string) error
 	// 提取收件人域名
 	domain := f.extractDomain(to)
 	if domain == "" {
-		return fmt.Errorf("invalid email address: %s", to)
+		return &permanentError{fmt.Errorf("invalid email address: %s", to)}
 	}
 
 	log.Printf("[Forwarder] 准备转发邮件到外部邮箱: %s (域名: %s)", to, domain)
 
-	// 查询MX记录
-	mxRecords, err := net.LookupMX(domain)
+	hosts, implicit, err := f.lookupHosts(domain)
 	if err != nil {
-		log.Printf("[Forwarder] 查询MX记录失败: %v, 尝试使用域名直连", err)
-		// 如果MX记录查询失败，尝试直接使用域名
-		return f.sendToServerDirect(domain, from, to, rawData)
+		return err
 	}
 
-	if len(mxRecords) == 0 {
-		return fmt.Errorf("no MX records found for domain: %s", domain)
-	}
-
-	// 按优先级排序，尝试每个MX记录
-	log.Printf("[Forwarder] 找到 %d 个MX记录", len(mxRecords))
-	for _, mx := range mxRecords {
-		log.Printf("[Forwarder] 尝试MX服务器: %s (优先级: %d)", mx.Host, mx.Pref)
-		err = f.sendToServerDirect(strings.TrimSuffix(mx.Host, "."), from, to, rawData)
+	// 按优先级依次尝试每个MX服务器
+	var lastErr error
+	for _, host := range hosts {
+		log.Printf("[Forwarder] 尝试MX服务器: %s", host)
+		err := f.sendToHost(host, implicit, from, to, rawData)
 		if err == nil {
-			log.Printf("[Forwarder] ✓ 邮件成功转发到: %s", mx.Host)
+			log.Printf("[Forwarder] ✓ 邮件成功转发到: %s", host)
 			return nil
 		}
+		// 服务器明确拒绝时不再尝试其他MX
+		if IsPermanentError(err) {
+			return err
+		}
 		log.Printf("[Forwarder] ✗ 发送失败: %v, 尝试下一个MX服务器", err)
+		lastErr = err
 	}
 
-	return fmt.Errorf("failed to forward to all MX servers for domain: %s", domain)
+	return fmt.Errorf("failed to forward to all MX servers for domain %s: %w", domain, lastErr)
 }
 
-// sendToServerDirect 使用智能端口检测发送邮件到指定SMTP服务器
-func (f *MailForwarder) sendToServerDirect(host string, from string, to string, rawData string) error {
-	// 提取域名用于端口检测
-	domain := f.extractDomain(to)
+// lookupHosts 查询域名的邮件服务器，按MX优先级排序；没有MX记录时使用域名本身（RFC 5321 5.1），此时 implicit 为 true
+func (f *MailForwarder) lookupHosts(domain string) (hosts []string, implicit bool, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dnsTimeout)
+	defer cancel()
 
-	// 获取该邮箱服务商推荐的端口
-	recommendedPorts := f.getPortsForDomain(domain)
+	mxRecords, lookupErr := f.Resolver.LookupMX(ctx, domain)
+	if lookupErr != nil && !isNotFound(lookupErr) {
+		return nil, false, fmt.Errorf("failed to lookup MX for %s: %w", domain, lookupErr)
+	}
 
-	// 检测哪些端口可用
-	availablePorts := f.findAvailablePorts(host, recommendedPorts)
+	if len(mxRecords) == 0 {
+		log.Printf("[Forwarder] %s 没有MX记录，使用A/AAAA记录", domain)
+		return []string{domain}, true, nil
+	}
 
-	var lastErr error
-	for _, port := range availablePorts {
-		addr := fmt.Sprintf("%s:%d", host, port)
-		log.Printf("[Forwarder] 尝试连接 %s", addr)
+	sort.SliceStable(mxRecords, func(i, j int) bool {
+		return mxRecords[i].Pref < mxRecords[j].Pref
+	})
 
-		// 使用较短的超时时间快速失败
-		conn, err := net.DialTimeout("tcp", addr, 3*time.Second)
-		if err != nil {
-			lastErr = fmt.Errorf("连接失败: %v", err)
-			log.Printf("[Forwarder] ✗ %s", lastErr.Error())
+	for _, mx := range mxRecords {
+		host := strings.TrimSuffix(mx.Host, ".")
+		if host == "" {
 			continue
 		}
+		log.Printf("[Forwarder] MX记录: %s (优先级: %d)", host, mx.Pref)
+		hosts = append(hosts, host)
+	}
 
-		// 设置连接超时
-		conn.SetDeadline(time.Now().Add(8 * time.Second))
-
-		// 创建SMTP客户端
-		client, err := smtp.NewClient(conn, host)
-		if err != nil {
-			conn.Close()
-			lastErr = fmt.Errorf("创建SMTP客户端失败: %v", err)
-			log.Printf("[Forwarder] ✗ %s", lastErr.Error())
-			continue
-		}
+	// Null MX（RFC 7505）：唯一的MX记录为 "."，域名声明不接收邮件
+	if len(hosts) == 0 {
+		return nil, false, &permanentError{&textproto.Error{Code: 556, Msg: "5.1.10 Domain " + domain + " does not accept mail (null MX)"}}
+	}
+	return hosts, false, nil
+}
 
-		// 发送HELO/EHLO
-		if err = client.Hello(f.localDomain); err != nil {
-			client.Close()
-			lastErr = fmt.Errorf("HELO失败: %v", err)
-			log.Printf("[Forwarder] ✗ %s", lastErr.Error())
-			continue
-		}
+// sendToHost 依次连接主机的每个IP地址投递邮件，implicit 表示主机是没有MX记录时回退的域名本身
+func (f *MailForwarder) sendToHost(host string, implicit bool, from string, to string, rawData string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dnsTimeout)
+	defer cancel()
 
-		// 根据端口尝试TLS
-		if port == 587 {
-			tlsOk, _ := client.Extension("STARTTLS")
-			if tlsOk {
-				if err = client.StartTLS(nil); err != nil {
-					log.Printf("[Forwarder] STARTTLS失败: %v，尝试不加密连接", err)
-					// 继续尝试不加密连接
-				} else {
-					log.Printf("[Forwarder] ✓ TLS已启动")
-				}
+	addrs, err := f.Resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		if isNotFound(err) {
+			// 没有MX时域名本身也没有地址，说明域名不存在
+			if !implicit {
+				return fmt.Errorf("MX host %s has no address: %w", host, err)
 			}
-		} else if port == 465 {
-			// 465端口通常使用SSL/TLS
-			log.Printf("[Forwarder] 465端口需要SSL，当前实现不支持，跳过")
-			client.Close()
-			continue
-		}
-
-		// 设置发件人
-		if err = client.Mail(from); err != nil {
-			client.Close()
-			lastErr = fmt.Errorf("MAIL FROM失败: %v", err)
-			log.Printf("[Forwarder] ✗ %s", lastErr.Error())
-			continue
+			return &permanentError{fmt.Errorf("domain %s has no MX or A/AAAA records", host)}
 		}
+		return fmt.Errorf("failed to lookup address for %s: %w", host, err)
+	}
+	if len(addrs) == 0 {
+		return fmt.Errorf("no address found for %s", host)
+	}
 
-		// 设置收件人
-		if err = client.Rcpt(to); err != nil {
-			client.Close()
-			lastErr = fmt.Errorf("RCPT TO失败: %v", err)
-			log.Printf("[Forwarder] ✗ %s", lastErr.Error())
-			continue
+	var lastErr error
+	for _, addr := range addrs {
+		err := f.sendToAddr(host, addr.IP.String(), from, to, rawData, true)
+		if errors.Is(err, errStartTLSFailed) {
+			// 机会性TLS：握手失败时回退到明文重新投递
+			log.Printf("[Forwarder] %s STARTTLS失败，使用不加密连接重试", host)
+			err = f.sendToAddr(host, addr.IP.String(), from, to, rawData, false)
 		}
-
-		// 发送邮件数据
-		wc, err := client.Data()
-		if err != nil {
-			client.Close()
-			lastErr = fmt.Errorf("DATA失败: %v", err)
-			log.Printf("[Forwarder] ✗ %s", lastErr.Error())
-			continue
+		if err == nil {
+			return nil
 		}
-
-		_, err = wc.Write([]byte(rawData))
-		if err != nil {
-			wc.Close()
-			client.Close()
-			lastErr = fmt.Errorf("发送数据失败: %v", err)
-			log.Printf("[Forwarder] ✗ %s", lastErr.Error())
-			continue
+		if IsPermanentError(err) {
+			return err
 		}
-
-		wc.Close()
-		client.Quit()
-		log.Printf("[Forwarder] ✓ 邮件发送成功到 %s:%d", host, port)
-		return nil
+		log.Printf("[Forwarder] ✗ %s (%s): %v", host, addr.IP, err)
+		lastErr = err
 	}
-
 	return lastErr
 }
 
-// sendToServer 发送邮件到指定SMTP服务器
-func (f *MailForwarder) sendToServer(host string, from string, to string, rawData string) error {
-	// 尝试更多端口和连接策略
-	ports := []int{25, 587, 465, 2525}
+// errStartTLSFailed STARTTLS握手失败，连接已不可用
+var errStartTLSFailed = errors.New("STARTTLS failed")
 
-	var lastErr error
-	for _, port := range ports {
-		addr := fmt.Sprintf("%s:%d", host, port)
-		log.Printf("[Forwarder] 连接到 %s", addr)
+// sendToAddr 连接指定IP的SMTP服务器投递邮件，服务器支持时启用STARTTLS
+func (f *MailForwarder) sendToAddr(host, ip string, from string, to string, rawData string, tryTLS bool) error {
+	addr := net.JoinHostPort(ip, strconv.Itoa(f.Port))
+	log.Printf("[Forwarder] 连接 %s (%s)", host, addr)
 
-		// 使用更短的超时时间快速失败
-		conn, err := net.DialTimeout("tcp", addr, 3*time.Second)
-		if err != nil {
-			lastErr = fmt.Errorf("连接失败: %v", err)
-			log.Printf("[Forwarder] ✗ %s", lastErr.Error())
-			continue
-		}
-
-		// 设置连接超时
-		conn.SetDeadline(time.Now().Add(8 * time.Second))
+	conn, err := net.DialTimeout("tcp", addr, dialTimeout)
+	if err != nil {
+		return fmt.Errorf("连接失败: %w", err)
+	}
+	conn.SetDeadline(time.Now().Add(deliveryTimeout))
 
-		// 创建SMTP客户端
-		client, err := smtp.NewClient(conn, host)
-		if err != nil {
-			conn.Close()
-			lastErr = fmt.Errorf("创建SMTP客户端失败: %v", err)
-			log.Printf("[Forwarder] ✗ %s", lastErr.Error())
-			continue
-		}
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("创建SMTP客户端失败: %w", err)
+	}
+	defer client.Close()
 
-		// 发送HELO/EHLO
-		if err = client.Hello(f.localDomain); err != nil {
-			client.Close()
-			lastErr = fmt.Errorf("HELO失败: %v", err)
-			log.Printf("[Forwarder] ✗ %s", lastErr.Error())
-			continue
-		}
+	if err = client.Hello(f.Hostname); err != nil {
+		return fmt.Errorf("HELO失败: %w", err)
+	}
 
-		// 根据端口尝试TLS
-		if port == 587 {
-			tlsOk, _ := client.Extension("STARTTLS")
-			if tlsOk {
-				if err = client.StartTLS(nil); err != nil {
-					log.Printf("[Forwarder] STARTTLS失败: %v，尝试不加密连接", err)
-					// 继续尝试不加密连接
-				} else {
-					log.Printf("[Forwarder] ✓ TLS已启动")
-				}
-			}
-		} else if port == 465 {
-			// 465端口通常使用SSL/TLS
-			log.Printf("[Forwarder] 465端口需要SSL，当前实现不支持，跳过")
-			client.Close()
-			continue
+	if ok, _ := client.Extension("STARTTLS"); ok && tryTLS {
+		if err = client.StartTLS(f.tlsConfig(host)); err != nil {
+			return fmt.Errorf("%w: %v", errStartTLSFailed, err)
 		}
+		log.Printf("[Forwarder] ✓ TLS已启动")
+	}
 
-		// 设置发件人
-		if err = client.Mail(from); err != nil {
-			client.Close()
-			lastErr = fmt.Errorf("MAIL FROM失败: %v", err)
-			log.Printf("[Forwarder] ✗ %s", lastErr.Error())
-			continue
-		}
+	if err = client.Mail(from); err != nil {
+		return fmt.Errorf("MAIL FROM失败: %w", err)
+	}
+	if err = client.Rcpt(to); err != nil {
+		return fmt.Errorf("RCPT TO失败: %w", err)
+	}
 
-		// 设置收件人
-		if err = client.Rcpt(to); err != nil {
-			client.Close()
-			lastErr = fmt.Errorf("RCPT TO失败: %v", err)
-			log.Printf("[Forwarder] ✗ %s", lastErr.Error())
-			continue
-		}
+	wc, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("DATA失败: %w", err)
+	}
+	if _, err = wc.Write([]byte(rawData)); err != nil {
+		wc.Close()
+		return fmt.Errorf("发送数据失败: %w", err)
+	}
+	if err = wc.Close(); err != nil {
+		return fmt.Errorf("邮件提交失败: %w", err)
+	}
 
-		// 发送邮件数据
-		wc, err := client.Data()
-		if err != nil {
-			client.Close()
-			lastErr = fmt.Errorf("DATA失败: %v", err)
-			log.Printf("[Forwarder] ✗ %s", lastErr.Error())
-			continue
-		}
+	client.Quit()
+	log.Printf("[Forwarder] ✓ 邮件发送成功到 %s", addr)
+	return nil
+}
 
-		_, err = wc.Write([]byte(rawData))
-		if err != nil {
-			wc.Close()
-			client.Close()
-			lastErr = fmt.Errorf("发送数据失败: %v", err)
-			log.Printf("[Forwarder] ✗ %s", lastErr.Error())
-			continue
+// tlsConfig 机会性STARTTLS的配置：不校验证书，只防止被动窃听（RFC 7435）
+func (f *MailForwarder) tlsConfig(host string) *tls.Config {
+	if f.TLSConfig != nil {
+		config := f.TLSConfig.Clone()
+		if config.ServerName == "" {
+			config.ServerName = host
 		}
-
-		wc.Close()
-		client.Quit()
-		log.Printf("[Forwarder] ✓ 邮件发送成功到 %s:%d", host, port)
-		return nil
+		return config
+	}
+	return &tls.Config{
+		ServerName:         host,
+		InsecureSkipVerify: true,
+		MinVersion:         tls.VersionTLS12,
 	}
+}
 
-	return lastErr
+// isNotFound 判断DNS错误是否为记录不存在（NXDOMAIN或无该类型记录）
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
 }
 
 // isLocalDomain 检查是否是本地域名
@@ -350,8 +286,9 @@ func (f *MailForwarder) isLocalDomain(email string) bool {
 		return false
 	}
 	domain := strings.ToLower(parts[1])
-	// 检查是否是本地域名
-	return domain == strings.ToLower(f.localDomain)
+	// 检查是否是本地域名（包括生成的子域名）
+	localDomain := strings.ToLower(f.localDomain)
+	return domain == localDomain || strings.HasSuffix(domain, "."+localDomain)
 }
 
 // extractDomain 从邮箱地址提取域名
@@ -0,0 +1,228 @@
+package smtp
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+)
+
+// fakeResolver 内存中的DNS，未配置的名字返回 NXDOMAIN
+type fakeResolver struct {
+	mx   map[string][]*net.MX
+	ip   map[string][]net.IPAddr
+	txt  map[string][]string
+	fail map[string]bool // 这些名字的查询返回临时错误
+}
+
+func newFakeResolver() *fakeResolver {
+	return &fakeResolver{
+		mx:   make(map[string][]*net.MX),
+		ip:   make(map[string][]net.IPAddr),
+		txt:  make(map[string][]string),
+		fail: make(map[string]bool),
+	}
+}
+
+func (r *fakeResolver) lookup(name string) error {
+	if r.fail[name] {
+		return &net.DNSError{Err: "server misbehaving", Name: name, IsTemporary: true}
+	}
+	return &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (r *fakeResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	name = strings.TrimSuffix(name, ".")
+	if records, ok := r.mx[name]; ok && !r.fail[name] {
+		return records, nil
+	}
+	return nil, r.lookup(name)
+}
+
+func (r *fakeResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	host = strings.TrimSuffix(host, ".")
+	if addrs, ok := r.ip[host]; ok && !r.fail[host] {
+		return addrs, nil
+	}
+	return nil, r.lookup(host)
+}
+
+func (r *fakeResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	name = strings.TrimSuffix(name, ".")
+	if records, ok := r.txt[name]; ok && !r.fail[name] {
+		return records, nil
+	}
+	return nil, r.lookup(name)
+}
+
+func ipAddrs(ips ...string) []net.IPAddr {
+	addrs := make([]net.IPAddr, 0, len(ips))
+	for _, ip := range ips {
+		addrs = append(addrs, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+	return addrs
+}
+
+func TestLookupHostsOrdersByPreference(t *testing.T) {
+	resolver := newFakeResolver()
+	resolver.mx["example.org"] = []*net.MX{
+		{Host: "mx3.example.org.", Pref: 30},
+		{Host: "mx1.example.org.", Pref: 10},
+		{Host: "mx2a.example.org.", Pref: 20},
+		{Host: "mx2b.example.org.", Pref: 20},
+	}
+	f := NewMailForwarder("test.local")
+	f.Resolver = resolver
+
+	hosts, implicit, err := f.lookupHosts("example.org")
+	if err != nil {
+		t.Fatalf("lookupHosts: %v", err)
+	}
+	if implicit {
+		t.Errorf("implicit = true for a domain with MX records")
+	}
+	want := "mx1.example.org mx2a.example.org mx2b.example.org mx3.example.org"
+	if got := strings.Join(hosts, " "); got != want {
+		t.Errorf("hosts = %q, want %q", got, want)
+	}
+}
+
+func TestLookupHostsFallsBackToDomain(t *testing.T) {
+	resolver := newFakeResolver()
+	resolver.ip["example.org"] = ipAddrs("192.0.2.1")
+	f := NewMailForwarder("test.local")
+	f.Resolver = resolver
+
+	hosts, implicit, err := f.lookupHosts("example.org")
+	if err != nil {
+		t.Fatalf("lookupHosts: %v", err)
+	}
+	if !implicit || len(hosts) != 1 || hosts[0] != "example.org" {
+		t.Errorf("hosts = %v, implicit = %v; want [example.org], true", hosts, implicit)
+	}
+}
+
+func TestLookupHostsNullMX(t *testing.T) {
+	resolver := newFakeResolver()
+	resolver.mx["example.org"] = []*net.MX{{Host: ".", Pref: 0}}
+	f := NewMailForwarder("test.local")
+	f.Resolver = resolver
+
+	_, _, err := f.lookupHosts("example.org")
+	if err == nil {
+		t.Fatal("null MX accepted")
+	}
+	if !IsPermanentError(err) {
+		t.Errorf("null MX error is not permanent: %v", err)
+	}
+}
+
+func TestLookupHostsTemporaryFailure(t *testing.T) {
+	resolver := newFakeResolver()
+	resolver.fail["example.org"] = true
+	f := NewMailForwarder("test.local")
+	f.Resolver = resolver
+
+	_, _, err := f.lookupHosts("example.org")
+	if err == nil || IsPermanentError(err) {
+		t.Errorf("DNS failure should be a temporary error, got %v", err)
+	}
+}
+
+func TestForwardNoMXAndNoAddressIsPermanent(t *testing.T) {
+	f := NewMailForwarder("test.local")
+	f.Resolver = newFakeResolver()
+
+	err := f.Forward("a@test.local", "b@nowhere.example", "Subject: hi\r\n\r\nbody\r\n")
+	if err == nil || !IsPermanentError(err) {
+		t.Errorf("Forward to a domain without MX or A = %v, want permanent error", err)
+	}
+}
+
+func TestForwardRejectsLocalDomain(t *testing.T) {
+	f := NewMailForwarder("test.local")
+	f.Resolver = newFakeResolver()
+
+	if err := f.Forward("a@test.local", "b@sub.test.local", "Subject: hi\r\n\r\nbody\r\n"); !IsPermanentError(err) {
+		t.Errorf("Forward to local domain = %v, want permanent error", err)
+	}
+}
+
+// startReceiver 在本地端口上运行接收服务器，返回端口号
+func startReceiver(t *testing.T, srv *Server) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go srv.handleConnection(conn)
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestForwardFallsBackToNextMX(t *testing.T) {
+	handler := &recordingHandler{}
+	receiver := NewServer("mail.remote.example", 0, handler)
+	srvWithTLS, _ := newTLSTestServer(t)
+	receiver.TLSConfig = srvWithTLS.TLSConfig
+	port := startReceiver(t, receiver)
+
+	resolver := newFakeResolver()
+	resolver.mx["remote.example"] = []*net.MX{
+		{Host: "backup.remote.example.", Pref: 20},
+		{Host: "primary.remote.example.", Pref: 10},
+	}
+	// 主MX没有地址，应当继续尝试备用MX
+	resolver.ip["backup.remote.example"] = ipAddrs("127.0.0.1")
+
+	f := NewMailForwarder("test.local")
+	f.Resolver = resolver
+	f.Port = port
+
+	if err := f.Forward("a@test.local", "b@remote.example", "Subject: forwarded\r\n\r\nbody\r\n"); err != nil {
+		t.Fatalf("Forward: %v", err)
+	}
+
+	msgs := handler.messages()
+	if len(msgs) != 1 {
+		t.Fatalf("receiver got %d messages, want 1", len(msgs))
+	}
+	if msgs[0].From != "a@test.local" || msgs[0].Subject != "forwarded" {
+		t.Errorf("unexpected message: from=%q subject=%q", msgs[0].From, msgs[0].Subject)
+	}
+}
+
+func TestForwardStopsOnPermanentRejection(t *testing.T) {
+	receiver := NewServer("mail.remote.example", 0, &recordingHandler{})
+	receiver.Recipients = rejectAll{}
+	port := startReceiver(t, receiver)
+
+	resolver := newFakeResolver()
+	resolver.mx["remote.example"] = []*net.MX{{Host: "mx.remote.example.", Pref: 10}}
+	resolver.ip["mx.remote.example"] = ipAddrs("127.0.0.1")
+
+	f := NewMailForwarder("test.local")
+	f.Resolver = resolver
+	f.Port = port
+
+	err := f.Forward("a@test.local", "nobody@remote.example", "Subject: hi\r\n\r\nbody\r\n")
+	if err == nil || !IsPermanentError(err) {
+		t.Fatalf("Forward = %v, want permanent error", err)
+	}
+	if !strings.Contains(err.Error(), "550") {
+		t.Errorf("error %q does not carry the 550 reply", err)
+	}
+}
+
+// rejectAll 所有收件人都不存在
+type rejectAll struct{}
+
+func (rejectAll) ValidateRecipient(string) (bool, error) { return false, nil }
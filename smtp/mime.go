@@ -0,0 +1,275 @@
+package smtp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// maxMIMEDepth multipart 最大嵌套层数，防止恶意邮件无限嵌套
+const maxMIMEDepth = 10
+
+// maxMIMEParts 单封邮件最多解析的部分数量
+const maxMIMEParts = 200
+
+// ParsedMessage 解析后的邮件内容
+type ParsedMessage struct {
+	Subject     string          // 已解码的主题
+	From        *mail.Address   // 邮件头 From，无法解析时为nil
+	To          []*mail.Address // 邮件头 To
+	Cc          []*mail.Address // 邮件头 Cc
+	Text        string          // 纯文本正文（已解码为UTF-8）
+	HTML        string          // HTML正文（已解码为UTF-8）
+	Attachments []*Attachment   // 附件和内嵌资源
+}
+
+// Attachment 邮件附件或内嵌资源（如HTML中通过 cid: 引用的图片）
+type Attachment struct {
+	Filename    string
+	ContentType string
+	ContentID   string // 不含尖括号
+	Inline      bool
+	Data        []byte // 已解码的内容
+}
+
+// wordDecoder RFC 2047 编码字解码器，支持 GBK/Big5 等非UTF-8字符集
+var wordDecoder = &mime.WordDecoder{CharsetReader: charsetReader}
+
+// ParseMessage 解析MIME邮件，解码传输编码和字符集，提取主题、地址、文本、HTML正文和附件
+func ParseMessage(msg *mail.Message) (*ParsedMessage, error) {
+	p := &mimeParser{result: &ParsedMessage{
+		Subject: DecodeHeader(msg.Header.Get("Subject")),
+		To:      ParseAddressList(msg.Header.Get("To")),
+		Cc:      ParseAddressList(msg.Header.Get("Cc")),
+	}}
+	if from := ParseAddressList(msg.Header.Get("From")); len(from) > 0 {
+		p.result.From = from[0]
+	}
+	if err := p.parsePart(textproto.MIMEHeader(msg.Header), msg.Body, 0); err != nil {
+		return p.result, err
+	}
+	return p.result, nil
+}
+
+// mimeParser 解析过程中的状态
+type mimeParser struct {
+	result *ParsedMessage
+	parts  int
+}
+
+// parsePart 解析一个MIME部分，multipart 递归解析子部分
+func (p *mimeParser) parsePart(header textproto.MIMEHeader, body io.Reader, depth int) error {
+	p.parts++
+	if p.parts > maxMIMEParts {
+		return fmt.Errorf("too many MIME parts")
+	}
+
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		// 没有或无法解析 Content-Type 时按 text/plain 处理（RFC 2045 5.2）
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		if depth >= maxMIMEDepth {
+			return fmt.Errorf("MIME nesting too deep")
+		}
+		boundary := params["boundary"]
+		if boundary == "" {
+			return fmt.Errorf("multipart without boundary")
+		}
+		reader := multipart.NewReader(body, boundary)
+		for {
+			part, err := reader.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read MIME part: %v", err)
+			}
+			err = p.parsePart(part.Header, part, depth+1)
+			part.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	data, err := io.ReadAll(decodeTransferEncoding(header.Get("Content-Transfer-Encoding"), body))
+	if err != nil {
+		return fmt.Errorf("failed to decode MIME part: %v", err)
+	}
+
+	disposition, dispParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	filename := decodeFilename(dispParams["filename"])
+	if filename == "" {
+		filename = decodeFilename(params["name"])
+	}
+
+	// 没有标记为附件的 text/plain 和 text/html 作为正文
+	if disposition != "attachment" && (mediaType == "text/plain" || mediaType == "text/html") && filename == "" {
+		text := decodeCharset(data, params["charset"])
+		if mediaType == "text/html" {
+			p.result.HTML = appendBody(p.result.HTML, text)
+		} else {
+			p.result.Text = appendBody(p.result.Text, text)
+		}
+		return nil
+	}
+
+	if filename == "" && mediaType == "message/rfc822" {
+		filename = "message.eml"
+	}
+	p.result.Attachments = append(p.result.Attachments, &Attachment{
+		Filename:    filename,
+		ContentType: mediaType,
+		ContentID:   strings.Trim(strings.TrimSpace(header.Get("Content-ID")), "<>"),
+		Inline:      disposition == "inline",
+		Data:        data,
+	})
+	return nil
+}
+
+// appendBody 同一类型有多个正文部分时（如 multipart/mixed 中的多段文本）依次拼接
+func appendBody(existing, text string) string {
+	if existing == "" {
+		return text
+	}
+	return existing + "\n" + text
+}
+
+// decodeTransferEncoding 按 Content-Transfer-Encoding 解码，未知编码原样返回
+func decodeTransferEncoding(encoding string, r io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, &base64Cleaner{r: r})
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	default:
+		return r
+	}
+}
+
+// base64Cleaner 去掉 base64 内容中的换行和空白，以及部分客户端附加的无效字符
+type base64Cleaner struct {
+	r io.Reader
+}
+
+func (c *base64Cleaner) Read(p []byte) (int, error) {
+	for {
+		n, err := c.r.Read(p)
+		kept := 0
+		for _, b := range p[:n] {
+			if b >= 'A' && b <= 'Z' || b >= 'a' && b <= 'z' || b >= '0' && b <= '9' || b == '+' || b == '/' || b == '=' {
+				p[kept] = b
+				kept++
+			}
+		}
+		if kept > 0 || err != nil {
+			return kept, err
+		}
+	}
+}
+
+// decodeCharset 将指定字符集的内容转换为UTF-8，未知字符集时原样返回
+func decodeCharset(data []byte, charset string) string {
+	charset = strings.ToLower(strings.Trim(strings.TrimSpace(charset), `"`))
+	switch charset {
+	case "", "utf-8", "utf8", "us-ascii", "ascii":
+		if utf8.Valid(data) {
+			return string(data)
+		}
+		// 未声明字符集的中文邮件大多是GBK
+		if charset == "" {
+			charset = "gbk"
+		} else {
+			return strings.ToValidUTF8(string(data), "\uFFFD")
+		}
+	}
+
+	reader, err := charsetReader(charset, bytes.NewReader(data))
+	if err != nil {
+		return string(data)
+	}
+	decoded, err := io.ReadAll(reader)
+	if err != nil {
+		return string(data)
+	}
+	return string(decoded)
+}
+
+// charsetReader 返回将指定字符集转换为UTF-8的 Reader，GB2312 按 GBK 解码（WHATWG 编码标准）
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported charset %s", charset)
+	}
+	return enc.NewDecoder().Reader(input), nil
+}
+
+// DecodeHeader 解码邮件头中的 RFC 2047 编码字（如 =?GBK?B?...?=），无法解码的部分保持原样
+func DecodeHeader(value string) string {
+	value = strings.TrimSpace(value)
+	if decoded, err := wordDecoder.DecodeHeader(value); err == nil {
+		value = decoded
+	}
+	// 部分客户端直接发送未编码的GBK邮件头
+	if !utf8.ValidString(value) {
+		value = decodeCharset([]byte(value), "")
+	}
+	return value
+}
+
+// ParseAddressList 解析 From/To/Cc 等地址列表并解码显示名，整体无法解析时逐个地址尝试，跳过无效的部分
+func ParseAddressList(value string) []*mail.Address {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil
+	}
+	parser := &mail.AddressParser{WordDecoder: wordDecoder}
+	list, err := parser.ParseList(value)
+	if err != nil {
+		list = nil
+		for _, part := range strings.Split(value, ",") {
+			if addr, err := parser.Parse(part); err == nil {
+				list = append(list, addr)
+			}
+		}
+	}
+
+	// 不少客户端把编码字放在引号里（"=?GBK?B?...?="），严格来说不应解码，但显示时需要
+	for _, addr := range list {
+		if strings.Contains(addr.Name, "=?") || !utf8.ValidString(addr.Name) {
+			addr.Name = DecodeHeader(addr.Name)
+		}
+	}
+	return list
+}
+
+// decodeFilename 解码附件文件名，兼容不规范地使用 RFC 2047 编码字的客户端
+func decodeFilename(name string) string {
+	if name == "" {
+		return ""
+	}
+	if decoded, err := wordDecoder.DecodeHeader(name); err == nil {
+		name = decoded
+	}
+	if !utf8.ValidString(name) {
+		name = decodeCharset([]byte(name), "")
+	}
+	// 去掉路径，防止下载时被当作目录
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	return strings.TrimSpace(name)
+}
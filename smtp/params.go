@@ -0,0 +1,102 @@
+package smtp
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	// errInvalidPath 地址格式错误
+	errInvalidPath = errors.New("invalid address syntax")
+	// errInvalidParam 参数格式错误
+	errInvalidParam = errors.New("invalid parameter syntax")
+)
+
+// parsePathArgs 解析 MAIL FROM/RCPT TO 的参数部分，如 "<user@example.com> SIZE=1024 BODY=8BITMIME"
+// 返回地址（空字符串表示 null reverse-path "<>"）和大写键名的 ESMTP 参数
+func parsePathArgs(arg string) (string, map[string]string, error) {
+	arg = strings.TrimSpace(arg)
+	if arg == "" {
+		return "", nil, errInvalidPath
+	}
+
+	var addr, rest string
+	if arg[0] == '<' {
+		end := findPathEnd(arg)
+		if end < 0 {
+			return "", nil, errInvalidPath
+		}
+		addr = arg[1:end]
+		rest = arg[end+1:]
+		if rest != "" && rest[0] != ' ' {
+			return "", nil, errInvalidPath
+		}
+	} else {
+		// 兼容不带尖括号的地址
+		if i := strings.IndexByte(arg, ' '); i >= 0 {
+			addr, rest = arg[:i], arg[i+1:]
+		} else {
+			addr = arg
+		}
+	}
+
+	addr = stripSourceRoute(addr)
+	if strings.ContainsAny(addr, " \t") && !strings.HasPrefix(addr, "\"") {
+		return "", nil, errInvalidPath
+	}
+	if addr != "" && !utf8.ValidString(addr) {
+		return "", nil, errInvalidPath
+	}
+
+	params := make(map[string]string)
+	for _, field := range strings.Fields(rest) {
+		key, value, _ := strings.Cut(field, "=")
+		if key == "" {
+			return "", nil, errInvalidParam
+		}
+		params[strings.ToUpper(key)] = value
+	}
+
+	return addr, params, nil
+}
+
+// findPathEnd 查找与开头 '<' 匹配的 '>' 位置，跳过引号内的内容
+func findPathEnd(arg string) int {
+	inQuote := false
+	for i := 1; i < len(arg); i++ {
+		switch arg[i] {
+		case '\\':
+			if inQuote {
+				i++
+			}
+		case '"':
+			inQuote = !inQuote
+		case '>':
+			if !inQuote {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// stripSourceRoute 去除已废弃的源路由前缀，如 "@a.example,@b.example:user@c.example"
+func stripSourceRoute(addr string) string {
+	if strings.HasPrefix(addr, "@") {
+		if i := strings.IndexByte(addr, ':'); i >= 0 {
+			return addr[i+1:]
+		}
+	}
+	return addr
+}
+
+// isASCII 检查字符串是否只包含ASCII字符
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
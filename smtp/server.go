@@ -1,9 +1,9 @@
 package smtp
 
 import (
-	"bytes"
+	"crypto/tls"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net"
 	"net/mail"
@@ -13,50 +13,91 @@ import (
 
 // MailMessage 表示接收到的邮件
 type MailMessage struct {
-	From       string
-	To         []string
-	Subject    string
-	Body       string
-	RawData    string
-	ReceivedAt time.Time
+	From        string          // 信封发件人（MAIL FROM）
+	To          []string        // 信封收件人（RCPT TO）
+	Subject     string          // 已解码的主题
+	HeaderFrom  *mail.Address   // 邮件头 From，可能与信封发件人不同
+	HeaderTo    []*mail.Address // 邮件头 To
+	Cc          []*mail.Address // 邮件头 Cc
+	Body        string          // 纯文本正文
+	HTML        string          // HTML正文
+	Attachments []*Attachment   // 附件和内嵌资源
+	RawData     string
+	ReceivedAt  time.Time
+	Auth        *AuthResults // 发件人认证结果，未检查时为nil
+}
+
+// DeliveryResult 单个收件人的投递结果
+type DeliveryResult struct {
+	Recipient string
+	Err       error // nil 表示投递成功
+	Temporary bool  // 是否为临时错误（可稍后重试）
 }
 
 // MailHandler 处理接收到的邮件
 type MailHandler interface {
-	HandleMail(msg *MailMessage) error
+	// HandleMail 投递本地邮件，返回每个收件人的结果；返回error表示整封邮件处理失败
+	HandleMail(msg *MailMessage) ([]DeliveryResult, error)
+}
+
+// RecipientValidator 在 RCPT TO 阶段校验本地收件人
+type RecipientValidator interface {
+	// ValidateRecipient 检查本地邮箱是否存在，返回false表示没有这个邮箱
+	ValidateRecipient(email string) (bool, error)
+}
+
+// OutboundQueue 外发队列，接收需要投递到外部域名的邮件
+type OutboundQueue interface {
+	// Enqueue 持久化邮件，返回nil后由队列负责投递、重试和退信
+	Enqueue(from string, to []string, rawData string) error
 }
 
+// DefaultMaxMessageSize 默认邮件大小上限（25MB）
+const DefaultMaxMessageSize = 25 * 1024 * 1024
+
 // Server SMTP服务器
 type Server struct {
 	Domain      string
 	Port        int
 	Handler     MailHandler
-	Forwarder   *MailForwarder
+	Recipients  RecipientValidator // 本地收件人校验，为nil时接受所有本地地址
+	Queue       OutboundQueue      // 外发队列，为nil时禁用转发
 	listener    net.Listener
 	LocalDomain string // 本地主域名（用于判断是否本地邮件）
+
+	// IsLocalDomain 判断主域名以外的域名是否由本服务器接收（如已验证的自有域名），为nil时只有主域名及其子域名是本地域名
+	IsLocalDomain func(domain string) bool
+
+	MaxMessageSize int64 // 邮件大小上限（字节），通过 EHLO SIZE 通告并在 DATA 阶段强制执行
+
+	// TLS配置
+	TLSConfig   *tls.Config // 非空时在EHLO中通告STARTTLS
+	ImplicitTLS bool        // 隐式TLS模式（465端口），连接建立即进行TLS握手
+	RequireTLS  bool        // 要求在MAIL FROM之前完成TLS加密
+
+	// 认证配置
+	Auth       Authenticator // 非空时支持 AUTH PLAIN/LOGIN
+	Submission bool          // 提交模式（587/465），MAIL FROM 之前必须完成认证
+
+	// 入站验证
+	Verifier *Verifier // 非空时对未认证会话的邮件执行SPF/DKIM/DMARC检查
 }
 
 // NewServer 创建新的SMTP服务器
-func NewServer(domain string, port int, handler MailHandler, forwardEnabled bool) *Server {
+func NewServer(domain string, port int, handler MailHandler) *Server {
 	// 从 domain 提取主域名（去除 "mail." 前缀）
 	localDomain := domain
 	if strings.HasPrefix(strings.ToLower(domain), "mail.") {
 		localDomain = domain[5:] // 去除 "mail." 前缀
 	}
 
-	var forwarder *MailForwarder
-	if forwardEnabled {
-		forwarder = NewMailForwarder(localDomain)
-	} else {
-		forwarder = nil // 禁用转发
-	}
-
 	return &Server{
 		Domain:      domain,
 		Port:        port,
 		Handler:     handler,
-		Forwarder:   forwarder,
 		LocalDomain: localDomain,
+
+		MaxMessageSize: DefaultMaxMessageSize,
 	}
 }
 
@@ -67,12 +108,29 @@ func (s *Server) Start() error {
 	if err != nil {
 		return fmt.Errorf("failed to listen on %s: %v", addr, err)
 	}
+	if s.ImplicitTLS {
+		if s.TLSConfig == nil {
+			listener.Close()
+			return fmt.Errorf("implicit TLS on %s requires a TLS config", addr)
+		}
+		listener = tls.NewListener(listener, s.TLSConfig)
+		log.Printf("SMTP server listening on %s (implicit TLS)", addr)
+	} else {
+		log.Printf("SMTP server listening on %s", addr)
+	}
+	return s.Serve(listener)
+}
+
+// Serve 在已有的监听器上接受连接，监听器关闭后返回
+func (s *Server) Serve(listener net.Listener) error {
 	s.listener = listener
-	log.Printf("SMTP server listening on %s", addr)
 
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
 			log.Printf("failed to accept connection: %v", err)
 			continue
 		}
@@ -88,219 +146,23 @@ func (s *Server) Stop() error {
 	return nil
 }
 
-// handleConnection 处理单个连接
-func (s *Server) handleConnection(conn net.Conn) {
-	defer conn.Close()
-
-	session := &smtpSession{
-		conn:   conn,
-		server: s,
-	}
-	session.handle()
-}
-
-// smtpSession SMTP会话
-type smtpSession struct {
-	conn       net.Conn
-	server     *Server
-	mailFrom   string
-	rcptTo     []string
-	dataBuffer bytes.Buffer
-}
-
-// handle 处理SMTP会话
-func (s *smtpSession) handle() {
-	// 发送欢迎消息
-	s.writeLine(fmt.Sprintf("220 %s SMTP Service Ready", s.server.Domain))
-
-	reader := io.Reader(s.conn)
-	buffer := make([]byte, 4096)
-
-	for {
-		n, err := reader.Read(buffer)
-		if err != nil {
-			if err != io.EOF {
-				log.Printf("read error: %v", err)
-			}
-			return
-		}
-
-		line := strings.TrimSpace(string(buffer[:n]))
-		if line == "" {
-			continue
-		}
-
-		log.Printf("Received: %s", line)
-
-		// 处理SMTP命令
-		if !s.processCommand(line) {
-			return
-		}
-	}
-}
-
-// processCommand 处理SMTP命令
-func (s *smtpSession) processCommand(line string) bool {
-	parts := strings.SplitN(line, " ", 2)
-	cmd := strings.ToUpper(parts[0])
-	var arg string
-	if len(parts) > 1 {
-		arg = parts[1]
-	}
-
-	switch cmd {
-	case "HELO", "EHLO":
-		s.writeLine(fmt.Sprintf("250 %s Hello", s.server.Domain))
-	case "MAIL":
-		// MAIL FROM:<sender@example.com>
-		if strings.HasPrefix(strings.ToUpper(arg), "FROM:") {
-			email := extractEmail(arg[5:])
-			s.mailFrom = email
-			s.writeLine("250 OK")
-		} else {
-			s.writeLine("501 Syntax error")
-		}
-	case "RCPT":
-		// RCPT TO:<recipient@example.com>
-		if strings.HasPrefix(strings.ToUpper(arg), "TO:") {
-			email := extractEmail(arg[3:])
-			s.rcptTo = append(s.rcptTo, email)
-			s.writeLine("250 OK")
-		} else {
-			s.writeLine("501 Syntax error")
-		}
-	case "DATA":
-		s.writeLine("354 Start mail input; end with <CRLF>.<CRLF>")
-		s.receiveData()
-	case "QUIT":
-		s.writeLine("221 Bye")
+// isLocalAddress 检查邮箱是否属于本地域名（主域名或其子域名，以及 IsLocalDomain 接受的域名）
+func (s *Server) isLocalAddress(email string) bool {
+	domain := extractDomain(email)
+	if domain == "" {
 		return false
-	case "RSET":
-		s.reset()
-		s.writeLine("250 OK")
-	case "NOOP":
-		s.writeLine("250 OK")
-	default:
-		s.writeLine("500 Command not recognized")
-	}
-
-	return true
-}
-
-// receiveData 接收邮件数据
-func (s *smtpSession) receiveData() {
-	s.dataBuffer.Reset()
-	reader := io.Reader(s.conn)
-	buffer := make([]byte, 4096)
-
-	for {
-		n, err := reader.Read(buffer)
-		if err != nil {
-			log.Printf("error reading data: %v", err)
-			return
-		}
-
-		s.dataBuffer.Write(buffer[:n])
-
-		// 检查是否以 \r\n.\r\n 结束
-		data := s.dataBuffer.String()
-		if strings.HasSuffix(data, "\r\n.\r\n") || strings.HasSuffix(data, "\n.\n") {
-			// 移除结束标记
-			data = strings.TrimSuffix(data, "\r\n.\r\n")
-			data = strings.TrimSuffix(data, "\n.\n")
-			s.processMailData(data)
-			s.reset()
-			return
-		}
-	}
-}
-
-// processMailData 处理邮件数据
-func (s *smtpSession) processMailData(data string) {
-	// 解析邮件
-	msg, err := mail.ReadMessage(strings.NewReader(data))
-	if err != nil {
-		log.Printf("failed to parse mail: %v", err)
-		s.writeLine("550 Failed to parse message")
-		return
 	}
-
-	// 读取邮件正文
-	body, err := io.ReadAll(msg.Body)
-	if err != nil {
-		log.Printf("failed to read body: %v", err)
-		body = []byte("")
-	}
-
-	// 处理每个收件人
-	var localRecipients []string
-	var forwardErrors []string
-
-	for _, recipient := range s.rcptTo {
-		// 检查是否是本地域名
-		if s.server.Forwarder != nil && s.server.Forwarder.isLocalDomain(recipient) {
-			localRecipients = append(localRecipients, recipient)
-			log.Printf("[SMTP] 本地邮件: %s", recipient)
-		} else if s.server.Forwarder != nil {
-			// 转发到外部邮箱
-			log.Printf("[SMTP] 准备转发外部邮件: %s", recipient)
-			err := s.server.Forwarder.Forward(s.mailFrom, recipient, data)
-			if err != nil {
-				log.Printf("[SMTP] 转发失败: %v", err)
-				forwardErrors = append(forwardErrors, fmt.Sprintf("%s: %v", recipient, err))
-			} else {
-				log.Printf("[SMTP] ✓ 邮件已转发到: %s", recipient)
-			}
-		} else {
-			// 转发功能已禁用，记录但不转发
-			log.Printf("[SMTP] 转发功能已禁用，跳过外部邮件: %s", recipient)
-			forwardErrors = append(forwardErrors, fmt.Sprintf("%s: 转发功能已禁用", recipient))
-		}
-	}
-
-	// 如果有本地收件人，调用本地处理器保存
-	if len(localRecipients) > 0 && s.server.Handler != nil {
-		localMsg := &MailMessage{
-			From:       s.mailFrom,
-			To:         localRecipients,
-			Subject:    msg.Header.Get("Subject"),
-			Body:       string(body),
-			RawData:    data,
-			ReceivedAt: time.Now(),
-		}
-		err := s.server.Handler.HandleMail(localMsg)
-		if err != nil {
-			log.Printf("failed to handle local mail: %v", err)
-			s.writeLine("550 Failed to process local message")
-			return
-		}
-	}
-
-	// 返回响应
-	if len(forwardErrors) > 0 {
-		log.Printf("[SMTP] 部分邮件转发失败: %v", forwardErrors)
-		// 即使转发失败，也返回成功，避免重复发送
+	localDomain := strings.ToLower(s.LocalDomain)
+	if domain == localDomain || strings.HasSuffix(domain, "."+localDomain) {
+		return true
 	}
-
-	s.writeLine("250 OK: Message accepted for delivery")
-}
-
-// reset 重置会话状态
-func (s *smtpSession) reset() {
-	s.mailFrom = ""
-	s.rcptTo = []string{}
-	s.dataBuffer.Reset()
+	return s.IsLocalDomain != nil && s.IsLocalDomain(domain)
 }
 
-// writeLine 写入一行响应
-func (s *smtpSession) writeLine(line string) {
-	s.conn.Write([]byte(line + "\r\n"))
-	log.Printf("Sent: %s", line)
-}
+// handleConnection 处理单个连接
+func (s *Server) handleConnection(conn net.Conn) {
+	defer conn.Close()
 
-// extractEmail 从字符串中提取邮箱地址
-func extractEmail(s string) string {
-	s = strings.TrimSpace(s)
-	s = strings.Trim(s, "<>")
-	return s
+	session := newSession(conn, s)
+	session.handle()
 }
@@ -0,0 +1,692 @@
+package smtp
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxCommandLength 命令行最大长度（RFC 5321 §4.5.3.1.4 规定为512，这里适当放宽）
+	maxCommandLength = 2048
+	// maxDataLineLength DATA阶段单行最大长度（RFC 5321 规定为1000，这里放宽以兼容不规范的客户端）
+	maxDataLineLength = 1024 * 1024
+	// maxRecipients 单个事务允许的最大收件人数量
+	maxRecipients = 100
+	// maxBadCommands 连续错误命令上限，超过后断开连接
+	maxBadCommands = 10
+	// commandTimeout 等待客户端命令的超时时间
+	commandTimeout = 5 * time.Minute
+	// dataTimeout DATA阶段读取超时时间
+	dataTimeout = 10 * time.Minute
+	// handshakeTimeout TLS握手超时时间
+	handshakeTimeout = 30 * time.Second
+)
+
+var (
+	// errLineTooLong 行长度超过限制
+	errLineTooLong = errors.New("line too long")
+	// errMessageTooLarge 邮件超过大小限制
+	errMessageTooLarge = errors.New("message too large")
+)
+
+// sessionState SMTP会话状态
+type sessionState int
+
+const (
+	stateConnected sessionState = iota // 已连接，尚未 HELO/EHLO
+	stateGreeted                       // 已完成 HELO/EHLO
+	stateMail                          // 已收到 MAIL FROM
+	stateRcpt                          // 已收到至少一个 RCPT TO
+)
+
+// smtpSession SMTP会话
+type smtpSession struct {
+	conn         net.Conn
+	server       *Server
+	reader       *bufio.Reader
+	writer       *bufio.Writer
+	state        sessionState
+	helo         string
+	tls          bool      // 连接是否已加密
+	authUser     *AuthUser // 已认证的用户，未认证为nil
+	authFailures int
+	mailFrom     string
+	smtputf8     bool // MAIL FROM 是否声明了 SMTPUTF8
+	rcptTo       []string
+	badCommands  int
+}
+
+// newSession 创建SMTP会话
+func newSession(conn net.Conn, server *Server) *smtpSession {
+	_, isTLS := conn.(*tls.Conn)
+	return &smtpSession{
+		conn:   conn,
+		server: server,
+		reader: bufio.NewReader(conn),
+		writer: bufio.NewWriter(conn),
+		state:  stateConnected,
+		tls:    isTLS,
+	}
+}
+
+// handle 处理SMTP会话
+func (s *smtpSession) handle() {
+	// 隐式TLS连接先完成握手，避免握手错误在写欢迎消息时被忽略
+	if tlsConn, ok := s.conn.(*tls.Conn); ok {
+		tlsConn.SetDeadline(time.Now().Add(handshakeTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			log.Printf("[SMTP] TLS握手失败 (%s): %v", s.conn.RemoteAddr(), err)
+			return
+		}
+		tlsConn.SetDeadline(time.Time{})
+	}
+
+	// 发送欢迎消息
+	s.writeLine(fmt.Sprintf("220 %s SMTP Service Ready", s.server.Domain))
+	s.flush()
+
+	for {
+		s.conn.SetReadDeadline(time.Now().Add(commandTimeout))
+		line, err := s.readLine(maxCommandLength)
+		if err == errLineTooLong {
+			s.writeLine("500 5.5.2 Line too long")
+			s.flush()
+			continue
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("read error: %v", err)
+			}
+			return
+		}
+
+		if strings.HasPrefix(strings.ToUpper(line), "AUTH ") {
+			// 不记录认证凭据
+			log.Printf("Received: AUTH ***")
+		} else {
+			log.Printf("Received: %s", line)
+		}
+
+		// 处理SMTP命令
+		if !s.processCommand(line) {
+			s.flush()
+			return
+		}
+
+		// 支持命令流水线（RFC 2920）：客户端一次发送的命令全部处理完后再统一回写响应
+		if s.reader.Buffered() == 0 {
+			if err := s.flush(); err != nil {
+				log.Printf("write error: %v", err)
+				return
+			}
+		}
+	}
+}
+
+// processCommand 处理SMTP命令，返回false表示关闭连接
+func (s *smtpSession) processCommand(line string) bool {
+	parts := strings.SplitN(line, " ", 2)
+	cmd := strings.ToUpper(parts[0])
+	var arg string
+	if len(parts) > 1 {
+		arg = strings.TrimSpace(parts[1])
+	}
+
+	switch cmd {
+	case "HELO", "EHLO":
+		s.handleHelo(cmd, arg)
+	case "MAIL":
+		s.handleMail(arg)
+	case "RCPT":
+		s.handleRcpt(arg)
+	case "DATA":
+		return s.handleData(arg)
+	case "STARTTLS":
+		return s.handleStartTLS(arg)
+	case "AUTH":
+		return s.handleAuth(arg)
+	case "RSET":
+		s.reset()
+		s.writeLine("250 2.0.0 OK")
+	case "NOOP":
+		s.writeLine("250 2.0.0 OK")
+	case "VRFY":
+		s.writeLine("252 2.0.0 Cannot VRFY user, but will accept message and attempt delivery")
+	case "QUIT":
+		s.writeLine("221 2.0.0 Bye")
+		return false
+	default:
+		s.badCommands++
+		s.writeLine("500 5.5.2 Command not recognized")
+		if s.badCommands >= maxBadCommands {
+			s.writeLine("421 4.7.0 Too many errors, closing connection")
+			return false
+		}
+	}
+
+	return true
+}
+
+// handleHelo 处理 HELO/EHLO 命令
+func (s *smtpSession) handleHelo(cmd, arg string) {
+	if arg == "" {
+		s.writeLine(fmt.Sprintf("501 5.5.2 Syntax: %s hostname", cmd))
+		return
+	}
+
+	// HELO/EHLO 隐含 RSET 语义
+	s.reset()
+	s.helo = arg
+	s.state = stateGreeted
+
+	if cmd == "EHLO" {
+		extensions := []string{
+			"PIPELINING",
+			fmt.Sprintf("SIZE %d", s.server.MaxMessageSize),
+			"8BITMIME",
+			"SMTPUTF8",
+			"ENHANCEDSTATUSCODES",
+		}
+		if s.server.TLSConfig != nil && !s.tls {
+			extensions = append(extensions, "STARTTLS")
+		}
+		if s.authAvailable() {
+			extensions = append(extensions, "AUTH PLAIN LOGIN")
+		}
+
+		s.writeLine(fmt.Sprintf("250-%s Hello %s", s.server.Domain, arg))
+		for i, ext := range extensions {
+			if i == len(extensions)-1 {
+				s.writeLine("250 " + ext)
+			} else {
+				s.writeLine("250-" + ext)
+			}
+		}
+		return
+	}
+	s.writeLine(fmt.Sprintf("250 %s Hello %s", s.server.Domain, arg))
+}
+
+// handleMail 处理 MAIL FROM 命令
+func (s *smtpSession) handleMail(arg string) {
+	switch s.state {
+	case stateConnected:
+		s.writeLine("503 5.5.1 Send HELO/EHLO first")
+		return
+	case stateMail, stateRcpt:
+		s.writeLine("503 5.5.1 Sender already specified")
+		return
+	}
+	if s.server.RequireTLS && !s.tls {
+		s.writeLine("530 5.7.0 Must issue a STARTTLS command first")
+		return
+	}
+	if s.server.Submission && s.authUser == nil {
+		s.writeLine("530 5.7.0 Authentication required")
+		return
+	}
+
+	// MAIL FROM:<sender@example.com> [SIZE=n] [BODY=7BIT|8BITMIME] [SMTPUTF8]
+	if !strings.HasPrefix(strings.ToUpper(arg), "FROM:") {
+		s.writeLine("501 5.5.2 Syntax: MAIL FROM:<address>")
+		return
+	}
+	from, params, err := parsePathArgs(arg[5:])
+	if err != nil {
+		s.writeLine("501 5.1.7 Bad sender address syntax")
+		return
+	}
+
+	var size int64
+	utf8Mail := false
+	for key, value := range params {
+		switch key {
+		case "SIZE":
+			size, err = strconv.ParseInt(value, 10, 64)
+			if err != nil || size < 0 {
+				s.writeLine("501 5.5.4 Invalid SIZE parameter")
+				return
+			}
+		case "BODY":
+			bodyType := strings.ToUpper(value)
+			if bodyType != "7BIT" && bodyType != "8BITMIME" {
+				s.writeLine("501 5.5.4 Unsupported BODY type")
+				return
+			}
+		case "SMTPUTF8":
+			if value != "" {
+				s.writeLine("501 5.5.4 SMTPUTF8 does not take a value")
+				return
+			}
+			utf8Mail = true
+		case "AUTH":
+			// RFC 4954 §5，接受但忽略
+		default:
+			s.writeLine(fmt.Sprintf("555 5.5.4 Unsupported parameter %s", key))
+			return
+		}
+	}
+
+	if size > s.server.MaxMessageSize {
+		s.writeLine(fmt.Sprintf("552 5.3.4 Message size exceeds fixed limit of %d bytes", s.server.MaxMessageSize))
+		return
+	}
+	if !utf8Mail && !isASCII(from) {
+		s.writeLine("553 5.6.7 Non-ASCII address requires SMTPUTF8")
+		return
+	}
+
+	// 已认证用户只能使用自己名下的邮箱作为发件人
+	if s.authUser != nil {
+		allowed, err := s.server.Auth.CanSendAs(s.authUser, from)
+		if err != nil {
+			log.Printf("[SMTP] 检查发件人权限失败 (%s): %v", from, err)
+			s.writeLine("451 4.3.0 Temporary failure checking sender")
+			return
+		}
+		if !allowed {
+			log.Printf("[SMTP] 用户 %s 无权使用发件人 %s", s.authUser.Email, from)
+			s.writeLine("553 5.7.1 Sender address not owned by authenticated user")
+			return
+		}
+	}
+
+	s.mailFrom = from
+	s.smtputf8 = utf8Mail
+	s.state = stateMail
+	s.writeLine("250 2.1.0 OK")
+}
+
+// handleRcpt 处理 RCPT TO 命令
+func (s *smtpSession) handleRcpt(arg string) {
+	if s.state != stateMail && s.state != stateRcpt {
+		s.writeLine("503 5.5.1 Need MAIL command")
+		return
+	}
+
+	// RCPT TO:<recipient@example.com>
+	if !strings.HasPrefix(strings.ToUpper(arg), "TO:") {
+		s.writeLine("501 5.5.2 Syntax: RCPT TO:<address>")
+		return
+	}
+	email, params, err := parsePathArgs(arg[3:])
+	if err != nil || email == "" {
+		s.writeLine("501 5.1.3 Bad recipient address syntax")
+		return
+	}
+	for key := range params {
+		s.writeLine(fmt.Sprintf("555 5.5.4 Unsupported parameter %s", key))
+		return
+	}
+	if !s.smtputf8 && !isASCII(email) {
+		s.writeLine("553 5.6.7 Non-ASCII address requires SMTPUTF8")
+		return
+	}
+
+	if len(s.rcptTo) >= maxRecipients {
+		s.writeLine("452 4.5.3 Too many recipients")
+		return
+	}
+	if s.server.isLocalAddress(email) {
+		// 本地收件人在信封阶段校验，避免接收后无人认领
+		if s.server.Recipients != nil {
+			exists, err := s.server.Recipients.ValidateRecipient(email)
+			if err != nil {
+				log.Printf("[SMTP] 校验收件人失败 (%s): %v", email, err)
+				s.writeLine("451 4.3.0 Temporary failure checking recipient")
+				return
+			}
+			if !exists {
+				log.Printf("[SMTP] 收件人不存在: %s", email)
+				s.writeLine("550 5.1.1 Mailbox unavailable")
+				return
+			}
+		}
+	} else if s.authUser == nil {
+		// 只有已认证的会话才允许中继到外部域名
+		log.Printf("[SMTP] 拒绝中继: %s -> %s (%s)", s.mailFrom, email, s.conn.RemoteAddr())
+		s.writeLine("554 5.7.1 Relay access denied")
+		return
+	} else if s.server.Queue == nil {
+		s.writeLine("550 5.7.1 Relaying disabled")
+		return
+	}
+
+	s.rcptTo = append(s.rcptTo, email)
+	s.state = stateRcpt
+	s.writeLine("250 2.1.5 OK")
+}
+
+// handleStartTLS 处理 STARTTLS 命令（RFC 3207），返回false表示关闭连接
+func (s *smtpSession) handleStartTLS(arg string) bool {
+	if s.server.TLSConfig == nil {
+		s.writeLine("502 5.5.1 Command not implemented")
+		return true
+	}
+	if s.tls {
+		s.writeLine("503 5.5.1 TLS already active")
+		return true
+	}
+	if arg != "" {
+		s.writeLine("501 5.5.2 Syntax: STARTTLS")
+		return true
+	}
+	if s.state == stateConnected {
+		s.writeLine("503 5.5.1 Send EHLO first")
+		return true
+	}
+	// STARTTLS 之后不允许流水线命令，防止明文命令注入到加密会话中
+	if s.reader.Buffered() > 0 {
+		s.writeLine("554 5.5.1 Pipelining not allowed after STARTTLS")
+		return false
+	}
+
+	s.writeLine("220 2.0.0 Ready to start TLS")
+	if err := s.flush(); err != nil {
+		log.Printf("write error: %v", err)
+		return false
+	}
+
+	tlsConn := tls.Server(s.conn, s.server.TLSConfig)
+	tlsConn.SetDeadline(time.Now().Add(handshakeTimeout))
+	if err := tlsConn.Handshake(); err != nil {
+		log.Printf("[SMTP] STARTTLS握手失败 (%s): %v", s.conn.RemoteAddr(), err)
+		return false
+	}
+	tlsConn.SetDeadline(time.Time{})
+
+	// 握手完成后丢弃之前的会话状态，客户端需要重新 EHLO
+	s.conn = tlsConn
+	s.reader = bufio.NewReader(tlsConn)
+	s.writer = bufio.NewWriter(tlsConn)
+	s.tls = true
+	s.helo = ""
+	s.authUser = nil
+	s.state = stateConnected
+	s.reset()
+	log.Printf("[SMTP] ✓ TLS已启动 (%s)", s.conn.RemoteAddr())
+	return true
+}
+
+// handleData 处理 DATA 命令，返回false表示关闭连接
+func (s *smtpSession) handleData(arg string) bool {
+	if arg != "" {
+		s.writeLine("501 5.5.2 Syntax: DATA")
+		return true
+	}
+	switch s.state {
+	case stateConnected, stateGreeted:
+		s.writeLine("503 5.5.1 Need MAIL command")
+		return true
+	case stateMail:
+		s.writeLine("503 5.5.1 Need RCPT command")
+		return true
+	}
+
+	s.writeLine("354 Start mail input; end with <CRLF>.<CRLF>")
+	if err := s.flush(); err != nil {
+		log.Printf("write error: %v", err)
+		return false
+	}
+
+	data, err := s.receiveData()
+	if err == errLineTooLong {
+		log.Printf("[SMTP] 邮件行超过长度限制 (%d bytes): %s", maxDataLineLength, s.mailFrom)
+		s.writeLine("552 5.3.4 Line too long")
+		s.reset()
+		return true
+	}
+	if err == errMessageTooLarge {
+		log.Printf("[SMTP] 邮件超过大小限制 (%d bytes): %s", s.server.MaxMessageSize, s.mailFrom)
+		s.writeLine(fmt.Sprintf("552 5.3.4 Message size exceeds fixed limit of %d bytes", s.server.MaxMessageSize))
+		s.reset()
+		return true
+	}
+	if err != nil {
+		log.Printf("error reading data: %v", err)
+		return false
+	}
+
+	s.processMailData(string(data))
+	s.reset()
+	return true
+}
+
+// receiveData 接收邮件数据，直到单独一行的 "." 为止，并处理点号转义（RFC 5321 §4.5.2）
+// 超过大小或行长度限制时继续读取并丢弃剩余数据，直到结束标记后返回 errMessageTooLarge 或 errLineTooLong
+func (s *smtpSession) receiveData() ([]byte, error) {
+	var buf bytes.Buffer
+	var failure error
+
+	for {
+		s.conn.SetReadDeadline(time.Now().Add(dataTimeout))
+		line, err := s.readDataLine()
+		if err == errLineTooLong {
+			if failure == nil {
+				failure = errLineTooLong
+				buf.Reset()
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if line == "." {
+			if failure != nil {
+				return nil, failure
+			}
+			return buf.Bytes(), nil
+		}
+		if failure != nil {
+			continue
+		}
+
+		// 去除行首转义的点号
+		if strings.HasPrefix(line, ".") {
+			line = line[1:]
+		}
+
+		if int64(buf.Len()+len(line)+2) > s.server.MaxMessageSize {
+			failure = errMessageTooLarge
+			buf.Reset()
+			continue
+		}
+		buf.WriteString(line)
+		buf.WriteString("\r\n")
+	}
+}
+
+// readLine 读取一行命令，去除行尾的 CRLF（兼容单独的 LF）
+func (s *smtpSession) readLine(limit int) (string, error) {
+	var line []byte
+	tooLong := false
+
+	for {
+		chunk, err := s.reader.ReadSlice('\n')
+		if !tooLong {
+			line = append(line, chunk...)
+			if len(line) > limit {
+				tooLong = true
+				line = nil
+			}
+		}
+
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			if err == io.EOF && len(line) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+		break
+	}
+
+	if tooLong {
+		return "", errLineTooLong
+	}
+
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	line = bytes.TrimSuffix(line, []byte("\r"))
+	return string(line), nil
+}
+
+// readDataLine 读取 DATA 阶段的一行，超过 maxDataLineLength 时丢弃该行并返回 errLineTooLong
+func (s *smtpSession) readDataLine() (string, error) {
+	line, err := s.readLine(maxDataLineLength)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return line, err
+}
+
+// processMailData 处理邮件数据
+func (s *smtpSession) processMailData(data string) {
+	// 检查发件人（已认证的提交会话无需检查）
+	var authResults *AuthResults
+	if s.server.Verifier != nil && s.authUser == nil {
+		authResults = s.server.Verifier.Verify(s.remoteIP(), s.helo, s.mailFrom, data)
+		data = s.server.Verifier.Stamp(data, authResults, s.mailFrom, s.helo)
+		log.Printf("[SMTP] 发件人验证: spf=%s dkim=%s dmarc=%s (from: %s)",
+			authResults.SPF, authResults.DKIMResult(), authResults.DMARC, authResults.FromDomain)
+	}
+
+	// 解析邮件
+	msg, err := mail.ReadMessage(strings.NewReader(data))
+	if err != nil {
+		log.Printf("failed to parse mail: %v", err)
+		s.writeLine("550 5.6.0 Failed to parse message")
+		return
+	}
+
+	// 解析MIME正文和附件，格式有误时保留已解析的部分
+	content, err := ParseMessage(msg)
+	if err != nil {
+		log.Printf("failed to parse MIME body: %v", err)
+	}
+
+	// 区分本地和外部收件人
+	var localRecipients, remoteRecipients []string
+	for _, recipient := range s.rcptTo {
+		if s.server.isLocalAddress(recipient) {
+			localRecipients = append(localRecipients, recipient)
+			log.Printf("[SMTP] 本地邮件: %s", recipient)
+		} else {
+			remoteRecipients = append(remoteRecipients, recipient)
+		}
+	}
+
+	// 外部收件人先写入外发队列，入队失败则整封邮件临时拒绝，避免邮件丢失
+	if len(remoteRecipients) > 0 {
+		if err := s.server.Queue.Enqueue(s.mailFrom, remoteRecipients, data); err != nil {
+			log.Printf("[SMTP] 外发邮件入队失败: %v", err)
+			s.writeLine("451 4.3.0 Failed to queue message, try again later")
+			return
+		}
+		log.Printf("[SMTP] 外发邮件已入队: %v", remoteRecipients)
+	}
+
+	// 如果有本地收件人，调用本地处理器保存
+	if len(localRecipients) > 0 && s.server.Handler != nil {
+		localMsg := &MailMessage{
+			From:        s.mailFrom,
+			To:          localRecipients,
+			Subject:     content.Subject,
+			HeaderFrom:  content.From,
+			HeaderTo:    content.To,
+			Cc:          content.Cc,
+			Body:        content.Text,
+			HTML:        content.HTML,
+			Attachments: content.Attachments,
+			RawData:     data,
+			ReceivedAt:  time.Now(),
+			Auth:        authResults,
+		}
+		results, err := s.server.Handler.HandleMail(localMsg)
+		if err != nil {
+			log.Printf("failed to handle local mail: %v", err)
+			s.writeLine("451 4.3.0 Failed to process local message, try again later")
+			return
+		}
+
+		delivered, temporary := 0, 0
+		for _, result := range results {
+			if result.Err == nil {
+				delivered++
+				continue
+			}
+			if result.Temporary {
+				temporary++
+			}
+			log.Printf("[SMTP] 本地投递失败: %s: %v", result.Recipient, result.Err)
+		}
+
+		// 所有本地收件人都失败且没有外部收件人时，整封邮件拒绝
+		if delivered == 0 && len(results) > 0 && len(localRecipients) == len(s.rcptTo) {
+			if temporary > 0 {
+				s.writeLine("451 4.3.0 Local delivery failed, try again later")
+			} else {
+				s.writeLine("550 5.1.1 Local delivery failed for all recipients")
+			}
+			return
+		}
+		if delivered < len(results) {
+			log.Printf("[SMTP] 部分本地收件人投递失败: %d/%d 成功", delivered, len(results))
+			s.writeLine(fmt.Sprintf("250 2.0.0 OK: Message accepted for %d of %d local recipients", delivered, len(results)))
+			return
+		}
+	}
+
+	s.writeLine("250 2.0.0 OK: Message accepted for delivery")
+}
+
+// remoteIP 客户端IP地址
+func (s *smtpSession) remoteIP() net.IP {
+	host, _, err := net.SplitHostPort(s.conn.RemoteAddr().String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
+// reset 重置会话状态（保留 HELO 信息）
+func (s *smtpSession) reset() {
+	s.mailFrom = ""
+	s.smtputf8 = false
+	s.rcptTo = nil
+	if s.state != stateConnected {
+		s.state = stateGreeted
+	}
+}
+
+// writeLine 写入一行响应（缓冲，需调用flush发送）
+func (s *smtpSession) writeLine(line string) {
+	s.writer.WriteString(line + "\r\n")
+	log.Printf("Sent: %s", line)
+}
+
+// flush 将缓冲的响应发送给客户端
+func (s *smtpSession) flush() error {
+	return s.writer.Flush()
+}
+
+// extractDomain 从邮箱地址提取域名（小写）
+func extractDomain(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 0 || at == len(email)-1 {
+		return ""
+	}
+	return strings.ToLower(email[at+1:])
+}
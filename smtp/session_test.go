@@ -0,0 +1,269 @@
+package smtp
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingHandler 记录投递的邮件，所有收件人都视为投递成功
+type recordingHandler struct {
+	mu   sync.Mutex
+	msgs []*MailMessage
+}
+
+func (h *recordingHandler) HandleMail(msg *MailMessage) ([]DeliveryResult, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.msgs = append(h.msgs, msg)
+
+	results := make([]DeliveryResult, 0, len(msg.To))
+	for _, rcpt := range msg.To {
+		results = append(results, DeliveryResult{Recipient: rcpt})
+	}
+	return results, nil
+}
+
+func (h *recordingHandler) messages() []*MailMessage {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]*MailMessage(nil), h.msgs...)
+}
+
+// runTranscript 通过 net.Pipe 把客户端数据按块写入会话，返回服务器的全部响应行
+// 每个块单独写入，用于模拟命令被拆分到多次TCP读取中
+func runTranscript(t *testing.T, srv *Server, chunks ...string) []string {
+	t.Helper()
+
+	client, server := net.Pipe()
+	client.SetDeadline(time.Now().Add(10 * time.Second))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		newSession(server, srv).handle()
+		server.Close()
+	}()
+	go func() {
+		for _, chunk := range chunks {
+			if _, err := client.Write([]byte(chunk)); err != nil {
+				return
+			}
+		}
+	}()
+
+	var replies []string
+	scanner := bufio.NewScanner(client)
+	for scanner.Scan() {
+		replies = append(replies, scanner.Text())
+	}
+	client.Close()
+	<-done
+	return replies
+}
+
+// replyCodes 提取每个响应的最终状态码（忽略多行响应的中间行）
+func replyCodes(replies []string) []string {
+	var codes []string
+	for _, line := range replies {
+		if len(line) >= 4 && line[3] == '-' {
+			continue
+		}
+		if len(line) >= 3 {
+			codes = append(codes, line[:3])
+		}
+	}
+	return codes
+}
+
+func newTestServer(handler MailHandler) *Server {
+	return NewServer("mail.test.local", 0, handler)
+}
+
+func TestSessionTranscripts(t *testing.T) {
+	longLine := strings.Repeat("a", maxDataLineLength+10)
+
+	tests := []struct {
+		name    string
+		maxSize int64
+		chunks  []string
+		want    []string
+		check   func(t *testing.T, msgs []*MailMessage)
+	}{
+		{
+			name: "pipelined commands",
+			chunks: []string{
+				"EHLO client.example.org\r\nMAIL FROM:<a@example.org>\r\nRCPT TO:<b@test.local>\r\nRCPT TO:<c@sub.test.local>\r\nDATA\r\n",
+				"Subject: hi\r\n\r\nbody\r\n.\r\nQUIT\r\n",
+			},
+			want: []string{"220", "250", "250", "250", "250", "354", "250", "221"},
+			check: func(t *testing.T, msgs []*MailMessage) {
+				if len(msgs) != 1 {
+					t.Fatalf("got %d messages, want 1", len(msgs))
+				}
+				if got := strings.Join(msgs[0].To, ","); got != "b@test.local,c@sub.test.local" {
+					t.Errorf("recipients = %q", got)
+				}
+				if msgs[0].Subject != "hi" {
+					t.Errorf("subject = %q, want %q", msgs[0].Subject, "hi")
+				}
+			},
+		},
+		{
+			name: "command split across reads",
+			chunks: []string{
+				"EH", "LO client\r\nMA", "IL FROM:<a@exa", "mple.org>\r", "\nRCPT TO:<b@test.local>\r\n",
+				"DA", "TA\r\n", "Subject: split\r\n\r\nbo", "dy\r\n.", "\r\nQU", "IT\r\n",
+			},
+			want: []string{"220", "250", "250", "250", "354", "250", "221"},
+			check: func(t *testing.T, msgs []*MailMessage) {
+				if len(msgs) != 1 || msgs[0].Subject != "split" {
+					t.Fatalf("unexpected messages: %+v", msgs)
+				}
+			},
+		},
+		{
+			name: "dot unstuffing",
+			chunks: []string{
+				"EHLO client\r\nMAIL FROM:<a@example.org>\r\nRCPT TO:<b@test.local>\r\nDATA\r\n",
+				"Subject: dots\r\n\r\n..leading dot\r\n...\r\n. not end\r\n.\r\nQUIT\r\n",
+			},
+			want: []string{"220", "250", "250", "250", "354", "250", "221"},
+			check: func(t *testing.T, msgs []*MailMessage) {
+				if len(msgs) != 1 {
+					t.Fatalf("got %d messages, want 1", len(msgs))
+				}
+				want := "Subject: dots\r\n\r\n.leading dot\r\n..\r\n not end\r\n"
+				if msgs[0].RawData != want {
+					t.Errorf("raw data = %q, want %q", msgs[0].RawData, want)
+				}
+			},
+		},
+		{
+			name:   "mail before helo",
+			chunks: []string{"MAIL FROM:<a@example.org>\r\nQUIT\r\n"},
+			want:   []string{"220", "503", "221"},
+		},
+		{
+			name:   "rcpt before mail",
+			chunks: []string{"EHLO client\r\nRCPT TO:<b@test.local>\r\nQUIT\r\n"},
+			want:   []string{"220", "250", "503", "221"},
+		},
+		{
+			name:   "data before rcpt",
+			chunks: []string{"EHLO client\r\nDATA\r\nMAIL FROM:<a@example.org>\r\nDATA\r\nQUIT\r\n"},
+			want:   []string{"220", "250", "503", "250", "503", "221"},
+		},
+		{
+			name:   "nested mail",
+			chunks: []string{"EHLO client\r\nMAIL FROM:<a@example.org>\r\nMAIL FROM:<a@example.org>\r\nQUIT\r\n"},
+			want:   []string{"220", "250", "250", "503", "221"},
+		},
+		{
+			name:   "rset clears transaction",
+			chunks: []string{"EHLO client\r\nMAIL FROM:<a@example.org>\r\nRSET\r\nRCPT TO:<b@test.local>\r\nQUIT\r\n"},
+			want:   []string{"220", "250", "250", "250", "503", "221"},
+		},
+		{
+			name:   "relay denied without auth",
+			chunks: []string{"EHLO client\r\nMAIL FROM:<a@example.org>\r\nRCPT TO:<x@elsewhere.org>\r\nQUIT\r\n"},
+			want:   []string{"220", "250", "250", "554", "221"},
+		},
+		{
+			name:    "declared size over limit",
+			maxSize: 64,
+			chunks:  []string{"EHLO client\r\nMAIL FROM:<a@example.org> SIZE=1000\r\nQUIT\r\n"},
+			want:    []string{"220", "250", "552", "221"},
+		},
+		{
+			name:    "message over size limit",
+			maxSize: 64,
+			chunks: []string{
+				"EHLO client\r\nMAIL FROM:<a@example.org>\r\nRCPT TO:<b@test.local>\r\nDATA\r\n",
+				"Subject: big\r\n\r\n" + strings.Repeat("x", 100) + "\r\n.\r\n",
+				"MAIL FROM:<a@example.org>\r\nQUIT\r\n",
+			},
+			want: []string{"220", "250", "250", "250", "354", "552", "250", "221"},
+			check: func(t *testing.T, msgs []*MailMessage) {
+				if len(msgs) != 0 {
+					t.Errorf("got %d messages, want 0", len(msgs))
+				}
+			},
+		},
+		{
+			name: "data line over length limit",
+			chunks: []string{
+				"EHLO client\r\nMAIL FROM:<a@example.org>\r\nRCPT TO:<b@test.local>\r\nDATA\r\n",
+				"Subject: long\r\n\r\n" + longLine + "\r\nafter\r\n.\r\n",
+				"NOOP\r\nQUIT\r\n",
+			},
+			want: []string{"220", "250", "250", "250", "354", "552", "250", "221"},
+			check: func(t *testing.T, msgs []*MailMessage) {
+				if len(msgs) != 0 {
+					t.Errorf("got %d messages, want 0", len(msgs))
+				}
+			},
+		},
+		{
+			name:   "command line too long",
+			chunks: []string{"EHLO client\r\nNOOP " + strings.Repeat("n", maxCommandLength) + "\r\nQUIT\r\n"},
+			want:   []string{"220", "250", "500", "221"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := &recordingHandler{}
+			srv := newTestServer(handler)
+			if tt.maxSize > 0 {
+				srv.MaxMessageSize = tt.maxSize
+			}
+
+			replies := runTranscript(t, srv, tt.chunks...)
+			got := replyCodes(replies)
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Fatalf("reply codes = %v, want %v\nreplies:\n%s", got, tt.want, strings.Join(replies, "\n"))
+			}
+			if tt.check != nil {
+				tt.check(t, handler.messages())
+			}
+		})
+	}
+}
+
+func TestSessionAcceptsCustomDomainRecipient(t *testing.T) {
+	handler := &recordingHandler{}
+	srv := newTestServer(handler)
+	srv.IsLocalDomain = func(domain string) bool { return domain == "custom.example" }
+
+	replies := runTranscript(t, srv,
+		"EHLO client\r\nMAIL FROM:<a@example.org>\r\nRCPT TO:<box@Custom.Example>\r\nRCPT TO:<x@other.example>\r\nDATA\r\n",
+		"Subject: custom\r\n\r\nbody\r\n.\r\nQUIT\r\n",
+	)
+	// 未认证会话发往自有域名按本地邮件接收，其他外部域名仍然拒绝中继
+	want := []string{"220", "250", "250", "250", "554", "354", "250", "221"}
+	if got := replyCodes(replies); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("reply codes = %v, want %v\nreplies:\n%s", got, want, strings.Join(replies, "\n"))
+	}
+	msgs := handler.messages()
+	if len(msgs) != 1 || len(msgs[0].To) != 1 || msgs[0].To[0] != "box@Custom.Example" {
+		t.Fatalf("delivered = %+v, want one local message to box@Custom.Example", msgs)
+	}
+}
+
+func TestSessionEHLOAdvertisesExtensions(t *testing.T) {
+	replies := runTranscript(t, newTestServer(&recordingHandler{}), "EHLO client\r\nQUIT\r\n")
+
+	joined := strings.Join(replies, "\n")
+	for _, ext := range []string{"PIPELINING", "SIZE 26214400", "8BITMIME", "SMTPUTF8", "ENHANCEDSTATUSCODES"} {
+		if !strings.Contains(joined, ext) {
+			t.Errorf("EHLO response missing %s:\n%s", ext, joined)
+		}
+	}
+	if strings.Contains(joined, "STARTTLS") {
+		t.Errorf("STARTTLS advertised without a TLS config:\n%s", joined)
+	}
+}
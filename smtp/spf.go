@@ -0,0 +1,431 @@
+package smtp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// spfMaxLookups 一次检查中允许的DNS查询次数上限（RFC 7208 4.6.4）
+const spfMaxLookups = 10
+
+// errSPFLookupLimit 超过DNS查询次数上限
+var errSPFLookupLimit = errors.New("too many DNS lookups")
+
+// spfChecker 单次SPF检查的上下文
+type spfChecker struct {
+	resolver Resolver
+	ip       net.IP
+	sender   string // 信封发件人，空发件人时为 postmaster@helo
+	helo     string
+	lookups  int
+}
+
+// CheckSPF 检查连接IP是否被发件人域名授权发送邮件，返回结果和说明
+func CheckSPF(ctx context.Context, resolver Resolver, ip net.IP, sender, helo string) (string, string) {
+	domain := extractDomain(sender)
+	if domain == "" {
+		domain = strings.ToLower(helo)
+		sender = "postmaster@" + domain
+	}
+	if domain == "" {
+		return ResultNone, "no sender domain"
+	}
+
+	c := &spfChecker{resolver: resolver, ip: ip, sender: sender, helo: helo}
+	result, err := c.check(ctx, domain)
+	if err != nil {
+		return result, err.Error()
+	}
+	return result, ""
+}
+
+// check 对指定域名执行SPF检查（include 和 redirect 会递归调用）
+func (c *spfChecker) check(ctx context.Context, domain string) (string, error) {
+	record, err := c.lookupRecord(ctx, domain)
+	if err != nil {
+		return spfResult(err), err
+	}
+	if record == "" {
+		return ResultNone, fmt.Errorf("no SPF record for %s", domain)
+	}
+
+	var redirect string
+	for _, term := range strings.Fields(record)[1:] {
+		// 修饰符：name=value
+		if name, value, ok := strings.Cut(term, "="); ok && !strings.ContainsAny(name, ":/") {
+			if strings.EqualFold(name, "redirect") {
+				redirect = value
+			}
+			continue
+		}
+
+		qualifier := ResultPass
+		switch term[0] {
+		case '+':
+			term = term[1:]
+		case '-':
+			qualifier, term = ResultFail, term[1:]
+		case '~':
+			qualifier, term = ResultSoftFail, term[1:]
+		case '?':
+			qualifier, term = ResultNeutral, term[1:]
+		}
+
+		match, err := c.matchMechanism(ctx, domain, term)
+		if err != nil {
+			return spfResult(err), err
+		}
+		if match {
+			return qualifier, nil
+		}
+	}
+
+	if redirect != "" {
+		target, err := c.expand(redirect, domain)
+		if err != nil {
+			return spfResult(err), err
+		}
+		if err := c.countLookup(); err != nil {
+			return spfResult(err), err
+		}
+		result, err := c.check(ctx, target)
+		if result == ResultNone {
+			return ResultPermError, fmt.Errorf("redirect target %s has no SPF record", target)
+		}
+		return result, err
+	}
+
+	return ResultNeutral, nil
+}
+
+// spfError 带有SPF结果的错误
+type spfError struct {
+	result string
+	err    error
+}
+
+func (e *spfError) Error() string { return e.err.Error() }
+
+// spfResult 取出错误对应的SPF结果，普通错误视为 permerror
+func spfResult(err error) string {
+	var sErr *spfError
+	if errors.As(err, &sErr) {
+		return sErr.result
+	}
+	return ResultPermError
+}
+
+// lookupRecord 查询域名的 v=spf1 记录，不存在时返回空字符串
+func (c *spfChecker) lookupRecord(ctx context.Context, domain string) (string, error) {
+	txts, err := c.resolver.LookupTXT(ctx, domain)
+	if err != nil {
+		if isNotFound(err) {
+			return "", nil
+		}
+		return "", &spfError{ResultTempError, fmt.Errorf("lookup TXT %s: %v", domain, err)}
+	}
+
+	var records []string
+	for _, txt := range txts {
+		lower := strings.ToLower(txt)
+		if lower == "v=spf1" || strings.HasPrefix(lower, "v=spf1 ") {
+			records = append(records, txt)
+		}
+	}
+	switch len(records) {
+	case 0:
+		return "", nil
+	case 1:
+		return records[0], nil
+	default:
+		return "", &spfError{ResultPermError, fmt.Errorf("multiple SPF records for %s", domain)}
+	}
+}
+
+// countLookup 计数DNS查询，超过上限时返回 permerror
+func (c *spfChecker) countLookup() error {
+	c.lookups++
+	if c.lookups > spfMaxLookups {
+		return &spfError{ResultPermError, errSPFLookupLimit}
+	}
+	return nil
+}
+
+// matchMechanism 判断单个机制是否匹配
+func (c *spfChecker) matchMechanism(ctx context.Context, domain, term string) (bool, error) {
+	name, arg, _ := strings.Cut(term, ":")
+	// a/mx 的CIDR写在域名后面，如 a/24 或 mx:example.com/24//64
+	if i := strings.IndexByte(name, '/'); i >= 0 {
+		name, arg = name[:i], name[i:]
+	}
+	name = strings.ToLower(name)
+
+	switch name {
+	case "all":
+		return true, nil
+
+	case "ip4", "ip6":
+		if !strings.Contains(arg, "/") {
+			if name == "ip4" {
+				arg += "/32"
+			} else {
+				arg += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(arg)
+		if err != nil {
+			return false, &spfError{ResultPermError, fmt.Errorf("invalid %s: %s", name, arg)}
+		}
+		return network.Contains(c.ip), nil
+
+	case "a", "mx":
+		if err := c.countLookup(); err != nil {
+			return false, err
+		}
+		target, cidr4, cidr6, err := c.splitCIDR(arg, domain)
+		if err != nil {
+			return false, err
+		}
+
+		hosts := []string{target}
+		if name == "mx" {
+			mxs, err := c.resolver.LookupMX(ctx, target)
+			if err != nil && !isNotFound(err) {
+				return false, &spfError{ResultTempError, fmt.Errorf("lookup MX %s: %v", target, err)}
+			}
+			hosts = hosts[:0]
+			for _, mx := range mxs {
+				hosts = append(hosts, strings.TrimSuffix(mx.Host, "."))
+			}
+			if len(hosts) > spfMaxLookups {
+				return false, &spfError{ResultPermError, errSPFLookupLimit}
+			}
+		}
+
+		for _, host := range hosts {
+			addrs, err := c.resolver.LookupIPAddr(ctx, host)
+			if err != nil {
+				if isNotFound(err) {
+					continue
+				}
+				return false, &spfError{ResultTempError, fmt.Errorf("lookup %s: %v", host, err)}
+			}
+			for _, addr := range addrs {
+				if ipInPrefix(c.ip, addr.IP, cidr4, cidr6) {
+					return true, nil
+				}
+			}
+		}
+		return false, nil
+
+	case "include":
+		if err := c.countLookup(); err != nil {
+			return false, err
+		}
+		target, err := c.expand(arg, domain)
+		if err != nil {
+			return false, err
+		}
+		result, err := c.check(ctx, target)
+		switch result {
+		case ResultPass:
+			return true, nil
+		case ResultFail, ResultSoftFail, ResultNeutral:
+			return false, nil
+		case ResultTempError:
+			return false, &spfError{ResultTempError, err}
+		default:
+			return false, &spfError{ResultPermError, fmt.Errorf("include %s: %v", target, err)}
+		}
+
+	case "exists":
+		if err := c.countLookup(); err != nil {
+			return false, err
+		}
+		target, err := c.expand(arg, domain)
+		if err != nil {
+			return false, err
+		}
+		addrs, err := c.resolver.LookupIPAddr(ctx, target)
+		if err != nil {
+			if isNotFound(err) {
+				return false, nil
+			}
+			return false, &spfError{ResultTempError, fmt.Errorf("lookup %s: %v", target, err)}
+		}
+		for _, addr := range addrs {
+			if addr.IP.To4() != nil {
+				return true, nil
+			}
+		}
+		return false, nil
+
+	case "ptr":
+		// ptr 已不推荐使用（RFC 7208 5.5），只计数不匹配
+		return false, c.countLookup()
+
+	default:
+		return false, &spfError{ResultPermError, fmt.Errorf("unknown mechanism %q", term)}
+	}
+}
+
+// splitCIDR 拆分 a/mx 参数中的域名和前缀长度，如 ":example.com/24//64"
+func (c *spfChecker) splitCIDR(arg, domain string) (string, int, int, error) {
+	cidr4, cidr6 := 32, 128
+	target := arg
+	if i := strings.Index(target, "//"); i >= 0 {
+		n, err := strconv.Atoi(target[i+2:])
+		if err != nil || n < 0 || n > 128 {
+			return "", 0, 0, &spfError{ResultPermError, fmt.Errorf("invalid ip6-cidr-length in %q", arg)}
+		}
+		cidr6, target = n, target[:i]
+	}
+	if i := strings.IndexByte(target, '/'); i >= 0 {
+		n, err := strconv.Atoi(target[i+1:])
+		if err != nil || n < 0 || n > 32 {
+			return "", 0, 0, &spfError{ResultPermError, fmt.Errorf("invalid ip4-cidr-length in %q", arg)}
+		}
+		cidr4, target = n, target[:i]
+	}
+
+	if target == "" {
+		return domain, cidr4, cidr6, nil
+	}
+	expanded, err := c.expand(target, domain)
+	return expanded, cidr4, cidr6, err
+}
+
+// ipInPrefix 判断两个地址是否在同一前缀内（地址族不同则不匹配）
+func ipInPrefix(ip, addr net.IP, cidr4, cidr6 int) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		addr4 := addr.To4()
+		if addr4 == nil {
+			return false
+		}
+		mask := net.CIDRMask(cidr4, 32)
+		return ip4.Mask(mask).Equal(addr4.Mask(mask))
+	}
+	if addr.To4() != nil {
+		return false
+	}
+	mask := net.CIDRMask(cidr6, 128)
+	return ip.To16().Mask(mask).Equal(addr.To16().Mask(mask))
+}
+
+// expand 展开SPF宏（RFC 7208 7），支持 s l o d i h v 宏及数字和 r 变换
+func (c *spfChecker) expand(spec, domain string) (string, error) {
+	if !strings.Contains(spec, "%") {
+		return spec, nil
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(spec); i++ {
+		if spec[i] != '%' {
+			b.WriteByte(spec[i])
+			continue
+		}
+		if i+1 >= len(spec) {
+			return "", &spfError{ResultPermError, fmt.Errorf("invalid macro in %q", spec)}
+		}
+		i++
+		switch spec[i] {
+		case '%':
+			b.WriteByte('%')
+			continue
+		case '_':
+			b.WriteByte(' ')
+			continue
+		case '-':
+			b.WriteString("%20")
+			continue
+		case '{':
+		default:
+			return "", &spfError{ResultPermError, fmt.Errorf("invalid macro in %q", spec)}
+		}
+
+		end := strings.IndexByte(spec[i:], '}')
+		if end < 2 {
+			return "", &spfError{ResultPermError, fmt.Errorf("invalid macro in %q", spec)}
+		}
+		macro := spec[i+1 : i+end]
+		i += end
+
+		value, err := c.macroValue(macro[0], domain)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(transformMacro(value, macro[1:]))
+	}
+	return b.String(), nil
+}
+
+// macroValue 宏字母对应的值
+func (c *spfChecker) macroValue(letter byte, domain string) (string, error) {
+	local, senderDomain, _ := strings.Cut(c.sender, "@")
+	switch letter | 0x20 {
+	case 's':
+		return c.sender, nil
+	case 'l':
+		return local, nil
+	case 'o':
+		return senderDomain, nil
+	case 'd':
+		return domain, nil
+	case 'h':
+		return c.helo, nil
+	case 'i':
+		if ip4 := c.ip.To4(); ip4 != nil {
+			return ip4.String(), nil
+		}
+		// IPv6 使用点分隔的半字节形式
+		ip6 := c.ip.To16()
+		nibbles := make([]string, 0, 32)
+		for _, b := range ip6 {
+			nibbles = append(nibbles, strconv.FormatUint(uint64(b>>4), 16), strconv.FormatUint(uint64(b&0xf), 16))
+		}
+		return strings.Join(nibbles, "."), nil
+	case 'v':
+		if c.ip.To4() != nil {
+			return "in-addr", nil
+		}
+		return "ip6", nil
+	default:
+		return "", &spfError{ResultPermError, fmt.Errorf("unknown macro letter %q", letter)}
+	}
+}
+
+// transformMacro 按变换规则处理宏的值，如 "2r" 表示反转后保留最右两段，"r-" 表示按 - 分割后反转
+func transformMacro(value, transformers string) string {
+	digits := 0
+	i := 0
+	for i < len(transformers) && transformers[i] >= '0' && transformers[i] <= '9' {
+		digits = digits*10 + int(transformers[i]-'0')
+		i++
+	}
+	reverse := false
+	if i < len(transformers) && (transformers[i] == 'r' || transformers[i] == 'R') {
+		reverse = true
+		i++
+	}
+	delimiters := transformers[i:]
+	if delimiters == "" {
+		delimiters = "."
+	}
+
+	parts := strings.FieldsFunc(value, func(r rune) bool {
+		return strings.ContainsRune(delimiters, r)
+	})
+	if reverse {
+		for l, r := 0, len(parts)-1; l < r; l, r = l+1, r-1 {
+			parts[l], parts[r] = parts[r], parts[l]
+		}
+	}
+	if digits > 0 && digits < len(parts) {
+		parts = parts[len(parts)-digits:]
+	}
+	return strings.Join(parts, ".")
+}
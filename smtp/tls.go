@@ -0,0 +1,117 @@
+package smtp
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// certCheckInterval 检查证书文件是否变更的最小间隔
+const certCheckInterval = 30 * time.Second
+
+// CertReloader 从磁盘加载TLS证书，并在文件变更后自动重新加载（证书续期无需重启服务）
+type CertReloader struct {
+	certFile  string
+	keyFile   string
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+// NewCertReloader 创建证书加载器，首次加载失败时返回错误
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload 强制从磁盘重新加载证书
+func (r *CertReloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate %s: %v", r.certFile, err)
+	}
+
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.modTime = modTime
+	r.lastCheck = time.Now()
+	r.mu.Unlock()
+
+	log.Printf("[TLS] 证书已加载: %s", r.certFile)
+	return nil
+}
+
+// GetCertificate 供 tls.Config 使用，必要时先检查证书文件是否更新
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.maybeReload()
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// TLSConfig 返回使用该加载器的TLS配置
+func (r *CertReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: r.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+}
+
+// maybeReload 证书文件修改时间变化时重新加载，失败则继续使用旧证书
+func (r *CertReloader) maybeReload() {
+	r.mu.RLock()
+	due := time.Since(r.lastCheck) >= certCheckInterval
+	current := r.modTime
+	r.mu.RUnlock()
+	if !due {
+		return
+	}
+
+	r.mu.Lock()
+	r.lastCheck = time.Now()
+	r.mu.Unlock()
+
+	modTime, err := r.latestModTime()
+	if err != nil {
+		log.Printf("[TLS] 检查证书文件失败: %v", err)
+		return
+	}
+	if !modTime.After(current) {
+		return
+	}
+
+	if err := r.Reload(); err != nil {
+		log.Printf("[TLS] 重新加载证书失败，继续使用旧证书: %v", err)
+	}
+}
+
+// latestModTime 返回证书和私钥文件中较新的修改时间
+func (r *CertReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to stat %s: %v", name, err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
@@ -0,0 +1,235 @@
+package smtp
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeSelfSignedCert 生成自签名证书并写入临时目录，返回证书和私钥文件路径
+func writeSelfSignedCert(t *testing.T, dir, commonName string) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("write cert: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	return certFile, keyFile
+}
+
+// smtpClient 测试用的最小SMTP客户端
+type smtpClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func newSMTPClient(t *testing.T, conn net.Conn) *smtpClient {
+	return &smtpClient{t: t, conn: conn, reader: bufio.NewReader(conn)}
+}
+
+// reply 读取一个完整响应（包括多行响应），返回状态码和全部文本
+func (c *smtpClient) reply() (string, string) {
+	c.t.Helper()
+	var lines []string
+	for {
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			c.t.Fatalf("read reply: %v (so far %q)", err, lines)
+		}
+		line = strings.TrimRight(line, "\r\n")
+		lines = append(lines, line)
+		if len(line) < 4 || line[3] != '-' {
+			return line[:3], strings.Join(lines, "\n")
+		}
+	}
+}
+
+// cmd 发送命令并检查响应状态码
+func (c *smtpClient) cmd(line, wantCode string) string {
+	c.t.Helper()
+	if _, err := io.WriteString(c.conn, line+"\r\n"); err != nil {
+		c.t.Fatalf("write %q: %v", line, err)
+	}
+	code, text := c.reply()
+	if code != wantCode {
+		c.t.Fatalf("%s: got %s, want %s\n%s", line, code, wantCode, text)
+	}
+	return text
+}
+
+// startPipeSession 在 net.Pipe 的服务端运行会话，返回客户端连接
+func startPipeSession(t *testing.T, srv *Server, wrap func(net.Conn) net.Conn) net.Conn {
+	t.Helper()
+	client, server := net.Pipe()
+	client.SetDeadline(time.Now().Add(10 * time.Second))
+
+	var conn net.Conn = server
+	if wrap != nil {
+		conn = wrap(server)
+	}
+	go func() {
+		newSession(conn, srv).handle()
+		conn.Close()
+	}()
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func newTLSTestServer(t *testing.T) (*Server, *x509.CertPool) {
+	t.Helper()
+	certFile, keyFile := writeSelfSignedCert(t, t.TempDir(), "mail.test.local")
+	reloader, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("NewCertReloader: %v", err)
+	}
+
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		t.Fatalf("read cert: %v", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(certPEM)
+
+	srv := newTestServer(&recordingHandler{})
+	srv.TLSConfig = reloader.TLSConfig()
+	return srv, pool
+}
+
+func TestSTARTTLS(t *testing.T) {
+	srv, pool := newTLSTestServer(t)
+	srv.RequireTLS = true
+	conn := startPipeSession(t, srv, nil)
+
+	c := newSMTPClient(t, conn)
+	c.reply()
+	ehlo := c.cmd("EHLO client", "250")
+	if !strings.Contains(ehlo, "STARTTLS") {
+		t.Fatalf("STARTTLS not advertised:\n%s", ehlo)
+	}
+	c.cmd("MAIL FROM:<a@example.org>", "530")
+	c.cmd("STARTTLS", "220")
+
+	tlsConn := tls.Client(conn, &tls.Config{ServerName: "mail.test.local", RootCAs: pool})
+	if err := tlsConn.Handshake(); err != nil {
+		t.Fatalf("client handshake: %v", err)
+	}
+
+	c = newSMTPClient(t, tlsConn)
+	// TLS建立后会话状态重置，必须重新 EHLO
+	c.cmd("MAIL FROM:<a@example.org>", "503")
+	ehlo = c.cmd("EHLO client", "250")
+	if strings.Contains(ehlo, "STARTTLS") {
+		t.Errorf("STARTTLS advertised on an encrypted session:\n%s", ehlo)
+	}
+	c.cmd("STARTTLS", "503")
+	c.cmd("MAIL FROM:<a@example.org>", "250")
+	c.cmd("RCPT TO:<b@test.local>", "250")
+	c.cmd("QUIT", "221")
+}
+
+func TestSTARTTLSRejectsPipelinedCommands(t *testing.T) {
+	srv, _ := newTLSTestServer(t)
+	conn := startPipeSession(t, srv, nil)
+
+	c := newSMTPClient(t, conn)
+	c.reply()
+	c.cmd("EHLO client", "250")
+	// STARTTLS 之后紧跟的明文命令不能被带入加密会话
+	c.cmd("STARTTLS\r\nMAIL FROM:<a@example.org>", "554")
+}
+
+func TestImplicitTLS(t *testing.T) {
+	srv, pool := newTLSTestServer(t)
+	srv.ImplicitTLS = true
+	conn := startPipeSession(t, srv, func(c net.Conn) net.Conn {
+		return tls.Server(c, srv.TLSConfig)
+	})
+
+	tlsConn := tls.Client(conn, &tls.Config{ServerName: "mail.test.local", RootCAs: pool})
+	if err := tlsConn.Handshake(); err != nil {
+		t.Fatalf("client handshake: %v", err)
+	}
+
+	c := newSMTPClient(t, tlsConn)
+	if code, text := c.reply(); code != "220" {
+		t.Fatalf("greeting = %s", text)
+	}
+	ehlo := c.cmd("EHLO client", "250")
+	if strings.Contains(ehlo, "STARTTLS") {
+		t.Errorf("STARTTLS advertised on implicit TLS:\n%s", ehlo)
+	}
+	c.cmd("MAIL FROM:<a@example.org>", "250")
+	c.cmd("QUIT", "221")
+}
+
+func TestCertReloaderPicksUpNewCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeSelfSignedCert(t, dir, "old.test.local")
+	reloader, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("NewCertReloader: %v", err)
+	}
+
+	writeSelfSignedCert(t, dir, "new.test.local")
+	later := time.Now().Add(time.Minute)
+	for _, name := range []string{certFile, keyFile} {
+		if err := os.Chtimes(name, later, later); err != nil {
+			t.Fatalf("chtimes: %v", err)
+		}
+	}
+	reloader.mu.Lock()
+	reloader.lastCheck = time.Time{}
+	reloader.mu.Unlock()
+
+	cert, err := reloader.GetCertificate(nil)
+	if err != nil {
+		t.Fatalf("GetCertificate: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("parse certificate: %v", err)
+	}
+	if leaf.Subject.CommonName != "new.test.local" {
+		t.Errorf("certificate CN = %q, want new.test.local", leaf.Subject.CommonName)
+	}
+}
@@ -0,0 +1,283 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// mailDomainRecipientMatch 判断收件地址 r.value 是否属于邮箱域名 d：邮箱地址本身及其 +tag 形式，
+// 以及有别名或开启catch-all时子域名下的任意地址（子域名只属于一个邮箱）
+const mailDomainRecipientMatch = `(lower(r.value) = lower(d.email)
+	OR (instr(r.value, '+') > 0 AND lower(substr(r.value, 1, instr(r.value, '+') - 1) || substr(r.value, instr(r.value, '@'))) = lower(d.email))
+	OR ((d.catch_all = 1 OR EXISTS (SELECT 1 FROM aliases a WHERE a.domain_id = d.id)) AND lower(r.value) LIKE '%@' || lower(d.full_domain)))`
+
+// Alias 邮箱子域名下的其他地址，本地部分包含 * 时为通配地址
+type Alias struct {
+	ID        int64     `json:"id"`
+	DomainID  int64     `json:"domain_id"`
+	UserID    int64     `json:"user_id"`
+	Email     string    `json:"email"`
+	Wildcard  bool      `json:"wildcard"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Recipient 收件地址的解析结果
+type Recipient struct {
+	UserID  int64
+	Mailbox string // 实际匹配到的地址，通配地址和catch-all为收件地址本身（去掉 +tag）
+	Tag     string // 收件地址中 + 之后的部分
+}
+
+// SplitAddressTag 拆分 user+tag@domain 形式的地址，返回 user@domain 和 tag，没有 tag 时原样返回
+func SplitAddressTag(email string) (string, string) {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return email, ""
+	}
+	local, tag, ok := strings.Cut(email[:at], "+")
+	if !ok || local == "" {
+		return email, ""
+	}
+	return local + email[at:], tag
+}
+
+// scanAlias 扫描一行别名记录
+func scanAlias(row rowScanner) (*Alias, error) {
+	var a Alias
+	if err := row.Scan(&a.ID, &a.DomainID, &a.UserID, &a.Email, &a.CreatedAt); err != nil {
+		return nil, err
+	}
+	a.Wildcard = strings.Contains(a.Email, "*")
+	return &a, nil
+}
+
+// CreateAlias 为邮箱域名添加别名，写入 ID 和 CreatedAt
+func (s *SQLiteStorage) CreateAlias(a *Alias) error {
+	a.Email = strings.ToLower(a.Email)
+	a.Wildcard = strings.Contains(a.Email, "*")
+	a.CreatedAt = time.Now()
+
+	query := `INSERT INTO aliases (domain_id, user_id, email, created_at) VALUES (?, ?, ?, ?)`
+	result, err := s.db.Exec(query, a.DomainID, a.UserID, a.Email, a.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create alias: %v", err)
+	}
+	a.ID, _ = result.LastInsertId()
+	return nil
+}
+
+// GetAliases 获取邮箱域名的所有别名
+func (s *SQLiteStorage) GetAliases(userID, domainID int64) ([]*Alias, error) {
+	query := `
+	SELECT id, domain_id, user_id, email, created_at
+	FROM aliases
+	WHERE domain_id = ? AND user_id = ?
+	ORDER BY email
+	`
+	rows, err := s.db.Query(query, domainID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query aliases: %v", err)
+	}
+	defer rows.Close()
+
+	aliases := []*Alias{}
+	for rows.Next() {
+		a, err := scanAlias(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan alias: %v", err)
+		}
+		aliases = append(aliases, a)
+	}
+	return aliases, nil
+}
+
+// GetAliasByEmail 根据地址查找别名（通配地址需要原样传入），不存在时返回 nil
+func (s *SQLiteStorage) GetAliasByEmail(email string) (*Alias, error) {
+	query := `SELECT id, domain_id, user_id, email, created_at FROM aliases WHERE email = ?`
+	a, err := scanAlias(s.db.QueryRow(query, strings.ToLower(email)))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query alias: %v", err)
+	}
+	return a, nil
+}
+
+// DeleteAlias 删除邮箱域名的别名
+func (s *SQLiteStorage) DeleteAlias(userID, domainID, id int64) error {
+	query := `DELETE FROM aliases WHERE id = ? AND domain_id = ? AND user_id = ?`
+	result, err := s.db.Exec(query, id, domainID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete alias: %v", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("alias not found")
+	}
+	return nil
+}
+
+// SetMailTags 保存收件地址中的 +tag
+func (s *SQLiteStorage) SetMailTags(mailID int64, tags []string) error {
+	return updateMailTags(s.db, mailID, tags)
+}
+
+// updateMailTags 更新邮件记录的 +tag
+func updateMailTags(db execer, mailID int64, tags []string) error {
+	tagsJSON, err := json.Marshal(tags)
+	if err != nil {
+		return fmt.Errorf("failed to marshal tags: %v", err)
+	}
+	if _, err := db.Exec(`UPDATE mails SET tags = ? WHERE id = ?`, string(tagsJSON), mailID); err != nil {
+		return fmt.Errorf("failed to update mail tags: %v", err)
+	}
+	return nil
+}
+
+// ResolveRecipient 查找接收该地址邮件的用户，不存在时返回 nil
+// 依次匹配：精确地址、去掉 +tag 后的地址、通配别名（最长的优先）、开启catch-all的邮箱域名或自有域名，
+// 最后 postmaster 地址投递给管理员（RFC 5321 §4.5.1）
+func (s *SQLiteStorage) ResolveRecipient(email string) (*Recipient, error) {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return nil, nil
+	}
+	base, tag := SplitAddressTag(email)
+
+	userID, err := s.exactMailboxOwner(email)
+	if err != nil {
+		return nil, err
+	}
+	if userID != 0 {
+		return &Recipient{UserID: userID, Mailbox: strings.ToLower(email)}, nil
+	}
+
+	if tag != "" {
+		if userID, err = s.exactMailboxOwner(base); err != nil {
+			return nil, err
+		}
+		if userID != 0 {
+			return &Recipient{UserID: userID, Mailbox: strings.ToLower(base), Tag: tag}, nil
+		}
+	}
+
+	userID, err = s.catchAllOwner(strings.ToLower(base))
+	if err != nil {
+		return nil, err
+	}
+	if userID != 0 {
+		return &Recipient{UserID: userID, Mailbox: strings.ToLower(base), Tag: tag}, nil
+	}
+
+	if strings.EqualFold(email[:at], "postmaster") {
+		if userID, err = s.adminUserID(); err != nil {
+			return nil, err
+		}
+		if userID != 0 {
+			return &Recipient{UserID: userID, Mailbox: strings.ToLower(email)}, nil
+		}
+	}
+	return nil, nil
+}
+
+// adminUserID 最早创建的管理员用户ID，没有管理员时返回0
+func (s *SQLiteStorage) adminUserID() (int64, error) {
+	var userID int64
+	err := s.db.QueryRow(`SELECT id FROM users WHERE is_admin = 1 ORDER BY id LIMIT 1`).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to query admin user: %v", err)
+	}
+	return userID, nil
+}
+
+// GetMailboxOwner 查找接收该地址邮件的用户ID，不存在时返回0
+func (s *SQLiteStorage) GetMailboxOwner(email string) (int64, error) {
+	r, err := s.ResolveRecipient(email)
+	if err != nil || r == nil {
+		return 0, err
+	}
+	return r.UserID, nil
+}
+
+// exactMailboxOwner 按完整地址查找邮箱、别名和已验证自有域名下的地址
+func (s *SQLiteStorage) exactMailboxOwner(email string) (int64, error) {
+	domain, err := s.GetMailDomainByEmail(email)
+	if err != nil {
+		return 0, err
+	}
+	if domain != nil {
+		return domain.UserID, nil
+	}
+
+	query := `
+	SELECT user_id FROM aliases WHERE email = ?
+	UNION ALL
+	SELECT a.user_id FROM custom_domain_addresses a JOIN custom_domains d ON d.id = a.domain_id
+	WHERE a.email = ? AND d.status = ?
+	LIMIT 1
+	`
+	email = strings.ToLower(email)
+	var userID int64
+	err = s.db.QueryRow(query, email, email, CustomDomainVerified).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to query mailbox owner: %v", err)
+	}
+	return userID, nil
+}
+
+// catchAllOwner 按通配别名和catch-all查找地址所属用户，email 需要是小写
+func (s *SQLiteStorage) catchAllOwner(email string) (int64, error) {
+	at := strings.LastIndex(email, "@")
+	local, host := email[:at], email[at+1:]
+
+	rows, err := s.db.Query(`SELECT user_id, email FROM aliases WHERE instr(email, '*') > 0 AND substr(email, instr(email, '@') + 1) = ?`, host)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query wildcard aliases: %v", err)
+	}
+	defer rows.Close()
+
+	var owner int64
+	best := -1
+	for rows.Next() {
+		var userID int64
+		var pattern string
+		if err := rows.Scan(&userID, &pattern); err != nil {
+			return 0, fmt.Errorf("failed to scan wildcard alias: %v", err)
+		}
+		pattern = pattern[:strings.LastIndex(pattern, "@")]
+		if ok, _ := path.Match(pattern, local); ok && len(pattern) > best {
+			owner, best = userID, len(pattern)
+		}
+	}
+	if owner != 0 {
+		return owner, nil
+	}
+
+	domain, err := s.GetCatchAllMailDomain(host)
+	if err != nil {
+		return 0, err
+	}
+	if domain != nil {
+		return domain.UserID, nil
+	}
+
+	query := `SELECT user_id FROM custom_domains WHERE domain = ? AND status = ? AND catch_all = 1`
+	err = s.db.QueryRow(query, host, CustomDomainVerified).Scan(&owner)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to query custom domain owner: %v", err)
+	}
+	return owner, nil
+}
@@ -0,0 +1,57 @@
+package storage
+
+import "testing"
+
+func TestResolveRecipientIgnoresCase(t *testing.T) {
+	s := newTestStorage(t)
+	user, err := s.CreateUser("user@example.org", HashPassword("secret"), "127.0.0.1")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if err := s.CreateMailDomain(user.ID, "abc", "abc.test.local", "rec-1", nil, "foo@abc.test.local", nil); err != nil {
+		t.Fatalf("CreateMailDomain: %v", err)
+	}
+
+	for _, email := range []string{"foo@abc.test.local", "Foo@abc.test.local", "FOO@ABC.TEST.LOCAL", "Foo+News@abc.test.local"} {
+		r, err := s.ResolveRecipient(email)
+		if err != nil {
+			t.Fatalf("ResolveRecipient(%s): %v", email, err)
+		}
+		if r == nil || r.UserID != user.ID {
+			t.Errorf("ResolveRecipient(%s) = %+v, want user %d", email, r, user.ID)
+		}
+	}
+
+	domain, err := s.GetMailDomainByEmail("FOO@abc.test.local")
+	if err != nil || domain == nil {
+		t.Fatalf("GetMailDomainByEmail = %v, %v", domain, err)
+	}
+}
+
+func TestResolveRecipientPostmaster(t *testing.T) {
+	s := newTestStorage(t)
+
+	r, err := s.ResolveRecipient("postmaster@abc.test.local")
+	if err != nil {
+		t.Fatalf("ResolveRecipient: %v", err)
+	}
+	if r != nil {
+		t.Fatalf("postmaster resolved without an admin: %+v", r)
+	}
+
+	if _, err := s.CreateUser("user@example.org", HashPassword("secret"), "127.0.0.1"); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	admin, err := s.CreateUser("admin@admin.com", HashPassword("secret"), "127.0.0.1")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	r, err = s.ResolveRecipient("PostMaster@abc.test.local")
+	if err != nil {
+		t.Fatalf("ResolveRecipient: %v", err)
+	}
+	if r == nil || r.UserID != admin.ID {
+		t.Fatalf("ResolveRecipient(postmaster) = %+v, want admin %d", r, admin.ID)
+	}
+}
@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// Attachment 邮件附件
+type Attachment struct {
+	ID          int64     `json:"id"`
+	MailID      int64     `json:"mail_id"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"content_type"`
+	ContentID   string    `json:"content_id,omitempty"` // 内嵌资源的 Content-ID，HTML正文通过 cid: 引用
+	Inline      bool      `json:"inline"`
+	Size        int64     `json:"size"`
+	Data        []byte    `json:"-"` // 列表查询时不加载
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// attachmentColumns 查询附件元数据时使用的列，顺序与 scanAttachment 一致
+const attachmentColumns = `a.id, a.mail_id, COALESCE(a.filename, ''), a.content_type, COALESCE(a.content_id, ''), a.inline, a.size, a.created_at`
+
+// scanAttachment 扫描一行附件元数据，extra 追加在末尾（如 data 列）
+func scanAttachment(row rowScanner, extra ...interface{}) (*Attachment, error) {
+	var a Attachment
+	dest := []interface{}{&a.ID, &a.MailID, &a.Filename, &a.ContentType, &a.ContentID, &a.Inline, &a.Size, &a.CreatedAt}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// SaveAttachments 保存一份邮件记录的所有附件
+// 附件内容按SHA-256存入 attachment_blobs，同一封邮件的多份记录共享同一份内容
+func (s *SQLiteStorage) SaveAttachments(mailID int64, attachments []*Attachment) error {
+	if len(attachments) == 0 {
+		return nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := insertAttachments(tx, mailID, attachments); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit attachments: %v", err)
+	}
+	return nil
+}
+
+// insertAttachments 在事务中插入附件记录
+func insertAttachments(tx *sql.Tx, mailID int64, attachments []*Attachment) error {
+	query := `
+	INSERT INTO attachments (mail_id, blob_id, filename, content_type, content_id, inline, size, data, created_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, X'', ?)
+	`
+	now := time.Now()
+	for _, a := range attachments {
+		blobID, err := saveAttachmentBlob(tx, a.Data, now)
+		if err != nil {
+			return err
+		}
+
+		a.MailID = mailID
+		a.Size = int64(len(a.Data))
+		a.CreatedAt = now
+		result, err := tx.Exec(query, mailID, blobID, a.Filename, a.ContentType, a.ContentID, a.Inline, a.Size, now)
+		if err != nil {
+			return fmt.Errorf("failed to insert attachment: %v", err)
+		}
+		a.ID, _ = result.LastInsertId()
+	}
+	return nil
+}
+
+// saveAttachmentBlob 保存附件内容，内容相同时复用已有记录，返回记录ID
+func saveAttachmentBlob(tx *sql.Tx, data []byte, now time.Time) (int64, error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	_, err := tx.Exec(`INSERT INTO attachment_blobs (hash, data, created_at) VALUES (?, ?, ?) ON CONFLICT(hash) DO NOTHING`, hash, data, now)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert attachment blob: %v", err)
+	}
+	var id int64
+	if err := tx.QueryRow(`SELECT id FROM attachment_blobs WHERE hash = ?`, hash).Scan(&id); err != nil {
+		return 0, fmt.Errorf("failed to query attachment blob: %v", err)
+	}
+	return id, nil
+}
+
+// GetAttachments 获取用户某封邮件的附件列表（不含内容）
+func (s *SQLiteStorage) GetAttachments(userID, mailID int64) ([]*Attachment, error) {
+	query := `
+	SELECT ` + attachmentColumns + `
+	FROM attachments a JOIN mails m ON m.id = a.mail_id
+	WHERE a.mail_id = ? AND m.user_id = ?
+	ORDER BY a.id
+	`
+
+	rows, err := s.db.Query(query, mailID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query attachments: %v", err)
+	}
+	defer rows.Close()
+
+	attachments := []*Attachment{}
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan attachment: %v", err)
+		}
+		attachments = append(attachments, a)
+	}
+	return attachments, nil
+}
+
+// GetAttachment 获取单个附件及其内容，不存在或不属于该用户时返回 nil
+func (s *SQLiteStorage) GetAttachment(userID, mailID, id int64) (*Attachment, error) {
+	query := `
+	SELECT ` + attachmentColumns + `, COALESCE(b.data, a.data)
+	FROM attachments a JOIN mails m ON m.id = a.mail_id
+	LEFT JOIN attachment_blobs b ON b.id = a.blob_id
+	WHERE a.id = ? AND a.mail_id = ? AND m.user_id = ?
+	`
+
+	var data []byte
+	a, err := scanAttachment(s.db.QueryRow(query, id, mailID, userID), &data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query attachment: %v", err)
+	}
+	a.Data = data
+	return a, nil
+}
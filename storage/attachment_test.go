@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"bytes"
+	"testing"
+)
+
+func countRows(t *testing.T, s *SQLiteStorage, table string) int {
+	t.Helper()
+	var n int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM ` + table).Scan(&n); err != nil {
+		t.Fatalf("count %s: %v", table, err)
+	}
+	return n
+}
+
+func TestAttachmentsShareBlobAcrossCopies(t *testing.T) {
+	s := newTestStorage(t)
+	rawID, err := s.SaveRawMessage("Subject: hi\r\n\r\nbody\r\n")
+	if err != nil {
+		t.Fatalf("SaveRawMessage: %v", err)
+	}
+
+	data := []byte("attachment content")
+	var mailIDs []int64
+	for _, userID := range []int64{1, 2} {
+		mailID, err := s.SaveMailCopy(userID, "a@example.org", []string{"b@test.local"}, "hi", "body", "", MailHeaders{}, rawID, AuthResult{})
+		if err != nil {
+			t.Fatalf("SaveMailCopy: %v", err)
+		}
+		attachments := []*Attachment{{Filename: "a.txt", ContentType: "text/plain", Data: data}}
+		if err := s.SaveAttachments(mailID, attachments); err != nil {
+			t.Fatalf("SaveAttachments: %v", err)
+		}
+		mailIDs = append(mailIDs, mailID)
+	}
+
+	if n := countRows(t, s, "attachment_blobs"); n != 1 {
+		t.Fatalf("attachment_blobs has %d rows, want 1", n)
+	}
+	for i, mailID := range mailIDs {
+		userID := int64(i + 1)
+		list, err := s.GetAttachments(userID, mailID)
+		if err != nil || len(list) != 1 {
+			t.Fatalf("GetAttachments = %v, %v", list, err)
+		}
+		a, err := s.GetAttachment(userID, mailID, list[0].ID)
+		if err != nil || a == nil {
+			t.Fatalf("GetAttachment = %v, %v", a, err)
+		}
+		if !bytes.Equal(a.Data, data) || a.Size != int64(len(data)) {
+			t.Errorf("attachment data = %q (size %d), want %q", a.Data, a.Size, data)
+		}
+	}
+
+	// 删除一份记录后共享内容仍然保留，全部删除后一并清理
+	if _, err := s.DeleteMails(1, mailIDs[:1]); err != nil {
+		t.Fatalf("DeleteMails: %v", err)
+	}
+	if n := countRows(t, s, "attachment_blobs"); n != 1 {
+		t.Fatalf("attachment_blobs has %d rows after first delete, want 1", n)
+	}
+	if _, err := s.DeleteMails(2, mailIDs[1:]); err != nil {
+		t.Fatalf("DeleteMails: %v", err)
+	}
+	if n := countRows(t, s, "attachment_blobs"); n != 0 {
+		t.Errorf("attachment_blobs has %d rows after deleting all copies, want 0", n)
+	}
+	if n := countRows(t, s, "raw_messages"); n != 0 {
+		t.Errorf("raw_messages has %d rows after deleting all copies, want 0", n)
+	}
+}
+
+func TestDeliverMailCopy(t *testing.T) {
+	s := newTestStorage(t)
+	rawID, err := s.SaveRawMessage("Subject: hi\r\n\r\nbody\r\n")
+	if err != nil {
+		t.Fatalf("SaveRawMessage: %v", err)
+	}
+
+	mailID, err := s.DeliverMailCopy(&MailCopy{
+		UserID:      1,
+		From:        "a@example.org",
+		To:          []string{"b+news@test.local"},
+		Subject:     "hi",
+		Body:        "code 123456",
+		RawID:       rawID,
+		Attachments: []*Attachment{{Filename: "a.txt", ContentType: "text/plain", Data: []byte("content")}},
+		Codes:       MailCodes{Codes: []string{"123456"}},
+		Tags:        []string{"news"},
+	})
+	if err != nil {
+		t.Fatalf("DeliverMailCopy: %v", err)
+	}
+
+	mail, err := s.GetMailByID(1, mailID)
+	if err != nil || mail == nil {
+		t.Fatalf("GetMailByID = %v, %v", mail, err)
+	}
+	if len(mail.Codes) != 1 || mail.Codes[0] != "123456" || len(mail.Tags) != 1 || mail.Tags[0] != "news" {
+		t.Errorf("codes = %v, tags = %v", mail.Codes, mail.Tags)
+	}
+	if list, err := s.GetAttachments(1, mailID); err != nil || len(list) != 1 {
+		t.Errorf("GetAttachments = %v, %v", list, err)
+	}
+}
+
+func TestDeliverMailCopyRollsBackOnFailure(t *testing.T) {
+	s := newTestStorage(t)
+	rawID, err := s.SaveRawMessage("Subject: hi\r\n\r\nbody\r\n")
+	if err != nil {
+		t.Fatalf("SaveRawMessage: %v", err)
+	}
+
+	// 附件写入失败时，已插入的邮件记录也要回滚，否则发件方重试后会出现重复邮件
+	if _, err := s.db.Exec(`DROP TABLE attachments`); err != nil {
+		t.Fatalf("drop attachments: %v", err)
+	}
+	_, err = s.DeliverMailCopy(&MailCopy{
+		UserID:      1,
+		From:        "a@example.org",
+		To:          []string{"b@test.local"},
+		Subject:     "hi",
+		RawID:       rawID,
+		Attachments: []*Attachment{{Filename: "a.txt", Data: []byte("content")}},
+	})
+	if err == nil {
+		t.Fatalf("DeliverMailCopy succeeded without attachments table")
+	}
+	if n := countRows(t, s, "mails"); n != 0 {
+		t.Errorf("mails has %d rows after failed delivery, want 0", n)
+	}
+	if n := countRows(t, s, "attachment_blobs"); n != 0 {
+		t.Errorf("attachment_blobs has %d rows after failed delivery, want 0", n)
+	}
+}
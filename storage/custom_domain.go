@@ -0,0 +1,272 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// 自有域名状态
+const (
+	CustomDomainPending  = "pending"  // 等待验证TXT记录
+	CustomDomainVerified = "verified" // 已验证，可以创建地址和收信
+)
+
+// DNS记录检查结果
+const (
+	DNSCheckUnchecked = "unchecked" // 还没有检查过
+	DNSCheckOK        = "ok"        // 已正确配置
+	DNSCheckMissing   = "missing"   // 没有查到记录
+	DNSCheckMismatch  = "mismatch"  // 查到了记录但值不符合要求
+	DNSCheckError     = "error"     // 查询失败
+)
+
+// DNSCheck 一条需要配置的DNS记录及其检查结果
+type DNSCheck struct {
+	Purpose  string   `json:"purpose"` // verification、mx、spf、dkim、dmarc
+	Type     string   `json:"type"`
+	Name     string   `json:"name"`  // 完整域名
+	Value    string   `json:"value"` // 需要配置的值
+	Priority int      `json:"priority,omitempty"`
+	Status   string   `json:"status"`
+	Found    []string `json:"found"` // 实际查询到的值
+	Error    string   `json:"error,omitempty"`
+}
+
+// CustomDomain 用户添加的自有域名
+type CustomDomain struct {
+	ID                int64       `json:"id"`
+	UserID            int64       `json:"user_id"`
+	Domain            string      `json:"domain"`
+	VerificationToken string      `json:"verification_token"`
+	Status            string      `json:"status"`
+	CatchAll          bool        `json:"catch_all"` // 是否接收该域名下所有地址的邮件
+	Checks            []*DNSCheck `json:"checks"`    // 需要配置的记录和最近一次检查结果
+	VerifiedAt        *time.Time  `json:"verified_at"`
+	CheckedAt         *time.Time  `json:"checked_at"`
+	CreatedAt         time.Time   `json:"created_at"`
+}
+
+// CustomDomainAddress 自有域名下的邮箱地址
+type CustomDomainAddress struct {
+	ID        int64     `json:"id"`
+	DomainID  int64     `json:"domain_id"`
+	UserID    int64     `json:"user_id"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// customDomainColumns 查询自有域名时使用的列，顺序与 scanCustomDomain 一致
+const customDomainColumns = `id, user_id, domain, verification_token, status, catch_all, COALESCE(checks, ''), verified_at, checked_at, created_at`
+
+// scanCustomDomain 扫描一行自有域名记录
+func scanCustomDomain(row rowScanner) (*CustomDomain, error) {
+	var d CustomDomain
+	var checks string
+	var verifiedAt, checkedAt sql.NullTime
+	err := row.Scan(&d.ID, &d.UserID, &d.Domain, &d.VerificationToken, &d.Status, &d.CatchAll, &checks, &verifiedAt, &checkedAt, &d.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	d.Checks = []*DNSCheck{}
+	if checks != "" {
+		if err := json.Unmarshal([]byte(checks), &d.Checks); err != nil {
+			return nil, err
+		}
+	}
+	if verifiedAt.Valid {
+		d.VerifiedAt = &verifiedAt.Time
+	}
+	if checkedAt.Valid {
+		d.CheckedAt = &checkedAt.Time
+	}
+	return &d, nil
+}
+
+// queryCustomDomains 查询多条自有域名记录
+func (s *SQLiteStorage) queryCustomDomains(query string, args ...interface{}) ([]*CustomDomain, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query custom domains: %v", err)
+	}
+	defer rows.Close()
+
+	domains := []*CustomDomain{}
+	for rows.Next() {
+		d, err := scanCustomDomain(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan custom domain: %v", err)
+		}
+		domains = append(domains, d)
+	}
+	return domains, nil
+}
+
+// CreateCustomDomain 添加自有域名，写入 ID 和 CreatedAt
+func (s *SQLiteStorage) CreateCustomDomain(d *CustomDomain) error {
+	checks, err := json.Marshal(d.Checks)
+	if err != nil {
+		return fmt.Errorf("failed to marshal dns checks: %v", err)
+	}
+	if d.Status == "" {
+		d.Status = CustomDomainPending
+	}
+	d.CreatedAt = time.Now()
+
+	query := `
+	INSERT INTO custom_domains (user_id, domain, verification_token, status, catch_all, checks, created_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?)
+	`
+	result, err := s.db.Exec(query, d.UserID, d.Domain, d.VerificationToken, d.Status, d.CatchAll, string(checks), d.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create custom domain: %v", err)
+	}
+	d.ID, _ = result.LastInsertId()
+	return nil
+}
+
+// GetCustomDomains 获取用户的所有自有域名
+func (s *SQLiteStorage) GetCustomDomains(userID int64) ([]*CustomDomain, error) {
+	return s.queryCustomDomains(`SELECT `+customDomainColumns+` FROM custom_domains WHERE user_id = ? ORDER BY id`, userID)
+}
+
+// GetCustomDomain 获取用户的自有域名，不存在时返回 nil
+func (s *SQLiteStorage) GetCustomDomain(userID, id int64) (*CustomDomain, error) {
+	query := `SELECT ` + customDomainColumns + ` FROM custom_domains WHERE id = ? AND user_id = ?`
+	d, err := scanCustomDomain(s.db.QueryRow(query, id, userID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query custom domain: %v", err)
+	}
+	return d, nil
+}
+
+// GetCustomDomainByName 根据域名查找已验证的自有域名（任意用户），不存在时返回 nil
+// 未验证的申请可以有多条，不通过这里查询
+func (s *SQLiteStorage) GetCustomDomainByName(domain string) (*CustomDomain, error) {
+	query := `SELECT ` + customDomainColumns + ` FROM custom_domains WHERE domain = ? AND status = ?`
+	d, err := scanCustomDomain(s.db.QueryRow(query, strings.ToLower(domain), CustomDomainVerified))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query custom domain: %v", err)
+	}
+	return d, nil
+}
+
+// GetCustomDomainsByStatus 获取所有用户处于某个状态的自有域名，用于后台检查
+func (s *SQLiteStorage) GetCustomDomainsByStatus(status string) ([]*CustomDomain, error) {
+	return s.queryCustomDomains(`SELECT `+customDomainColumns+` FROM custom_domains WHERE status = ? ORDER BY id`, status)
+}
+
+// UpdateCustomDomainChecks 保存检查结果、状态和时间
+// 域名变为已验证时删除其他用户对同一域名的未验证申请；域名已被他人验证时返回错误
+func (s *SQLiteStorage) UpdateCustomDomainChecks(d *CustomDomain) error {
+	checks, err := json.Marshal(d.Checks)
+	if err != nil {
+		return fmt.Errorf("failed to marshal dns checks: %v", err)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE custom_domains SET status = ?, checks = ?, verified_at = ?, checked_at = ? WHERE id = ?`
+	result, err := tx.Exec(query, d.Status, string(checks), d.VerifiedAt, d.CheckedAt, d.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update custom domain: %v", err)
+	}
+
+	// 申请已被删除（例如同一域名先被他人验证）时不再处理
+	if n, _ := result.RowsAffected(); n > 0 && d.Status == CustomDomainVerified {
+		others := `SELECT id FROM custom_domains WHERE domain = ? AND id != ? AND status = ?`
+		if _, err := tx.Exec(`DELETE FROM custom_domain_addresses WHERE domain_id IN (`+others+`)`, d.Domain, d.ID, CustomDomainPending); err != nil {
+			return fmt.Errorf("failed to delete custom domain addresses: %v", err)
+		}
+		if _, err := tx.Exec(`DELETE FROM custom_domains WHERE id IN (`+others+`)`, d.Domain, d.ID, CustomDomainPending); err != nil {
+			return fmt.Errorf("failed to delete pending custom domains: %v", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// SetCustomDomainCatchAll 开启或关闭自有域名的catch-all
+func (s *SQLiteStorage) SetCustomDomainCatchAll(userID, id int64, enabled bool) error {
+	query := `UPDATE custom_domains SET catch_all = ? WHERE id = ? AND user_id = ?`
+	if _, err := s.db.Exec(query, enabled, id, userID); err != nil {
+		return fmt.Errorf("failed to update custom domain: %v", err)
+	}
+	return nil
+}
+
+// DeleteCustomDomain 删除自有域名及其所有地址，已收到的邮件保留
+func (s *SQLiteStorage) DeleteCustomDomain(userID, id int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM custom_domain_addresses WHERE domain_id = ? AND user_id = ?`, id, userID); err != nil {
+		return fmt.Errorf("failed to delete custom domain addresses: %v", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM custom_domains WHERE id = ? AND user_id = ?`, id, userID); err != nil {
+		return fmt.Errorf("failed to delete custom domain: %v", err)
+	}
+	return tx.Commit()
+}
+
+// CreateCustomDomainAddress 在自有域名下创建地址，写入 ID 和 CreatedAt
+func (s *SQLiteStorage) CreateCustomDomainAddress(addr *CustomDomainAddress) error {
+	addr.Email = strings.ToLower(addr.Email)
+	addr.CreatedAt = time.Now()
+
+	query := `INSERT INTO custom_domain_addresses (domain_id, user_id, email, created_at) VALUES (?, ?, ?, ?)`
+	result, err := s.db.Exec(query, addr.DomainID, addr.UserID, addr.Email, addr.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create custom domain address: %v", err)
+	}
+	addr.ID, _ = result.LastInsertId()
+	return nil
+}
+
+// GetCustomDomainAddresses 获取自有域名下的所有地址
+func (s *SQLiteStorage) GetCustomDomainAddresses(userID, domainID int64) ([]*CustomDomainAddress, error) {
+	query := `
+	SELECT id, domain_id, user_id, email, created_at
+	FROM custom_domain_addresses
+	WHERE domain_id = ? AND user_id = ?
+	ORDER BY email
+	`
+	rows, err := s.db.Query(query, domainID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query custom domain addresses: %v", err)
+	}
+	defer rows.Close()
+
+	addrs := []*CustomDomainAddress{}
+	for rows.Next() {
+		var a CustomDomainAddress
+		if err := rows.Scan(&a.ID, &a.DomainID, &a.UserID, &a.Email, &a.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan custom domain address: %v", err)
+		}
+		addrs = append(addrs, &a)
+	}
+	return addrs, nil
+}
+
+// DeleteCustomDomainAddress 删除自有域名下的地址
+func (s *SQLiteStorage) DeleteCustomDomainAddress(userID, domainID, id int64) error {
+	query := `DELETE FROM custom_domain_addresses WHERE id = ? AND domain_id = ? AND user_id = ?`
+	if _, err := s.db.Exec(query, id, domainID, userID); err != nil {
+		return fmt.Errorf("failed to delete custom domain address: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newPendingClaim 为用户添加一条未验证的自有域名申请
+func newPendingClaim(t *testing.T, s *SQLiteStorage, userID int64, domain string) *CustomDomain {
+	t.Helper()
+	d := &CustomDomain{UserID: userID, Domain: domain, VerificationToken: "token", Checks: []*DNSCheck{}}
+	if err := s.CreateCustomDomain(d); err != nil {
+		t.Fatalf("CreateCustomDomain: %v", err)
+	}
+	return d
+}
+
+// verifyClaim 把申请标记为已验证
+func verifyClaim(s *SQLiteStorage, d *CustomDomain) error {
+	now := time.Now()
+	d.Status = CustomDomainVerified
+	d.VerifiedAt = &now
+	d.CheckedAt = &now
+	return s.UpdateCustomDomainChecks(d)
+}
+
+func TestCustomDomainConcurrentClaims(t *testing.T) {
+	s := newTestStorage(t)
+
+	first := newPendingClaim(t, s, 1, "example.org")
+	second := newPendingClaim(t, s, 2, "example.org")
+
+	if d, err := s.GetCustomDomainByName("example.org"); err != nil || d != nil {
+		t.Fatalf("GetCustomDomainByName before verification = %+v, %v; want nil", d, err)
+	}
+
+	if err := verifyClaim(s, second); err != nil {
+		t.Fatalf("verify second claim: %v", err)
+	}
+	d, err := s.GetCustomDomainByName("example.org")
+	if err != nil || d == nil || d.UserID != 2 {
+		t.Fatalf("GetCustomDomainByName = %+v, %v; want user 2", d, err)
+	}
+
+	// 其他未验证的申请被删除
+	if d, err := s.GetCustomDomain(1, first.ID); err != nil || d != nil {
+		t.Errorf("pending claim of user 1 = %+v, %v; want deleted", d, err)
+	}
+
+	// 已验证的域名唯一
+	third := newPendingClaim(t, s, 3, "example.org")
+	if err := verifyClaim(s, third); err == nil {
+		t.Errorf("second verified claim for the same domain accepted")
+	}
+}
+
+func TestMigrateCustomDomainsDropsUniqueConstraint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mails.db")
+
+	// 旧版本的表结构
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	_, err = db.Exec(`
+	CREATE TABLE custom_domains (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		domain TEXT NOT NULL UNIQUE,
+		verification_token TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending',
+		catch_all BOOLEAN DEFAULT 0,
+		checks TEXT,
+		verified_at DATETIME,
+		checked_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	INSERT INTO custom_domains (user_id, domain, verification_token, status) VALUES (1, 'example.org', 'token', 'verified');
+	`)
+	db.Close()
+	if err != nil {
+		t.Fatalf("create legacy table: %v", err)
+	}
+
+	s, err := NewSQLiteStorage(path)
+	if err != nil {
+		t.Fatalf("NewSQLiteStorage: %v", err)
+	}
+	defer s.Close()
+
+	var tableSQL string
+	s.db.QueryRow(`SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'custom_domains'`).Scan(&tableSQL)
+	if strings.Contains(tableSQL, "UNIQUE") {
+		t.Errorf("custom_domains still has a UNIQUE constraint:\n%s", tableSQL)
+	}
+	if d, err := s.GetCustomDomainByName("example.org"); err != nil || d == nil || d.UserID != 1 {
+		t.Fatalf("migrated domain = %+v, %v", d, err)
+	}
+
+	newPendingClaim(t, s, 2, "example.org")
+	newPendingClaim(t, s, 3, "example.org")
+}
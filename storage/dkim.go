@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// DKIMKey 域名的DKIM签名密钥
+type DKIMKey struct {
+	ID         int64     `json:"id"`
+	Domain     string    `json:"domain"`
+	Selector   string    `json:"selector"`
+	Algorithm  string    `json:"algorithm"`  // rsa-sha256 或 ed25519-sha256
+	PrivateKey string    `json:"-"`          // PKCS#8 PEM
+	DNSRecord  string    `json:"dns_record"` // selector._domainkey 的TXT记录值
+	RecordID   string    `json:"record_id"`  // DNS服务商记录ID，未发布时为空
+	Active     bool      `json:"active"`     // 每个域名每种算法只有一个密钥用于签名
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// dkimKeyColumns 查询DKIM密钥时使用的列，顺序与 scanDKIMKey 一致
+const dkimKeyColumns = `id, domain, selector, algorithm, private_key, dns_record, COALESCE(record_id, ''), active, created_at`
+
+// scanDKIMKey 扫描一行DKIM密钥记录
+func scanDKIMKey(row rowScanner) (*DKIMKey, error) {
+	var key DKIMKey
+	err := row.Scan(&key.ID, &key.Domain, &key.Selector, &key.Algorithm, &key.PrivateKey, &key.DNSRecord, &key.RecordID, &key.Active, &key.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// CreateDKIMKey 保存新密钥并设为该域名同算法的签名密钥，旧密钥保留但不再使用
+func (s *SQLiteStorage) CreateDKIMKey(key *DKIMKey) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE dkim_keys SET active = 0 WHERE domain = ? AND algorithm = ?`, key.Domain, key.Algorithm); err != nil {
+		return fmt.Errorf("failed to deactivate dkim keys: %v", err)
+	}
+
+	key.Active = true
+	key.CreatedAt = time.Now()
+	query := `
+	INSERT INTO dkim_keys (domain, selector, algorithm, private_key, dns_record, record_id, active, created_at)
+	VALUES (?, ?, ?, ?, ?, ?, 1, ?)
+	`
+	result, err := tx.Exec(query, key.Domain, key.Selector, key.Algorithm, key.PrivateKey, key.DNSRecord, key.RecordID, key.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create dkim key: %v", err)
+	}
+	key.ID, _ = result.LastInsertId()
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit dkim key: %v", err)
+	}
+	return nil
+}
+
+// GetDKIMKeys 获取所有DKIM密钥
+func (s *SQLiteStorage) GetDKIMKeys() ([]*DKIMKey, error) {
+	query := `SELECT ` + dkimKeyColumns + ` FROM dkim_keys ORDER BY domain, created_at DESC`
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query dkim keys: %v", err)
+	}
+	defer rows.Close()
+
+	var keys []*DKIMKey
+	for rows.Next() {
+		key, err := scanDKIMKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan dkim key: %v", err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// GetDKIMKeyByID 根据ID获取DKIM密钥，不存在时返回 nil
+func (s *SQLiteStorage) GetDKIMKeyByID(id int64) (*DKIMKey, error) {
+	query := `SELECT ` + dkimKeyColumns + ` FROM dkim_keys WHERE id = ?`
+	key, err := scanDKIMKey(s.db.QueryRow(query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query dkim key: %v", err)
+	}
+	return key, nil
+}
+
+// GetActiveDKIMKeys 获取域名当前用于签名的密钥（每种算法最多一个）
+func (s *SQLiteStorage) GetActiveDKIMKeys(domain string) ([]*DKIMKey, error) {
+	query := `SELECT ` + dkimKeyColumns + ` FROM dkim_keys WHERE domain = ? AND active = 1 ORDER BY algorithm DESC`
+	rows, err := s.db.Query(query, domain)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query dkim keys: %v", err)
+	}
+	defer rows.Close()
+
+	var keys []*DKIMKey
+	for rows.Next() {
+		key, err := scanDKIMKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan dkim key: %v", err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// SetDKIMKeyRecordID 记录已发布的DNS记录ID
+func (s *SQLiteStorage) SetDKIMKeyRecordID(id int64, recordID string) error {
+	_, err := s.db.Exec(`UPDATE dkim_keys SET record_id = ? WHERE id = ?`, recordID, id)
+	if err != nil {
+		return fmt.Errorf("failed to update dkim key: %v", err)
+	}
+	return nil
+}
+
+// DeleteDKIMKey 删除DKIM密钥
+func (s *SQLiteStorage) DeleteDKIMKey(id int64) error {
+	_, err := s.db.Exec(`DELETE FROM dkim_keys WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete dkim key: %v", err)
+	}
+	return nil
+}
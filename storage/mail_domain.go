@@ -2,28 +2,62 @@ package storage
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"time"
 )
 
 // MailDomain 邮箱域名记录
 type MailDomain struct {
-	ID         int64     `json:"id"`
-	UserID     int64     `json:"user_id"`
-	Subdomain  string    `json:"subdomain"`
-	FullDomain string    `json:"full_domain"`
-	RecordID   string    `json:"record_id"`
-	Email      string    `json:"email"`
-	CreatedAt  time.Time `json:"created_at"`
+	ID             int64      `json:"id"`
+	UserID         int64      `json:"user_id"`
+	Subdomain      string     `json:"subdomain"`
+	FullDomain     string     `json:"full_domain"`
+	RecordID       string     `json:"record_id"`        // MX记录ID
+	ExtraRecordIDs []string   `json:"extra_record_ids"` // SPF、DMARC、TLS-RPT等记录的ID
+	Email          string     `json:"email"`
+	CatchAll       bool       `json:"catch_all"`  // 是否接收该域名下所有地址的邮件
+	ExpiresAt      *time.Time `json:"expires_at"` // 过期时间，到期后连同邮件一起删除，为空表示永久
+	CreatedAt      time.Time  `json:"created_at"`
 }
 
-// CreateMailDomain 创建邮箱域名记录
-func (s *SQLiteStorage) CreateMailDomain(userID int64, subdomain, fullDomain, recordID, email string) error {
+// mailDomainColumns 查询邮箱域名时使用的列，顺序与 scanMailDomain 一致
+const mailDomainColumns = `id, user_id, subdomain, full_domain, record_id, COALESCE(extra_record_ids, ''), email, catch_all, expires_at, created_at`
+
+// scanMailDomain 扫描一行邮箱域名记录
+func scanMailDomain(row rowScanner) (*MailDomain, error) {
+	var domain MailDomain
+	var extraRecordIDs string
+	var expiresAt sql.NullTime
+	err := row.Scan(&domain.ID, &domain.UserID, &domain.Subdomain, &domain.FullDomain, &domain.RecordID, &extraRecordIDs, &domain.Email, &domain.CatchAll, &expiresAt, &domain.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	domain.ExtraRecordIDs = []string{}
+	if extraRecordIDs != "" {
+		json.Unmarshal([]byte(extraRecordIDs), &domain.ExtraRecordIDs)
+	}
+	if expiresAt.Valid {
+		domain.ExpiresAt = &expiresAt.Time
+	}
+	return &domain, nil
+}
+
+// CreateMailDomain 创建邮箱域名记录，extraRecordIDs 为MX以外的DNS记录ID，expiresAt 为空表示永久
+func (s *SQLiteStorage) CreateMailDomain(userID int64, subdomain, fullDomain, recordID string, extraRecordIDs []string, email string, expiresAt *time.Time) error {
+	if extraRecordIDs == nil {
+		extraRecordIDs = []string{}
+	}
+	extraJSON, err := json.Marshal(extraRecordIDs)
+	if err != nil {
+		return fmt.Errorf("failed to marshal record ids: %v", err)
+	}
+
 	query := `
-	INSERT INTO mail_domains (user_id, subdomain, full_domain, record_id, email, created_at)
-	VALUES (?, ?, ?, ?, ?, ?)
+	INSERT INTO mail_domains (user_id, subdomain, full_domain, record_id, extra_record_ids, email, expires_at, created_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)
 	`
-	_, err := s.db.Exec(query, userID, subdomain, fullDomain, recordID, email, time.Now())
+	_, err = s.db.Exec(query, userID, subdomain, fullDomain, recordID, string(extraJSON), email, expiresAt, time.Now())
 	if err != nil {
 		return fmt.Errorf("failed to create mail domain: %v", err)
 	}
@@ -33,7 +67,7 @@ func (s *SQLiteStorage) CreateMailDomain(userID int64, subdomain, fullDomain, re
 // GetMailDomains 获取所有邮箱域名
 func (s *SQLiteStorage) GetMailDomains(userID int64) ([]*MailDomain, error) {
 	query := `
-	SELECT id, user_id, subdomain, full_domain, record_id, email, created_at
+	SELECT ` + mailDomainColumns + `
 	FROM mail_domains
 	WHERE user_id = ?
 	ORDER BY created_at DESC
@@ -46,49 +80,95 @@ func (s *SQLiteStorage) GetMailDomains(userID int64) ([]*MailDomain, error) {
 
 	var domains []*MailDomain
 	for rows.Next() {
-		var domain MailDomain
-		err := rows.Scan(&domain.ID, &domain.UserID, &domain.Subdomain, &domain.FullDomain, &domain.RecordID, &domain.Email, &domain.CreatedAt)
+		domain, err := scanMailDomain(rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan mail domain: %v", err)
 		}
-		domains = append(domains, &domain)
+		domains = append(domains, domain)
 	}
 	return domains, nil
 }
 
-// DeleteMailDomain 删除邮箱域名记录
+// GetMailDomain 根据ID获取用户的邮箱域名，不存在时返回 nil
+func (s *SQLiteStorage) GetMailDomain(userID int64, id int64) (*MailDomain, error) {
+	query := `
+	SELECT ` + mailDomainColumns + `
+	FROM mail_domains
+	WHERE id = ? AND user_id = ?
+	`
+	domain, err := scanMailDomain(s.db.QueryRow(query, id, userID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query mail domain: %v", err)
+	}
+	return domain, nil
+}
+
+// GetAllMailDomains 获取所有用户的邮箱域名，用于DNS记录对账
+func (s *SQLiteStorage) GetAllMailDomains() ([]*MailDomain, error) {
+	query := `
+	SELECT ` + mailDomainColumns + `
+	FROM mail_domains
+	ORDER BY id
+	`
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query mail domains: %v", err)
+	}
+	defer rows.Close()
+
+	var domains []*MailDomain
+	for rows.Next() {
+		domain, err := scanMailDomain(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan mail domain: %v", err)
+		}
+		domains = append(domains, domain)
+	}
+	return domains, nil
+}
+
+// DeleteMailDomain 删除邮箱域名记录及其别名
 func (s *SQLiteStorage) DeleteMailDomain(userID int64, id int64) error {
-	query := `DELETE FROM mail_domains WHERE id = ? AND user_id = ?`
-	_, err := s.db.Exec(query, id, userID)
+	tx, err := s.db.Begin()
 	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM aliases WHERE domain_id = ? AND user_id = ?`, id, userID); err != nil {
+		return fmt.Errorf("failed to delete aliases: %v", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM mail_domains WHERE id = ? AND user_id = ?`, id, userID); err != nil {
 		return fmt.Errorf("failed to delete mail domain: %v", err)
 	}
-	return nil
+	return tx.Commit()
 }
 
-// GetMailDomainByEmail 根据邮箱地址获取域名
+// GetMailDomainByEmail 根据邮箱地址获取域名，地址不区分大小写
 func (s *SQLiteStorage) GetMailDomainByEmail(email string) (*MailDomain, error) {
 	query := `
-	SELECT id, user_id, subdomain, full_domain, record_id, email, created_at
+	SELECT ` + mailDomainColumns + `
 	FROM mail_domains
-	WHERE email = ?
+	WHERE lower(email) = lower(?)
 	LIMIT 1
 	`
-	var domain MailDomain
-	err := s.db.QueryRow(query, email).Scan(&domain.ID, &domain.UserID, &domain.Subdomain, &domain.FullDomain, &domain.RecordID, &domain.Email, &domain.CreatedAt)
+	domain, err := scanMailDomain(s.db.QueryRow(query, email))
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to query mail domain: %v", err)
 	}
-	return &domain, nil
+	return domain, nil
 }
 
 // GetMailDomainsByDomain 根据域名查找所有记录（如 niuma946.com）
 func (s *SQLiteStorage) GetMailDomainsByDomain(domain string) ([]*MailDomain, error) {
 	query := `
-	SELECT id, user_id, subdomain, full_domain, record_id, email, created_at
+	SELECT ` + mailDomainColumns + `
 	FROM mail_domains
 	WHERE full_domain = ?
 	ORDER BY created_at DESC
@@ -101,12 +181,97 @@ func (s *SQLiteStorage) GetMailDomainsByDomain(domain string) ([]*MailDomain, er
 
 	var domains []*MailDomain
 	for rows.Next() {
-		var d MailDomain
-		err := rows.Scan(&d.ID, &d.UserID, &d.Subdomain, &d.FullDomain, &d.RecordID, &d.Email, &d.CreatedAt)
+		d, err := scanMailDomain(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan mail domain: %v", err)
+		}
+		domains = append(domains, d)
+	}
+	return domains, nil
+}
+
+// GetCatchAllMailDomain 查找开启了catch-all的域名记录，不存在时返回 nil
+func (s *SQLiteStorage) GetCatchAllMailDomain(domain string) (*MailDomain, error) {
+	query := `
+	SELECT ` + mailDomainColumns + `
+	FROM mail_domains
+	WHERE full_domain = ? AND catch_all = 1
+	ORDER BY created_at ASC
+	LIMIT 1
+	`
+	d, err := scanMailDomain(s.db.QueryRow(query, domain))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query catch-all mail domain: %v", err)
+	}
+	return d, nil
+}
+
+// SetMailDomainCatchAll 开启或关闭域名的catch-all
+func (s *SQLiteStorage) SetMailDomainCatchAll(userID int64, id int64, enabled bool) error {
+	query := `UPDATE mail_domains SET catch_all = ? WHERE id = ? AND user_id = ?`
+	result, err := s.db.Exec(query, enabled, id, userID)
+	if err != nil {
+		return fmt.Errorf("failed to update catch-all: %v", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("mail domain not found")
+	}
+	return nil
+}
+
+// SetMailDomainExpiry 设置域名的过期时间，为空表示永久
+func (s *SQLiteStorage) SetMailDomainExpiry(userID int64, id int64, expiresAt *time.Time) error {
+	query := `UPDATE mail_domains SET expires_at = ? WHERE id = ? AND user_id = ?`
+	result, err := s.db.Exec(query, expiresAt, id, userID)
+	if err != nil {
+		return fmt.Errorf("failed to update mail domain expiry: %v", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("mail domain not found")
+	}
+	return nil
+}
+
+// SetMailDomainExtraRecords 更新邮箱域名MX以外的DNS记录ID，用于对账补发或删除记录后
+func (s *SQLiteStorage) SetMailDomainExtraRecords(id int64, extraRecordIDs []string) error {
+	if extraRecordIDs == nil {
+		extraRecordIDs = []string{}
+	}
+	extraJSON, err := json.Marshal(extraRecordIDs)
+	if err != nil {
+		return fmt.Errorf("failed to marshal record ids: %v", err)
+	}
+
+	if _, err := s.db.Exec(`UPDATE mail_domains SET extra_record_ids = ? WHERE id = ?`, string(extraJSON), id); err != nil {
+		return fmt.Errorf("failed to update mail domain records: %v", err)
+	}
+	return nil
+}
+
+// GetExpiredMailDomains 获取在 before 之前过期的域名
+func (s *SQLiteStorage) GetExpiredMailDomains(before time.Time) ([]*MailDomain, error) {
+	query := `
+	SELECT ` + mailDomainColumns + `
+	FROM mail_domains
+	WHERE expires_at IS NOT NULL AND expires_at <= ?
+	ORDER BY expires_at ASC
+	`
+	rows, err := s.db.Query(query, before)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query expired mail domains: %v", err)
+	}
+	defer rows.Close()
+
+	var domains []*MailDomain
+	for rows.Next() {
+		d, err := scanMailDomain(rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan mail domain: %v", err)
 		}
-		domains = append(domains, &d)
+		domains = append(domains, d)
 	}
 	return domains, nil
 }
@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// idPlaceholders 生成 IN 查询的占位符和参数
+func idPlaceholders(ids []int64) (string, []interface{}) {
+	placeholders := make([]string, len(ids))
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		placeholders[i] = "?"
+		args[i] = id
+	}
+	return strings.Join(placeholders, ", "), args
+}
+
+// UpdateMailFlags 设置邮件的已读/星标状态，参数为nil的标记保持不变，返回更新的邮件数量
+func (s *SQLiteStorage) UpdateMailFlags(userID int64, ids []int64, read, starred *bool) (int64, error) {
+	if len(ids) == 0 || (read == nil && starred == nil) {
+		return 0, nil
+	}
+
+	var sets []string
+	var args []interface{}
+	if read != nil {
+		sets = append(sets, "is_read = ?")
+		args = append(args, *read)
+	}
+	if starred != nil {
+		sets = append(sets, "is_starred = ?")
+		args = append(args, *starred)
+	}
+
+	in, idArgs := idPlaceholders(ids)
+	args = append(args, userID)
+	args = append(args, idArgs...)
+
+	result, err := s.db.Exec(`UPDATE mails SET `+strings.Join(sets, ", ")+` WHERE user_id = ? AND id IN (`+in+`)`, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to update mail flags: %v", err)
+	}
+	return result.RowsAffected()
+}
+
+// TrashMails 将邮件移入回收站，超过保留期后由 PurgeTrash 永久删除
+func (s *SQLiteStorage) TrashMails(userID int64, ids []int64) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	in, idArgs := idPlaceholders(ids)
+	args := append([]interface{}{time.Now(), userID}, idArgs...)
+
+	result, err := s.db.Exec(`UPDATE mails SET deleted_at = ? WHERE user_id = ? AND deleted_at IS NULL AND id IN (`+in+`)`, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to trash mails: %v", err)
+	}
+	return result.RowsAffected()
+}
+
+// RestoreMails 从回收站恢复邮件
+func (s *SQLiteStorage) RestoreMails(userID int64, ids []int64) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	in, idArgs := idPlaceholders(ids)
+	args := append([]interface{}{userID}, idArgs...)
+
+	result, err := s.db.Exec(`UPDATE mails SET deleted_at = NULL WHERE user_id = ? AND deleted_at IS NOT NULL AND id IN (`+in+`)`, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to restore mails: %v", err)
+	}
+	return result.RowsAffected()
+}
+
+// DeleteMails 永久删除用户的邮件及其附件
+func (s *SQLiteStorage) DeleteMails(userID int64, ids []int64) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	in, idArgs := idPlaceholders(ids)
+	args := append([]interface{}{userID}, idArgs...)
+	return s.deleteMailsWhere(`user_id = ? AND id IN (`+in+`)`, args...)
+}
+
+// PurgeTrash 永久删除在回收站中超过保留期的邮件，返回删除的数量
+func (s *SQLiteStorage) PurgeTrash(before time.Time) (int64, error) {
+	return s.deleteMailsWhere(`deleted_at IS NOT NULL AND deleted_at < ?`, before)
+}
+
+// DeleteMailDomainMails 永久删除只发给某个邮箱的邮件（包括 +tag 地址、别名和catch-all），同时发给用户其他邮箱的邮件保留
+// 需要在删除邮箱域名记录之前调用
+func (s *SQLiteStorage) DeleteMailDomainMails(domain *MailDomain) (int64, error) {
+	match := `EXISTS (SELECT 1 FROM mail_domains d WHERE d.id = ? AND ` + mailDomainRecipientMatch + `)`
+	return s.deleteMailsWhere(`user_id = ?
+		AND EXISTS (SELECT 1 FROM json_each(mails.mail_to) r WHERE `+match+`)
+		AND NOT EXISTS (SELECT 1 FROM json_each(mails.mail_to) r WHERE NOT `+match+`)`,
+		domain.UserID, domain.ID, domain.ID)
+}
+
+// deleteMailsWhere 在一个事务中删除符合条件的邮件、附件，以及不再被引用的原始邮件和附件内容
+func (s *SQLiteStorage) deleteMailsWhere(where string, args ...interface{}) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM attachments WHERE mail_id IN (SELECT id FROM mails WHERE `+where+`)`, args...); err != nil {
+		return 0, fmt.Errorf("failed to delete attachments: %v", err)
+	}
+	result, err := tx.Exec(`DELETE FROM mails WHERE `+where, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete mails: %v", err)
+	}
+	deleted, _ := result.RowsAffected()
+
+	if deleted > 0 {
+		// 外发队列也引用 raw_messages，投递前不能删除
+		_, err := tx.Exec(`DELETE FROM raw_messages
+			WHERE NOT EXISTS (SELECT 1 FROM mails WHERE mails.raw_id = raw_messages.id)
+			AND NOT EXISTS (SELECT 1 FROM outbound_queue q WHERE q.raw_id = raw_messages.id)`)
+		if err != nil {
+			return 0, fmt.Errorf("failed to delete raw messages: %v", err)
+		}
+		_, err = tx.Exec(`DELETE FROM attachment_blobs WHERE NOT EXISTS (SELECT 1 FROM attachments WHERE attachments.blob_id = attachment_blobs.id)`)
+		if err != nil {
+			return 0, fmt.Errorf("failed to delete attachment blobs: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit mail deletion: %v", err)
+	}
+	return deleted, nil
+}
+
+// GetUnreadCounts 按收件地址统计未读邮件数（不含回收站）
+func (s *SQLiteStorage) GetUnreadCounts(userID int64) (map[string]int64, error) {
+	query := `
+	SELECT lower(r.value), COUNT(DISTINCT m.id)
+	FROM mails m, json_each(m.mail_to) r
+	WHERE m.user_id = ? AND COALESCE(m.is_read, 0) = 0 AND m.deleted_at IS NULL
+	GROUP BY lower(r.value)
+	`
+	rows, err := s.db.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count unread mails: %v", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int64)
+	for rows.Next() {
+		var address sql.NullString
+		var count int64
+		if err := rows.Scan(&address, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan unread count: %v", err)
+		}
+		counts[address.String] = count
+	}
+	return counts, nil
+}
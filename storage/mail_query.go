@@ -0,0 +1,216 @@
+package storage
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MailFilter 邮件列表的筛选条件，零值表示不限制
+type MailFilter struct {
+	DomainID      int64     // 只看发到某个邮箱（MailDomain）的邮件，包括 +tag 地址、别名和catch-all
+	To            string    // 收件地址
+	Mailbox       string    // 收件邮箱，同时匹配发到 user+tag@domain 的邮件
+	Tag           string    // 收件地址中的 +tag
+	From          string    // 发件人，匹配信封发件人和邮件头 From（子串）
+	Subject       string    // 主题（子串）
+	Since         time.Time // 接收时间下限（含）
+	Until         time.Time // 接收时间上限（不含）
+	Read          *bool     // 已读/未读
+	Starred       *bool     // 星标
+	HasAttachment *bool     // 是否有附件
+	Trash         bool      // 只看回收站，否则不含回收站中的邮件
+
+	Before  *MailCursor // 返回比游标更早的邮件（翻页），按时间倒序
+	After   *MailCursor // 返回比游标更新的邮件（轮询新邮件），按时间正序
+	AfterID int64       // 返回ID大于该值的邮件（事件续传），按ID正序
+	Limit   int
+	Offset  int // 未使用游标时的偏移量
+}
+
+// MailCursor 邮件列表游标，按 (received_at, id) 定位，新邮件到达时翻页不会跳过或重复
+type MailCursor struct {
+	ReceivedAt time.Time
+	ID         int64
+}
+
+// CursorOf 返回指向某封邮件的游标
+func CursorOf(mail *Mail) *MailCursor {
+	return &MailCursor{ReceivedAt: mail.ReceivedAt, ID: mail.ID}
+}
+
+// String 编码为不透明的字符串，供客户端原样传回
+func (c *MailCursor) String() string {
+	raw := c.ReceivedAt.Format(time.RFC3339Nano) + "|" + strconv.FormatInt(c.ID, 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// ParseMailCursor 解析 MailCursor.String 生成的游标
+func ParseMailCursor(s string) (*MailCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	ts, idStr, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	receivedAt, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	return &MailCursor{ReceivedAt: receivedAt, ID: id}, nil
+}
+
+// where 生成筛选条件（不含游标和分页）
+func (f *MailFilter) where(userID int64) (string, []interface{}) {
+	conditions := []string{"m.user_id = ?"}
+	args := []interface{}{userID}
+
+	if f.Trash {
+		conditions = append(conditions, "m.deleted_at IS NOT NULL")
+	} else {
+		conditions = append(conditions, "m.deleted_at IS NULL")
+	}
+
+	if f.DomainID > 0 {
+		conditions = append(conditions, `EXISTS (
+			SELECT 1 FROM mail_domains d, json_each(m.mail_to) r
+			WHERE d.id = ? AND d.user_id = m.user_id
+			AND `+mailDomainRecipientMatch+`
+		)`)
+		args = append(args, f.DomainID)
+	}
+	if f.To != "" {
+		conditions = append(conditions, `EXISTS (SELECT 1 FROM json_each(m.mail_to) r WHERE lower(r.value) = lower(?))`)
+		args = append(args, f.To)
+	}
+	if f.Mailbox != "" {
+		// 与收信时一样去掉 +tag 后比较
+		base, _ := SplitAddressTag(strings.ToLower(f.Mailbox))
+		pattern := base
+		if at := strings.LastIndex(base, "@"); at >= 0 {
+			escape := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+			pattern = escape.Replace(base[:at]) + "+%" + escape.Replace(base[at:])
+		}
+		conditions = append(conditions, `EXISTS (SELECT 1 FROM json_each(m.mail_to) r WHERE lower(r.value) = ? OR lower(r.value) LIKE ? ESCAPE '\')`)
+		args = append(args, base, pattern)
+	}
+	if f.Tag != "" {
+		conditions = append(conditions, `EXISTS (SELECT 1 FROM json_each(m.tags) t WHERE lower(t.value) = lower(?))`)
+		args = append(args, f.Tag)
+	}
+	if f.From != "" {
+		pattern := "%" + strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(f.From) + "%"
+		conditions = append(conditions, `(m.mail_from LIKE ? ESCAPE '\' OR COALESCE(m.from_address, '') LIKE ? ESCAPE '\' OR COALESCE(m.from_name, '') LIKE ? ESCAPE '\')`)
+		args = append(args, pattern, pattern, pattern)
+	}
+	if f.Subject != "" {
+		pattern := "%" + strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(f.Subject) + "%"
+		conditions = append(conditions, `m.subject LIKE ? ESCAPE '\'`)
+		args = append(args, pattern)
+	}
+	if !f.Since.IsZero() {
+		// received_at 以本地时区的文本保存，比较前统一时区
+		conditions = append(conditions, "m.received_at >= ?")
+		args = append(args, f.Since.Local())
+	}
+	if !f.Until.IsZero() {
+		conditions = append(conditions, "m.received_at < ?")
+		args = append(args, f.Until.Local())
+	}
+	if f.Read != nil {
+		conditions = append(conditions, "COALESCE(m.is_read, 0) = ?")
+		args = append(args, *f.Read)
+	}
+	if f.Starred != nil {
+		conditions = append(conditions, "COALESCE(m.is_starred, 0) = ?")
+		args = append(args, *f.Starred)
+	}
+	if f.HasAttachment != nil {
+		exists := "EXISTS (SELECT 1 FROM attachments a WHERE a.mail_id = m.id)"
+		if !*f.HasAttachment {
+			exists = "NOT " + exists
+		}
+		conditions = append(conditions, exists)
+	}
+
+	return strings.Join(conditions, " AND "), args
+}
+
+// ListMails 按条件获取邮件列表，默认按接收时间倒序
+func (s *SQLiteStorage) ListMails(userID int64, filter *MailFilter) ([]*Mail, error) {
+	if filter == nil {
+		filter = &MailFilter{}
+	}
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = 20
+	}
+
+	where, args := filter.where(userID)
+	order := "m.received_at DESC, m.id DESC"
+	offset := filter.Offset
+	switch {
+	case filter.After != nil:
+		where += " AND (m.received_at > ? OR (m.received_at = ? AND m.id > ?))"
+		args = append(args, filter.After.ReceivedAt, filter.After.ReceivedAt, filter.After.ID)
+		order = "m.received_at ASC, m.id ASC"
+		offset = 0
+	case filter.AfterID > 0:
+		where += " AND m.id > ?"
+		args = append(args, filter.AfterID)
+		order = "m.id ASC"
+		offset = 0
+	case filter.Before != nil:
+		where += " AND (m.received_at < ? OR (m.received_at = ? AND m.id < ?))"
+		args = append(args, filter.Before.ReceivedAt, filter.Before.ReceivedAt, filter.Before.ID)
+		offset = 0
+	}
+
+	query := `
+	SELECT ` + mailColumns + `
+	FROM ` + mailTables + `
+	WHERE ` + where + `
+	ORDER BY ` + order + `
+	LIMIT ? OFFSET ?
+	`
+	args = append(args, limit, offset)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query mails: %v", err)
+	}
+	defer rows.Close()
+
+	mails := []*Mail{}
+	for rows.Next() {
+		mail, err := scanMail(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan mail: %v", err)
+		}
+		mails = append(mails, mail)
+	}
+	return mails, nil
+}
+
+// CountMails 统计符合条件的邮件数量（忽略游标和分页）
+func (s *SQLiteStorage) CountMails(userID int64, filter *MailFilter) (int64, error) {
+	if filter == nil {
+		filter = &MailFilter{}
+	}
+	where, args := filter.where(userID)
+
+	var count int64
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM mails m WHERE `+where, args...).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count mails: %v", err)
+	}
+	return count, nil
+}
@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+)
+
+// 外发队列任务状态
+const (
+	OutboundPending   = "pending"   // 等待投递
+	OutboundSending   = "sending"   // 正在投递
+	OutboundDelivered = "delivered" // 投递成功
+	OutboundFailed    = "failed"    // 永久失败（已退信）
+)
+
+// OutboundJob 外发队列任务（每个收件人一条）
+type OutboundJob struct {
+	ID            int64     `json:"id"`
+	RawID         int64     `json:"raw_id"`
+	From          string    `json:"from"`
+	To            string    `json:"to"`
+	Status        string    `json:"status"`
+	Attempts      int       `json:"attempts"`
+	LastError     string    `json:"last_error"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	CreatedAt     time.Time `json:"created_at"`
+	RawData       string    `json:"-"`
+}
+
+// EnqueueOutbound 将邮件加入外发队列，原始数据只保存一份，每个收件人一条任务
+func (s *SQLiteStorage) EnqueueOutbound(from string, recipients []string, rawData string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	result, err := tx.Exec(`INSERT INTO raw_messages (data, created_at) VALUES (?, ?)`, rawData, now)
+	if err != nil {
+		return fmt.Errorf("failed to insert raw message: %v", err)
+	}
+	rawID, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get raw message id: %v", err)
+	}
+
+	query := `
+	INSERT INTO outbound_queue (raw_id, mail_from, rcpt_to, status, attempts, next_attempt_at, created_at, updated_at)
+	VALUES (?, ?, ?, ?, 0, ?, ?, ?)
+	`
+	for _, rcpt := range recipients {
+		if _, err := tx.Exec(query, rawID, from, rcpt, OutboundPending, now, now, now); err != nil {
+			return fmt.Errorf("failed to enqueue outbound mail: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit outbound mail: %v", err)
+	}
+	return nil
+}
+
+// ClaimOutboundJobs 取出到期的待投递任务并标记为投递中
+func (s *SQLiteStorage) ClaimOutboundJobs(limit int) ([]*OutboundJob, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+	SELECT q.id, q.raw_id, q.mail_from, q.rcpt_to, q.status, q.attempts, COALESCE(q.last_error, ''), q.next_attempt_at, q.created_at, r.data
+	FROM outbound_queue q JOIN raw_messages r ON r.id = q.raw_id
+	WHERE q.status = ? AND q.next_attempt_at <= ?
+	ORDER BY q.next_attempt_at ASC
+	LIMIT ?
+	`
+	rows, err := tx.Query(query, OutboundPending, time.Now(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query outbound queue: %v", err)
+	}
+
+	var jobs []*OutboundJob
+	for rows.Next() {
+		var job OutboundJob
+		err := rows.Scan(&job.ID, &job.RawID, &job.From, &job.To, &job.Status, &job.Attempts, &job.LastError, &job.NextAttemptAt, &job.CreatedAt, &job.RawData)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan outbound job: %v", err)
+		}
+		jobs = append(jobs, &job)
+	}
+	rows.Close()
+
+	for _, job := range jobs {
+		_, err := tx.Exec(`UPDATE outbound_queue SET status = ?, updated_at = ? WHERE id = ?`, OutboundSending, time.Now(), job.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to claim outbound job: %v", err)
+		}
+		job.Status = OutboundSending
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit outbound claim: %v", err)
+	}
+	return jobs, nil
+}
+
+// CompleteOutboundJob 标记任务投递成功
+func (s *SQLiteStorage) CompleteOutboundJob(id int64) error {
+	query := `UPDATE outbound_queue SET status = ?, attempts = attempts + 1, last_error = NULL, updated_at = ? WHERE id = ?`
+	_, err := s.db.Exec(query, OutboundDelivered, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("failed to complete outbound job: %v", err)
+	}
+	return nil
+}
+
+// RetryOutboundJob 记录失败原因并安排下次重试
+func (s *SQLiteStorage) RetryOutboundJob(id int64, nextAttempt time.Time, lastError string) error {
+	query := `UPDATE outbound_queue SET status = ?, attempts = attempts + 1, last_error = ?, next_attempt_at = ?, updated_at = ? WHERE id = ?`
+	_, err := s.db.Exec(query, OutboundPending, lastError, nextAttempt, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("failed to reschedule outbound job: %v", err)
+	}
+	return nil
+}
+
+// FailOutboundJob 标记任务永久失败
+func (s *SQLiteStorage) FailOutboundJob(id int64, lastError string) error {
+	query := `UPDATE outbound_queue SET status = ?, attempts = attempts + 1, last_error = ?, updated_at = ? WHERE id = ?`
+	_, err := s.db.Exec(query, OutboundFailed, lastError, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("failed to fail outbound job: %v", err)
+	}
+	return nil
+}
+
+// ResetOutboundJobs 将上次进程退出时仍在投递中的任务恢复为待投递
+func (s *SQLiteStorage) ResetOutboundJobs() error {
+	_, err := s.db.Exec(`UPDATE outbound_queue SET status = ?, updated_at = ? WHERE status = ?`, OutboundPending, time.Now(), OutboundSending)
+	if err != nil {
+		return fmt.Errorf("failed to reset outbound jobs: %v", err)
+	}
+	return nil
+}
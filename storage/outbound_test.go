@@ -0,0 +1,41 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPurgeTrashKeepsQueuedRawMessages(t *testing.T) {
+	s := newTestStorage(t)
+
+	if err := s.EnqueueOutbound("a@test.local", []string{"b@example.org"}, "Subject: out\r\n\r\nbody\r\n"); err != nil {
+		t.Fatalf("EnqueueOutbound: %v", err)
+	}
+
+	// 回收站中的邮件被清理时会回收没有引用的原始邮件
+	rawID, err := s.SaveRawMessage("Subject: in\r\n\r\nbody\r\n")
+	if err != nil {
+		t.Fatalf("SaveRawMessage: %v", err)
+	}
+	mailID, err := s.SaveMailCopy(1, "c@example.org", []string{"a@test.local"}, "in", "body", "", MailHeaders{}, rawID, AuthResult{})
+	if err != nil {
+		t.Fatalf("SaveMailCopy: %v", err)
+	}
+	if _, err := s.TrashMails(1, []int64{mailID}); err != nil {
+		t.Fatalf("TrashMails: %v", err)
+	}
+	if n, err := s.PurgeTrash(time.Now().Add(time.Minute)); err != nil || n != 1 {
+		t.Fatalf("PurgeTrash = %d, %v; want 1", n, err)
+	}
+
+	jobs, err := s.ClaimOutboundJobs(10)
+	if err != nil {
+		t.Fatalf("ClaimOutboundJobs: %v", err)
+	}
+	if len(jobs) != 1 || jobs[0].To != "b@example.org" || jobs[0].RawData != "Subject: out\r\n\r\nbody\r\n" {
+		t.Fatalf("claimed jobs = %+v, want the queued mail", jobs)
+	}
+	if n := countRows(t, s, "raw_messages"); n != 1 {
+		t.Errorf("raw_messages has %d rows, want only the queued message", n)
+	}
+}
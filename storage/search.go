@@ -0,0 +1,261 @@
+package storage
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// 全文索引中各列对应的邮件内容，触发器和回退的 LIKE 查询共用
+const (
+	searchSubjectExpr    = `COALESCE(%[1]s.subject, '')`
+	searchBodyExpr       = `CASE WHEN COALESCE(%[1]s.body, '') = '' THEN COALESCE(%[1]s.html_body, '') ELSE %[1]s.body END`
+	searchSenderExpr     = `%[1]s.mail_from || ' ' || COALESCE(%[1]s.from_name, '') || ' ' || COALESCE(%[1]s.from_address, '')`
+	searchRecipientsExpr = `%[1]s.mail_to || ' ' || COALESCE(%[1]s.header_to, '') || ' ' || COALESCE(%[1]s.header_cc, '')`
+)
+
+// searchFields 查询语法中的字段名对应的索引列
+var searchFields = map[string]string{
+	"subject": "subject",
+	"body":    "body",
+	"from":    "sender",
+	"to":      "recipients",
+}
+
+// searchColumnExprs 索引列对应的表达式
+var searchColumnExprs = map[string]string{
+	"subject":    searchSubjectExpr,
+	"body":       searchBodyExpr,
+	"sender":     searchSenderExpr,
+	"recipients": searchRecipientsExpr,
+}
+
+// searchTerm 查询中的一个条件
+type searchTerm struct {
+	column string // 索引列，为空时匹配所有列
+	text   string // 按子串匹配，前缀写法 veri* 去掉 * 后同样适用
+}
+
+// ftsTriggers 保持全文索引与 mails 表同步的触发器
+var ftsTriggers = []string{"mails_fts_insert", "mails_fts_delete", "mails_fts_update"}
+
+// ftsMinTermLength trigram 分词只能匹配至少3个字符的条件，更短的条件（如两个字的中文词）使用 LIKE
+const ftsMinTermLength = 3
+
+// initSearch 创建FTS5全文索引并用触发器与 mails 表保持同步，SQLite未编译FTS5时退回 LIKE 查询
+// 索引使用 trigram 分词，按子串匹配，中文等没有空格分词的文本也能搜索
+func (s *SQLiteStorage) initSearch() {
+	var available bool
+	s.db.QueryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&available)
+	if !available {
+		// 之前用FTS5版本创建的触发器会让所有邮件写入失败（no such module: fts5），需要删除
+		s.dropSearchTriggers()
+		log.Printf("[Storage] 全文索引不可用，搜索将使用 LIKE 查询（编译时加 -tags sqlite_fts5 启用）")
+		return
+	}
+
+	var tableSQL string
+	s.db.QueryRow(`SELECT COALESCE(sql, '') FROM sqlite_master WHERE type = 'table' AND name = 'mails_fts'`).Scan(&tableSQL)
+	var triggerCount int
+	s.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name IN ('mails_fts_insert', 'mails_fts_delete', 'mails_fts_update')`).Scan(&triggerCount)
+
+	// 旧版本的 unicode61 索引，或者触发器曾被删除（索引已过期）时重建
+	if tableSQL != "" && strings.Contains(tableSQL, "trigram") && triggerCount == len(ftsTriggers) {
+		s.fts = true
+		return
+	}
+	if tableSQL != "" {
+		log.Printf("[Storage] 重建全文索引")
+	}
+	s.dropSearchTriggers()
+	if _, err := s.db.Exec(`DROP TABLE IF EXISTS mails_fts`); err != nil {
+		log.Printf("[Storage] 删除旧的全文索引失败: %v", err)
+		return
+	}
+
+	_, err := s.db.Exec(`CREATE VIRTUAL TABLE mails_fts USING fts5(
+		subject, body, sender, recipients,
+		tokenize = 'trigram'
+	)`)
+	if err != nil {
+		log.Printf("[Storage] 全文索引不可用，搜索将使用 LIKE 查询: %v", err)
+		return
+	}
+
+	values := fmt.Sprintf(`new.id, `+searchSubjectExpr+`, `+searchBodyExpr+`, `+searchSenderExpr+`, `+searchRecipientsExpr, "new")
+	triggers := `
+	CREATE TRIGGER mails_fts_insert AFTER INSERT ON mails BEGIN
+		INSERT INTO mails_fts (rowid, subject, body, sender, recipients) VALUES (` + values + `);
+	END;
+	CREATE TRIGGER mails_fts_delete AFTER DELETE ON mails BEGIN
+		DELETE FROM mails_fts WHERE rowid = old.id;
+	END;
+	CREATE TRIGGER mails_fts_update AFTER UPDATE OF subject, body, html_body, mail_from, mail_to, from_name, from_address, header_to, header_cc ON mails BEGIN
+		DELETE FROM mails_fts WHERE rowid = old.id;
+		INSERT INTO mails_fts (rowid, subject, body, sender, recipients) VALUES (` + values + `);
+	END;
+	`
+	if _, err := s.db.Exec(triggers); err != nil {
+		log.Printf("[Storage] 创建全文索引触发器失败: %v", err)
+		s.dropSearchTriggers()
+		return
+	}
+
+	// 为已有邮件建立索引
+	backfill := fmt.Sprintf(`INSERT INTO mails_fts (rowid, subject, body, sender, recipients)
+	SELECT m.id, `+searchSubjectExpr+`, `+searchBodyExpr+`, `+searchSenderExpr+`, `+searchRecipientsExpr+` FROM mails m`, "m")
+	if _, err := s.db.Exec(backfill); err != nil {
+		log.Printf("[Storage] 建立全文索引失败: %v", err)
+		s.dropSearchTriggers()
+		return
+	}
+	s.fts = true
+}
+
+// dropSearchTriggers 删除全文索引触发器
+func (s *SQLiteStorage) dropSearchTriggers() {
+	for _, name := range ftsTriggers {
+		if _, err := s.db.Exec(`DROP TRIGGER IF EXISTS ` + name); err != nil {
+			log.Printf("[Storage] 删除全文索引触发器 %s 失败: %v", name, err)
+		}
+	}
+}
+
+// SearchMails 全文搜索用户的邮件，支持 "短语"、前缀 veri* 和 from:/to:/subject:/body: 字段限定，多个条件同时满足
+func (s *SQLiteStorage) SearchMails(userID int64, query string, limit, offset int) ([]*Mail, error) {
+	if limit <= 0 {
+		limit = 20
+	}
+
+	terms := parseSearchQuery(query)
+	if len(terms) == 0 {
+		return []*Mail{}, nil
+	}
+
+	// 少于3个字符的条件 trigram 索引无法匹配，改用 LIKE
+	var ftsTerms, likeTerms []searchTerm
+	for _, t := range terms {
+		if s.fts && utf8.RuneCountInString(t.text) >= ftsMinTermLength {
+			ftsTerms = append(ftsTerms, t)
+		} else {
+			likeTerms = append(likeTerms, t)
+		}
+	}
+
+	from := mailTables
+	conditions := []string{"m.user_id = ?", "m.deleted_at IS NULL"}
+	args := []interface{}{userID}
+	if len(ftsTerms) > 0 {
+		from += ` JOIN mails_fts ON mails_fts.rowid = m.id`
+		conditions = append(conditions, "mails_fts MATCH ?")
+		args = append(args, ftsExpression(ftsTerms))
+	}
+	if len(likeTerms) > 0 {
+		where, likeArgs := likeConditions(likeTerms)
+		conditions = append(conditions, where)
+		args = append(args, likeArgs...)
+	}
+	args = append(args, limit, offset)
+
+	sqlQuery := `
+	SELECT ` + mailColumns + `
+	FROM ` + from + `
+	WHERE ` + strings.Join(conditions, " AND ") + `
+	ORDER BY m.received_at DESC
+	LIMIT ? OFFSET ?
+	`
+
+	rows, err := s.db.Query(sqlQuery, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search mails: %v", err)
+	}
+	defer rows.Close()
+
+	mails := []*Mail{}
+	for rows.Next() {
+		mail, err := scanMail(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan mail: %v", err)
+		}
+		mails = append(mails, mail)
+	}
+	return mails, nil
+}
+
+// parseSearchQuery 解析搜索语句，如 from:github subject:"verify your" code*
+func parseSearchQuery(query string) []searchTerm {
+	var terms []searchTerm
+	rest := strings.TrimSpace(query)
+	for rest != "" {
+		var term searchTerm
+
+		// 字段限定
+		if colon := strings.IndexByte(rest, ':'); colon > 0 {
+			if column, ok := searchFields[strings.ToLower(rest[:colon])]; ok {
+				term.column = column
+				rest = rest[colon+1:]
+			}
+		}
+
+		if strings.HasPrefix(rest, `"`) {
+			// 短语，到下一个引号为止
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				term.text, rest = rest[1:], ""
+			} else {
+				term.text, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else {
+			end := strings.IndexFunc(rest, unicode.IsSpace)
+			if end < 0 {
+				end = len(rest)
+			}
+			term.text, rest = rest[:end], rest[end:]
+		}
+
+		term.text = strings.TrimRight(term.text, "*")
+		term.text = strings.TrimSpace(term.text)
+		if term.text != "" {
+			terms = append(terms, term)
+		}
+		rest = strings.TrimSpace(rest)
+	}
+	return terms
+}
+
+// ftsExpression 生成FTS5查询表达式，每个条件都作为字符串引用，避免用户输入被当作查询语法
+// trigram 索引按子串匹配，前缀条件不需要额外的 *
+func ftsExpression(terms []searchTerm) string {
+	parts := make([]string, 0, len(terms))
+	for _, t := range terms {
+		expr := `"` + strings.ReplaceAll(t.text, `"`, `""`) + `"`
+		if t.column != "" {
+			expr = t.column + " : " + expr
+		}
+		parts = append(parts, expr)
+	}
+	return strings.Join(parts, " AND ")
+}
+
+// likeConditions 用 LIKE 实现同样的查询（前缀和短语都按子串匹配），用于未启用FTS5或条件过短时
+func likeConditions(terms []searchTerm) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	for _, t := range terms {
+		pattern := "%" + strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(t.text) + "%"
+
+		columns := []string{t.column}
+		if t.column == "" {
+			columns = []string{"subject", "body", "sender", "recipients"}
+		}
+		var alternatives []string
+		for _, column := range columns {
+			alternatives = append(alternatives, "("+fmt.Sprintf(searchColumnExprs[column], "m")+`) LIKE ? ESCAPE '\'`)
+			args = append(args, pattern)
+		}
+		conditions = append(conditions, "("+strings.Join(alternatives, " OR ")+")")
+	}
+	return strings.Join(conditions, " AND "), args
+}
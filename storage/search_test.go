@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func saveTestMail(t *testing.T, s *SQLiteStorage, userID int64, from, subject, body string) int64 {
+	t.Helper()
+	id, err := s.SaveMailCopy(userID, from, []string{"box@abc.test.local"}, subject, body, "", MailHeaders{}, 0, AuthResult{})
+	if err != nil {
+		t.Fatalf("SaveMailCopy: %v", err)
+	}
+	return id
+}
+
+func TestSearchMails(t *testing.T) {
+	s := newTestStorage(t)
+	code := saveTestMail(t, s, 1, "noreply@github.com", "您的验证码是123456", "请在10分钟内完成验证。")
+	login := saveTestMail(t, s, 1, "service@bank.example", "账户通知", "您的账户在新设备上登录，如非本人操作请修改密码。")
+	verify := saveTestMail(t, s, 1, "noreply@example.com", "Please verify your device", "Your verification code is 654321")
+	saveTestMail(t, s, 2, "noreply@github.com", "您的验证码是999999", "其他用户的邮件")
+
+	tests := []struct {
+		query string
+		want  []int64
+	}{
+		{"验证码", []int64{code}},
+		{"验证", []int64{code}},
+		{"新设备上登录", []int64{login}},
+		{"登录", []int64{login}},
+		{"body:修改密码", []int64{login}},
+		{"subject:验证码 from:github", []int64{code}},
+		{"subject:登录", nil},
+		{`"verify your"`, []int64{verify}},
+		{"veri*", []int64{verify}},
+		{"VERIFICATION", []int64{verify}},
+		{"from:github", []int64{code}},
+		{"123456", []int64{code}},
+		{"不存在的内容", nil},
+	}
+
+	for _, tt := range tests {
+		mails, err := s.SearchMails(1, tt.query, 20, 0)
+		if err != nil {
+			t.Fatalf("SearchMails(%q): %v", tt.query, err)
+		}
+		var got []int64
+		for _, m := range mails {
+			got = append(got, m.ID)
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("SearchMails(%q) = %v, want %v", tt.query, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("SearchMails(%q) = %v, want %v", tt.query, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestSearchTriggersRemovedWithoutFTS5(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mails.db")
+	s, err := NewSQLiteStorage(path)
+	if err != nil {
+		t.Fatalf("NewSQLiteStorage: %v", err)
+	}
+	if s.fts {
+		s.Close()
+		t.Skip("built with FTS5")
+	}
+
+	// 模拟用带FTS5的版本创建过的数据库
+	_, err = s.db.Exec(`CREATE TRIGGER mails_fts_insert AFTER INSERT ON mails BEGIN
+		INSERT INTO mails_fts (rowid, subject) VALUES (new.id, new.subject);
+	END`)
+	if err != nil {
+		t.Fatalf("create trigger: %v", err)
+	}
+	s.Close()
+
+	s, err = NewSQLiteStorage(path)
+	if err != nil {
+		t.Fatalf("NewSQLiteStorage: %v", err)
+	}
+	defer s.Close()
+	saveTestMail(t, s, 1, "a@example.org", "hi", "body")
+}
+
+func TestSearchIndexRebuiltFromUnicode61(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mails.db")
+	s, err := NewSQLiteStorage(path)
+	if err != nil {
+		t.Fatalf("NewSQLiteStorage: %v", err)
+	}
+	if !s.fts {
+		s.Close()
+		t.Skip("built without FTS5")
+	}
+
+	// 旧版本使用 unicode61 分词，整段中文是一个词
+	s.dropSearchTriggers()
+	for _, stmt := range []string{
+		`DROP TABLE mails_fts`,
+		`CREATE VIRTUAL TABLE mails_fts USING fts5(subject, body, sender, recipients, tokenize = 'unicode61 remove_diacritics 2')`,
+	} {
+		if _, err := s.db.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	id := saveTestMail(t, s, 1, "noreply@github.com", "您的验证码是123456", "body")
+	s.Close()
+
+	s, err = NewSQLiteStorage(path)
+	if err != nil {
+		t.Fatalf("NewSQLiteStorage: %v", err)
+	}
+	defer s.Close()
+
+	mails, err := s.SearchMails(1, "验证码", 20, 0)
+	if err != nil {
+		t.Fatalf("SearchMails: %v", err)
+	}
+	if len(mails) != 1 || mails[0].ID != id {
+		t.Errorf("SearchMails after rebuild = %v, want mail %d", mails, id)
+	}
+}
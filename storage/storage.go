@@ -4,6 +4,8 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"log"
+	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -11,29 +13,127 @@ import (
 
 // Mail 邮件数据模型
 type Mail struct {
-	ID         int64     `json:"id"`
-	From       string    `json:"from"`
-	To         string    `json:"to"` // JSON array
-	Subject    string    `json:"subject"`
-	Body       string    `json:"body"`
-	RawData    string    `json:"raw_data"`
-	ReceivedAt time.Time `json:"received_at"`
+	ID         int64      `json:"id"`
+	From       string     `json:"from"`
+	To         string     `json:"to"` // JSON array
+	Subject    string     `json:"subject"`
+	Body       string     `json:"body"`
+	HTML       string     `json:"html"`
+	RawData    string     `json:"raw_data"`
+	ReceivedAt time.Time  `json:"received_at"`
+	Read       bool       `json:"read"`
+	Starred    bool       `json:"starred"`
+	DeletedAt  *time.Time `json:"deleted_at,omitempty"` // 移入回收站的时间
+	Tags       []string   `json:"tags"`                 // 收件地址中的 +tag
+	MailHeaders
+	MailCodes
+	AuthResult
+	Spoofed bool `json:"spoofed"` // 发件人验证失败，可能是伪造的邮件
+}
+
+// Recipients 解析信封收件人列表（To 字段是JSON数组）
+func (m *Mail) Recipients() []string {
+	var to []string
+	json.Unmarshal([]byte(m.To), &to)
+	return to
+}
+
+// Address 邮件地址及显示名
+type Address struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+}
+
+// MailHeaders 邮件头中的地址（已解码），From 字段是信封发件人，header_from 才是收件人看到的发件人
+type MailHeaders struct {
+	HeaderFrom Address   `json:"header_from"`
+	HeaderTo   []Address `json:"header_to"`
+	Cc         []Address `json:"cc"`
+}
+
+// MailCodes 收信时从正文中识别出的验证码和验证/登录链接，按可信度排序
+type MailCodes struct {
+	Codes []string `json:"codes"`
+	Links []string `json:"links"`
+}
+
+// MailCopy 投递给一个用户的邮件记录及其附件、验证码和 +tag，由 DeliverMailCopy 一次保存
+type MailCopy struct {
+	UserID      int64
+	From        string
+	To          []string
+	Subject     string
+	Body        string
+	HTML        string
+	Headers     MailHeaders
+	RawID       int64
+	Auth        AuthResult
+	Attachments []*Attachment
+	Codes       MailCodes
+	Tags        []string
+}
+
+// AuthResult 入站邮件的发件人认证结果，未检查时为空
+type AuthResult struct {
+	SPF   string `json:"spf"`
+	DKIM  string `json:"dkim"`
+	DMARC string `json:"dmarc"`
+}
+
+// IsSpoofed DMARC失败，或发件域名没有DMARC策略但SPF失败时视为伪造
+func (a AuthResult) IsSpoofed() bool {
+	if a.DMARC == "fail" {
+		return true
+	}
+	return (a.DMARC == "" || a.DMARC == "none") && a.SPF == "fail"
 }
 
 // Storage 邮件存储接口
 type Storage interface {
 	SaveMail(userID int64, from string, to []string, subject, body, rawData string) error
+	SaveRawMessage(rawData string) (int64, error)
+	SaveMailCopy(userID int64, from string, to []string, subject, body, html string, headers MailHeaders, rawID int64, auth AuthResult) (int64, error)
+	SaveAttachments(mailID int64, attachments []*Attachment) error
+	SetMailCodes(mailID int64, codes MailCodes) error
+	SetMailTags(mailID int64, tags []string) error
+	DeliverMailCopy(m *MailCopy) (int64, error)
+	GetAttachments(userID, mailID int64) ([]*Attachment, error)
+	GetAttachment(userID, mailID, id int64) (*Attachment, error)
 	GetMails(userID int64, limit, offset int) ([]*Mail, error)
+	ListMails(userID int64, filter *MailFilter) ([]*Mail, error)
+	CountMails(userID int64, filter *MailFilter) (int64, error)
+	UpdateMailFlags(userID int64, ids []int64, read, starred *bool) (int64, error)
+	TrashMails(userID int64, ids []int64) (int64, error)
+	RestoreMails(userID int64, ids []int64) (int64, error)
+	DeleteMails(userID int64, ids []int64) (int64, error)
+	PurgeTrash(before time.Time) (int64, error)
+	DeleteMailDomainMails(domain *MailDomain) (int64, error)
+	GetUnreadCounts(userID int64) (map[string]int64, error)
 	GetMailByID(userID int64, id int64) (*Mail, error)
 	GetMailCount(userID int64) (int64, error)
+	SearchMails(userID int64, query string, limit, offset int) ([]*Mail, error)
 	Close() error
 
 	// 邮箱域名管理
-	CreateMailDomain(userID int64, subdomain, fullDomain, recordID, email string) error
+	CreateMailDomain(userID int64, subdomain, fullDomain, recordID string, extraRecordIDs []string, email string, expiresAt *time.Time) error
 	GetMailDomains(userID int64) ([]*MailDomain, error)
+	GetMailDomain(userID int64, id int64) (*MailDomain, error)
+	GetAllMailDomains() ([]*MailDomain, error)
 	DeleteMailDomain(userID int64, id int64) error
 	GetMailDomainByEmail(email string) (*MailDomain, error)
 	GetMailDomainsByDomain(domain string) ([]*MailDomain, error)
+	GetCatchAllMailDomain(domain string) (*MailDomain, error)
+	SetMailDomainCatchAll(userID int64, id int64, enabled bool) error
+	SetMailDomainExpiry(userID int64, id int64, expiresAt *time.Time) error
+	SetMailDomainExtraRecords(id int64, extraRecordIDs []string) error
+	GetExpiredMailDomains(before time.Time) ([]*MailDomain, error)
+
+	// 邮箱别名
+	CreateAlias(alias *Alias) error
+	GetAliases(userID, domainID int64) ([]*Alias, error)
+	GetAliasByEmail(email string) (*Alias, error)
+	DeleteAlias(userID, domainID, id int64) error
+	ResolveRecipient(email string) (*Recipient, error)
 
 	// 用户管理
 	CreateUser(email, password, registerIP string) (*User, error)
@@ -46,11 +146,66 @@ type Storage interface {
 	// 验证码管理
 	CreateVerifyCode(email string) (string, error)
 	VerifyCode(email, code string) (bool, error)
+
+	// 外发队列
+	EnqueueOutbound(from string, recipients []string, rawData string) error
+	ClaimOutboundJobs(limit int) ([]*OutboundJob, error)
+	CompleteOutboundJob(id int64) error
+	RetryOutboundJob(id int64, nextAttempt time.Time, lastError string) error
+	FailOutboundJob(id int64, lastError string) error
+	ResetOutboundJobs() error
+
+	// Webhook
+	CreateWebhook(hook *Webhook) error
+	UpdateWebhook(hook *Webhook) error
+	GetWebhooks(userID int64) ([]*Webhook, error)
+	GetWebhook(userID, id int64) (*Webhook, error)
+	DeleteWebhook(userID, id int64) error
+	GetMailWebhooks(userID int64, recipients []string, event string) ([]*Webhook, error)
+	CreateWebhookDelivery(d *WebhookDelivery) error
+	ClaimWebhookDeliveries(limit int) ([]*WebhookDelivery, error)
+	FinishWebhookDelivery(id int64, status string, responseStatus int, lastError string, nextAttempt time.Time) error
+	ResetWebhookDeliveries() error
+	GetWebhookDelivery(id int64) (*WebhookDelivery, error)
+	GetWebhookDeliveries(userID, webhookID int64, limit, offset int) ([]*WebhookDelivery, error)
+
+	// DKIM密钥
+	CreateDKIMKey(key *DKIMKey) error
+	GetDKIMKeys() ([]*DKIMKey, error)
+	GetDKIMKeyByID(id int64) (*DKIMKey, error)
+	GetActiveDKIMKeys(domain string) ([]*DKIMKey, error)
+	SetDKIMKeyRecordID(id int64, recordID string) error
+	DeleteDKIMKey(id int64) error
+
+	// 自有域名
+	CreateCustomDomain(domain *CustomDomain) error
+	GetCustomDomains(userID int64) ([]*CustomDomain, error)
+	GetCustomDomain(userID, id int64) (*CustomDomain, error)
+	GetCustomDomainByName(domain string) (*CustomDomain, error)
+	GetCustomDomainsByStatus(status string) ([]*CustomDomain, error)
+	UpdateCustomDomainChecks(domain *CustomDomain) error
+	SetCustomDomainCatchAll(userID, id int64, enabled bool) error
+	DeleteCustomDomain(userID, id int64) error
+	CreateCustomDomainAddress(addr *CustomDomainAddress) error
+	GetCustomDomainAddresses(userID, domainID int64) ([]*CustomDomainAddress, error)
+	DeleteCustomDomainAddress(userID, domainID, id int64) error
+	GetMailboxOwner(email string) (int64, error)
+}
+
+// rowScanner 兼容 *sql.Row 和 *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// execer 兼容 *sql.DB 和 *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
 }
 
 // SQLiteStorage SQLite存储实现
 type SQLiteStorage struct {
-	db *sql.DB
+	db  *sql.DB
+	fts bool // 是否启用了FTS5全文索引
 }
 
 // NewSQLiteStorage 创建SQLite存储
@@ -104,13 +259,137 @@ func (s *SQLiteStorage) init() error {
 		mail_to TEXT NOT NULL,
 		subject TEXT,
 		body TEXT,
+		html_body TEXT,
+		from_name TEXT,
+		from_address TEXT,
+		header_to TEXT,
+		header_cc TEXT,
+		is_read BOOLEAN DEFAULT 0,
+		is_starred BOOLEAN DEFAULT 0,
+		deleted_at DATETIME,
+		codes TEXT,
+		links TEXT,
+		tags TEXT,
 		raw_data TEXT,
+		raw_id INTEGER REFERENCES raw_messages(id),
+		spf_result TEXT,
+		dkim_result TEXT,
+		dmarc_result TEXT,
 		received_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 		FOREIGN KEY (user_id) REFERENCES users(id)
 	);
 	CREATE INDEX IF NOT EXISTS idx_mails_user ON mails(user_id, received_at DESC);
 	CREATE INDEX IF NOT EXISTS idx_mail_from ON mails(mail_from);
 	
+	-- 原始邮件表（多个收件人共享同一份原始数据）
+	CREATE TABLE IF NOT EXISTS raw_messages (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		data TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	
+	-- 外发队列表（每个外部收件人一条任务）
+	CREATE TABLE IF NOT EXISTS outbound_queue (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		raw_id INTEGER NOT NULL,
+		mail_from TEXT NOT NULL,
+		rcpt_to TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending',
+		attempts INTEGER DEFAULT 0,
+		last_error TEXT,
+		next_attempt_at DATETIME NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (raw_id) REFERENCES raw_messages(id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_outbound_due ON outbound_queue(status, next_attempt_at);
+	
+	-- 附件内容表（按SHA-256去重，多份邮件记录共享同一份内容）
+	CREATE TABLE IF NOT EXISTS attachment_blobs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		hash TEXT NOT NULL UNIQUE,
+		data BLOB NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	-- 附件表（解析后的MIME部分，属于某一份邮件记录，内容引用 attachment_blobs；旧记录的内容保存在 data 列）
+	CREATE TABLE IF NOT EXISTS attachments (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		mail_id INTEGER NOT NULL,
+		blob_id INTEGER REFERENCES attachment_blobs(id),
+		filename TEXT,
+		content_type TEXT NOT NULL,
+		content_id TEXT,
+		inline BOOLEAN DEFAULT 0,
+		size INTEGER NOT NULL,
+		data BLOB NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (mail_id) REFERENCES mails(id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_attachments_mail ON attachments(mail_id);
+
+	-- Webhook表（收到邮件时推送到用户配置的地址）
+	CREATE TABLE IF NOT EXISTS webhooks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		domain_id INTEGER DEFAULT 0,
+		url TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		events TEXT,
+		active BOOLEAN DEFAULT 1,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_webhooks_user ON webhooks(user_id);
+
+	-- Webhook投递表（持久化重试队列，同时作为投递日志）
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		webhook_id INTEGER NOT NULL,
+		event TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending',
+		attempts INTEGER DEFAULT 0,
+		response_status INTEGER,
+		last_error TEXT,
+		next_attempt_at DATETIME NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (webhook_id) REFERENCES webhooks(id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(status, next_attempt_at);
+	CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_hook ON webhook_deliveries(webhook_id, id DESC);
+
+	-- 用户自有域名表（验证TXT记录后可以在该域名下创建任意地址）
+	-- 同一域名可以被多个用户同时申请，只有验证通过的那一条唯一（见 migrate 中的 idx_custom_domains_verified）
+	CREATE TABLE IF NOT EXISTS custom_domains (` + customDomainsDefinition + `);
+	CREATE INDEX IF NOT EXISTS idx_custom_domains_user ON custom_domains(user_id);
+
+	-- 自有域名下的邮箱地址
+	CREATE TABLE IF NOT EXISTS custom_domain_addresses (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		domain_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		email TEXT NOT NULL UNIQUE,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (domain_id) REFERENCES custom_domains(id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_custom_domain_addresses_domain ON custom_domain_addresses(domain_id);
+
+	-- DKIM密钥表
+	CREATE TABLE IF NOT EXISTS dkim_keys (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		domain TEXT NOT NULL,
+		selector TEXT NOT NULL,
+		algorithm TEXT NOT NULL,
+		private_key TEXT NOT NULL,
+		dns_record TEXT NOT NULL,
+		record_id TEXT,
+		active BOOLEAN DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE(domain, selector)
+	);
+	
 	-- 邮箱域名表（添加user_id）
 	CREATE TABLE IF NOT EXISTS mail_domains (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -118,18 +397,165 @@ func (s *SQLiteStorage) init() error {
 		subdomain TEXT NOT NULL,
 		full_domain TEXT NOT NULL UNIQUE,
 		record_id TEXT NOT NULL,
+		extra_record_ids TEXT,
 		email TEXT NOT NULL UNIQUE,
+		catch_all BOOLEAN DEFAULT 0,
+		expires_at DATETIME,
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 		FOREIGN KEY (user_id) REFERENCES users(id)
 	);
 	CREATE INDEX IF NOT EXISTS idx_domains_user ON mail_domains(user_id);
 	CREATE INDEX IF NOT EXISTS idx_email ON mail_domains(email);
+
+	-- 邮箱别名表（邮箱子域名下的其他地址，本地部分可以包含通配符 *）
+	CREATE TABLE IF NOT EXISTS aliases (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		domain_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		email TEXT NOT NULL UNIQUE,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (domain_id) REFERENCES mail_domains(id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_aliases_domain ON aliases(domain_id);
 	`
 
 	_, err := s.db.Exec(query)
 	if err != nil {
 		return fmt.Errorf("failed to create table: %v", err)
 	}
+
+	if err := s.migrate(); err != nil {
+		return err
+	}
+	s.initSearch()
+	return nil
+}
+
+// customDomainsDefinition custom_domains 表的列定义，建表和迁移旧表时共用
+const customDomainsDefinition = `
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		domain TEXT NOT NULL,
+		verification_token TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending',
+		catch_all BOOLEAN DEFAULT 0,
+		checks TEXT,
+		verified_at DATETIME,
+		checked_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	`
+
+// migrate 为旧版本数据库补充新增的列
+func (s *SQLiteStorage) migrate() error {
+	columns := []struct {
+		table      string
+		column     string
+		definition string
+	}{
+		{"mail_domains", "catch_all", "BOOLEAN DEFAULT 0"},
+		{"mail_domains", "expires_at", "DATETIME"},
+		{"mail_domains", "extra_record_ids", "TEXT"},
+		{"mails", "raw_id", "INTEGER REFERENCES raw_messages(id)"},
+		{"attachments", "blob_id", "INTEGER REFERENCES attachment_blobs(id)"},
+		{"mails", "spf_result", "TEXT"},
+		{"mails", "dkim_result", "TEXT"},
+		{"mails", "dmarc_result", "TEXT"},
+		{"mails", "html_body", "TEXT"},
+		{"mails", "from_name", "TEXT"},
+		{"mails", "from_address", "TEXT"},
+		{"mails", "header_to", "TEXT"},
+		{"mails", "header_cc", "TEXT"},
+		{"mails", "is_read", "BOOLEAN DEFAULT 0"},
+		{"mails", "is_starred", "BOOLEAN DEFAULT 0"},
+		{"mails", "deleted_at", "DATETIME"},
+		{"mails", "codes", "TEXT"},
+		{"mails", "links", "TEXT"},
+		{"mails", "tags", "TEXT"},
+	}
+
+	for _, c := range columns {
+		if err := s.addColumnIfMissing(c.table, c.column, c.definition); err != nil {
+			return err
+		}
+	}
+
+	// 依赖新增列的索引需要在补列之后创建
+	if _, err := s.db.Exec(`CREATE INDEX IF NOT EXISTS idx_attachments_blob ON attachments(blob_id)`); err != nil {
+		return fmt.Errorf("failed to create attachment blob index: %v", err)
+	}
+
+	if err := s.migrateCustomDomains(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// migrateCustomDomains 旧版本的 custom_domains.domain 带有 UNIQUE 约束，未验证的申请会占用域名
+// SQLite 不能删除约束，需要重建表；之后只对已验证的域名建立唯一索引
+func (s *SQLiteStorage) migrateCustomDomains() error {
+	var tableSQL string
+	if err := s.db.QueryRow(`SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'custom_domains'`).Scan(&tableSQL); err != nil {
+		return fmt.Errorf("failed to inspect table custom_domains: %v", err)
+	}
+
+	if strings.Contains(tableSQL, "domain TEXT NOT NULL UNIQUE") {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return fmt.Errorf("failed to begin transaction: %v", err)
+		}
+		defer tx.Rollback()
+
+		statements := []string{
+			`CREATE TABLE custom_domains_new (` + customDomainsDefinition + `)`,
+			`INSERT INTO custom_domains_new (id, user_id, domain, verification_token, status, catch_all, checks, verified_at, checked_at, created_at)
+			SELECT id, user_id, domain, verification_token, status, catch_all, checks, verified_at, checked_at, created_at FROM custom_domains`,
+			`DROP TABLE custom_domains`,
+			`ALTER TABLE custom_domains_new RENAME TO custom_domains`,
+			`CREATE INDEX IF NOT EXISTS idx_custom_domains_user ON custom_domains(user_id)`,
+		}
+		for _, stmt := range statements {
+			if _, err := tx.Exec(stmt); err != nil {
+				return fmt.Errorf("failed to migrate custom_domains: %v", err)
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to migrate custom_domains: %v", err)
+		}
+		log.Printf("[Storage] 已迁移 custom_domains 表，未验证的域名不再占用域名")
+	}
+
+	if _, err := s.db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_custom_domains_verified ON custom_domains(domain) WHERE status = 'verified'`); err != nil {
+		return fmt.Errorf("failed to create custom domain index: %v", err)
+	}
+	return nil
+}
+
+// addColumnIfMissing 列不存在时执行 ALTER TABLE ADD COLUMN
+func (s *SQLiteStorage) addColumnIfMissing(table, column, definition string) error {
+	rows, err := s.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return fmt.Errorf("failed to inspect table %s: %v", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return fmt.Errorf("failed to scan table info: %v", err)
+		}
+		if name == column {
+			return nil
+		}
+	}
+	rows.Close()
+
+	_, err = s.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil {
+		return fmt.Errorf("failed to add column %s.%s: %v", table, column, err)
+	}
 	return nil
 }
 
@@ -153,52 +579,162 @@ func (s *SQLiteStorage) SaveMail(userID int64, from string, to []string, subject
 	return nil
 }
 
-// GetMails 获取邮件列表
-func (s *SQLiteStorage) GetMails(userID int64, limit, offset int) ([]*Mail, error) {
-	if limit <= 0 {
-		limit = 20
+// SaveRawMessage 保存原始邮件数据，返回ID供多份邮件记录共享
+func (s *SQLiteStorage) SaveRawMessage(rawData string) (int64, error) {
+	result, err := s.db.Exec(`INSERT INTO raw_messages (data, created_at) VALUES (?, ?)`, rawData, time.Now())
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert raw message: %v", err)
+	}
+	return result.LastInsertId()
+}
+
+// SaveMailCopy 为某个用户保存一份邮件记录，原始数据引用 raw_messages
+func (s *SQLiteStorage) SaveMailCopy(userID int64, from string, to []string, subject, body, html string, headers MailHeaders, rawID int64, auth AuthResult) (int64, error) {
+	return insertMailCopy(s.db, userID, from, to, subject, body, html, headers, rawID, auth)
+}
+
+// DeliverMailCopy 在一个事务中保存邮件记录、附件、验证码和 +tag，失败时不留下不完整的邮件
+func (s *SQLiteStorage) DeliverMailCopy(m *MailCopy) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	mailID, err := insertMailCopy(tx, m.UserID, m.From, m.To, m.Subject, m.Body, m.HTML, m.Headers, m.RawID, m.Auth)
+	if err != nil {
+		return 0, err
+	}
+	if err := insertAttachments(tx, mailID, m.Attachments); err != nil {
+		return 0, err
+	}
+	if err := updateMailCodes(tx, mailID, m.Codes); err != nil {
+		return 0, err
+	}
+	if len(m.Tags) > 0 {
+		if err := updateMailTags(tx, mailID, m.Tags); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit mail: %v", err)
+	}
+	return mailID, nil
+}
+
+// insertMailCopy 插入一份邮件记录
+func insertMailCopy(db execer, userID int64, from string, to []string, subject, body, html string, headers MailHeaders, rawID int64, auth AuthResult) (int64, error) {
+	toJSON, err := json.Marshal(to)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal recipients: %v", err)
+	}
+	headerToJSON, err := json.Marshal(headers.HeaderTo)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal header recipients: %v", err)
+	}
+	ccJSON, err := json.Marshal(headers.Cc)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal cc: %v", err)
 	}
 
 	query := `
-	SELECT id, mail_from, mail_to, subject, body, raw_data, received_at
-	FROM mails
-	WHERE user_id = ?
-	ORDER BY received_at DESC
-	LIMIT ? OFFSET ?
+	INSERT INTO mails (user_id, mail_from, mail_to, subject, body, html_body, from_name, from_address, header_to, header_cc,
+		raw_data, raw_id, spf_result, dkim_result, dmarc_result, received_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, '', ?, ?, ?, ?, ?)
 	`
 
-	rows, err := s.db.Query(query, userID, limit, offset)
+	result, err := db.Exec(query, userID, from, string(toJSON), subject, body, html,
+		headers.HeaderFrom.Name, headers.HeaderFrom.Address, string(headerToJSON), string(ccJSON),
+		rawID, auth.SPF, auth.DKIM, auth.DMARC, time.Now())
 	if err != nil {
-		return nil, fmt.Errorf("failed to query mails: %v", err)
+		return 0, fmt.Errorf("failed to insert mail: %v", err)
 	}
-	defer rows.Close()
+	return result.LastInsertId()
+}
 
-	var mails []*Mail
-	for rows.Next() {
-		var mail Mail
-		var toJSON string
-		err := rows.Scan(&mail.ID, &mail.From, &toJSON, &mail.Subject, &mail.Body, &mail.RawData, &mail.ReceivedAt)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan mail: %v", err)
-		}
-		mail.To = toJSON
-		mails = append(mails, &mail)
+// SetMailCodes 保存识别出的验证码和链接
+func (s *SQLiteStorage) SetMailCodes(mailID int64, codes MailCodes) error {
+	return updateMailCodes(s.db, mailID, codes)
+}
+
+// updateMailCodes 更新邮件记录的验证码和链接
+func updateMailCodes(db execer, mailID int64, codes MailCodes) error {
+	codesJSON, err := json.Marshal(codes.Codes)
+	if err != nil {
+		return fmt.Errorf("failed to marshal codes: %v", err)
+	}
+	linksJSON, err := json.Marshal(codes.Links)
+	if err != nil {
+		return fmt.Errorf("failed to marshal links: %v", err)
+	}
+	if _, err := db.Exec(`UPDATE mails SET codes = ?, links = ? WHERE id = ?`, string(codesJSON), string(linksJSON), mailID); err != nil {
+		return fmt.Errorf("failed to update mail codes: %v", err)
+	}
+	return nil
+}
+
+// mailColumns 查询邮件时使用的列，顺序与 scanMail 一致（原始数据优先取共享的 raw_messages）
+const mailColumns = `m.id, m.mail_from, m.mail_to, m.subject, m.body, COALESCE(m.html_body, ''), COALESCE(r.data, m.raw_data), m.received_at,
+	COALESCE(m.from_name, ''), COALESCE(m.from_address, ''), COALESCE(m.header_to, ''), COALESCE(m.header_cc, ''),
+	COALESCE(m.spf_result, ''), COALESCE(m.dkim_result, ''), COALESCE(m.dmarc_result, ''),
+	COALESCE(m.is_read, 0), COALESCE(m.is_starred, 0), m.deleted_at, COALESCE(m.codes, ''), COALESCE(m.links, ''), COALESCE(m.tags, '')`
+
+// mailTables 邮件查询的 FROM 子句
+const mailTables = `mails m LEFT JOIN raw_messages r ON r.id = m.raw_id`
+
+// scanMail 扫描一行邮件记录
+func scanMail(row rowScanner) (*Mail, error) {
+	var mail Mail
+	var toJSON, headerToJSON, ccJSON, codesJSON, linksJSON, tagsJSON string
+	var deletedAt sql.NullTime
+	err := row.Scan(&mail.ID, &mail.From, &toJSON, &mail.Subject, &mail.Body, &mail.HTML, &mail.RawData, &mail.ReceivedAt,
+		&mail.HeaderFrom.Name, &mail.HeaderFrom.Address, &headerToJSON, &ccJSON,
+		&mail.SPF, &mail.DKIM, &mail.DMARC, &mail.Read, &mail.Starred, &deletedAt, &codesJSON, &linksJSON, &tagsJSON)
+	if err != nil {
+		return nil, err
+	}
+	if deletedAt.Valid {
+		mail.DeletedAt = &deletedAt.Time
+	}
+	mail.To = toJSON
+	// 旧邮件没有解析邮件头，这两列为空
+	if headerToJSON != "" {
+		json.Unmarshal([]byte(headerToJSON), &mail.HeaderTo)
 	}
+	if ccJSON != "" {
+		json.Unmarshal([]byte(ccJSON), &mail.Cc)
+	}
+	// 未识别过的旧邮件这两列为空，返回空数组
+	mail.Codes, mail.Links = []string{}, []string{}
+	if codesJSON != "" {
+		json.Unmarshal([]byte(codesJSON), &mail.Codes)
+	}
+	if linksJSON != "" {
+		json.Unmarshal([]byte(linksJSON), &mail.Links)
+	}
+	mail.Tags = []string{}
+	if tagsJSON != "" {
+		json.Unmarshal([]byte(tagsJSON), &mail.Tags)
+	}
+	mail.Spoofed = mail.IsSpoofed()
+	return &mail, nil
+}
 
-	return mails, nil
+// GetMails 获取邮件列表
+func (s *SQLiteStorage) GetMails(userID int64, limit, offset int) ([]*Mail, error) {
+	return s.ListMails(userID, &MailFilter{Limit: limit, Offset: offset})
 }
 
 // GetMailByID 根据ID获取邮件
 func (s *SQLiteStorage) GetMailByID(userID int64, id int64) (*Mail, error) {
 	query := `
-	SELECT id, mail_from, mail_to, subject, body, raw_data, received_at
-	FROM mails
-	WHERE id = ? AND user_id = ?
+	SELECT ` + mailColumns + `
+	FROM ` + mailTables + `
+	WHERE m.id = ? AND m.user_id = ?
 	`
 
-	var mail Mail
-	var toJSON string
-	err := s.db.QueryRow(query, id, userID).Scan(&mail.ID, &mail.From, &toJSON, &mail.Subject, &mail.Body, &mail.RawData, &mail.ReceivedAt)
+	mail, err := scanMail(s.db.QueryRow(query, id, userID))
 	if err == sql.ErrNoRows {
 		return nil, fmt.Errorf("mail not found")
 	}
@@ -206,14 +742,13 @@ func (s *SQLiteStorage) GetMailByID(userID int64, id int64) (*Mail, error) {
 		return nil, fmt.Errorf("failed to query mail: %v", err)
 	}
 
-	mail.To = toJSON
-	return &mail, nil
+	return mail, nil
 }
 
-// GetMailCount 获取邮件总数
+// GetMailCount 获取邮件总数（不含回收站）
 func (s *SQLiteStorage) GetMailCount(userID int64) (int64, error) {
 	var count int64
-	err := s.db.QueryRow("SELECT COUNT(*) FROM mails WHERE user_id = ?", userID).Scan(&count)
+	err := s.db.QueryRow("SELECT COUNT(*) FROM mails WHERE user_id = ? AND deleted_at IS NULL", userID).Scan(&count)
 	if err != nil {
 		return 0, fmt.Errorf("failed to count mails: %v", err)
 	}
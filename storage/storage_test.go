@@ -0,0 +1,17 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// newTestStorage 在临时目录中创建数据库
+func newTestStorage(t *testing.T) *SQLiteStorage {
+	t.Helper()
+	s, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "mails.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteStorage: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
@@ -2,7 +2,10 @@ package storage
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"database/sql"
+	"encoding/hex"
 	"fmt"
 	"math/big"
 	"time"
@@ -29,6 +32,20 @@ type VerifyCode struct {
 	CreatedAt time.Time `json:"created_at"`
 }
 
+// HashPassword 对密码进行SHA256哈希
+func HashPassword(password string) string {
+	hash := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(hash[:])
+}
+
+// CheckPassword 校验密码是否与用户保存的哈希一致（未设置密码的用户始终校验失败）
+func (u *User) CheckPassword(password string) bool {
+	if u.Password == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(u.Password), []byte(HashPassword(password))) == 1
+}
+
 // CreateUser 创建用户
 func (s *SQLiteStorage) CreateUser(email, password, registerIP string) (*User, error) {
 	// 检查是否是管理员
@@ -0,0 +1,355 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Webhook事件类型
+const (
+	WebhookEventMailReceived = "mail.received" // 收到新邮件
+	WebhookEventTest         = "webhook.test"  // 手动测试，不受事件过滤影响
+)
+
+// Webhook投递状态
+const (
+	WebhookPending    = "pending"    // 等待投递
+	WebhookDelivering = "delivering" // 正在投递
+	WebhookDelivered  = "delivered"  // 投递成功
+	WebhookFailed     = "failed"     // 重试次数用完
+)
+
+// Webhook 用户配置的回调地址，收到邮件时推送
+type Webhook struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"user_id"`
+	DomainID  int64     `json:"domain_id"` // 只推送发到该邮箱（MailDomain）的邮件，0表示用户的所有邮箱
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret"` // HMAC-SHA256签名密钥
+	Events    []string  `json:"events"` // 订阅的事件，为空表示全部
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Subscribes 判断是否订阅了某个事件
+func (w *Webhook) Subscribes(event string) bool {
+	if event == WebhookEventTest || len(w.Events) == 0 {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery 一次Webhook投递（包括所有重试），同时作为投递日志
+type WebhookDelivery struct {
+	ID             int64           `json:"id"`
+	WebhookID      int64           `json:"webhook_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	ResponseStatus int             `json:"response_status"` // 最后一次请求的HTTP状态码，0表示请求失败
+	LastError      string          `json:"last_error"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at"`
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+	URL            string          `json:"-"` // 投递时从 webhooks 表读取
+	Secret         string          `json:"-"`
+}
+
+// webhookColumns 查询Webhook时使用的列，顺序与 scanWebhook 一致
+const webhookColumns = `id, user_id, COALESCE(domain_id, 0), url, secret, COALESCE(events, ''), active, created_at`
+
+// scanWebhook 扫描一行Webhook记录
+func scanWebhook(row rowScanner) (*Webhook, error) {
+	var w Webhook
+	var events string
+	if err := row.Scan(&w.ID, &w.UserID, &w.DomainID, &w.URL, &w.Secret, &events, &w.Active, &w.CreatedAt); err != nil {
+		return nil, err
+	}
+	w.Events = []string{}
+	if events != "" {
+		w.Events = strings.Split(events, ",")
+	}
+	return &w, nil
+}
+
+// webhookDeliveryColumns 查询投递记录时使用的列，顺序与 scanWebhookDelivery 一致
+const webhookDeliveryColumns = `d.id, d.webhook_id, d.event, d.payload, d.status, d.attempts, COALESCE(d.response_status, 0),
+	COALESCE(d.last_error, ''), d.next_attempt_at, d.created_at, d.updated_at`
+
+// scanWebhookDelivery 扫描一行投递记录，extra 追加在末尾（如 url、secret 列）
+func scanWebhookDelivery(row rowScanner, extra ...interface{}) (*WebhookDelivery, error) {
+	var d WebhookDelivery
+	var payload string
+	dest := []interface{}{&d.ID, &d.WebhookID, &d.Event, &payload, &d.Status, &d.Attempts, &d.ResponseStatus,
+		&d.LastError, &d.NextAttemptAt, &d.CreatedAt, &d.UpdatedAt}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	d.Payload = json.RawMessage(payload)
+	return &d, nil
+}
+
+// CreateWebhook 创建Webhook
+func (s *SQLiteStorage) CreateWebhook(hook *Webhook) error {
+	hook.CreatedAt = time.Now()
+	query := `
+	INSERT INTO webhooks (user_id, domain_id, url, secret, events, active, created_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?)
+	`
+	result, err := s.db.Exec(query, hook.UserID, hook.DomainID, hook.URL, hook.Secret, strings.Join(hook.Events, ","), hook.Active, hook.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to insert webhook: %v", err)
+	}
+	hook.ID, _ = result.LastInsertId()
+	return nil
+}
+
+// UpdateWebhook 修改Webhook的地址、范围、事件和启用状态
+func (s *SQLiteStorage) UpdateWebhook(hook *Webhook) error {
+	query := `UPDATE webhooks SET domain_id = ?, url = ?, secret = ?, events = ?, active = ? WHERE id = ? AND user_id = ?`
+	result, err := s.db.Exec(query, hook.DomainID, hook.URL, hook.Secret, strings.Join(hook.Events, ","), hook.Active, hook.ID, hook.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to update webhook: %v", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("webhook not found")
+	}
+	return nil
+}
+
+// GetWebhooks 获取用户的所有Webhook
+func (s *SQLiteStorage) GetWebhooks(userID int64) ([]*Webhook, error) {
+	query := `SELECT ` + webhookColumns + ` FROM webhooks WHERE user_id = ? ORDER BY id`
+	rows, err := s.db.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query webhooks: %v", err)
+	}
+	defer rows.Close()
+
+	hooks := []*Webhook{}
+	for rows.Next() {
+		hook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan webhook: %v", err)
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, nil
+}
+
+// GetWebhook 获取单个Webhook，不存在或不属于该用户时返回 nil
+func (s *SQLiteStorage) GetWebhook(userID, id int64) (*Webhook, error) {
+	query := `SELECT ` + webhookColumns + ` FROM webhooks WHERE id = ? AND user_id = ?`
+	hook, err := scanWebhook(s.db.QueryRow(query, id, userID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query webhook: %v", err)
+	}
+	return hook, nil
+}
+
+// DeleteWebhook 删除Webhook及其投递记录
+func (s *SQLiteStorage) DeleteWebhook(userID, id int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`DELETE FROM webhooks WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook: %v", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("webhook not found")
+	}
+	if _, err := tx.Exec(`DELETE FROM webhook_deliveries WHERE webhook_id = ?`, id); err != nil {
+		return fmt.Errorf("failed to delete webhook deliveries: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit webhook deletion: %v", err)
+	}
+	return nil
+}
+
+// GetMailWebhooks 获取应推送某封邮件的Webhook：已启用、订阅了该事件，且不限邮箱或限定的邮箱是收件人之一
+func (s *SQLiteStorage) GetMailWebhooks(userID int64, recipients []string, event string) ([]*Webhook, error) {
+	recipientsJSON, err := json.Marshal(recipients)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal recipients: %v", err)
+	}
+
+	query := `
+	SELECT ` + webhookColumns + `
+	FROM webhooks w
+	WHERE w.user_id = ? AND w.active = 1 AND (
+		COALESCE(w.domain_id, 0) = 0 OR EXISTS (
+			SELECT 1 FROM mail_domains d, json_each(?) r
+			WHERE d.id = w.domain_id AND d.user_id = w.user_id
+			AND ` + mailDomainRecipientMatch + `
+		)
+	)
+	ORDER BY w.id
+	`
+	rows, err := s.db.Query(query, userID, string(recipientsJSON))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query webhooks: %v", err)
+	}
+	defer rows.Close()
+
+	var hooks []*Webhook
+	for rows.Next() {
+		hook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan webhook: %v", err)
+		}
+		if hook.Subscribes(event) {
+			hooks = append(hooks, hook)
+		}
+	}
+	return hooks, nil
+}
+
+// CreateWebhookDelivery 创建投递记录，Status 为空时按待投递处理
+func (s *SQLiteStorage) CreateWebhookDelivery(d *WebhookDelivery) error {
+	now := time.Now()
+	if d.Status == "" {
+		d.Status = WebhookPending
+	}
+	if d.NextAttemptAt.IsZero() {
+		d.NextAttemptAt = now
+	}
+	d.CreatedAt, d.UpdatedAt = now, now
+
+	query := `
+	INSERT INTO webhook_deliveries (webhook_id, event, payload, status, attempts, next_attempt_at, created_at, updated_at)
+	VALUES (?, ?, ?, ?, 0, ?, ?, ?)
+	`
+	result, err := s.db.Exec(query, d.WebhookID, d.Event, string(d.Payload), d.Status, d.NextAttemptAt, now, now)
+	if err != nil {
+		return fmt.Errorf("failed to insert webhook delivery: %v", err)
+	}
+	d.ID, _ = result.LastInsertId()
+	return nil
+}
+
+// ClaimWebhookDeliveries 取出到期的待投递记录并标记为投递中
+func (s *SQLiteStorage) ClaimWebhookDeliveries(limit int) ([]*WebhookDelivery, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+	SELECT ` + webhookDeliveryColumns + `, w.url, w.secret
+	FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id
+	WHERE d.status = ? AND d.next_attempt_at <= ?
+	ORDER BY d.next_attempt_at ASC
+	LIMIT ?
+	`
+	rows, err := tx.Query(query, WebhookPending, time.Now(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query webhook deliveries: %v", err)
+	}
+
+	var deliveries []*WebhookDelivery
+	for rows.Next() {
+		var url, secret string
+		d, err := scanWebhookDelivery(rows, &url, &secret)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan webhook delivery: %v", err)
+		}
+		d.URL, d.Secret = url, secret
+		deliveries = append(deliveries, d)
+	}
+	rows.Close()
+
+	for _, d := range deliveries {
+		_, err := tx.Exec(`UPDATE webhook_deliveries SET status = ?, updated_at = ? WHERE id = ?`, WebhookDelivering, time.Now(), d.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to claim webhook delivery: %v", err)
+		}
+		d.Status = WebhookDelivering
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit webhook claim: %v", err)
+	}
+	return deliveries, nil
+}
+
+// FinishWebhookDelivery 记录一次投递尝试的结果：status 为 WebhookPending 时在 nextAttempt 重试
+func (s *SQLiteStorage) FinishWebhookDelivery(id int64, status string, responseStatus int, lastError string, nextAttempt time.Time) error {
+	query := `
+	UPDATE webhook_deliveries
+	SET status = ?, attempts = attempts + 1, response_status = ?, last_error = ?, next_attempt_at = ?, updated_at = ?
+	WHERE id = ?
+	`
+	_, err := s.db.Exec(query, status, responseStatus, lastError, nextAttempt, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("failed to update webhook delivery: %v", err)
+	}
+	return nil
+}
+
+// ResetWebhookDeliveries 将上次进程退出时仍在投递中的记录恢复为待投递
+func (s *SQLiteStorage) ResetWebhookDeliveries() error {
+	_, err := s.db.Exec(`UPDATE webhook_deliveries SET status = ?, updated_at = ? WHERE status = ?`, WebhookPending, time.Now(), WebhookDelivering)
+	if err != nil {
+		return fmt.Errorf("failed to reset webhook deliveries: %v", err)
+	}
+	return nil
+}
+
+// GetWebhookDelivery 获取单条投递记录
+func (s *SQLiteStorage) GetWebhookDelivery(id int64) (*WebhookDelivery, error) {
+	query := `SELECT ` + webhookDeliveryColumns + ` FROM webhook_deliveries d WHERE d.id = ?`
+	d, err := scanWebhookDelivery(s.db.QueryRow(query, id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query webhook delivery: %v", err)
+	}
+	return d, nil
+}
+
+// GetWebhookDeliveries 获取Webhook的投递日志，按时间倒序
+func (s *SQLiteStorage) GetWebhookDeliveries(userID, webhookID int64, limit, offset int) ([]*WebhookDelivery, error) {
+	if limit <= 0 {
+		limit = 20
+	}
+	query := `
+	SELECT ` + webhookDeliveryColumns + `
+	FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id
+	WHERE d.webhook_id = ? AND w.user_id = ?
+	ORDER BY d.id DESC
+	LIMIT ? OFFSET ?
+	`
+	rows, err := s.db.Query(query, webhookID, userID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query webhook deliveries: %v", err)
+	}
+	defer rows.Close()
+
+	deliveries := []*WebhookDelivery{}
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan webhook delivery: %v", err)
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, nil
+}